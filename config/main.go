@@ -3,8 +3,47 @@ package config
 var Host string = "0.0.0.0"
 var Port int = 7379
 var KEYS_LIMIT = 100
-var EVICTION_STRATEGY = "EVICT_LRU"
+var EVICTION_STRATEGY = "allkeys-lru"
 var APPEND_ONLY_FILE = "dice.aof"
 var EVICTION_RATIO = 0.4
 var SAMPLE_SIZE = 20
 var EVICTION_POOL_SIZE = 16
+var LFU_LOG_FACTOR = 10
+var LFU_DECAY_TIME = 1
+
+// positive values cap the number of entries of a list node, negative values from -1 to -5 cap
+// its size to 4kb, 8kb, 16kb, 32kb and 64kb
+var LIST_MAX_LISTPACK_SIZE = -2
+
+// hashes are kept in a listpack while they have at most HASH_MAX_LISTPACK_ENTRIES fields
+// and no field or value longer than HASH_MAX_LISTPACK_VALUE bytes
+var HASH_MAX_LISTPACK_ENTRIES = 128
+var HASH_MAX_LISTPACK_VALUE = 64
+
+// sets made of integers only are kept in a sorted intset while they have at most SET_MAX_INTSET_ENTRIES members
+var SET_MAX_INTSET_ENTRIES = 512
+
+// sorted sets are kept in a listpack while they have at most ZSET_MAX_LISTPACK_ENTRIES members
+// and no member longer than ZSET_MAX_LISTPACK_VALUE bytes
+var ZSET_MAX_LISTPACK_ENTRIES = 128
+var ZSET_MAX_LISTPACK_VALUE = 64
+
+// a stream node holds at most STREAM_NODE_MAX_ENTRIES entries and STREAM_NODE_MAX_BYTES bytes,
+// 0 disables the limit
+var STREAM_NODE_MAX_ENTRIES = 100
+var STREAM_NODE_MAX_BYTES = 4096
+
+// sparse HyperLogLogs are converted to the dense representation past HLL_SPARSE_MAX_BYTES bytes
+var HLL_SPARSE_MAX_BYTES = 3000
+
+// filters created by BF.ADD, BF.MADD and CF.ADD without a reservation get these parameters
+var BF_ERROR_RATE = 0.01
+var BF_INITIAL_SIZE = 100
+var BF_EXPANSION_FACTOR = 2
+var CF_INITIAL_SIZE = 1024
+var CF_BUCKET_SIZE = 2
+var CF_MAX_ITERATIONS = 20
+var CF_EXPANSION_FACTOR = 1
+
+// time series created without a RETENTION keep their samples for TS_RETENTION_POLICY milliseconds, 0 keeps them forever
+var TS_RETENTION_POLICY = 0
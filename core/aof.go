@@ -0,0 +1,236 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AOF_REWRITE_ITEMS_PER_CMD caps the number of elements rewritten by a single command for collections
+const AOF_REWRITE_ITEMS_PER_CMD = 64
+
+// rewriteObject writes the commands that rebuild the key and its expiry
+func rewriteObject(w *bufio.Writer, key string, obj *Obj) error {
+	var err error
+	switch obj.Type() {
+	case OBJ_TYPE_STRING:
+		err = writeAofCommand(w, "SET", key, stringValueOf(obj))
+	case OBJ_TYPE_LIST:
+		list := listOf(obj)
+		err = writeAofBatches(w, []string{"RPUSH", key}, list.Range(0, list.Len()-1), 1)
+	case OBJ_TYPE_HASH:
+		if err = writeAofBatches(w, []string{"HSET", key}, hashEntries(obj), 2); err == nil {
+			err = rewriteHashFieldExpires(w, key, obj)
+		}
+	case OBJ_TYPE_SET:
+		err = writeAofBatches(w, []string{"SADD", key}, setMembers(obj), 1)
+	case OBJ_TYPE_ZSET:
+		z := zsetOf(obj)
+		items := make([]string, 0, 2*z.Len())
+		for _, e := range z.Range(0, z.Len()-1) {
+			items = append(items, formatScore(e.score), e.member)
+		}
+		err = writeAofBatches(w, []string{"ZADD", key}, items, 2)
+	case OBJ_TYPE_STREAM:
+		err = rewriteStream(w, key, streamOf(obj))
+	case OBJ_TYPE_JSON:
+		err = writeAofCommand(w, "JSON.SET", key, "$", obj.Value.(*jsonValue).String())
+	case OBJ_TYPE_BLOOM:
+		bf := obj.Value.(*bloomFilter)
+		err = rewriteFilter(w, "BF.LOADCHUNK", key, bf.header(), bf.segments())
+	case OBJ_TYPE_CUCKOO:
+		cf := obj.Value.(*cuckooFilter)
+		err = rewriteFilter(w, "CF.LOADCHUNK", key, cf.header(), cf.segments())
+	case OBJ_TYPE_TIMESERIES:
+		err = rewriteTimeSeries(w, obj.Value.(*timeSeries))
+	default:
+		logger.Printf("AOF rewrite: Key=%s skipped, unsupported type %d", key, obj.Type())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if obj.TtlSet() {
+		return writeAofCommand(w, "PEXPIREAT", key, strconv.Itoa(obj.ValidTill))
+	}
+	return nil
+}
+
+// rewriteFilter replays the SCANDUMP of a Bloom or Cuckoo filter with LOADCHUNK, skipping the chunks that
+// are all zeros since the filter the header creates starts empty
+func rewriteFilter(w *bufio.Writer, loadCmd string, key string, header []byte, segments [][]byte) error {
+	if err := writeAofCommand(w, loadCmd, key, "1", string(header)); err != nil {
+		return err
+	}
+	for iter, chunk := dumpChunk(segments, 1); iter != 0; iter, chunk = dumpChunk(segments, iter) {
+		if bytes.Count(chunk, []byte{0}) == len(chunk) {
+			continue
+		}
+		if err := writeAofCommand(w, loadCmd, key, strconv.FormatInt(iter, 10), string(chunk)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteTimeSeries creates the series and adds its samples, its compaction rules are rewritten by
+// rewriteCompactionRules once every series exists
+func rewriteTimeSeries(w *bufio.Writer, s *timeSeries) error {
+	args := []string{"TS.CREATE", s.key, "RETENTION", strconv.FormatInt(s.retention, 10), "DUPLICATE_POLICY", s.duplicatePolicy}
+	if len(s.labels) > 0 {
+		args = append(args, "LABELS")
+		for _, l := range s.labels {
+			args = append(args, l.name, l.value)
+		}
+	}
+	if err := writeAofCommand(w, args...); err != nil {
+		return err
+	}
+
+	items := make([]string, 0, 3*len(s.samples))
+	for _, sample := range s.samples {
+		items = append(items, s.key, strconv.FormatInt(sample.ts, 10), formatScore(sample.value))
+	}
+	return writeAofBatches(w, []string{"TS.MADD"}, items, 3)
+}
+
+// rewriteCompactionRules writes the compaction rules of the time series, after their samples so that
+// replaying the samples of a source does not add to its destinations
+func rewriteCompactionRules(w *bufio.Writer) error {
+	for _, obj := range store {
+		if obj.Type() != OBJ_TYPE_TIMESERIES {
+			continue
+		}
+		s := obj.Value.(*timeSeries)
+		for _, r := range s.rules {
+			if dst, _ := lookupTimeSeries(r.destKey); dst == nil || !strings.EqualFold(dst.sourceKey, s.key) {
+				continue
+			}
+			err := writeAofCommand(w, "TS.CREATERULE", s.key, r.destKey, "AGGREGATION", r.aggregator, strconv.FormatInt(r.bucketDuration, 10))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteSearchIndexes writes the definitions of the indexes, after the hashes so that creating an index
+// indexes them at once
+func rewriteSearchIndexes(w *bufio.Writer) error {
+	names := make([]string, 0, len(searchIndexes))
+	for name := range searchIndexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args := append([]string{"FT.CREATE", name}, searchIndexes[name].definition...)
+		if err := writeAofCommand(w, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteHashFieldExpires writes a HPEXPIREAT for every distinct expiry time of the fields of the hash
+func rewriteHashFieldExpires(w *bufio.Writer, key string, obj *Obj) error {
+	fieldsByTime := make(map[int64][]string)
+	for field, at := range hashObjectOf(obj).expires {
+		fieldsByTime[at] = append(fieldsByTime[at], field)
+	}
+
+	times := make([]int64, 0, len(fieldsByTime))
+	for at := range fieldsByTime {
+		times = append(times, at)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	for _, at := range times {
+		fields := fieldsByTime[at]
+		sort.Strings(fields)
+		args := []string{"HPEXPIREAT", key, strconv.FormatInt(at, 10), "FIELDS", strconv.Itoa(len(fields))}
+		if err := writeAofCommand(w, append(args, fields...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeAofCommand(w *bufio.Writer, args ...string) error {
+	_, err := w.Write(Encode(args, false))
+	return err
+}
+
+// writeAofBatches writes the items with as many commands as needed to stay under AOF_REWRITE_ITEMS_PER_CMD,
+// itemSize is the number of arguments making up a single item, like 2 for a field and its value
+func writeAofBatches(w *bufio.Writer, prefix []string, items []string, itemSize int) error {
+	batch := AOF_REWRITE_ITEMS_PER_CMD * itemSize
+	for start := 0; start < len(items); start += batch {
+		end := min(start+batch, len(items))
+		args := append(append([]string{}, prefix...), items[start:end]...)
+		if err := writeAofCommand(w, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteStream writes the entries of the stream one XADD at a time, then restores its metadata with XSETID
+// and its consumer groups with their consumers and pending entries. An empty stream is created by an XADD
+// trimming itself to nothing, with an id XSETID moves back when the stream never had any entry
+func rewriteStream(w *bufio.Writer, key string, s *stream) error {
+	entries := s.rangeEntries(streamID{}, streamIDMax, 0, false)
+	if len(entries) == 0 {
+		id := s.lastID
+		if id.isZero() {
+			id.seq = 1
+		}
+		if err := writeAofCommand(w, "XADD", key, "MAXLEN", "0", id.String(), "x", "y"); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		if err := writeAofCommand(w, append([]string{"XADD", key, e.id.String()}, e.fields...)...); err != nil {
+			return err
+		}
+	}
+	err := writeAofCommand(w, "XSETID", key, s.lastID.String(),
+		"ENTRIESADDED", strconv.FormatInt(s.entriesAdded, 10), "MAXDELETEDID", s.maxDeletedID.String())
+	if err != nil {
+		return err
+	}
+
+	for _, name := range s.sortedGroupNames() {
+		g := s.groups[name]
+		err := writeAofCommand(w, "XGROUP", "CREATE", key, name, g.lastID.String(),
+			"ENTRIESREAD", strconv.FormatInt(g.entriesRead, 10))
+		if err != nil {
+			return err
+		}
+
+		consumers := make([]string, 0, len(g.consumers))
+		for consumer := range g.consumers {
+			consumers = append(consumers, consumer)
+		}
+		sort.Strings(consumers)
+		for _, consumer := range consumers {
+			if err := writeAofCommand(w, "XGROUP", "CREATECONSUMER", key, name, consumer); err != nil {
+				return err
+			}
+		}
+
+		for _, id := range pendingIDs(g.pel) {
+			nack := g.pel[id]
+			err := writeAofCommand(w, "XCLAIM", key, name, nack.consumer.name, "0", id.String(),
+				"TIME", strconv.FormatInt(nack.deliveryTime, 10), "RETRYCOUNT", strconv.FormatInt(nack.deliveryCount, 10),
+				"JUSTID", "FORCE")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/diceclone/core"
+)
+
+func TestSetBitAndGetBit(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"setbit creates the key", &core.RedisCmd{Cmd: "SETBIT", Args: []string{"bitkey", "7", "1"}}, []byte(":0\r\n")},
+		{"the bit is the last of the first byte", &core.RedisCmd{Cmd: "GET", Args: []string{"bitkey"}}, []byte("$1\r\n\x01\r\n")},
+		{"setbit returns the previous bit", &core.RedisCmd{Cmd: "SETBIT", Args: []string{"bitkey", "7", "0"}}, []byte(":1\r\n")},
+		{"setbit grows the string", &core.RedisCmd{Cmd: "SETBIT", Args: []string{"bitkey", "17", "1"}}, []byte(":0\r\n")},
+		{"grown string length", &core.RedisCmd{Cmd: "STRLEN", Args: []string{"bitkey"}}, []byte(":3\r\n")},
+		{"getbit", &core.RedisCmd{Cmd: "GETBIT", Args: []string{"bitkey", "17"}}, []byte(":1\r\n")},
+		{"getbit past the end", &core.RedisCmd{Cmd: "GETBIT", Args: []string{"bitkey", "1000"}}, []byte(":0\r\n")},
+		{"getbit on a missing key", &core.RedisCmd{Cmd: "GETBIT", Args: []string{"nobits", "0"}}, []byte(":0\r\n")},
+		{"setbit with a bad bit", &core.RedisCmd{Cmd: "SETBIT", Args: []string{"bitkey", "0", "2"}}, []byte("-ERR bit is not an integer or out of range\r\n")},
+		{"setbit with a negative offset", &core.RedisCmd{Cmd: "SETBIT", Args: []string{"bitkey", "-1", "1"}}, []byte("-ERR bit offset is not an integer or out of range\r\n")},
+		{"setbit past the largest string", &core.RedisCmd{Cmd: "SETBIT", Args: []string{"bitkey", "4294967296", "1"}}, []byte("-ERR bit offset is not an integer or out of range\r\n")},
+		{"setbit on an int encoded string", &core.RedisCmd{Cmd: "SET", Args: []string{"number", "1"}}, []byte("+OK\r\n")},
+		{"set the lowest bit of '1'", &core.RedisCmd{Cmd: "SETBIT", Args: []string{"number", "6", "1"}}, []byte(":0\r\n")},
+		{"'1' became '3'", &core.RedisCmd{Cmd: "GET", Args: []string{"number"}}, []byte("$1\r\n3\r\n")},
+	})
+}
+
+func TestBitCount(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"set foobar", &core.RedisCmd{Cmd: "SET", Args: []string{"bc", "foobar"}}, []byte("+OK\r\n")},
+		{"bitcount", &core.RedisCmd{Cmd: "BITCOUNT", Args: []string{"bc"}}, []byte(":26\r\n")},
+		{"bitcount of the first byte", &core.RedisCmd{Cmd: "BITCOUNT", Args: []string{"bc", "0", "0"}}, []byte(":4\r\n")},
+		{"bitcount of the second byte", &core.RedisCmd{Cmd: "BITCOUNT", Args: []string{"bc", "1", "1", "BYTE"}}, []byte(":6\r\n")},
+		{"bitcount with negative indexes", &core.RedisCmd{Cmd: "BITCOUNT", Args: []string{"bc", "-2", "-1"}}, []byte(":7\r\n")},
+		{"bitcount of a bit range", &core.RedisCmd{Cmd: "BITCOUNT", Args: []string{"bc", "5", "30", "BIT"}}, []byte(":17\r\n")},
+		{"bitcount of an empty range", &core.RedisCmd{Cmd: "BITCOUNT", Args: []string{"bc", "4", "2"}}, []byte(":0\r\n")},
+		{"bitcount on a missing key", &core.RedisCmd{Cmd: "BITCOUNT", Args: []string{"nobits"}}, []byte(":0\r\n")},
+		{"bitcount with a start only", &core.RedisCmd{Cmd: "BITCOUNT", Args: []string{"bc", "1"}}, []byte("-ERR syntax error\r\n")},
+		{"bitcount with a bad unit", &core.RedisCmd{Cmd: "BITCOUNT", Args: []string{"bc", "1", "2", "WORD"}}, []byte("-ERR syntax error\r\n")},
+	})
+}
+
+func TestBitPos(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"set ones first", &core.RedisCmd{Cmd: "SET", Args: []string{"bp", "\xff\xf0\x00"}}, []byte("+OK\r\n")},
+		{"first clear bit", &core.RedisCmd{Cmd: "BITPOS", Args: []string{"bp", "0"}}, []byte(":12\r\n")},
+		{"set zeros first", &core.RedisCmd{Cmd: "SET", Args: []string{"bp", "\x00\xff\xf0"}}, []byte("+OK\r\n")},
+		{"first set bit from the first byte", &core.RedisCmd{Cmd: "BITPOS", Args: []string{"bp", "1", "0"}}, []byte(":8\r\n")},
+		{"first set bit from the third byte", &core.RedisCmd{Cmd: "BITPOS", Args: []string{"bp", "1", "2"}}, []byte(":16\r\n")},
+		{"first set bit of a byte range", &core.RedisCmd{Cmd: "BITPOS", Args: []string{"bp", "1", "2", "-1", "BYTE"}}, []byte(":16\r\n")},
+		{"first set bit of a bit range", &core.RedisCmd{Cmd: "BITPOS", Args: []string{"bp", "1", "7", "15", "BIT"}}, []byte(":8\r\n")},
+		{"set all ones", &core.RedisCmd{Cmd: "SET", Args: []string{"bp", "\xff\xff\xff"}}, []byte("+OK\r\n")},
+		{"clear bit past the end of the string", &core.RedisCmd{Cmd: "BITPOS", Args: []string{"bp", "0"}}, []byte(":24\r\n")},
+		{"no clear bit in an explicit range", &core.RedisCmd{Cmd: "BITPOS", Args: []string{"bp", "0", "0", "-1"}}, []byte(":-1\r\n")},
+		{"set all zeros", &core.RedisCmd{Cmd: "SET", Args: []string{"bp", "\x00\x00\x00"}}, []byte("+OK\r\n")},
+		{"no set bit", &core.RedisCmd{Cmd: "BITPOS", Args: []string{"bp", "1"}}, []byte(":-1\r\n")},
+		{"set bit on a missing key", &core.RedisCmd{Cmd: "BITPOS", Args: []string{"nobits", "1"}}, []byte(":-1\r\n")},
+		{"clear bit on a missing key", &core.RedisCmd{Cmd: "BITPOS", Args: []string{"nobits", "0"}}, []byte(":0\r\n")},
+		{"bad bit", &core.RedisCmd{Cmd: "BITPOS", Args: []string{"bp", "2"}}, []byte("-ERR The bit argument must be 1 or 0.\r\n")},
+	})
+}
+
+func TestBitOp(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"set key1", &core.RedisCmd{Cmd: "SET", Args: []string{"key1", "foobar"}}, []byte("+OK\r\n")},
+		{"set key2", &core.RedisCmd{Cmd: "SET", Args: []string{"key2", "abcdef"}}, []byte("+OK\r\n")},
+		{"bitop and", &core.RedisCmd{Cmd: "BITOP", Args: []string{"AND", "dest", "key1", "key2"}}, []byte(":6\r\n")},
+		{"and result", &core.RedisCmd{Cmd: "GET", Args: []string{"dest"}}, []byte("$6\r\n`bc`ab\r\n")},
+		{"bitop or with a shorter string", &core.RedisCmd{Cmd: "SET", Args: []string{"short", "\x01"}}, []byte("+OK\r\n")},
+		{"bitop or", &core.RedisCmd{Cmd: "BITOP", Args: []string{"OR", "dest", "short", "nobits", "key2"}}, []byte(":6\r\n")},
+		{"or result", &core.RedisCmd{Cmd: "GET", Args: []string{"dest"}}, []byte("$6\r\nabcdef\r\n")},
+		{"bitop and with a shorter string", &core.RedisCmd{Cmd: "BITOP", Args: []string{"AND", "dest", "key1", "short"}}, []byte(":6\r\n")},
+		{"and clears the tail of the shorter string", &core.RedisCmd{Cmd: "GET", Args: []string{"dest"}}, []byte("$6\r\n\x00\x00\x00\x00\x00\x00\r\n")},
+		{"bitop xor starting with a shorter string", &core.RedisCmd{Cmd: "BITOP", Args: []string{"XOR", "dest", "short", "key2"}}, []byte(":6\r\n")},
+		{"xor keeps the tail of the longer string", &core.RedisCmd{Cmd: "GET", Args: []string{"dest"}}, []byte("$6\r\n`bcdef\r\n")},
+		{"bitop xor with itself", &core.RedisCmd{Cmd: "BITOP", Args: []string{"XOR", "dest", "key1", "key1"}}, []byte(":6\r\n")},
+		{"xor result", &core.RedisCmd{Cmd: "GET", Args: []string{"dest"}}, []byte("$6\r\n\x00\x00\x00\x00\x00\x00\r\n")},
+		{"bitop not", &core.RedisCmd{Cmd: "BITOP", Args: []string{"NOT", "dest", "short"}}, []byte(":1\r\n")},
+		{"not result", &core.RedisCmd{Cmd: "GET", Args: []string{"dest"}}, []byte("$1\r\n\xfe\r\n")},
+		{"bitop not of two keys", &core.RedisCmd{Cmd: "BITOP", Args: []string{"NOT", "dest", "key1", "key2"}}, []byte("-ERR BITOP NOT must be called with a single source key.\r\n")},
+		{"bitop of missing keys deletes the destination", &core.RedisCmd{Cmd: "BITOP", Args: []string{"AND", "dest", "nobits"}}, []byte(":0\r\n")},
+		{"destination is gone", &core.RedisCmd{Cmd: "TYPE", Args: []string{"dest"}}, []byte("+none\r\n")},
+		{"bitop with an unknown operation", &core.RedisCmd{Cmd: "BITOP", Args: []string{"NAND", "dest", "key1"}}, []byte("-ERR syntax error\r\n")},
+	})
+}
+
+func TestBitField(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"signed set", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"bf", "SET", "i8", "0", "-100"}}, []byte("*1\r\n:0\r\n")},
+		{"signed set returns the old value", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"bf", "SET", "i8", "0", "101"}}, []byte("*1\r\n:-100\r\n")},
+		{"signed get", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"bf", "GET", "i8", "0"}}, []byte("*1\r\n:101\r\n")},
+		{"unsigned fields with the # form", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"abc", "SET", "u8", "#0", "65", "SET", "u8", "#1", "66", "SET", "u8", "#2", "67"}}, []byte("*3\r\n:0\r\n:0\r\n:0\r\n")},
+		{"# form writes whole bytes", &core.RedisCmd{Cmd: "GET", Args: []string{"abc"}}, []byte("$3\r\nABC\r\n")},
+		{"chained commands", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"chain", "SET", "u8", "#0", "10", "INCRBY", "u8", "#0", "100", "INCRBY", "u8", "#0", "100"}}, []byte("*3\r\n:0\r\n:110\r\n:210\r\n")},
+		{"unsigned wrap", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"chain", "SET", "u8", "#0", "100", "INCRBY", "u8", "#0", "257", "INCRBY", "u8", "#0", "255"}}, []byte("*3\r\n:210\r\n:101\r\n:100\r\n")},
+		{"unsigned sat", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"chain", "OVERFLOW", "SAT", "INCRBY", "u8", "#0", "257", "INCRBY", "u8", "#0", "-255"}}, []byte("*2\r\n:255\r\n:0\r\n")},
+		{"signed wrap", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"signed", "SET", "i8", "0", "100", "INCRBY", "i8", "0", "257", "INCRBY", "i8", "0", "255"}}, []byte("*3\r\n:0\r\n:101\r\n:100\r\n")},
+		{"signed sat", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"signed", "OVERFLOW", "SAT", "INCRBY", "i8", "0", "257", "INCRBY", "i8", "0", "-255"}}, []byte("*2\r\n:127\r\n:-128\r\n")},
+		{"fail leaves the field untouched", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"small", "OVERFLOW", "FAIL", "INCRBY", "u2", "100", "3", "INCRBY", "u2", "100", "1", "GET", "u2", "100"}}, []byte("*3\r\n:3\r\n$-1\r\n:3\r\n")},
+		{"set with a value out of range wraps", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"small", "SET", "u2", "100", "5", "GET", "u2", "100"}}, []byte("*2\r\n:3\r\n:1\r\n")},
+		{"i64 fields", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"wide", "SET", "i64", "0", "-1", "GET", "u63", "0", "GET", "i64", "0"}}, []byte("*3\r\n:0\r\n:9223372036854775807\r\n:-1\r\n")},
+		{"get on a missing key", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"nobits", "GET", "u8", "0"}}, []byte("*1\r\n:0\r\n")},
+		{"gets do not create the key", &core.RedisCmd{Cmd: "TYPE", Args: []string{"nobits"}}, []byte("+none\r\n")},
+		{"u64 is not supported", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"bf", "GET", "u64", "0"}}, []byte("-ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.\r\n")},
+		{"bad overflow", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"bf", "OVERFLOW", "CLAMP"}}, []byte("-ERR Invalid OVERFLOW type specified\r\n")},
+		{"missing arguments", &core.RedisCmd{Cmd: "BITFIELD", Args: []string{"bf", "GET", "i8"}}, []byte("-ERR syntax error\r\n")},
+		{"bitfield_ro get", &core.RedisCmd{Cmd: "BITFIELD_RO", Args: []string{"abc", "GET", "u8", "8"}}, []byte("*1\r\n:66\r\n")},
+		{"bitfield_ro refuses writes", &core.RedisCmd{Cmd: "BITFIELD_RO", Args: []string{"abc", "SET", "u8", "8", "1"}}, []byte("-ERR BITFIELD_RO only supports the GET subcommand\r\n")},
+	})
+}
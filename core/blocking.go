@@ -0,0 +1,147 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// blockedClient is a client parked by a blocking command until one of its keys can serve it or its timeout expires
+type blockedClient struct {
+	c        io.ReadWriter
+	keys     []string
+	deadline time.Time
+	// serve retries the command against the key that became ready and returns its reply,
+	// nil when the client has to keep waiting
+	serve        func(key string) []byte
+	timeoutReply []byte
+}
+
+// clients waiting on a key are served in the order they blocked
+var blockedOnKey = make(map[string][]*blockedClient)
+var blockedClients = make(map[io.ReadWriter]*blockedClient)
+
+// keys that received data since the last time the blocked clients were served
+var readyKeys []string
+var readyKeysSet = make(map[string]bool)
+
+// parseBlockingTimeout parses the timeout of blocking commands, expressed in seconds, 0 blocks forever
+func parseBlockingTimeout(s string) (time.Duration, error) {
+	timeout, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		return 0, errors.New("ERR timeout is not a float or out of range")
+	}
+	if timeout < 0 {
+		return 0, errors.New("ERR timeout is negative")
+	}
+	return time.Duration(timeout * float64(time.Second)), nil
+}
+
+// blockClient parks the client on the keys, the keys are kept as sent by the client so that replies echo them back
+func blockClient(c io.ReadWriter, keys []string, timeout time.Duration, serve func(key string) []byte, timeoutReply []byte) {
+	if bc, ok := blockedClients[c]; ok {
+		unblockClient(bc)
+	}
+
+	bc := &blockedClient{
+		c:            c,
+		keys:         keys,
+		serve:        serve,
+		timeoutReply: timeoutReply,
+	}
+	if timeout > 0 {
+		bc.deadline = time.Now().Add(timeout)
+	}
+	for _, k := range keys {
+		key := strings.ToUpper(k)
+		blockedOnKey[key] = append(blockedOnKey[key], bc)
+	}
+	blockedClients[c] = bc
+}
+
+func unblockClient(bc *blockedClient) {
+	for _, k := range bc.keys {
+		key := strings.ToUpper(k)
+		queue := blockedOnKey[key]
+		for i, waiting := range queue {
+			if waiting == bc {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(blockedOnKey, key)
+		} else {
+			blockedOnKey[key] = queue
+		}
+	}
+	delete(blockedClients, bc.c)
+}
+
+// IsClientBlocked tells whether the client waits for a blocking command to be served, the commands it sent
+// after that one are held until it is
+func IsClientBlocked(c io.ReadWriter) bool {
+	_, ok := blockedClients[c]
+	return ok
+}
+
+// keyName returns the key as sent by the client
+func (bc *blockedClient) keyName(key string) string {
+	for _, k := range bc.keys {
+		if strings.ToUpper(k) == key {
+			return k
+		}
+	}
+	return key
+}
+
+// signalKeyAsReady records that the key received data that may serve the clients blocked on it
+func signalKeyAsReady(k string) {
+	key := strings.ToUpper(k)
+	if len(blockedOnKey[key]) == 0 || readyKeysSet[key] {
+		return
+	}
+	readyKeysSet[key] = true
+	readyKeys = append(readyKeys, key)
+}
+
+// handleClientsBlockedOnKeys serves the clients blocked on the keys that became ready while executing a command,
+// serving a client can make more keys ready, like the destination of BLMOVE, so it runs until none is left
+func handleClientsBlockedOnKeys() {
+	for len(readyKeys) > 0 {
+		keys := readyKeys
+		readyKeys = nil
+		readyKeysSet = make(map[string]bool)
+
+		for _, key := range keys {
+			queue := append([]*blockedClient{}, blockedOnKey[key]...)
+			for _, bc := range queue {
+				// the client may have been served through another of its keys
+				if blockedClients[bc.c] != bc {
+					continue
+				}
+				reply := bc.serve(bc.keyName(key))
+				if reply == nil {
+					continue
+				}
+				unblockClient(bc)
+				bc.c.Write(reply)
+			}
+		}
+	}
+}
+
+// HandleBlockedClientsTimeout answers the blocked clients whose timeout expired, it is run by the server cron
+func HandleBlockedClientsTimeout() {
+	now := time.Now()
+	for _, bc := range blockedClients {
+		if bc.deadline.IsZero() || now.Before(bc.deadline) {
+			continue
+		}
+		unblockClient(bc)
+		bc.c.Write(bc.timeoutReply)
+	}
+}
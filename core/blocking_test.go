@@ -0,0 +1,162 @@
+package core_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/diceclone/core"
+)
+
+func evalAs(c *MockReadWriter, cmd string, args ...string) {
+	core.EvalAndRespond(&core.RedisCmd{Cmd: cmd, Args: args}, c, core.RealTimeProvider{})
+}
+
+func expectWrite(t *testing.T, c *MockReadWriter, want string) {
+	t.Helper()
+	if !bytes.Equal(c.LastWrite, []byte(want)) {
+		t.Errorf("got %q, want %q", string(c.LastWrite), want)
+	}
+}
+
+func TestBlockingPopServesImmediately(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"push to a list", &core.RedisCmd{Cmd: "RPUSH", Args: []string{"blist", "a", "b", "c"}}, []byte(":3\r\n")},
+		{"blpop skips missing keys", &core.RedisCmd{Cmd: "BLPOP", Args: []string{"bmissing", "blist", "0"}}, []byte("*2\r\n$5\r\nblist\r\n$1\r\na\r\n")},
+		{"brpop from the tail", &core.RedisCmd{Cmd: "BRPOP", Args: []string{"blist", "0"}}, []byte("*2\r\n$5\r\nblist\r\n$1\r\nc\r\n")},
+		{"blmove to another list", &core.RedisCmd{Cmd: "BLMOVE", Args: []string{"blist", "bdst", "LEFT", "LEFT", "0"}}, []byte("$1\r\nb\r\n")},
+		{"lmpop with count", &core.RedisCmd{Cmd: "LMPOP", Args: []string{"2", "bmissing", "bdst", "LEFT", "COUNT", "5"}}, []byte("*2\r\n$4\r\nbdst\r\n*1\r\n$1\r\nb\r\n")},
+		{"lmpop on missing lists", &core.RedisCmd{Cmd: "LMPOP", Args: []string{"1", "bmissing", "LEFT"}}, []byte("*-1\r\n")},
+		{"negative timeout", &core.RedisCmd{Cmd: "BLPOP", Args: []string{"blist", "-1"}}, []byte("-ERR timeout is negative\r\n")},
+		{"invalid timeout", &core.RedisCmd{Cmd: "BLPOP", Args: []string{"blist", "abc"}}, []byte("-ERR timeout is not a float or out of range\r\n")},
+		{"invalid numkeys", &core.RedisCmd{Cmd: "BLMPOP", Args: []string{"0", "0", "blist", "LEFT"}}, []byte("-ERR numkeys should be greater than 0\r\n")},
+		{"invalid count", &core.RedisCmd{Cmd: "BLMPOP", Args: []string{"0", "1", "blist", "LEFT", "COUNT", "0"}}, []byte("-ERR count should be greater than 0\r\n")},
+	})
+}
+
+func TestBlockingPopWaitsForPush(t *testing.T) {
+	first, _ := setupTest()
+	second, _ := setupTest()
+	pusher, _ := setupTest()
+
+	evalAs(first, "BLPOP", "waitlist", "otherlist", "0")
+	evalAs(second, "BRPOP", "waitlist", "0")
+	if first.LastWrite != nil || second.LastWrite != nil {
+		t.Fatalf("blocked clients got a reply before a push")
+	}
+
+	// clients are served in the order they blocked, the pusher gets its reply first
+	evalAs(pusher, "RPUSH", "waitlist", "a")
+	expectWrite(t, pusher, ":1\r\n")
+	expectWrite(t, first, "*2\r\n$8\r\nwaitlist\r\n$1\r\na\r\n")
+	if second.LastWrite != nil {
+		t.Fatalf("second client served before a second push")
+	}
+
+	evalAs(pusher, "LPUSH", "waitlist", "b", "c")
+	expectWrite(t, second, "*2\r\n$8\r\nwaitlist\r\n$1\r\nb\r\n")
+	evalAs(pusher, "LRANGE", "waitlist", "0", "-1")
+	expectWrite(t, pusher, "*1\r\n$1\r\nc\r\n")
+
+	// a blocked client is not served from a key whose type it cannot pop
+	third, _ := setupTest()
+	evalAs(third, "BLMPOP", "0", "1", "typedkey", "RIGHT", "COUNT", "2")
+	evalAs(pusher, "SET", "typedkey", "v")
+	if third.LastWrite != nil {
+		t.Fatalf("client served from a string")
+	}
+	evalAs(pusher, "DEL", "typedkey")
+	evalAs(pusher, "RPUSH", "typedkey", "x", "y", "z")
+	expectWrite(t, third, "*2\r\n$8\r\ntypedkey\r\n*2\r\n$1\r\nz\r\n$1\r\ny\r\n")
+}
+
+func TestBlockingMoveChain(t *testing.T) {
+	mover, _ := setupTest()
+	popper, _ := setupTest()
+	pusher, _ := setupTest()
+
+	evalAs(mover, "BLMOVE", "chainsrc", "chaindst", "RIGHT", "LEFT", "0")
+	evalAs(popper, "BLPOP", "chaindst", "0")
+
+	// serving the mover pushes to the destination which in turn serves the popper
+	evalAs(pusher, "RPUSH", "chainsrc", "v")
+	expectWrite(t, mover, "$1\r\nv\r\n")
+	expectWrite(t, popper, "*2\r\n$8\r\nchaindst\r\n$1\r\nv\r\n")
+	evalAs(pusher, "TYPE", "chaindst")
+	expectWrite(t, pusher, "+none\r\n")
+}
+
+func TestBlockingPopTimeout(t *testing.T) {
+	c, _ := setupTest()
+	mover, _ := setupTest()
+
+	evalAs(c, "BLPOP", "timeoutlist", "0.01")
+	evalAs(mover, "BLMOVE", "timeoutlist", "dst", "LEFT", "LEFT", "0.01")
+	core.HandleBlockedClientsTimeout()
+	if c.LastWrite != nil || mover.LastWrite != nil {
+		t.Fatalf("clients timed out too early")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	core.HandleBlockedClientsTimeout()
+	expectWrite(t, c, "*-1\r\n")
+	expectWrite(t, mover, "$-1\r\n")
+}
+
+func TestBlockedClientDisconnect(t *testing.T) {
+	c, _ := setupTest()
+	pusher, _ := setupTest()
+
+	evalAs(c, "BLPOP", "gonelist", "0")
+	core.DisconnectClient(c)
+
+	evalAs(pusher, "RPUSH", "gonelist", "a")
+	if c.LastWrite != nil {
+		t.Fatalf("disconnected client was served")
+	}
+	evalAs(pusher, "LLEN", "gonelist")
+	expectWrite(t, pusher, ":1\r\n")
+}
+
+func TestBlockingZsetPops(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "ZADD", "bzset", "1", "a", "2", "b", "3", "c")
+	evalAs(c, "BZPOPMIN", "bzmissing", "bzset", "0")
+	expectWrite(t, c, "*3\r\n$5\r\nbzset\r\n$1\r\na\r\n$1\r\n1\r\n")
+	evalAs(c, "BZMPOP", "0", "1", "bzset", "MAX", "COUNT", "5")
+	expectWrite(t, c, "*2\r\n$5\r\nbzset\r\n*2\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n")
+	evalAs(c, "ZMPOP", "1", "bzset", "MIN")
+	expectWrite(t, c, "*-1\r\n")
+	evalAs(c, "ZMPOP", "1", "bzset", "LEFT")
+	expectWrite(t, c, "-ERR syntax error\r\n")
+
+	maxWaiter, _ := setupTest()
+	multiWaiter, _ := setupTest()
+	producer, _ := setupTest()
+
+	// both wait on the queue, the first one is also willing to take from another key
+	evalAs(maxWaiter, "BZPOPMAX", "jobs", "otherjobs", "0")
+	evalAs(multiWaiter, "BZMPOP", "0", "1", "jobs", "MIN", "COUNT", "2")
+	if maxWaiter.LastWrite != nil || multiWaiter.LastWrite != nil {
+		t.Fatalf("blocked clients got a reply before a push")
+	}
+
+	evalAs(producer, "ZADD", "otherjobs", "7", "x")
+	expectWrite(t, maxWaiter, "*3\r\n$9\r\notherjobs\r\n$1\r\nx\r\n$1\r\n7\r\n")
+	if multiWaiter.LastWrite != nil {
+		t.Fatalf("client waiting on another key was served")
+	}
+
+	evalAs(producer, "ZADD", "jobs", "5", "low", "9", "high", "7", "mid")
+	expectWrite(t, multiWaiter, "*2\r\n$4\r\njobs\r\n*2\r\n*2\r\n$3\r\nlow\r\n$1\r\n5\r\n*2\r\n$3\r\nmid\r\n$1\r\n7\r\n")
+	evalAs(producer, "ZRANGE", "jobs", "0", "-1")
+	expectWrite(t, producer, "*1\r\n$4\r\nhigh\r\n")
+
+	timedOut, _ := setupTest()
+	evalAs(timedOut, "BZPOPMIN", "nojobs", "0.01")
+	time.Sleep(20 * time.Millisecond)
+	core.HandleBlockedClientsTimeout()
+	expectWrite(t, timedOut, "*-1\r\n")
+}
@@ -0,0 +1,246 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Scalable Bloom filters stack layers of growing capacity: once the last layer holds as many items as it
+// was sized for a new one, EXPANSION times larger and with half the error rate, is added on top, which keeps
+// the compound false positive rate under the requested one however many items the filter ends up holding
+const (
+	BLOOM_TIGHTENING_RATIO = 0.5
+	// no single layer or cuckoo sub filter may grow past the largest bulk string a client can send
+	FILTER_MAX_BYTES = 512 << 20
+	// SCANDUMP replies the byte arrays of a filter in chunks of at most this size
+	FILTER_DUMP_CHUNK_SIZE = 1 << 20
+)
+
+var errBloomNotFound = errors.New("ERR not found")
+var errBloomExists = errors.New("ERR item exists")
+var errBloomErrorRate = errors.New("ERR (0 < error rate range < 1)")
+var errBloomCapacity = errors.New("ERR (capacity should be larger than 0)")
+var errBloomExpansion = errors.New("ERR expansion should be greater or equal to 1")
+var errBloomNonScalingExpansion = errors.New("ERR Nonscaling filters cannot expand")
+var errBloomFull = errors.New("ERR non scaling filter is full")
+var errFilterTooLarge = errors.New("ERR filter is too large")
+var errFilterBadData = errors.New("ERR received bad data")
+var errFilterIterator = errors.New("ERR Invalid iterator")
+
+type bloomLayer struct {
+	bits     []byte
+	numBits  uint64
+	hashes   int
+	capacity int64
+	items    int64
+}
+
+type bloomFilter struct {
+	layers    []*bloomLayer
+	errorRate float64
+	// expansion is 0 for non scaling filters
+	expansion int64
+}
+
+// newBloomLayer sizes a layer for capacity items at the error rate with the optimal number of bits per
+// item, -ln(error) / ln(2)^2, and of hash functions, ln(2) bits per item
+func newBloomLayer(capacity int64, errorRate float64) (*bloomLayer, error) {
+	bitsPerItem := -math.Log(errorRate) / (math.Ln2 * math.Ln2)
+	bits := math.Ceil(float64(capacity) * bitsPerItem)
+	if bits > FILTER_MAX_BYTES*8 {
+		return nil, errFilterTooLarge
+	}
+	size := (uint64(bits) + 7) / 8
+	return &bloomLayer{
+		bits:     make([]byte, size),
+		numBits:  size * 8,
+		hashes:   int(math.Ceil(math.Ln2 * bitsPerItem)),
+		capacity: capacity,
+	}, nil
+}
+
+func newBloomFilter(errorRate float64, capacity int64, expansion int64) (*bloomFilter, error) {
+	layer, err := newBloomLayer(capacity, errorRate)
+	if err != nil {
+		return nil, err
+	}
+	return &bloomFilter{layers: []*bloomLayer{layer}, errorRate: errorRate, expansion: expansion}, nil
+}
+
+// bloomHashes derives the hash functions of an item from two murmur hashes, the i-th one being a + i * b
+func bloomHashes(item string) (uint64, uint64) {
+	a := murmurHash64A([]byte(item), 0xc6a4a7935bd1e995)
+	return a, murmurHash64A([]byte(item), a)
+}
+
+func (l *bloomLayer) test(a uint64, b uint64) bool {
+	for i := 0; i < l.hashes; i++ {
+		pos := (a + uint64(i)*b) % l.numBits
+		if l.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *bloomLayer) add(a uint64, b uint64) {
+	for i := 0; i < l.hashes; i++ {
+		pos := (a + uint64(i)*b) % l.numBits
+		l.bits[pos/8] |= 1 << (pos % 8)
+	}
+	l.items++
+}
+
+func (bf *bloomFilter) exists(item string) bool {
+	a, b := bloomHashes(item)
+	for _, l := range bf.layers {
+		if l.test(a, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// add returns false when the item may already be in the filter, new items go to the last layer, which
+// gets a successor when it is full
+func (bf *bloomFilter) add(item string) (bool, error) {
+	a, b := bloomHashes(item)
+	for _, l := range bf.layers {
+		if l.test(a, b) {
+			return false, nil
+		}
+	}
+
+	last := bf.layers[len(bf.layers)-1]
+	if last.items >= last.capacity {
+		if bf.expansion == 0 {
+			return false, errBloomFull
+		}
+		if last.capacity > math.MaxInt64/bf.expansion {
+			return false, errFilterTooLarge
+		}
+		errorRate := bf.errorRate * math.Pow(BLOOM_TIGHTENING_RATIO, float64(len(bf.layers)))
+		layer, err := newBloomLayer(last.capacity*bf.expansion, errorRate)
+		if err != nil {
+			return false, err
+		}
+		bf.layers = append(bf.layers, layer)
+		last = layer
+	}
+	last.add(a, b)
+	return true, nil
+}
+
+func (bf *bloomFilter) capacity() int64 {
+	var n int64
+	for _, l := range bf.layers {
+		n += l.capacity
+	}
+	return n
+}
+
+func (bf *bloomFilter) items() int64 {
+	var n int64
+	for _, l := range bf.layers {
+		n += l.items
+	}
+	return n
+}
+
+func (bf *bloomFilter) size() int64 {
+	var n int64
+	for _, l := range bf.layers {
+		n += int64(len(l.bits))
+	}
+	return n
+}
+
+// header serializes everything but the bits of the layers: the error rate, the expansion and the number
+// of layers followed by the capacity, item count, size in bits and number of hashes of each of them
+func (bf *bloomFilter) header() []byte {
+	b := binary.LittleEndian.AppendUint64(nil, math.Float64bits(bf.errorRate))
+	b = binary.LittleEndian.AppendUint64(b, uint64(bf.expansion))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(bf.layers)))
+	for _, l := range bf.layers {
+		b = binary.LittleEndian.AppendUint64(b, uint64(l.capacity))
+		b = binary.LittleEndian.AppendUint64(b, uint64(l.items))
+		b = binary.LittleEndian.AppendUint64(b, l.numBits)
+		b = binary.LittleEndian.AppendUint32(b, uint32(l.hashes))
+	}
+	return b
+}
+
+// bloomFromHeader allocates the empty filter a header describes, its bits are loaded by the chunks that follow
+func bloomFromHeader(b []byte) (*bloomFilter, error) {
+	if len(b) < 20 {
+		return nil, errFilterBadData
+	}
+	bf := &bloomFilter{
+		errorRate: math.Float64frombits(binary.LittleEndian.Uint64(b)),
+		expansion: int64(binary.LittleEndian.Uint64(b[8:])),
+	}
+	count := int(binary.LittleEndian.Uint32(b[16:]))
+	b = b[20:]
+	if !(bf.errorRate > 0 && bf.errorRate < 1) || bf.expansion < 0 || count == 0 || len(b) != count*28 {
+		return nil, errFilterBadData
+	}
+
+	for i := 0; i < count; i++ {
+		l := &bloomLayer{
+			capacity: int64(binary.LittleEndian.Uint64(b)),
+			items:    int64(binary.LittleEndian.Uint64(b[8:])),
+			numBits:  binary.LittleEndian.Uint64(b[16:]),
+			hashes:   int(binary.LittleEndian.Uint32(b[24:])),
+		}
+		b = b[28:]
+		if l.capacity <= 0 || l.items < 0 || l.numBits == 0 || l.numBits%8 != 0 || l.numBits > FILTER_MAX_BYTES*8 || l.hashes == 0 {
+			return nil, errFilterBadData
+		}
+		l.bits = make([]byte, l.numBits/8)
+		bf.layers = append(bf.layers, l)
+	}
+	return bf, nil
+}
+
+func (bf *bloomFilter) segments() [][]byte {
+	segs := make([][]byte, len(bf.layers))
+	for i, l := range bf.layers {
+		segs[i] = l.bits
+	}
+	return segs
+}
+
+// dumpChunk returns the chunk of the concatenated segments SCANDUMP replies for the iterator, which is one
+// past the offset of the chunk, along with the iterator of the next chunk, 0 once every byte was dumped
+func dumpChunk(segs [][]byte, iter int64) (int64, []byte) {
+	offset := iter - 1
+	var base int64
+	for _, seg := range segs {
+		if offset < base+int64(len(seg)) {
+			end := min(int64(len(seg)), offset-base+FILTER_DUMP_CHUNK_SIZE)
+			return base + end + 1, seg[offset-base : end]
+		}
+		base += int64(len(seg))
+	}
+	return 0, nil
+}
+
+// loadChunk copies back a chunk dumped with the iterator that followed it, chunks never span two segments
+func loadChunk(segs [][]byte, iter int64, data []byte) error {
+	offset := iter - 1 - int64(len(data))
+	if offset < 0 {
+		return errFilterBadData
+	}
+	for _, seg := range segs {
+		if offset < int64(len(seg)) {
+			if offset+int64(len(data)) > int64(len(seg)) {
+				return errFilterBadData
+			}
+			copy(seg[offset:], data)
+			return nil
+		}
+		offset -= int64(len(seg))
+	}
+	return errFilterBadData
+}
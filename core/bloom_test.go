@@ -0,0 +1,154 @@
+package core_test
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/diceclone/config"
+	"github.com/diceclone/core"
+)
+
+func TestBloomFilterCommands(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"bf.reserve", &core.RedisCmd{Cmd: "BF.RESERVE", Args: []string{"bf", "0.01", "1000"}}, []byte("+OK\r\n")},
+		{"bf.reserve an existing filter", &core.RedisCmd{Cmd: "BF.RESERVE", Args: []string{"bf", "0.01", "1000"}}, []byte("-ERR item exists\r\n")},
+		{"bf.add", &core.RedisCmd{Cmd: "BF.ADD", Args: []string{"bf", "apple"}}, []byte(":1\r\n")},
+		{"bf.add an item already added", &core.RedisCmd{Cmd: "BF.ADD", Args: []string{"bf", "apple"}}, []byte(":0\r\n")},
+		{"bf.madd", &core.RedisCmd{Cmd: "BF.MADD", Args: []string{"bf", "pear", "apple", "plum"}}, []byte("*3\r\n:1\r\n:0\r\n:1\r\n")},
+		{"bf.exists", &core.RedisCmd{Cmd: "BF.EXISTS", Args: []string{"bf", "pear"}}, []byte(":1\r\n")},
+		{"bf.exists an item never added", &core.RedisCmd{Cmd: "BF.EXISTS", Args: []string{"bf", "cherry"}}, []byte(":0\r\n")},
+		{"bf.exists on a missing key", &core.RedisCmd{Cmd: "BF.EXISTS", Args: []string{"nobf", "pear"}}, []byte(":0\r\n")},
+		{"bf.mexists", &core.RedisCmd{Cmd: "BF.MEXISTS", Args: []string{"bf", "plum", "cherry"}}, []byte("*2\r\n:1\r\n:0\r\n")},
+		{"bf.info", &core.RedisCmd{Cmd: "BF.INFO", Args: []string{"bf"}}, []byte("*10\r\n$8\r\nCapacity\r\n:1000\r\n$4\r\nSize\r\n:1199\r\n$17\r\nNumber of filters\r\n:1\r\n$24\r\nNumber of items inserted\r\n:3\r\n$14\r\nExpansion rate\r\n:2\r\n")},
+		{"bf.info of a single field", &core.RedisCmd{Cmd: "BF.INFO", Args: []string{"bf", "items"}}, []byte("*1\r\n:3\r\n")},
+		{"bf.info on a missing key", &core.RedisCmd{Cmd: "BF.INFO", Args: []string{"nobf"}}, []byte("-ERR not found\r\n")},
+		{"type of a bloom filter", &core.RedisCmd{Cmd: "TYPE", Args: []string{"bf"}}, []byte("+MBbloom--\r\n")},
+		{"bf.add creates a default filter", &core.RedisCmd{Cmd: "BF.ADD", Args: []string{"auto", "x"}}, []byte(":1\r\n")},
+		{"default capacity", &core.RedisCmd{Cmd: "BF.INFO", Args: []string{"auto", "CAPACITY"}}, []byte("*1\r\n:100\r\n")},
+		{"bf.reserve with an error rate out of range", &core.RedisCmd{Cmd: "BF.RESERVE", Args: []string{"bad", "1", "100"}}, []byte("-ERR (0 < error rate range < 1)\r\n")},
+		{"bf.reserve with an empty capacity", &core.RedisCmd{Cmd: "BF.RESERVE", Args: []string{"bad", "0.1", "0"}}, []byte("-ERR (capacity should be larger than 0)\r\n")},
+		{"bf.reserve with a null expansion", &core.RedisCmd{Cmd: "BF.RESERVE", Args: []string{"bad", "0.1", "10", "EXPANSION", "0"}}, []byte("-ERR expansion should be greater or equal to 1\r\n")},
+		{"bf.reserve nonscaling with an expansion", &core.RedisCmd{Cmd: "BF.RESERVE", Args: []string{"bad", "0.1", "10", "EXPANSION", "2", "NONSCALING"}}, []byte("-ERR Nonscaling filters cannot expand\r\n")},
+		{"bf.add on a string", &core.RedisCmd{Cmd: "SET", Args: []string{"plain", "v"}}, []byte("+OK\r\n")},
+		{"bf.add on the wrong type", &core.RedisCmd{Cmd: "BF.ADD", Args: []string{"plain", "x"}}, []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")},
+	})
+}
+
+func TestBloomFilterScaling(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "BF.RESERVE", "scaling", "0.01", "100", "EXPANSION", "4")
+	for i := 0; i < 2000; i++ {
+		evalAs(c, "BF.ADD", "scaling", "item:"+strconv.Itoa(i))
+	}
+	evalAs(c, "BF.INFO", "scaling", "FILTERS")
+	expectWrite(t, c, "*1\r\n:3\r\n")
+	evalAs(c, "BF.INFO", "scaling", "CAPACITY")
+	expectWrite(t, c, "*1\r\n:2100\r\n")
+
+	for i := 0; i < 2000; i++ {
+		evalAs(c, "BF.EXISTS", "scaling", "item:"+strconv.Itoa(i))
+		expectWrite(t, c, ":1\r\n")
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		evalAs(c, "BF.EXISTS", "scaling", "other:"+strconv.Itoa(i))
+		if string(c.LastWrite) == ":1\r\n" {
+			falsePositives++
+		}
+	}
+	if falsePositives > 200 {
+		t.Errorf("%d false positives out of 10000, want at most 1%% of them", falsePositives)
+	}
+
+	evalAs(c, "BF.RESERVE", "fixed", "0.01", "10", "NONSCALING")
+	for i := 0; i < 10; i++ {
+		evalAs(c, "BF.ADD", "fixed", "item:"+strconv.Itoa(i))
+	}
+	evalAs(c, "BF.ADD", "fixed", "one too many")
+	expectWrite(t, c, "-ERR non scaling filter is full\r\n")
+	evalAs(c, "BF.MADD", "fixed", "item:0", "one too many")
+	expectWrite(t, c, "*2\r\n:0\r\n-ERR non scaling filter is full\r\n")
+}
+
+// scanDump collects the chunks SCANDUMP replies for the filter, starting with its header
+func scanDump(t *testing.T, c *MockReadWriter, cmd string, key string) ([]string, []string) {
+	t.Helper()
+	var iters, chunks []string
+	for iter := "0"; ; {
+		evalAs(c, cmd, key, iter)
+		reply, err := core.Decode(c.LastWrite)
+		if err != nil {
+			t.Fatalf("cannot decode %q: %v", c.LastWrite, err)
+		}
+		pair := reply.([]interface{})
+		iter = strconv.FormatInt(pair[0].(int64), 10)
+		if iter == "0" {
+			return iters, chunks
+		}
+		iters = append(iters, iter)
+		chunks = append(chunks, pair[1].(string))
+	}
+}
+
+func TestBFScanDumpAndLoadChunk(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "BF.RESERVE", "src", "0.001", "50", "EXPANSION", "3")
+	for i := 0; i < 250; i++ {
+		evalAs(c, "BF.ADD", "src", "item:"+strconv.Itoa(i))
+	}
+	evalAs(c, "BF.INFO", "src")
+	info := string(c.LastWrite)
+
+	iters, chunks := scanDump(t, c, "BF.SCANDUMP", "src")
+	if len(chunks) != 4 {
+		t.Fatalf("got %d chunks, want the header and one chunk per layer", len(chunks))
+	}
+	for i := range chunks {
+		evalAs(c, "BF.LOADCHUNK", "dst", iters[i], chunks[i])
+		expectWrite(t, c, "+OK\r\n")
+	}
+	evalAs(c, "BF.INFO", "dst")
+	expectWrite(t, c, info)
+	for i := 0; i < 250; i++ {
+		evalAs(c, "BF.EXISTS", "dst", "item:"+strconv.Itoa(i))
+		expectWrite(t, c, ":1\r\n")
+	}
+
+	evalAs(c, "BF.LOADCHUNK", "dst", "1", chunks[0])
+	expectWrite(t, c, "-ERR item exists\r\n")
+	evalAs(c, "BF.LOADCHUNK", "other", "1", "garbage")
+	expectWrite(t, c, "-ERR received bad data\r\n")
+	evalAs(c, "BF.LOADCHUNK", "dst", "100000", "chunk")
+	expectWrite(t, c, "-ERR received bad data\r\n")
+	evalAs(c, "BF.SCANDUMP", "nobf", "0")
+	expectWrite(t, c, "-ERR not found\r\n")
+}
+
+func TestFilterRewriteAOF(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "BF.ADD", "aofbloom", "apple")
+	evalAs(c, "CF.ADD", "aofcuckoo", "apple")
+	evalAs(c, "BGREWRITEAOF")
+
+	content, _ := os.ReadFile(config.APPEND_ONLY_FILE)
+	os.Remove(config.APPEND_ONLY_FILE)
+	for _, want := range []string{
+		"*4\r\n$12\r\nBF.LOADCHUNK\r\n$8\r\nAOFBLOOM\r\n$1\r\n1\r\n",
+		"*4\r\n$12\r\nCF.LOADCHUNK\r\n$9\r\nAOFCUCKOO\r\n$1\r\n1\r\n",
+	} {
+		if !bytes.Contains(content, []byte(want)) {
+			t.Errorf("AOF content misses %q:\n%q", want, content)
+		}
+	}
+	// only the chunks holding set bits are rewritten
+	if n := bytes.Count(content, []byte("LOADCHUNK")); n != 4 {
+		t.Errorf("got %d LOADCHUNK commands, want 4", n)
+	}
+}
@@ -0,0 +1,86 @@
+package core_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/diceclone/core"
+)
+
+func TestCountMinSketchCommands(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"cms.initbydim", &core.RedisCmd{Cmd: "CMS.INITBYDIM", Args: []string{"cms", "2000", "5"}}, []byte("+OK\r\n")},
+		{"cms.initbydim an existing sketch", &core.RedisCmd{Cmd: "CMS.INITBYDIM", Args: []string{"cms", "2000", "5"}}, []byte("-ERR CMS: key already exists\r\n")},
+		{"cms.incrby", &core.RedisCmd{Cmd: "CMS.INCRBY", Args: []string{"cms", "/home", "5", "/about", "2"}}, []byte("*2\r\n:5\r\n:2\r\n")},
+		{"cms.incrby an item again", &core.RedisCmd{Cmd: "CMS.INCRBY", Args: []string{"cms", "/home", "3"}}, []byte("*1\r\n:8\r\n")},
+		{"cms.query", &core.RedisCmd{Cmd: "CMS.QUERY", Args: []string{"cms", "/home", "/about", "/never"}}, []byte("*3\r\n:8\r\n:2\r\n:0\r\n")},
+		{"cms.info", &core.RedisCmd{Cmd: "CMS.INFO", Args: []string{"cms"}}, []byte("*6\r\n$5\r\nwidth\r\n:2000\r\n$5\r\ndepth\r\n:5\r\n$5\r\ncount\r\n:10\r\n")},
+		{"cms.initbyprob", &core.RedisCmd{Cmd: "CMS.INITBYPROB", Args: []string{"prob", "0.001", "0.01"}}, []byte("+OK\r\n")},
+		{"cms.initbyprob dimensions", &core.RedisCmd{Cmd: "CMS.INFO", Args: []string{"prob"}}, []byte("*6\r\n$5\r\nwidth\r\n:2000\r\n$5\r\ndepth\r\n:7\r\n$5\r\ncount\r\n:0\r\n")},
+		{"type of a sketch", &core.RedisCmd{Cmd: "TYPE", Args: []string{"cms"}}, []byte("+CMSk-TYPE\r\n")},
+		{"cms.query on a missing key", &core.RedisCmd{Cmd: "CMS.QUERY", Args: []string{"nocms", "a"}}, []byte("-ERR CMS: key does not exist\r\n")},
+		{"cms.incrby on a missing key", &core.RedisCmd{Cmd: "CMS.INCRBY", Args: []string{"nocms", "a", "1"}}, []byte("-ERR CMS: key does not exist\r\n")},
+		{"cms.incrby with a negative increment", &core.RedisCmd{Cmd: "CMS.INCRBY", Args: []string{"cms", "a", "-1"}}, []byte("-ERR CMS: Cannot parse number\r\n")},
+		{"cms.incrby without an increment", &core.RedisCmd{Cmd: "CMS.INCRBY", Args: []string{"cms", "a"}}, []byte("-ERR wrong number of arguments for 'cms.incrby' command\r\n")},
+		{"cms.initbydim with a null width", &core.RedisCmd{Cmd: "CMS.INITBYDIM", Args: []string{"bad", "0", "5"}}, []byte("-ERR CMS: invalid width/depth\r\n")},
+		{"cms.initbyprob with an invalid error", &core.RedisCmd{Cmd: "CMS.INITBYPROB", Args: []string{"bad", "2", "0.01"}}, []byte("-ERR CMS: invalid overestimation value\r\n")},
+		{"cms.initbyprob with an invalid probability", &core.RedisCmd{Cmd: "CMS.INITBYPROB", Args: []string{"bad", "0.01", "0"}}, []byte("-ERR CMS: invalid prob value\r\n")},
+	})
+}
+
+func TestCMSMerge(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	for _, key := range []string{"a", "b", "dst"} {
+		evalAs(c, "CMS.INITBYDIM", key, "1000", "4")
+	}
+	evalAs(c, "CMS.INCRBY", "a", "x", "3", "y", "1")
+	evalAs(c, "CMS.INCRBY", "b", "x", "2", "z", "7")
+
+	evalAs(c, "CMS.MERGE", "dst", "2", "a", "b")
+	expectWrite(t, c, "+OK\r\n")
+	evalAs(c, "CMS.QUERY", "dst", "x", "y", "z")
+	expectWrite(t, c, "*3\r\n:5\r\n:1\r\n:7\r\n")
+
+	evalAs(c, "CMS.MERGE", "dst", "2", "a", "b", "WEIGHTS", "2", "3")
+	expectWrite(t, c, "+OK\r\n")
+	evalAs(c, "CMS.QUERY", "dst", "x", "y", "z")
+	expectWrite(t, c, "*3\r\n:12\r\n:2\r\n:21\r\n")
+	evalAs(c, "CMS.INFO", "dst")
+	expectWrite(t, c, "*6\r\n$5\r\nwidth\r\n:1000\r\n$5\r\ndepth\r\n:4\r\n$5\r\ncount\r\n:35\r\n")
+
+	// the destination may be one of the sources
+	evalAs(c, "CMS.MERGE", "a", "2", "a", "b")
+	evalAs(c, "CMS.QUERY", "a", "x")
+	expectWrite(t, c, "*1\r\n:5\r\n")
+
+	evalAs(c, "CMS.INITBYDIM", "small", "10", "4")
+	evalAs(c, "CMS.MERGE", "dst", "2", "a", "small")
+	expectWrite(t, c, "-ERR CMS: width/depth is not equal\r\n")
+	evalAs(c, "CMS.MERGE", "nodst", "1", "a")
+	expectWrite(t, c, "-ERR CMS: key does not exist\r\n")
+	evalAs(c, "CMS.MERGE", "dst", "3", "a", "b")
+	expectWrite(t, c, "-ERR CMS: invalid numkeys\r\n")
+	evalAs(c, "CMS.MERGE", "dst", "2", "a", "b", "WEIGHTS", "1")
+	expectWrite(t, c, "-ERR syntax error\r\n")
+}
+
+func TestCMSNeverUnderestimates(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "CMS.INITBYPROB", "hits", "0.01", "0.01")
+	total := 0
+	for i := 0; i < 500; i++ {
+		evalAs(c, "CMS.INCRBY", "hits", "url:"+strconv.Itoa(i), strconv.Itoa(i%10+1))
+		total += i%10 + 1
+	}
+	for i := 0; i < 500; i++ {
+		evalAs(c, "CMS.QUERY", "hits", "url:"+strconv.Itoa(i))
+		got := arrayOfIntegers(t, c)[0]
+		if want := int64(i%10 + 1); got < want || got > want+int64(total)/100 {
+			t.Errorf("estimate of url:%d is %d, want between %d and %d", i, got, want, want+int64(total)/100)
+		}
+	}
+}
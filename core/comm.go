@@ -1,6 +1,9 @@
 package core
 
-import "syscall"
+import (
+	"io"
+	"syscall"
+)
 
 type FDComm struct {
 	Fd int
@@ -13,3 +16,12 @@ func (f FDComm) Read(b []byte) (int, error) {
 func (f FDComm) Write(b []byte) (int, error) {
 	return syscall.Write(f.Fd, b)
 }
+
+// DisconnectClient releases everything the event loop holds on behalf of a client that went away
+func DisconnectClient(c io.ReadWriter) {
+	unsubscribeAll(c)
+	unwatchAll(c)
+	if bc, ok := blockedClients[c]; ok {
+		unblockClient(bc)
+	}
+}
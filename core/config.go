@@ -0,0 +1,181 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/diceclone/config"
+)
+
+// configParam exposes a runtime tunable through CONFIG GET and CONFIG SET
+type configParam struct {
+	get func() string
+	set func(value string) error
+}
+
+var configParams = map[string]*configParam{
+	"maxmemory-policy": {
+		get: func() string { return config.EVICTION_STRATEGY },
+		set: func(value string) error {
+			policy := strings.ToLower(value)
+			if !isValidEvictionPolicy(policy) {
+				return errors.New("argument must be one of the following: " + strings.Join(evictionPolicies, ", "))
+			}
+			config.EVICTION_STRATEGY = policy
+			// scores of the pooled candidates are not comparable across policies
+			InitializePool()
+			return nil
+		},
+	},
+	"notify-keyspace-events": {
+		get: func() string { return keyspaceEventsFlagsToString(notifyKeyspaceEvents) },
+		set: func(value string) error {
+			flags, ok := keyspaceEventsStringToFlags(value)
+			if !ok {
+				return errors.New("Invalid event class character. Use 'Ag$hxeKE'.")
+			}
+			notifyKeyspaceEvents = flags
+			return nil
+		},
+	},
+	"list-max-listpack-size": {
+		get: func() string { return strconv.Itoa(config.LIST_MAX_LISTPACK_SIZE) },
+		set: func(value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+			if n < -5 {
+				return errors.New("argument must be greater than or equal to -5")
+			}
+			config.LIST_MAX_LISTPACK_SIZE = n
+			return nil
+		},
+	},
+	"maxmemory-samples": intConfigParam(&config.SAMPLE_SIZE, 1),
+	"maxkeys":           intConfigParam(&config.KEYS_LIMIT, 1),
+	"lfu-log-factor":    intConfigParam(&config.LFU_LOG_FACTOR, 0),
+	"lfu-decay-time":    intConfigParam(&config.LFU_DECAY_TIME, 0),
+
+	"hash-max-listpack-entries": intConfigParam(&config.HASH_MAX_LISTPACK_ENTRIES, 0),
+	"hash-max-listpack-value":   intConfigParam(&config.HASH_MAX_LISTPACK_VALUE, 0),
+	"set-max-intset-entries":    intConfigParam(&config.SET_MAX_INTSET_ENTRIES, 0),
+	"zset-max-listpack-entries": intConfigParam(&config.ZSET_MAX_LISTPACK_ENTRIES, 0),
+	"zset-max-listpack-value":   intConfigParam(&config.ZSET_MAX_LISTPACK_VALUE, 0),
+	"stream-node-max-entries":   intConfigParam(&config.STREAM_NODE_MAX_ENTRIES, 0),
+	"stream-node-max-bytes":     intConfigParam(&config.STREAM_NODE_MAX_BYTES, 0),
+	"hll-sparse-max-bytes":      intConfigParam(&config.HLL_SPARSE_MAX_BYTES, 0),
+
+	"bf-error-rate":       floatConfigParam(&config.BF_ERROR_RATE, 0, 1),
+	"bf-initial-size":     intConfigParam(&config.BF_INITIAL_SIZE, 1),
+	"bf-expansion-factor": intConfigParam(&config.BF_EXPANSION_FACTOR, 0),
+	"cf-initial-size":     intConfigParam(&config.CF_INITIAL_SIZE, 2),
+	"cf-bucket-size":      intConfigParam(&config.CF_BUCKET_SIZE, 1),
+	"cf-max-iterations":   intConfigParam(&config.CF_MAX_ITERATIONS, 1),
+	"cf-expansion-factor": intConfigParam(&config.CF_EXPANSION_FACTOR, 0),
+
+	"ts-retention-policy": intConfigParam(&config.TS_RETENTION_POLICY, 0),
+}
+
+func intConfigParam(v *int, min int) *configParam {
+	return &configParam{
+		get: func() string { return strconv.Itoa(*v) },
+		set: func(value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+			if n < min {
+				return fmt.Errorf("argument must be greater than or equal to %d", min)
+			}
+			*v = n
+			return nil
+		},
+	}
+}
+
+// floatConfigParam accepts the floats strictly between min and max
+func floatConfigParam(v *float64, min float64, max float64) *configParam {
+	return &configParam{
+		get: func() string { return strconv.FormatFloat(*v, 'g', -1, 64) },
+		set: func(value string) error {
+			f, ok := parseFloat(value)
+			if !ok {
+				return errors.New("argument couldn't be parsed into a float")
+			}
+			if f <= min || f >= max {
+				return fmt.Errorf("argument must be between %g and %g", min, max)
+			}
+			*v = f
+			return nil
+		},
+	}
+}
+
+func evalConfig(args []string) []byte {
+	if len(args) == 0 {
+		return Encode(errWrongArgCount("config"), false)
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		return evalConfigGet(args[1:])
+	case "SET":
+		return evalConfigSet(args[1:])
+	default:
+		return Encode(fmt.Errorf("ERR unknown subcommand '%s'. Try CONFIG HELP.", args[0]), false)
+	}
+}
+
+func evalConfigGet(args []string) []byte {
+	if len(args) == 0 {
+		return Encode(errWrongArgCount("config|get"), false)
+	}
+
+	names := make([]string, 0, len(configParams))
+	for name := range configParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := []string{}
+	for _, name := range names {
+		for _, pattern := range args {
+			if matchPattern(strings.ToLower(pattern), name) {
+				result = append(result, name, configParams[name].get())
+				break
+			}
+		}
+	}
+	return Encode(result, false)
+}
+
+// SetConfig applies a parameter the way CONFIG SET does, it is used to validate the startup flags
+func SetConfig(name string, value string) error {
+	param, ok := configParams[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown option '%s'", name)
+	}
+	return param.set(value)
+}
+
+func evalConfigSet(args []string) []byte {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return Encode(errWrongArgCount("config|set"), false)
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		name := strings.ToLower(args[i])
+		param, ok := configParams[name]
+		if !ok {
+			return Encode(fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i]), false)
+		}
+		if err := param.set(args[i+1]); err != nil {
+			return Encode(fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", args[i], err), false)
+		}
+	}
+	return Encode("OK", true)
+}
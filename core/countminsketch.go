@@ -0,0 +1,83 @@
+package core
+
+import (
+	"errors"
+	"math"
+)
+
+var errCMSExists = errors.New("ERR CMS: key already exists")
+var errCMSMissing = errors.New("ERR CMS: key does not exist")
+var errCMSBadDimensions = errors.New("ERR CMS: invalid width/depth")
+var errCMSBadError = errors.New("ERR CMS: invalid overestimation value")
+var errCMSBadProbability = errors.New("ERR CMS: invalid prob value")
+var errCMSBadNumber = errors.New("ERR CMS: Cannot parse number")
+var errCMSBadNumKeys = errors.New("ERR CMS: invalid numkeys")
+var errCMSBadWeight = errors.New("ERR CMS: invalid weight value")
+var errCMSDimensionsMismatch = errors.New("ERR CMS: width/depth is not equal")
+
+// Count-Min Sketches estimate the frequency of items with depth rows of width counters: every row counts
+// the item in the counter one hash function picks, and the smallest of those counters is the estimate,
+// which can only overestimate the frequency since colliding items only ever add to the counters
+type countMinSketch struct {
+	width    uint64
+	depth    uint64
+	counters []uint32
+	// count is the sum of all the increments
+	count uint64
+}
+
+func newCountMinSketch(width uint64, depth uint64) (*countMinSketch, error) {
+	if width == 0 || depth == 0 || width > FILTER_MAX_BYTES/4/depth {
+		return nil, errCMSBadDimensions
+	}
+	return &countMinSketch{width: width, depth: depth, counters: make([]uint32, width*depth)}, nil
+}
+
+// cmsDimensions sizes a sketch overestimating by at most error times the total count with the given
+// probability of failing to
+func cmsDimensions(errorRate float64, probability float64) (uint64, uint64) {
+	width := math.Ceil(2 / errorRate)
+	depth := math.Ceil(math.Log(probability) / math.Log(0.5))
+	return uint64(width), uint64(depth)
+}
+
+func (s *countMinSketch) index(item string, row uint64) uint64 {
+	return row*s.width + murmurHash64A([]byte(item), row)%s.width
+}
+
+// incrBy adds to the counters of the item, saturating them, and returns its new estimate
+func (s *countMinSketch) incrBy(item string, incr uint64) uint64 {
+	estimate := uint64(math.MaxUint32)
+	for row := uint64(0); row < s.depth; row++ {
+		i := s.index(item, row)
+		s.counters[i] = uint32(min(uint64(s.counters[i])+incr, math.MaxUint32))
+		estimate = min(estimate, uint64(s.counters[i]))
+	}
+	s.count += incr
+	return estimate
+}
+
+func (s *countMinSketch) query(item string) uint64 {
+	estimate := uint64(math.MaxUint32)
+	for row := uint64(0); row < s.depth; row++ {
+		estimate = min(estimate, uint64(s.counters[s.index(item, row)]))
+	}
+	return estimate
+}
+
+// merge replaces the counters of the sketch with the weighted sums of the counters of the sources, which
+// all have its dimensions
+func (s *countMinSketch) merge(sources []*countMinSketch, weights []int64) {
+	var count int64
+	for i := range s.counters {
+		var sum int64
+		for j, src := range sources {
+			sum += int64(src.counters[i]) * weights[j]
+		}
+		s.counters[i] = uint32(max(0, min(sum, math.MaxUint32)))
+	}
+	for j, src := range sources {
+		count += int64(src.count) * weights[j]
+	}
+	s.count = uint64(max(0, count))
+}
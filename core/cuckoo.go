@@ -0,0 +1,255 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// Cuckoo filters store an 8 bits fingerprint of every item in one of two buckets, the second one being
+// derived from the first and the fingerprint alone so that fingerprints can be moved between their two
+// buckets, or removed, without knowing the items they stand for. When an item finds both its buckets full
+// it kicks fingerprints out to their alternate bucket, and once MAXITERATIONS kicks are not enough to make
+// room the filter grows a new sub filter EXPANSION times larger than the last one
+const (
+	CF_MAX_BUCKET_SIZE    = 255
+	CF_MAX_ITERATIONS_MAX = 65535
+	CF_MAX_EXPANSION      = 32768
+)
+
+var errCuckooNotFound = errors.New("ERR Not found")
+var errCuckooFull = errors.New("ERR Filter is full")
+var errCuckooCapacity = errors.New("ERR Capacity must be at least (BucketSize * 2)")
+var errCuckooBucketSize = errors.New("ERR Bucket size must be between 1 and 255")
+var errCuckooMaxIterations = errors.New("ERR MAXITERATIONS must be between 1 and 65535")
+var errCuckooExpansion = errors.New("ERR EXPANSION must be between 0 and 32768")
+
+type cuckooSubFilter struct {
+	numBuckets uint64
+	// fingerprints holds bucketSize slots per bucket, empty slots are 0
+	fingerprints []byte
+}
+
+type cuckooFilter struct {
+	filters       []*cuckooSubFilter
+	bucketSize    int
+	maxIterations int
+	expansion     int
+	items         int64
+	deletes       int64
+}
+
+// newCuckooFilter sizes the first sub filter with a power of two number of buckets holding at least capacity items
+func newCuckooFilter(capacity int64, bucketSize int, maxIterations int, expansion int) (*cuckooFilter, error) {
+	numBuckets := uint64(capacity+int64(bucketSize)-1) / uint64(bucketSize)
+	numBuckets = 1 << bits.Len64(numBuckets-1)
+	if numBuckets*uint64(bucketSize) > FILTER_MAX_BYTES {
+		return nil, errFilterTooLarge
+	}
+	cf := &cuckooFilter{bucketSize: bucketSize, maxIterations: maxIterations, expansion: expansion}
+	cf.filters = []*cuckooSubFilter{cf.newSubFilter(numBuckets)}
+	return cf, nil
+}
+
+func (cf *cuckooFilter) newSubFilter(numBuckets uint64) *cuckooSubFilter {
+	return &cuckooSubFilter{numBuckets: numBuckets, fingerprints: make([]byte, numBuckets*uint64(cf.bucketSize))}
+}
+
+// cuckooHash returns the hash the first bucket of the item is taken from and its fingerprint, never 0
+func cuckooHash(item string) (uint64, byte) {
+	h := murmurHash64A([]byte(item), 0)
+	return h, byte(h%255 + 1)
+}
+
+// altBucket is an involution: the alternate bucket of the alternate bucket is the original one
+func (f *cuckooSubFilter) altBucket(i uint64, fp byte) uint64 {
+	return (i ^ uint64(fp)*0x5bd1e995) & (f.numBuckets - 1)
+}
+
+func (f *cuckooSubFilter) buckets(h uint64, fp byte) (uint64, uint64) {
+	i := h & (f.numBuckets - 1)
+	return i, f.altBucket(i, fp)
+}
+
+func (cf *cuckooFilter) bucket(f *cuckooSubFilter, i uint64) []byte {
+	start := i * uint64(cf.bucketSize)
+	return f.fingerprints[start : start+uint64(cf.bucketSize)]
+}
+
+func (cf *cuckooFilter) count(item string) int64 {
+	h, fp := cuckooHash(item)
+	var n int64
+	for _, f := range cf.filters {
+		i1, i2 := f.buckets(h, fp)
+		for _, i := range []uint64{i1, i2} {
+			for _, slot := range cf.bucket(f, i) {
+				if slot == fp {
+					n++
+				}
+			}
+			if i1 == i2 {
+				break
+			}
+		}
+	}
+	return n
+}
+
+func (cf *cuckooFilter) exists(item string) bool {
+	return cf.count(item) > 0
+}
+
+// insertEmpty stores the fingerprint in an empty slot of the bucket
+func (cf *cuckooFilter) insertEmpty(f *cuckooSubFilter, i uint64, fp byte) bool {
+	b := cf.bucket(f, i)
+	for j, slot := range b {
+		if slot == 0 {
+			b[j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+// kick makes room for the fingerprint by moving fingerprints to their alternate bucket, the victims are picked
+// in turn from every slot so that two buckets do not trade the same fingerprints back and forth. The moves
+// are undone when MAXITERATIONS of them do not free a slot, leaving the sub filter as it was
+func (cf *cuckooFilter) kick(f *cuckooSubFilter, i uint64, fp byte) bool {
+	type move struct {
+		bucket uint64
+		slot   int
+	}
+	moves := make([]move, 0, cf.maxIterations)
+	for n := 0; n < cf.maxIterations; n++ {
+		slot := n % cf.bucketSize
+		b := cf.bucket(f, i)
+		fp, b[slot] = b[slot], fp
+		moves = append(moves, move{i, slot})
+
+		i = f.altBucket(i, fp)
+		if cf.insertEmpty(f, i, fp) {
+			return true
+		}
+	}
+
+	for n := len(moves) - 1; n >= 0; n-- {
+		b := cf.bucket(f, moves[n].bucket)
+		fp, b[moves[n].slot] = b[moves[n].slot], fp
+	}
+	return false
+}
+
+// add stores the fingerprint of the item in the last sub filter, growing a new one when it is full
+func (cf *cuckooFilter) add(item string) error {
+	h, fp := cuckooHash(item)
+	f := cf.filters[len(cf.filters)-1]
+	i1, i2 := f.buckets(h, fp)
+	if cf.insertEmpty(f, i1, fp) || cf.insertEmpty(f, i2, fp) || cf.kick(f, i1, fp) {
+		cf.items++
+		return nil
+	}
+
+	if cf.expansion == 0 {
+		return errCuckooFull
+	}
+	// sub filters keep a power of two number of buckets
+	numBuckets := f.numBuckets << bits.Len(uint(cf.expansion-1))
+	if numBuckets*uint64(cf.bucketSize) > FILTER_MAX_BYTES {
+		return errFilterTooLarge
+	}
+	f = cf.newSubFilter(numBuckets)
+	cf.filters = append(cf.filters, f)
+	i1, _ = f.buckets(h, fp)
+	cf.insertEmpty(f, i1, fp)
+	cf.items++
+	return nil
+}
+
+// del removes a single fingerprint of the item, looking at the most recent sub filters first
+func (cf *cuckooFilter) del(item string) bool {
+	h, fp := cuckooHash(item)
+	for n := len(cf.filters) - 1; n >= 0; n-- {
+		f := cf.filters[n]
+		i1, i2 := f.buckets(h, fp)
+		for _, i := range []uint64{i1, i2} {
+			b := cf.bucket(f, i)
+			for j, slot := range b {
+				if slot == fp {
+					b[j] = 0
+					cf.items--
+					cf.deletes++
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (cf *cuckooFilter) numBuckets() uint64 {
+	var n uint64
+	for _, f := range cf.filters {
+		n += f.numBuckets
+	}
+	return n
+}
+
+func (cf *cuckooFilter) size() int64 {
+	var n int64
+	for _, f := range cf.filters {
+		n += int64(len(f.fingerprints))
+	}
+	return n
+}
+
+// header serializes everything but the fingerprints: the parameters of the filter, its counters and the
+// number of buckets of every sub filter
+func (cf *cuckooFilter) header() []byte {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(cf.bucketSize))
+	b = binary.LittleEndian.AppendUint32(b, uint32(cf.maxIterations))
+	b = binary.LittleEndian.AppendUint32(b, uint32(cf.expansion))
+	b = binary.LittleEndian.AppendUint64(b, uint64(cf.items))
+	b = binary.LittleEndian.AppendUint64(b, uint64(cf.deletes))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(cf.filters)))
+	for _, f := range cf.filters {
+		b = binary.LittleEndian.AppendUint64(b, f.numBuckets)
+	}
+	return b
+}
+
+// cuckooFromHeader allocates the empty filter a header describes, its fingerprints are loaded by the chunks that follow
+func cuckooFromHeader(b []byte) (*cuckooFilter, error) {
+	if len(b) < 32 {
+		return nil, errFilterBadData
+	}
+	cf := &cuckooFilter{
+		bucketSize:    int(binary.LittleEndian.Uint32(b)),
+		maxIterations: int(binary.LittleEndian.Uint32(b[4:])),
+		expansion:     int(binary.LittleEndian.Uint32(b[8:])),
+		items:         int64(binary.LittleEndian.Uint64(b[12:])),
+		deletes:       int64(binary.LittleEndian.Uint64(b[20:])),
+	}
+	count := int(binary.LittleEndian.Uint32(b[28:]))
+	b = b[32:]
+	if cf.bucketSize < 1 || cf.bucketSize > CF_MAX_BUCKET_SIZE || cf.maxIterations < 1 || cf.maxIterations > CF_MAX_ITERATIONS_MAX ||
+		cf.expansion > CF_MAX_EXPANSION || cf.items < 0 || cf.deletes < 0 || count == 0 || len(b) != count*8 {
+		return nil, errFilterBadData
+	}
+
+	for i := 0; i < count; i++ {
+		numBuckets := binary.LittleEndian.Uint64(b[i*8:])
+		if numBuckets == 0 || numBuckets&(numBuckets-1) != 0 || numBuckets*uint64(cf.bucketSize) > FILTER_MAX_BYTES {
+			return nil, errFilterBadData
+		}
+		cf.filters = append(cf.filters, cf.newSubFilter(numBuckets))
+	}
+	return cf, nil
+}
+
+func (cf *cuckooFilter) segments() [][]byte {
+	segs := make([][]byte, len(cf.filters))
+	for i, f := range cf.filters {
+		segs[i] = f.fingerprints
+	}
+	return segs
+}
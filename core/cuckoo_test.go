@@ -0,0 +1,98 @@
+package core_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/diceclone/core"
+)
+
+func TestCuckooFilterCommands(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"cf.reserve", &core.RedisCmd{Cmd: "CF.RESERVE", Args: []string{"cf", "1000", "BUCKETSIZE", "4"}}, []byte("+OK\r\n")},
+		{"cf.reserve an existing filter", &core.RedisCmd{Cmd: "CF.RESERVE", Args: []string{"cf", "1000"}}, []byte("-ERR item exists\r\n")},
+		{"cf.add", &core.RedisCmd{Cmd: "CF.ADD", Args: []string{"cf", "apple"}}, []byte(":1\r\n")},
+		{"cf.add an item again", &core.RedisCmd{Cmd: "CF.ADD", Args: []string{"cf", "apple"}}, []byte(":1\r\n")},
+		{"cf.addnx an item already added", &core.RedisCmd{Cmd: "CF.ADDNX", Args: []string{"cf", "apple"}}, []byte(":0\r\n")},
+		{"cf.addnx a new item", &core.RedisCmd{Cmd: "CF.ADDNX", Args: []string{"cf", "pear"}}, []byte(":1\r\n")},
+		{"cf.count of an item added twice", &core.RedisCmd{Cmd: "CF.COUNT", Args: []string{"cf", "apple"}}, []byte(":2\r\n")},
+		{"cf.exists", &core.RedisCmd{Cmd: "CF.EXISTS", Args: []string{"cf", "pear"}}, []byte(":1\r\n")},
+		{"cf.del", &core.RedisCmd{Cmd: "CF.DEL", Args: []string{"cf", "apple"}}, []byte(":1\r\n")},
+		{"cf.count after a deletion", &core.RedisCmd{Cmd: "CF.COUNT", Args: []string{"cf", "apple"}}, []byte(":1\r\n")},
+		{"cf.del the last copy", &core.RedisCmd{Cmd: "CF.DEL", Args: []string{"cf", "apple"}}, []byte(":1\r\n")},
+		{"cf.exists after deletions", &core.RedisCmd{Cmd: "CF.EXISTS", Args: []string{"cf", "apple"}}, []byte(":0\r\n")},
+		{"cf.del an item not in the filter", &core.RedisCmd{Cmd: "CF.DEL", Args: []string{"cf", "apple"}}, []byte(":0\r\n")},
+		{"cf.info", &core.RedisCmd{Cmd: "CF.INFO", Args: []string{"cf"}}, []byte("*16\r\n$4\r\nSize\r\n:1024\r\n$17\r\nNumber of buckets\r\n:256\r\n$17\r\nNumber of filters\r\n:1\r\n$24\r\nNumber of items inserted\r\n:1\r\n$23\r\nNumber of items deleted\r\n:2\r\n$11\r\nBucket size\r\n:4\r\n$14\r\nExpansion rate\r\n:1\r\n$14\r\nMax iterations\r\n:20\r\n")},
+		{"type of a cuckoo filter", &core.RedisCmd{Cmd: "TYPE", Args: []string{"cf"}}, []byte("+MBbloomCF\r\n")},
+		{"cf.del on a missing key", &core.RedisCmd{Cmd: "CF.DEL", Args: []string{"nocf", "apple"}}, []byte("-ERR Not found\r\n")},
+		{"cf.exists on a missing key", &core.RedisCmd{Cmd: "CF.EXISTS", Args: []string{"nocf", "apple"}}, []byte(":0\r\n")},
+		{"cf.count on a missing key", &core.RedisCmd{Cmd: "CF.COUNT", Args: []string{"nocf", "apple"}}, []byte(":0\r\n")},
+		{"cf.reserve with a tiny capacity", &core.RedisCmd{Cmd: "CF.RESERVE", Args: []string{"bad", "3"}}, []byte("-ERR Capacity must be at least (BucketSize * 2)\r\n")},
+		{"cf.reserve with a huge bucket size", &core.RedisCmd{Cmd: "CF.RESERVE", Args: []string{"bad", "1000", "BUCKETSIZE", "256"}}, []byte("-ERR Bucket size must be between 1 and 255\r\n")},
+		{"cf.reserve with an unknown option", &core.RedisCmd{Cmd: "CF.RESERVE", Args: []string{"bad", "1000", "DEPTH", "2"}}, []byte("-ERR syntax error\r\n")},
+		{"bf.add on a cuckoo filter", &core.RedisCmd{Cmd: "BF.ADD", Args: []string{"cf", "x"}}, []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")},
+	})
+}
+
+func TestCuckooFilterGrowth(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "CF.RESERVE", "grow", "64", "EXPANSION", "2")
+	for i := 0; i < 1000; i++ {
+		evalAs(c, "CF.ADD", "grow", "item:"+strconv.Itoa(i))
+		expectWrite(t, c, ":1\r\n")
+	}
+	for i := 0; i < 1000; i++ {
+		evalAs(c, "CF.EXISTS", "grow", "item:"+strconv.Itoa(i))
+		expectWrite(t, c, ":1\r\n")
+	}
+	for i := 0; i < 1000; i += 2 {
+		evalAs(c, "CF.DEL", "grow", "item:"+strconv.Itoa(i))
+		expectWrite(t, c, ":1\r\n")
+	}
+	for i := 1; i < 1000; i += 2 {
+		evalAs(c, "CF.EXISTS", "grow", "item:"+strconv.Itoa(i))
+		expectWrite(t, c, ":1\r\n")
+	}
+
+	evalAs(c, "CF.RESERVE", "fixed", "8", "BUCKETSIZE", "2", "EXPANSION", "0")
+	full := false
+	for i := 0; i < 100 && !full; i++ {
+		evalAs(c, "CF.ADD", "fixed", "item:"+strconv.Itoa(i))
+		full = string(c.LastWrite) == "-ERR Filter is full\r\n"
+	}
+	if !full {
+		t.Fatal("a filter that cannot expand never filled up")
+	}
+	evalAs(c, "CF.INFO", "fixed")
+	reply, _ := core.Decode(c.LastWrite)
+	if filters := reply.([]interface{})[5]; filters != int64(1) {
+		t.Errorf("got %v filters, want 1", filters)
+	}
+}
+
+func TestCFScanDumpAndLoadChunk(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "CF.RESERVE", "src", "100", "EXPANSION", "2")
+	for i := 0; i < 300; i++ {
+		evalAs(c, "CF.ADD", "src", "item:"+strconv.Itoa(i))
+	}
+	evalAs(c, "CF.DEL", "src", "item:0")
+	evalAs(c, "CF.INFO", "src")
+	info := string(c.LastWrite)
+
+	iters, chunks := scanDump(t, c, "CF.SCANDUMP", "src")
+	for i := range chunks {
+		evalAs(c, "CF.LOADCHUNK", "dst", iters[i], chunks[i])
+		expectWrite(t, c, "+OK\r\n")
+	}
+	evalAs(c, "CF.INFO", "dst")
+	expectWrite(t, c, info)
+	for i := 1; i < 300; i++ {
+		evalAs(c, "CF.EXISTS", "dst", "item:"+strconv.Itoa(i))
+		expectWrite(t, c, ":1\r\n")
+	}
+}
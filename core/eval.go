@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
+	"math/big"
 	"os"
 	"strconv"
 	"strings"
@@ -14,6 +16,10 @@ import (
 	"github.com/diceclone/config"
 )
 
+func errWrongArgCount(cmd string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", cmd)
+}
+
 func evalPing(args []string) []byte {
 	var b []byte
 
@@ -38,13 +44,12 @@ func evalSet(args []string, timeProvider TimeProvider) []byte {
 
 	// build a map with the argument list
 	params := buildSetParams(args)
-	oType, oEncoding := deduceTypeEncoding(params["value"])
 
 	ttl, exists := params["EX"]
 	if exists {
-		Put(params["key"], NewObj(params["value"], calculateDuration(ttl, timeProvider), oType, oEncoding))
+		Put(params["key"], newStringObj(params["value"], calculateDuration(ttl, timeProvider)))
 	} else {
-		Put(params["key"], NewObj(params["value"], -1, oType, oEncoding))
+		Put(params["key"], newStringObj(params["value"], -1))
 	}
 
 	return Encode("OK", true)
@@ -54,7 +59,10 @@ func evalGet(args []string) []byte {
 	if len(args) != 1 {
 		return Encode(errors.New("invalid arguments"), false)
 	}
-	obj := Get(args[0])
+	obj, err := getOfType(args[0], OBJ_TYPE_STRING)
+	if err != nil {
+		return Encode(err, false)
+	}
 	value := valueOf(obj)
 
 	var b []byte
@@ -107,34 +115,190 @@ func evalExpire(args []string, c io.ReadWriter, t TimeProvider) []byte {
 	} else if v.HasExpired() {
 		return Encode(0, false)
 	} else {
-		evalSet([]string{args[0], v.Value.(string), "ex", args[1]}, t)
+		v.ValidTill = calculateDuration(args[1], t)
 		return Encode(1, false)
 	}
 
 }
 
-func evalIncrement(args []string) []byte {
+var errNotInteger = errors.New("ERR value is not an integer or out of range")
+var errNotFloat = errors.New("ERR value is not a valid float")
+var errSyntax = errors.New("ERR syntax error")
+
+func evalPExpireAt(args []string, t TimeProvider) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("pexpireat"), false)
+	}
+
+	validTill, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
 
-	// fetch the value from store
 	v := Get(args[0])
 	if v == nil {
-		Put(args[0], NewObj("0", -1, OBJ_TYPE_STRING, OBJ_ENCODING_INT))
+		return Encode(0, false)
+	}
+	if validTill <= t.Now().UnixMilli() {
+		Delete(args[0])
+		return Encode(1, false)
+	}
+	v.ValidTill = int(validTill)
+	return Encode(1, false)
+}
+
+func evalIncrement(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("incr"), false)
+	}
+	return incrDecrBy(args[0], 1)
+}
+
+func evalDecrement(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("decr"), false)
+	}
+	return incrDecrBy(args[0], -1)
+}
+
+func evalIncrementBy(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("incrby"), false)
+	}
+	delta, ok := parseInt64(args[1])
+	if !ok {
+		return Encode(errNotInteger, false)
+	}
+	return incrDecrBy(args[0], delta)
+}
+
+func evalDecrementBy(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("decrby"), false)
+	}
+	delta, ok := parseInt64(args[1])
+	if !ok {
+		return Encode(errNotInteger, false)
+	}
+	if delta == math.MinInt64 {
+		return Encode(errors.New("ERR decrement would overflow"), false)
+	}
+	return incrDecrBy(args[0], -delta)
+}
+
+// incrDecrBy adds delta to the integer held at the key, a missing key counts as 0
+// and the ttl of an existing key is left untouched
+func incrDecrBy(key string, delta int64) []byte {
+	v, err := getOfType(key, OBJ_TYPE_STRING)
+	if err != nil {
+		return Encode(err, false)
 	}
 
-	v = Get(args[0])
+	if v == nil {
+		Put(key, newIntObj(delta, -1))
+		return Encode(delta, false)
+	}
 
-	if !assertType(v.TypeEncoding, OBJ_TYPE_STRING) {
-		return Encode(errors.New("operation not permitted on this type"), false)
+	current, ok := int64ValueOf(v)
+	if !ok {
+		return Encode(errNotInteger, false)
 	}
-	// if the encoding is not integer, throw error
-	if !assertEncoding(v.TypeEncoding, OBJ_ENCODING_INT) {
-		return Encode(errors.New("operation not permitted on this encoding"), false)
+
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return Encode(errors.New("ERR increment or decrement would overflow"), false)
 	}
-	result, _ := strconv.ParseInt(v.Value.(string), 10, 64)
-	// convert the value to integer, increment the value and return it
-	v.Value = strconv.FormatInt(result+1, 10)
 
-	return Encode(result+1, false)
+	result := current + delta
+	v.Value = boxInt64(result)
+	v.setEncoding(OBJ_ENCODING_INT)
+	signalKeyModified(key)
+
+	return Encode(result, false)
+}
+
+func evalIncrementByFloat(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("incrbyfloat"), false)
+	}
+
+	incr, ok := parseFloat(args[1])
+	if !ok {
+		return Encode(errNotFloat, false)
+	}
+
+	v, err := getOfType(args[0], OBJ_TYPE_STRING)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	current, text := 0.0, "0"
+	if v != nil {
+		text = stringValueOf(v)
+		if current, ok = parseFloat(text); !ok {
+			return Encode(errNotFloat, false)
+		}
+	}
+
+	result := current + incr
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return Encode(errors.New("ERR increment would produce NaN or Infinity"), false)
+	}
+
+	// the computed value is stored as a plain string, this way the AOF rewrite
+	// replays it with a SET and the result does not depend on float arithmetic at load time
+	formatted := addFloats(text, current, args[1], incr)
+	obj := newStringObj(formatted, -1)
+	if v == nil {
+		Put(args[0], obj)
+	} else {
+		v.Value = obj.Value
+		v.TypeEncoding = obj.TypeEncoding
+		signalKeyModified(args[0])
+	}
+
+	return Encode(formatted, false)
+}
+
+func evalType(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("type"), false)
+	}
+
+	obj := peek(args[0])
+	if obj == nil {
+		return Encode("none", true)
+	}
+	return Encode(typeNames[obj.Type()], true)
+}
+
+func evalObject(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("object"), false)
+	}
+
+	obj := peek(args[1])
+	if obj == nil {
+		return Encode(nil, false)
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "ENCODING":
+		return Encode(encodingNames[obj.Encoding()], false)
+	case "IDLETIME":
+		return Encode(int64(idleTimeOf(obj.LastAccessedAt)), false)
+	case "FREQ":
+		if !strings.HasSuffix(config.EVICTION_STRATEGY, "-lfu") {
+			return Encode(errors.New("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."), false)
+		}
+		return Encode(int(lfuDecrAndReturn(obj)), false)
+	case "REFCOUNT":
+		if isSharedInteger(obj) {
+			return Encode(math.MaxInt32, false)
+		}
+		return Encode(1, false)
+	default:
+		return Encode(fmt.Errorf("ERR unknown subcommand '%s'. Try OBJECT HELP.", args[0]), false)
+	}
 }
 
 func evalBackgroundRewriteAof() []byte {
@@ -172,12 +336,20 @@ func evalBackgroundRewriteAof() []byte {
 	writer := bufio.NewWriterSize(file, 4096)
 
 	for pair := range IterateStore() {
-		_, err := writer.Write([]byte(fmt.Sprintf("*3\r\n$3\r\nSET\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(pair.Key), pair.Key, len(pair.Value.Value.(string)), pair.Value.Value)))
+		err := rewriteObject(writer, pair.Key, pair.Value)
 		if err != nil {
 			fmt.Println("Error writing to file: ", err)
 			return Encode(err, false)
 		}
 	}
+	if err := rewriteCompactionRules(writer); err != nil {
+		fmt.Println("Error writing to file: ", err)
+		return Encode(err, false)
+	}
+	if err := rewriteSearchIndexes(writer); err != nil {
+		fmt.Println("Error writing to file: ", err)
+		return Encode(err, false)
+	}
 
 	err = writer.Flush()
 	if err != nil {
@@ -192,11 +364,13 @@ func evalBackgroundRewriteAof() []byte {
 	return Encode("OK", true)
 }
 
-func evalInfo() []byte {
-	count := KeyspaceSize()
-	infoMsg := fmt.Sprintf("# Keyspace\ndb0:keys=%d,expires=0,avg_ttl=0\n", count)
+func evalInfo(args []string) []byte {
+	sections := args
+	if len(sections) == 0 {
+		sections = defaultInfoSections
+	}
 
-	return Encode(infoMsg, false)
+	return Encode(renderInfo(sections), false)
 }
 
 func evalFlushDb() []byte {
@@ -207,6 +381,12 @@ func evalFlushDb() []byte {
 
 func EvalAndRespond(cmd *RedisCmd, c io.ReadWriter, timeProvider TimeProvider) error {
 	var buf []byte
+
+	if denyOOMCommands[cmd.Cmd] && !performEvictions() {
+		_, err := c.Write(Encode(errOOM, false))
+		return err
+	}
+
 	switch cmd.Cmd {
 	case "PING":
 		buf = evalPing(cmd.Args)
@@ -220,19 +400,443 @@ func EvalAndRespond(cmd *RedisCmd, c io.ReadWriter, timeProvider TimeProvider) e
 		buf = evalDel(cmd.Args)
 	case "EXPIRE":
 		buf = evalExpire(cmd.Args, c, timeProvider)
+	case "PEXPIREAT":
+		buf = evalPExpireAt(cmd.Args, timeProvider)
 	case "INCR":
 		buf = evalIncrement(cmd.Args)
+	case "INCRBY":
+		buf = evalIncrementBy(cmd.Args)
+	case "DECR":
+		buf = evalDecrement(cmd.Args)
+	case "DECRBY":
+		buf = evalDecrementBy(cmd.Args)
+	case "INCRBYFLOAT":
+		buf = evalIncrementByFloat(cmd.Args)
+	case "APPEND":
+		buf = evalAppend(cmd.Args)
+	case "STRLEN":
+		buf = evalStrlen(cmd.Args)
+	case "GETRANGE":
+		buf = evalGetRange(cmd.Args)
+	case "SETRANGE":
+		buf = evalSetRange(cmd.Args)
+	case "LCS":
+		buf = evalLcs(cmd.Args)
+	case "MGET":
+		buf = evalMGet(cmd.Args)
+	case "MSET":
+		buf = evalMSet(cmd.Args)
+	case "MSETNX":
+		buf = evalMSetNX(cmd.Args)
+	case "GETDEL":
+		buf = evalGetDel(cmd.Args)
+	case "GETEX":
+		buf = evalGetEx(cmd.Args, timeProvider)
+	case "GETSET":
+		buf = evalGetSet(cmd.Args)
 	case "BGREWRITEAOF":
 		buf = evalBackgroundRewriteAof()
 	case "INFO":
-		buf = evalInfo()
+		buf = evalInfo(cmd.Args)
+	case "LPUSH":
+		buf = evalLPush(cmd.Args)
+	case "RPUSH":
+		buf = evalRPush(cmd.Args)
+	case "LPUSHX":
+		buf = evalLPushX(cmd.Args)
+	case "RPUSHX":
+		buf = evalRPushX(cmd.Args)
+	case "LPOP":
+		buf = evalLPop(cmd.Args)
+	case "RPOP":
+		buf = evalRPop(cmd.Args)
+	case "LLEN":
+		buf = evalLLen(cmd.Args)
+	case "LRANGE":
+		buf = evalLRange(cmd.Args)
+	case "LINDEX":
+		buf = evalLIndex(cmd.Args)
+	case "LSET":
+		buf = evalLSet(cmd.Args)
+	case "LINSERT":
+		buf = evalLInsert(cmd.Args)
+	case "LREM":
+		buf = evalLRem(cmd.Args)
+	case "LTRIM":
+		buf = evalLTrim(cmd.Args)
+	case "LPOS":
+		buf = evalLPos(cmd.Args)
+	case "LMOVE":
+		buf = evalLMove(cmd.Args)
+	case "LMPOP":
+		buf = evalLMPop(cmd.Args)
+	case "BLPOP":
+		buf = evalBLPop(cmd.Args, c)
+	case "BRPOP":
+		buf = evalBRPop(cmd.Args, c)
+	case "BLMOVE":
+		buf = evalBLMove(cmd.Args, c)
+	case "BLMPOP":
+		buf = evalBLMPop(cmd.Args, c)
+	case "HSET":
+		buf = evalHSet(cmd.Args)
+	case "HMSET":
+		buf = evalHMSet(cmd.Args)
+	case "HSETNX":
+		buf = evalHSetNX(cmd.Args)
+	case "HGET":
+		buf = evalHGet(cmd.Args)
+	case "HMGET":
+		buf = evalHMGet(cmd.Args)
+	case "HDEL":
+		buf = evalHDel(cmd.Args)
+	case "HEXISTS":
+		buf = evalHExists(cmd.Args)
+	case "HLEN":
+		buf = evalHLen(cmd.Args)
+	case "HSTRLEN":
+		buf = evalHStrlen(cmd.Args)
+	case "HGETALL":
+		buf = evalHGetAll(cmd.Args)
+	case "HKEYS":
+		buf = evalHKeys(cmd.Args)
+	case "HVALS":
+		buf = evalHVals(cmd.Args)
+	case "HINCRBY":
+		buf = evalHIncrBy(cmd.Args)
+	case "HINCRBYFLOAT":
+		buf = evalHIncrByFloat(cmd.Args)
+	case "HRANDFIELD":
+		buf = evalHRandField(cmd.Args)
+	case "HSCAN":
+		buf = evalHScan(cmd.Args)
+	case "HEXPIRE":
+		buf = evalHExpire(cmd.Args)
+	case "HPEXPIRE":
+		buf = evalHPExpire(cmd.Args)
+	case "HEXPIREAT":
+		buf = evalHExpireAt(cmd.Args)
+	case "HPEXPIREAT":
+		buf = evalHPExpireAt(cmd.Args)
+	case "HTTL":
+		buf = evalHTtl(cmd.Args)
+	case "HPTTL":
+		buf = evalHPTtl(cmd.Args)
+	case "HEXPIRETIME":
+		buf = evalHExpireTime(cmd.Args)
+	case "HPEXPIRETIME":
+		buf = evalHPExpireTime(cmd.Args)
+	case "HPERSIST":
+		buf = evalHPersist(cmd.Args)
+	case "HGETDEL":
+		buf = evalHGetDel(cmd.Args)
+	case "SADD":
+		buf = evalSAdd(cmd.Args)
+	case "SREM":
+		buf = evalSRem(cmd.Args)
+	case "SISMEMBER":
+		buf = evalSIsMember(cmd.Args)
+	case "SMISMEMBER":
+		buf = evalSMIsMember(cmd.Args)
+	case "SMEMBERS":
+		buf = evalSMembers(cmd.Args)
+	case "SCARD":
+		buf = evalSCard(cmd.Args)
+	case "SPOP":
+		buf = evalSPop(cmd.Args)
+	case "SRANDMEMBER":
+		buf = evalSRandMember(cmd.Args)
+	case "SMOVE":
+		buf = evalSMove(cmd.Args)
+	case "SINTER":
+		buf = evalSInter(cmd.Args)
+	case "SUNION":
+		buf = evalSUnion(cmd.Args)
+	case "SDIFF":
+		buf = evalSDiff(cmd.Args)
+	case "SINTERSTORE":
+		buf = evalSInterStore(cmd.Args)
+	case "SUNIONSTORE":
+		buf = evalSUnionStore(cmd.Args)
+	case "SDIFFSTORE":
+		buf = evalSDiffStore(cmd.Args)
+	case "SINTERCARD":
+		buf = evalSInterCard(cmd.Args)
+	case "SSCAN":
+		buf = evalSScan(cmd.Args)
+	case "ZADD":
+		buf = evalZAdd(cmd.Args)
+	case "ZINCRBY":
+		buf = evalZIncrBy(cmd.Args)
+	case "ZREM":
+		buf = evalZRem(cmd.Args)
+	case "ZSCORE":
+		buf = evalZScore(cmd.Args)
+	case "ZMSCORE":
+		buf = evalZMScore(cmd.Args)
+	case "ZCARD":
+		buf = evalZCard(cmd.Args)
+	case "ZCOUNT":
+		buf = evalZCount(cmd.Args)
+	case "ZLEXCOUNT":
+		buf = evalZLexCount(cmd.Args)
+	case "ZRANK":
+		buf = evalZRank(cmd.Args)
+	case "ZREVRANK":
+		buf = evalZRevRank(cmd.Args)
+	case "ZRANGE":
+		buf = evalZRange(cmd.Args)
+	case "ZREVRANGE":
+		buf = evalZRevRange(cmd.Args)
+	case "ZRANGEBYSCORE":
+		buf = evalZRangeByScore(cmd.Args)
+	case "ZREVRANGEBYSCORE":
+		buf = evalZRevRangeByScore(cmd.Args)
+	case "ZRANGEBYLEX":
+		buf = evalZRangeByLex(cmd.Args)
+	case "ZREVRANGEBYLEX":
+		buf = evalZRevRangeByLex(cmd.Args)
+	case "ZRANGESTORE":
+		buf = evalZRangeStore(cmd.Args)
+	case "ZREMRANGEBYRANK":
+		buf = evalZRemRangeByRank(cmd.Args)
+	case "ZREMRANGEBYSCORE":
+		buf = evalZRemRangeByScore(cmd.Args)
+	case "ZREMRANGEBYLEX":
+		buf = evalZRemRangeByLex(cmd.Args)
+	case "ZPOPMIN":
+		buf = evalZPopMin(cmd.Args)
+	case "ZPOPMAX":
+		buf = evalZPopMax(cmd.Args)
+	case "ZUNIONSTORE":
+		buf = evalZUnionStore(cmd.Args)
+	case "ZINTERSTORE":
+		buf = evalZInterStore(cmd.Args)
+	case "ZSCAN":
+		buf = evalZScan(cmd.Args)
+	case "ZMPOP":
+		buf = evalZMPop(cmd.Args)
+	case "BZPOPMIN":
+		buf = evalBZPopMin(cmd.Args, c)
+	case "BZPOPMAX":
+		buf = evalBZPopMax(cmd.Args, c)
+	case "BZMPOP":
+		buf = evalBZMPop(cmd.Args, c)
+	case "XADD":
+		buf = evalXAdd(cmd.Args)
+	case "XLEN":
+		buf = evalXLen(cmd.Args)
+	case "XRANGE":
+		buf = xrangeGeneric("xrange", cmd.Args, false)
+	case "XREVRANGE":
+		buf = xrangeGeneric("xrevrange", cmd.Args, true)
+	case "XDEL":
+		buf = evalXDel(cmd.Args)
+	case "XTRIM":
+		buf = evalXTrim(cmd.Args)
+	case "XREAD":
+		buf = evalXRead(cmd.Args, c)
+	case "XREADGROUP":
+		buf = evalXReadGroup(cmd.Args, c)
+	case "XACK":
+		buf = evalXAck(cmd.Args)
+	case "XGROUP":
+		buf = evalXGroup(cmd.Args)
+	case "XSETID":
+		buf = evalXSetID(cmd.Args)
+	case "XPENDING":
+		buf = evalXPending(cmd.Args)
+	case "XCLAIM":
+		buf = evalXClaim(cmd.Args)
+	case "XAUTOCLAIM":
+		buf = evalXAutoClaim(cmd.Args)
+	case "XINFO":
+		buf = evalXInfo(cmd.Args)
+	case "PFADD":
+		buf = evalPFAdd(cmd.Args)
+	case "PFCOUNT":
+		buf = evalPFCount(cmd.Args)
+	case "PFMERGE":
+		buf = evalPFMerge(cmd.Args)
+	case "PFDEBUG":
+		buf = evalPFDebug(cmd.Args)
+	case "PFSELFTEST":
+		buf = evalPFSelfTest(cmd.Args)
+	case "SETBIT":
+		buf = evalSetBit(cmd.Args)
+	case "GETBIT":
+		buf = evalGetBit(cmd.Args)
+	case "BITCOUNT":
+		buf = evalBitCount(cmd.Args)
+	case "BITPOS":
+		buf = evalBitPos(cmd.Args)
+	case "BITOP":
+		buf = evalBitOp(cmd.Args)
+	case "BITFIELD":
+		buf = bitfieldGeneric("bitfield", cmd.Args, false)
+	case "BITFIELD_RO":
+		buf = bitfieldGeneric("bitfield_ro", cmd.Args, true)
+	case "GEOADD":
+		buf = evalGeoAdd(cmd.Args)
+	case "GEOPOS":
+		buf = evalGeoPos(cmd.Args)
+	case "GEODIST":
+		buf = evalGeoDist(cmd.Args)
+	case "GEOHASH":
+		buf = evalGeoHash(cmd.Args)
+	case "GEOSEARCH":
+		buf = evalGeoSearch(cmd.Args)
+	case "GEOSEARCHSTORE":
+		buf = evalGeoSearchStore(cmd.Args)
+	case "JSON.SET":
+		buf = evalJSONSet(cmd.Args)
+	case "JSON.GET":
+		buf = evalJSONGet(cmd.Args)
+	case "JSON.DEL":
+		buf = evalJSONDel("json.del", cmd.Args)
+	case "JSON.FORGET":
+		buf = evalJSONDel("json.forget", cmd.Args)
+	case "JSON.TYPE":
+		buf = evalJSONType(cmd.Args)
+	case "JSON.NUMINCRBY":
+		buf = evalJSONNumIncrBy(cmd.Args)
+	case "JSON.STRAPPEND":
+		buf = evalJSONStrAppend(cmd.Args)
+	case "JSON.ARRAPPEND":
+		buf = evalJSONArrAppend(cmd.Args)
+	case "JSON.ARRINSERT":
+		buf = evalJSONArrInsert(cmd.Args)
+	case "JSON.ARRPOP":
+		buf = evalJSONArrPop(cmd.Args)
+	case "JSON.ARRLEN":
+		buf = evalJSONArrLen(cmd.Args)
+	case "JSON.OBJKEYS":
+		buf = evalJSONObjKeys(cmd.Args)
+	case "JSON.MGET":
+		buf = evalJSONMGet(cmd.Args)
+	case "BF.RESERVE":
+		buf = evalBFReserve(cmd.Args)
+	case "BF.ADD":
+		buf = evalBFAdd(cmd.Args)
+	case "BF.MADD":
+		buf = evalBFMAdd(cmd.Args)
+	case "BF.EXISTS":
+		buf = evalBFExists(cmd.Args)
+	case "BF.MEXISTS":
+		buf = evalBFMExists(cmd.Args)
+	case "BF.INFO":
+		buf = evalBFInfo(cmd.Args)
+	case "BF.SCANDUMP":
+		buf = evalBFScanDump(cmd.Args)
+	case "BF.LOADCHUNK":
+		buf = evalBFLoadChunk(cmd.Args)
+	case "CF.RESERVE":
+		buf = evalCFReserve(cmd.Args)
+	case "CF.ADD":
+		buf = evalCFAdd("cf.add", cmd.Args)
+	case "CF.ADDNX":
+		buf = evalCFAdd("cf.addnx", cmd.Args)
+	case "CF.DEL":
+		buf = evalCFDel(cmd.Args)
+	case "CF.EXISTS":
+		buf = evalCFExists(cmd.Args)
+	case "CF.COUNT":
+		buf = evalCFCount(cmd.Args)
+	case "CF.INFO":
+		buf = evalCFInfo(cmd.Args)
+	case "CF.SCANDUMP":
+		buf = evalCFScanDump(cmd.Args)
+	case "CF.LOADCHUNK":
+		buf = evalCFLoadChunk(cmd.Args)
+	case "CMS.INITBYDIM":
+		buf = evalCMSInitByDim(cmd.Args)
+	case "CMS.INITBYPROB":
+		buf = evalCMSInitByProb(cmd.Args)
+	case "CMS.INCRBY":
+		buf = evalCMSIncrBy(cmd.Args)
+	case "CMS.QUERY":
+		buf = evalCMSQuery(cmd.Args)
+	case "CMS.MERGE":
+		buf = evalCMSMerge(cmd.Args)
+	case "CMS.INFO":
+		buf = evalCMSInfo(cmd.Args)
+	case "TOPK.RESERVE":
+		buf = evalTopKReserve(cmd.Args)
+	case "TOPK.ADD":
+		buf = evalTopKAdd(cmd.Args)
+	case "TOPK.INCRBY":
+		buf = evalTopKIncrBy(cmd.Args)
+	case "TOPK.QUERY":
+		buf = evalTopKQuery(cmd.Args)
+	case "TOPK.LIST":
+		buf = evalTopKList(cmd.Args)
+	case "TOPK.INFO":
+		buf = evalTopKInfo(cmd.Args)
+	case "TS.CREATE":
+		buf = evalTSCreate(cmd.Args)
+	case "TS.ADD":
+		buf = evalTSAdd(cmd.Args)
+	case "TS.MADD":
+		buf = evalTSMAdd(cmd.Args)
+	case "TS.GET":
+		buf = evalTSGet(cmd.Args)
+	case "TS.RANGE":
+		buf = evalTSRange("ts.range", cmd.Args)
+	case "TS.REVRANGE":
+		buf = evalTSRange("ts.revrange", cmd.Args)
+	case "TS.MRANGE":
+		buf = evalTSMRange("ts.mrange", cmd.Args)
+	case "TS.MREVRANGE":
+		buf = evalTSMRange("ts.mrevrange", cmd.Args)
+	case "TS.CREATERULE":
+		buf = evalTSCreateRule(cmd.Args)
+	case "TS.DELETERULE":
+		buf = evalTSDeleteRule(cmd.Args)
+	case "TS.INFO":
+		buf = evalTSInfo(cmd.Args)
+	case "FT.CREATE":
+		buf = evalFTCreate(cmd.Args)
+	case "FT.SEARCH":
+		buf = evalFTSearch(cmd.Args)
+	case "FT.INFO":
+		buf = evalFTInfo(cmd.Args)
+	case "FT.DROPINDEX":
+		buf = evalFTDropIndex(cmd.Args)
+	case "FT._LIST":
+		buf = evalFTList(cmd.Args)
+	case "TYPE":
+		buf = evalType(cmd.Args)
+	case "OBJECT":
+		buf = evalObject(cmd.Args)
+	case "CONFIG":
+		buf = evalConfig(cmd.Args)
+	case "SUBSCRIBE":
+		buf = evalSubscribe(cmd.Args, c)
+	case "UNSUBSCRIBE":
+		buf = evalUnsubscribe(cmd.Args, c)
+	case "PSUBSCRIBE":
+		buf = evalPSubscribe(cmd.Args, c)
+	case "PUNSUBSCRIBE":
+		buf = evalPUnsubscribe(cmd.Args, c)
+	case "PUBLISH":
+		buf = evalPublish(cmd.Args)
+	case "Q.WATCH":
+		buf = evalQWatch(cmd.Args, c)
+	case "Q.UNWATCH":
+		buf = evalQUnwatch(cmd.Args, c)
 	case "FLUSHDB":
 		buf = evalFlushDb()
 	default:
 		buf = evalPing(cmd.Args)
 	}
 
-	_, err := c.Write(buf)
+	// blocked clients get no reply until they are served or time out
+	var err error
+	if buf != nil {
+		_, err = c.Write(buf)
+	}
+	handleClientsBlockedOnKeys()
+	notifyWatchers()
 	return err
 }
 
@@ -241,7 +845,36 @@ func calculateDuration(validFor interface{}, t TimeProvider) int {
 	if err != nil {
 		return -1
 	}
-	return int(t.Now().Unix()) + ttl
+	return int(t.Now().UnixMilli()) + ttl*1000
+}
+
+// expiryFromOption converts the EX, PX, EXAT and PXAT options to the unix time in milliseconds at which the key expires
+func expiryFromOption(cmd string, option string, value string, t TimeProvider) (int, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	errInvalidExpire := fmt.Errorf("ERR invalid expire time in '%s' command", cmd)
+	if n <= 0 {
+		return 0, errInvalidExpire
+	}
+
+	switch option {
+	case "EX", "EXAT":
+		if n > math.MaxInt64/1000 {
+			return 0, errInvalidExpire
+		}
+		n *= 1000
+	}
+	switch option {
+	case "EX", "PX":
+		now := t.Now().UnixMilli()
+		if n > math.MaxInt64-now {
+			return 0, errInvalidExpire
+		}
+		n += now
+	}
+	return int(n), nil
 }
 
 func buildSetParams(args []string) map[string]string {
@@ -262,13 +895,27 @@ func valueOf(obj *Obj) interface{} {
 	}
 
 	if obj.ValidTill == -1 {
-		return obj.Value
+		return stringValueOf(obj)
 	}
 
-	if obj.ValidTill < int(time.Now().Unix()) {
+	if obj.ValidTill < int(time.Now().UnixMilli()) {
 		return nil
 	}
-	return obj.Value
+	return stringValueOf(obj)
+}
+
+// stringValueOf returns the content of a string object whatever its encoding is
+func stringValueOf(obj *Obj) string {
+	switch v := obj.Value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
 }
 
 func ttlOf(obj *Obj) int {
@@ -278,15 +925,72 @@ func ttlOf(obj *Obj) int {
 	if obj.ValidTill == -1 {
 		return -1
 	}
-	if obj.ValidTill < int(time.Now().Unix()) {
+	remaining := obj.ValidTill - int(time.Now().UnixMilli())
+	if remaining < 0 {
 		return -2
 	}
-	return obj.ValidTill - int(time.Now().Unix())
+	// round to the closest second, as redis does
+	return (remaining + 500) / 1000
+}
+
+// int64ValueOf returns the integer held by a string object, strings are
+// parsed only when they are the exact representation of an int64
+func int64ValueOf(obj *Obj) (int64, bool) {
+	if v, ok := obj.Value.(int64); ok {
+		return v, true
+	}
+	return parseInt64(stringValueOf(obj))
+}
 
+func parseInt64(s string) (int64, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		return 0, false
+	}
+	return n, true
+}
+
+// parseFloat accepts what redis accepts as a float, spaces and NaN are refused
+func parseFloat(s string) (float64, bool) {
+	if len(s) == 0 || strings.TrimSpace(s) != s {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+// formatFloat renders floats in the human friendly form used by INCRBYFLOAT,
+// without exponent and with the shortest fractional part that represents the value
+// longDouble parses the number with the 64 bit mantissa of the long double Redis computes increments with,
+// f is the same number already parsed as a float64, used for the notations ParseFloat does not read
+func longDouble(s string, f float64) *big.Float {
+	if x, _, err := big.ParseFloat(s, 10, 64, big.ToNearestEven); err == nil {
+		return x
+	}
+	return new(big.Float).SetPrec(64).SetFloat64(f)
+}
+
+// addFloats adds the increment the way INCRBYFLOAT and HINCRBYFLOAT do, the sum is computed in long
+// double precision and formatted with 17 digits after the decimal point without the trailing zeros, so that
+// 0.1 incremented by 0.2 is 0.3
+func addFloats(current string, currentValue float64, incr string, incrValue float64) string {
+	sum := new(big.Float).SetPrec(64).Add(longDouble(current, currentValue), longDouble(incr, incrValue))
+	formatted := sum.Text('f', 17)
+	formatted = strings.TrimRight(formatted, "0")
+	formatted = strings.TrimSuffix(formatted, ".")
+	if formatted == "-0" {
+		return "0"
+	}
+	return formatted
 }
 
 func deduceTypeEncoding(v string) (uint8, uint8) {
-	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+	// only the canonical representation is int encoded so that GET returns the very same string,
+	// values like "007" or "+1" stay strings
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil && strconv.FormatInt(n, 10) == v {
 		return OBJ_TYPE_STRING, OBJ_ENCODING_INT
 	}
 
@@ -0,0 +1,559 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+var errBitOffset = errors.New("ERR bit offset is not an integer or out of range")
+
+// bits are numbered from the most significant bit of the first byte, as redis numbers them
+func getBit(b []byte, offset uint64) int {
+	i := offset >> 3
+	if i >= uint64(len(b)) {
+		return 0
+	}
+	return int(b[i]>>(7-offset&7)) & 1
+}
+
+func setBit(b []byte, offset uint64, on bool) {
+	mask := byte(1 << (7 - offset&7))
+	if on {
+		b[offset>>3] |= mask
+	} else {
+		b[offset>>3] &^= mask
+	}
+}
+
+// parseBitOffset parses a bit offset, a "#" prefix multiplies it by the width of the bitfield when
+// bitsWide is set. Offsets are limited to the size of the largest string
+func parseBitOffset(s string, hashAllowed bool, bitsWide int) (uint64, error) {
+	multiplier := int64(1)
+	if hashAllowed && strings.HasPrefix(s, "#") {
+		s = s[1:]
+		multiplier = int64(bitsWide)
+	}
+	n, ok := parseInt64(s)
+	if !ok || n < 0 || n > math.MaxInt64/multiplier {
+		return 0, errBitOffset
+	}
+	offset := uint64(n * multiplier)
+	if offset>>3 >= PROTO_MAX_BULK_LEN {
+		return 0, errBitOffset
+	}
+	return offset, nil
+}
+
+// bitmapForWrite returns the bytes of the string at key grown to hold the bit, creating the key when missing
+func bitmapForWrite(key string, maxBit uint64) (*Obj, []byte, error) {
+	obj, err := getOfType(key, OBJ_TYPE_STRING)
+	if err != nil {
+		return nil, nil, err
+	}
+	if obj == nil {
+		obj = NewObj([]byte{}, -1, OBJ_TYPE_STRING, OBJ_ENCODING_RAW)
+		Put(key, obj)
+	}
+
+	b := rawBytesOf(obj)
+	if needed := int(maxBit>>3) + 1; needed > len(b) {
+		b = append(b, make([]byte, needed-len(b))...)
+		obj.Value = b
+	}
+	// the caller writes the bits before the command completes, when the watched queries are re-evaluated
+	signalKeyModified(key)
+	return obj, b, nil
+}
+
+// bitmapOf returns the bytes of the string at key, nil when the key is missing
+func bitmapOf(key string) ([]byte, error) {
+	obj, err := getOfType(key, OBJ_TYPE_STRING)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	if b, ok := obj.Value.([]byte); ok {
+		return b, nil
+	}
+	return []byte(stringValueOf(obj)), nil
+}
+
+func evalSetBit(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("setbit"), false)
+	}
+
+	offset, err := parseBitOffset(args[1], false, 0)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if args[2] != "0" && args[2] != "1" {
+		return Encode(errors.New("ERR bit is not an integer or out of range"), false)
+	}
+
+	_, b, err := bitmapForWrite(args[0], offset)
+	if err != nil {
+		return Encode(err, false)
+	}
+	old := getBit(b, offset)
+	setBit(b, offset, args[2] == "1")
+	return Encode(old, false)
+}
+
+func evalGetBit(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("getbit"), false)
+	}
+
+	offset, err := parseBitOffset(args[1], false, 0)
+	if err != nil {
+		return Encode(err, false)
+	}
+	b, err := bitmapOf(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	return Encode(getBit(b, offset), false)
+}
+
+// parseBitRange parses the start, end and BYTE|BIT arguments of BITCOUNT and BITPOS and normalizes
+// the range the way GETRANGE does. The range is returned in bits, empty when start is past end
+func parseBitRange(args []string, strlen int64) (start int64, end int64, err error) {
+	isBit := false
+	if len(args) == 3 {
+		switch strings.ToUpper(args[2]) {
+		case "BYTE":
+		case "BIT":
+			isBit = true
+		default:
+			return 0, 0, errSyntax
+		}
+	}
+
+	var ok bool
+	if start, ok = parseInt64(args[0]); !ok {
+		return 0, 0, errNotInteger
+	}
+	end = math.MaxInt64
+	if len(args) > 1 {
+		if end, ok = parseInt64(args[1]); !ok {
+			return 0, 0, errNotInteger
+		}
+	}
+
+	total := strlen
+	if isBit {
+		total = strlen * 8
+	}
+	if start < 0 {
+		start = max(total+start, 0)
+	}
+	if end < 0 {
+		end = max(total+end, 0)
+	}
+	end = min(end, total-1)
+	if !isBit {
+		start, end = start*8, end*8+7
+	}
+	return start, end, nil
+}
+
+func evalBitCount(args []string) []byte {
+	if len(args) != 1 && len(args) != 3 && len(args) != 4 {
+		if len(args) == 2 {
+			return Encode(errSyntax, false)
+		}
+		return Encode(errWrongArgCount("bitcount"), false)
+	}
+
+	b, err := bitmapOf(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	start, end := int64(0), int64(len(b))*8-1
+	if len(args) > 1 {
+		if start, end, err = parseBitRange(args[1:], int64(len(b))); err != nil {
+			return Encode(err, false)
+		}
+	}
+	if b == nil || start > end {
+		return Encode(0, false)
+	}
+	return Encode(countBits(b, start, end), false)
+}
+
+// countBits counts the set bits between the start and end bits, both included
+func countBits(b []byte, start int64, end int64) int {
+	count := 0
+	firstByte, lastByte := start>>3, end>>3
+	for i := firstByte; i <= lastByte; i++ {
+		v := b[i]
+		if i == firstByte {
+			v &= 0xff >> (start & 7)
+		}
+		if i == lastByte {
+			v &= 0xff << (7 - end&7)
+		}
+		count += bits.OnesCount8(v)
+	}
+	return count
+}
+
+func evalBitPos(args []string) []byte {
+	if len(args) < 2 || len(args) > 5 {
+		return Encode(errWrongArgCount("bitpos"), false)
+	}
+
+	if args[1] != "0" && args[1] != "1" {
+		return Encode(errors.New("ERR The bit argument must be 1 or 0."), false)
+	}
+	want := 0
+	if args[1] == "1" {
+		want = 1
+	}
+
+	b, err := bitmapOf(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	start, end := int64(0), int64(len(b))*8-1
+	endGiven := len(args) > 3
+	if len(args) > 2 {
+		if start, end, err = parseBitRange(args[2:], int64(len(b))); err != nil {
+			return Encode(err, false)
+		}
+	}
+	if b == nil {
+		if want == 1 {
+			return Encode(-1, false)
+		}
+		return Encode(0, false)
+	}
+	if start > end {
+		return Encode(-1, false)
+	}
+
+	for pos := start; pos <= end; pos++ {
+		// whole bytes that can't hold the bit are skipped
+		if pos&7 == 0 && pos+7 <= end && ((want == 1 && b[pos>>3] == 0) || (want == 0 && b[pos>>3] == 0xff)) {
+			pos += 7
+			continue
+		}
+		if getBit(b, uint64(pos)) == want {
+			return Encode(pos, false)
+		}
+	}
+
+	// without an end, the string is considered padded with zeros on the right
+	if want == 0 && !endGiven {
+		return Encode(end+1, false)
+	}
+	return Encode(-1, false)
+}
+
+func evalBitOp(args []string) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount("bitop"), false)
+	}
+
+	op := strings.ToUpper(args[0])
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(args) != 3 {
+			return Encode(errors.New("ERR BITOP NOT must be called with a single source key."), false)
+		}
+	default:
+		return Encode(errSyntax, false)
+	}
+
+	sources := make([][]byte, len(args)-2)
+	maxLen := 0
+	for i, key := range args[2:] {
+		b, err := bitmapOf(key)
+		if err != nil {
+			return Encode(err, false)
+		}
+		sources[i] = b
+		maxLen = max(maxLen, len(b))
+	}
+
+	// missing keys and the missing tails of shorter strings count as zero bytes
+	result := make([]byte, maxLen)
+	copy(result, sources[0])
+	switch op {
+	case "AND":
+		for _, src := range sources[1:] {
+			for i, b := range src {
+				result[i] &= b
+			}
+			clear(result[len(src):])
+		}
+	case "OR":
+		for _, src := range sources[1:] {
+			for i, b := range src {
+				result[i] |= b
+			}
+		}
+	case "XOR":
+		for _, src := range sources[1:] {
+			for i, b := range src {
+				result[i] ^= b
+			}
+		}
+	case "NOT":
+		for i := range result {
+			result[i] = ^result[i]
+		}
+	}
+
+	if maxLen == 0 {
+		Delete(args[1])
+		return Encode(0, false)
+	}
+	Put(args[1], NewObj(result, -1, OBJ_TYPE_STRING, OBJ_ENCODING_RAW))
+	return Encode(maxLen, false)
+}
+
+const (
+	BITFIELD_OVERFLOW_WRAP = iota
+	BITFIELD_OVERFLOW_SAT
+	BITFIELD_OVERFLOW_FAIL
+)
+
+const (
+	BITFIELD_OP_GET = iota
+	BITFIELD_OP_SET
+	BITFIELD_OP_INCRBY
+)
+
+type bitfieldOp struct {
+	op       int
+	offset   uint64
+	bits     int
+	signed   bool
+	value    int64
+	overflow int
+}
+
+// parseBitfieldType parses i1 to i64 and u1 to u63
+func parseBitfieldType(s string) (signed bool, width int, err error) {
+	errType := errors.New("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'I' && s[0] != 'u' && s[0] != 'U') {
+		return false, 0, errType
+	}
+	signed = s[0] == 'i' || s[0] == 'I'
+	width, convErr := strconv.Atoi(s[1:])
+	if convErr != nil || width < 1 || (signed && width > 64) || (!signed && width > 63) {
+		return false, 0, errType
+	}
+	return signed, width, nil
+}
+
+func getUnsignedBitfield(b []byte, offset uint64, width int) uint64 {
+	var v uint64
+	for i := 0; i < width; i++ {
+		v = v<<1 | uint64(getBit(b, offset+uint64(i)))
+	}
+	return v
+}
+
+func getSignedBitfield(b []byte, offset uint64, width int) int64 {
+	v := getUnsignedBitfield(b, offset, width)
+	if width < 64 && v&(1<<(width-1)) != 0 {
+		v |= math.MaxUint64 << width
+	}
+	return int64(v)
+}
+
+func setBitfield(b []byte, offset uint64, width int, value uint64) {
+	for i := 0; i < width; i++ {
+		setBit(b, offset+uint64(i), value>>(width-1-i)&1 == 1)
+	}
+}
+
+// checkUnsignedBitfieldOverflow tells whether value plus incr overflows the field, 1 above and -1 below,
+// and returns the value to store according to the overflow behavior
+func checkUnsignedBitfieldOverflow(value uint64, incr int64, width int, overflow int) (int, uint64) {
+	maxValue := uint64(1)<<width - 1
+	maxIncr := int64(maxValue - value)
+	minIncr := -int64(value)
+	wrapped := (value + uint64(incr)) & maxValue
+
+	if value > maxValue || (incr > 0 && incr > maxIncr) {
+		if overflow == BITFIELD_OVERFLOW_SAT {
+			return 1, maxValue
+		}
+		return 1, wrapped
+	}
+	if incr < 0 && incr < minIncr {
+		if overflow == BITFIELD_OVERFLOW_SAT {
+			return -1, 0
+		}
+		return -1, wrapped
+	}
+	return 0, value + uint64(incr)
+}
+
+func checkSignedBitfieldOverflow(value int64, incr int64, width int, overflow int) (int, int64) {
+	maxValue := int64(math.MaxInt64)
+	if width < 64 {
+		maxValue = int64(1)<<(width-1) - 1
+	}
+	minValue := -maxValue - 1
+	maxIncr := maxValue - value
+	minIncr := minValue - value
+
+	// the addition is made on unsigned integers, where wrapping around is defined, then sign extended
+	wrapped := uint64(value) + uint64(incr)
+	if width < 64 {
+		mask := uint64(math.MaxUint64) << width
+		if wrapped&(1<<(width-1)) != 0 {
+			wrapped |= mask
+		} else {
+			wrapped &^= mask
+		}
+	}
+
+	if value > maxValue || (width != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr) {
+		if overflow == BITFIELD_OVERFLOW_SAT {
+			return 1, maxValue
+		}
+		return 1, int64(wrapped)
+	}
+	if value < minValue || (width != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr) {
+		if overflow == BITFIELD_OVERFLOW_SAT {
+			return -1, minValue
+		}
+		return -1, int64(wrapped)
+	}
+	return 0, int64(wrapped)
+}
+
+// bitfieldGeneric implements BITFIELD and BITFIELD_RO, the subcommands are run in order and every GET,
+// SET and INCRBY adds an entry to the reply. OVERFLOW changes the behavior of the writes following it
+func bitfieldGeneric(cmd string, args []string, readOnly bool) []byte {
+	if len(args) < 1 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	var ops []bitfieldOp
+	overflow := BITFIELD_OVERFLOW_WRAP
+	hasWrites := false
+	var maxBit uint64
+	for i := 1; i < len(args); i++ {
+		sub := strings.ToUpper(args[i])
+		left := len(args) - i - 1
+		switch {
+		case sub == "OVERFLOW" && left >= 1:
+			switch strings.ToUpper(args[i+1]) {
+			case "WRAP":
+				overflow = BITFIELD_OVERFLOW_WRAP
+			case "SAT":
+				overflow = BITFIELD_OVERFLOW_SAT
+			case "FAIL":
+				overflow = BITFIELD_OVERFLOW_FAIL
+			default:
+				return Encode(errors.New("ERR Invalid OVERFLOW type specified"), false)
+			}
+			i++
+			continue
+		case sub == "GET" && left >= 2:
+		case (sub == "SET" || sub == "INCRBY") && left >= 3:
+			if readOnly {
+				return Encode(errors.New("ERR BITFIELD_RO only supports the GET subcommand"), false)
+			}
+		default:
+			return Encode(errSyntax, false)
+		}
+
+		signed, width, err := parseBitfieldType(args[i+1])
+		if err != nil {
+			return Encode(err, false)
+		}
+		offset, err := parseBitOffset(args[i+2], true, width)
+		if err != nil {
+			return Encode(err, false)
+		}
+		op := bitfieldOp{op: BITFIELD_OP_GET, offset: offset, bits: width, signed: signed, overflow: overflow}
+		if sub != "GET" {
+			value, ok := parseInt64(args[i+3])
+			if !ok {
+				return Encode(errNotInteger, false)
+			}
+			op.value = value
+			op.op = BITFIELD_OP_SET
+			if sub == "INCRBY" {
+				op.op = BITFIELD_OP_INCRBY
+			}
+			hasWrites = true
+			maxBit = max(maxBit, offset+uint64(width)-1)
+			i++
+		}
+		ops = append(ops, op)
+		i += 2
+	}
+
+	// a write creates the key and grows it to the furthest field written, even when it ends up failing
+	var b []byte
+	var err error
+	if hasWrites {
+		_, b, err = bitmapForWrite(args[0], maxBit)
+	} else {
+		b, err = bitmapOf(args[0])
+	}
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	out := make([]interface{}, 0, len(ops))
+	for _, op := range ops {
+		if op.op == BITFIELD_OP_GET {
+			if op.signed {
+				out = append(out, getSignedBitfield(b, op.offset, op.bits))
+			} else {
+				out = append(out, int64(getUnsignedBitfield(b, op.offset, op.bits)))
+			}
+			continue
+		}
+
+		// SET checks the value itself for overflows, INCRBY checks the current value plus the increment
+		var oldValue int64
+		if op.signed {
+			oldValue = getSignedBitfield(b, op.offset, op.bits)
+		} else {
+			oldValue = int64(getUnsignedBitfield(b, op.offset, op.bits))
+		}
+		base, incr := op.value, int64(0)
+		if op.op == BITFIELD_OP_INCRBY {
+			base, incr = oldValue, op.value
+		}
+
+		var overflowed int
+		var newValue uint64
+		if op.signed {
+			var v int64
+			overflowed, v = checkSignedBitfieldOverflow(base, incr, op.bits, op.overflow)
+			newValue = uint64(v)
+		} else {
+			overflowed, newValue = checkUnsignedBitfieldOverflow(uint64(base), incr, op.bits, op.overflow)
+		}
+
+		if overflowed != 0 && op.overflow == BITFIELD_OVERFLOW_FAIL {
+			out = append(out, nil)
+			continue
+		}
+		setBitfield(b, op.offset, op.bits, newValue)
+
+		if op.op == BITFIELD_OP_SET {
+			out = append(out, oldValue)
+		} else {
+			out = append(out, int64(newValue))
+		}
+	}
+	return Encode(out, false)
+}
@@ -0,0 +1,286 @@
+package core
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/diceclone/config"
+)
+
+var errBloomBadErrorRate = errors.New("ERR bad error rate")
+var errBloomBadCapacity = errors.New("ERR bad capacity")
+var errBloomBadExpansion = errors.New("ERR bad expansion")
+
+func lookupBloom(key string) (*bloomFilter, error) {
+	obj, err := getOfType(key, OBJ_TYPE_BLOOM)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	return obj.Value.(*bloomFilter), nil
+}
+
+// bloomForAdd returns the filter at key, creating it with the configured defaults when it does not exist
+func bloomForAdd(key string) (*bloomFilter, error) {
+	bf, err := lookupBloom(key)
+	if err != nil || bf != nil {
+		return bf, err
+	}
+	bf, err = newBloomFilter(config.BF_ERROR_RATE, int64(config.BF_INITIAL_SIZE), int64(config.BF_EXPANSION_FACTOR))
+	if err != nil {
+		return nil, err
+	}
+	Put(key, NewObj(bf, -1, OBJ_TYPE_BLOOM, OBJ_ENCODING_BLOOM))
+	return bf, nil
+}
+
+// evalBFReserve implements BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING]
+func evalBFReserve(args []string) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount("bf.reserve"), false)
+	}
+
+	errorRate, ok := parseFloat(args[1])
+	if !ok {
+		return Encode(errBloomBadErrorRate, false)
+	}
+	if !(errorRate > 0 && errorRate < 1) {
+		return Encode(errBloomErrorRate, false)
+	}
+	capacity, ok := parseInt64(args[2])
+	if !ok {
+		return Encode(errBloomBadCapacity, false)
+	}
+	if capacity <= 0 {
+		return Encode(errBloomCapacity, false)
+	}
+
+	expansion := int64(config.BF_EXPANSION_FACTOR)
+	expansionSet, nonScaling := false, false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "EXPANSION":
+			if i+1 >= len(args) {
+				return Encode(errSyntax, false)
+			}
+			i++
+			if expansion, ok = parseInt64(args[i]); !ok {
+				return Encode(errBloomBadExpansion, false)
+			}
+			if expansion < 1 {
+				return Encode(errBloomExpansion, false)
+			}
+			expansionSet = true
+		case "NONSCALING":
+			nonScaling = true
+		default:
+			return Encode(errSyntax, false)
+		}
+	}
+	if nonScaling {
+		if expansionSet {
+			return Encode(errBloomNonScalingExpansion, false)
+		}
+		expansion = 0
+	}
+
+	bf, err := lookupBloom(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if bf != nil {
+		return Encode(errBloomExists, false)
+	}
+	bf, err = newBloomFilter(errorRate, capacity, expansion)
+	if err != nil {
+		return Encode(err, false)
+	}
+	Put(args[0], NewObj(bf, -1, OBJ_TYPE_BLOOM, OBJ_ENCODING_BLOOM))
+	return Encode("OK", true)
+}
+
+func evalBFAdd(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("bf.add"), false)
+	}
+
+	bf, err := bloomForAdd(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	added, err := bf.add(args[1])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if added {
+		return Encode(1, false)
+	}
+	return Encode(0, false)
+}
+
+// evalBFMAdd replies the outcome of every addition, a full non scaling filter fails the remaining ones
+func evalBFMAdd(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("bf.madd"), false)
+	}
+
+	bf, err := bloomForAdd(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	out := make([]interface{}, len(args)-1)
+	for i, item := range args[1:] {
+		added, err := bf.add(item)
+		switch {
+		case err != nil:
+			out[i] = err
+		case added:
+			out[i] = 1
+		default:
+			out[i] = 0
+		}
+	}
+	return Encode(out, false)
+}
+
+func evalBFExists(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("bf.exists"), false)
+	}
+
+	bf, err := lookupBloom(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if bf != nil && bf.exists(args[1]) {
+		return Encode(1, false)
+	}
+	return Encode(0, false)
+}
+
+func evalBFMExists(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("bf.mexists"), false)
+	}
+
+	bf, err := lookupBloom(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	out := make([]interface{}, len(args)-1)
+	for i, item := range args[1:] {
+		out[i] = 0
+		if bf != nil && bf.exists(item) {
+			out[i] = 1
+		}
+	}
+	return Encode(out, false)
+}
+
+// evalBFInfo implements BF.INFO key [CAPACITY | SIZE | FILTERS | ITEMS | EXPANSION]
+func evalBFInfo(args []string) []byte {
+	if len(args) != 1 && len(args) != 2 {
+		return Encode(errWrongArgCount("bf.info"), false)
+	}
+
+	bf, err := lookupBloom(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if bf == nil {
+		return Encode(errBloomNotFound, false)
+	}
+
+	var expansion interface{}
+	if bf.expansion > 0 {
+		expansion = bf.expansion
+	}
+	if len(args) == 1 {
+		return Encode([]interface{}{
+			"Capacity", bf.capacity(),
+			"Size", bf.size(),
+			"Number of filters", len(bf.layers),
+			"Number of items inserted", bf.items(),
+			"Expansion rate", expansion,
+		}, false)
+	}
+
+	switch strings.ToUpper(args[1]) {
+	case "CAPACITY":
+		return Encode([]interface{}{bf.capacity()}, false)
+	case "SIZE":
+		return Encode([]interface{}{bf.size()}, false)
+	case "FILTERS":
+		return Encode([]interface{}{len(bf.layers)}, false)
+	case "ITEMS":
+		return Encode([]interface{}{bf.items()}, false)
+	case "EXPANSION":
+		return Encode([]interface{}{expansion}, false)
+	}
+	return Encode(errors.New("ERR Invalid information value"), false)
+}
+
+// evalScanDump replies the chunk of a filter following the iterator: the header describing the filter for
+// the iterator 0, then its byte arrays, until the iterator 0 and an empty chunk mark the end of the dump
+func evalScanDump(segments [][]byte, header []byte, iter int64) []byte {
+	if iter == 0 {
+		return Encode([]interface{}{1, string(header)}, false)
+	}
+	next, chunk := dumpChunk(segments, iter)
+	return Encode([]interface{}{next, string(chunk)}, false)
+}
+
+func evalBFScanDump(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("bf.scandump"), false)
+	}
+
+	iter, ok := parseInt64(args[1])
+	if !ok || iter < 0 {
+		return Encode(errFilterIterator, false)
+	}
+	bf, err := lookupBloom(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if bf == nil {
+		return Encode(errBloomNotFound, false)
+	}
+	return evalScanDump(bf.segments(), bf.header(), iter)
+}
+
+// evalBFLoadChunk restores a chunk dumped by BF.SCANDUMP, the header replied for the iterator 0 comes back
+// with the iterator 1 and creates the filter the following chunks are loaded into
+func evalBFLoadChunk(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("bf.loadchunk"), false)
+	}
+
+	iter, ok := parseInt64(args[1])
+	if !ok || iter < 1 {
+		return Encode(errFilterIterator, false)
+	}
+	bf, err := lookupBloom(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	if iter == 1 {
+		if bf != nil {
+			return Encode(errBloomExists, false)
+		}
+		bf, err = bloomFromHeader([]byte(args[2]))
+		if err != nil {
+			return Encode(err, false)
+		}
+		Put(args[0], NewObj(bf, -1, OBJ_TYPE_BLOOM, OBJ_ENCODING_BLOOM))
+		return Encode("OK", true)
+	}
+
+	if bf == nil {
+		return Encode(errBloomNotFound, false)
+	}
+	if err := loadChunk(bf.segments(), iter, []byte(args[2])); err != nil {
+		return Encode(err, false)
+	}
+	return Encode("OK", true)
+}
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+)
+
+func lookupCMS(key string) (*countMinSketch, error) {
+	obj, err := getOfType(key, OBJ_TYPE_CMS)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	return obj.Value.(*countMinSketch), nil
+}
+
+// existingCMS returns the sketch at key, failing when there is none
+func existingCMS(key string) (*countMinSketch, error) {
+	s, err := lookupCMS(key)
+	if err == nil && s == nil {
+		err = errCMSMissing
+	}
+	return s, err
+}
+
+func putCMS(key string, s *countMinSketch) []byte {
+	obj, err := getOfType(key, OBJ_TYPE_CMS)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj != nil {
+		return Encode(errCMSExists, false)
+	}
+	Put(key, NewObj(s, -1, OBJ_TYPE_CMS, OBJ_ENCODING_CMS))
+	return Encode("OK", true)
+}
+
+// evalCMSInitByDim implements CMS.INITBYDIM key width depth
+func evalCMSInitByDim(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("cms.initbydim"), false)
+	}
+
+	width, ok1 := parseInt64(args[1])
+	depth, ok2 := parseInt64(args[2])
+	if !ok1 || !ok2 || width < 1 || depth < 1 {
+		return Encode(errCMSBadDimensions, false)
+	}
+	s, err := newCountMinSketch(uint64(width), uint64(depth))
+	if err != nil {
+		return Encode(err, false)
+	}
+	return putCMS(args[0], s)
+}
+
+// evalCMSInitByProb implements CMS.INITBYPROB key error probability, which sizes the sketch to overestimate
+// frequencies by at most error times the total count with the given probability of failing to
+func evalCMSInitByProb(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("cms.initbyprob"), false)
+	}
+
+	errorRate, ok := parseFloat(args[1])
+	if !ok || !(errorRate > 0 && errorRate < 1) {
+		return Encode(errCMSBadError, false)
+	}
+	probability, ok := parseFloat(args[2])
+	if !ok || !(probability > 0 && probability < 1) {
+		return Encode(errCMSBadProbability, false)
+	}
+	s, err := newCountMinSketch(cmsDimensions(errorRate, probability))
+	if err != nil {
+		return Encode(err, false)
+	}
+	return putCMS(args[0], s)
+}
+
+// evalCMSIncrBy implements CMS.INCRBY key item increment [item increment ...], replying the new estimates
+func evalCMSIncrBy(args []string) []byte {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return Encode(errWrongArgCount("cms.incrby"), false)
+	}
+
+	s, err := existingCMS(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	incrs := make([]uint64, 0, len(args)/2)
+	for i := 2; i < len(args); i += 2 {
+		incr, err := strconv.ParseUint(args[i], 10, 32)
+		if err != nil {
+			return Encode(errCMSBadNumber, false)
+		}
+		incrs = append(incrs, incr)
+	}
+
+	out := make([]interface{}, len(incrs))
+	for i, incr := range incrs {
+		out[i] = int64(s.incrBy(args[1+2*i], incr))
+	}
+	return Encode(out, false)
+}
+
+func evalCMSQuery(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("cms.query"), false)
+	}
+
+	s, err := existingCMS(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	out := make([]interface{}, len(args)-1)
+	for i, item := range args[1:] {
+		out[i] = int64(s.query(item))
+	}
+	return Encode(out, false)
+}
+
+// evalCMSMerge implements CMS.MERGE destination numkeys source [source ...] [WEIGHTS weight [weight ...]],
+// the destination must exist and all the sketches must have the same dimensions
+func evalCMSMerge(args []string) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount("cms.merge"), false)
+	}
+
+	numKeys, ok := parseInt64(args[1])
+	if !ok || numKeys < 1 || numKeys > int64(len(args)-2) {
+		return Encode(errCMSBadNumKeys, false)
+	}
+	weights := make([]int64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	rest := args[2+numKeys:]
+	if len(rest) > 0 {
+		if strings.ToUpper(rest[0]) != "WEIGHTS" || int64(len(rest)-1) != numKeys {
+			return Encode(errSyntax, false)
+		}
+		for i, w := range rest[1:] {
+			if weights[i], ok = parseInt64(w); !ok {
+				return Encode(errCMSBadWeight, false)
+			}
+		}
+	}
+
+	dst, err := existingCMS(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	sources := make([]*countMinSketch, numKeys)
+	for i, key := range args[2 : 2+numKeys] {
+		if sources[i], err = existingCMS(key); err != nil {
+			return Encode(err, false)
+		}
+		if sources[i].width != dst.width || sources[i].depth != dst.depth {
+			return Encode(errCMSDimensionsMismatch, false)
+		}
+	}
+	dst.merge(sources, weights)
+	return Encode("OK", true)
+}
+
+func evalCMSInfo(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("cms.info"), false)
+	}
+
+	s, err := existingCMS(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	return Encode([]interface{}{"width", int64(s.width), "depth", int64(s.depth), "count", int64(s.count)}, false)
+}
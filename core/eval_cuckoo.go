@@ -0,0 +1,229 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/diceclone/config"
+)
+
+func lookupCuckoo(key string) (*cuckooFilter, error) {
+	obj, err := getOfType(key, OBJ_TYPE_CUCKOO)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	return obj.Value.(*cuckooFilter), nil
+}
+
+// cuckooForAdd returns the filter at key, creating it with the configured defaults when it does not exist
+func cuckooForAdd(key string) (*cuckooFilter, error) {
+	cf, err := lookupCuckoo(key)
+	if err != nil || cf != nil {
+		return cf, err
+	}
+	cf, err = newCuckooFilter(int64(config.CF_INITIAL_SIZE), config.CF_BUCKET_SIZE, config.CF_MAX_ITERATIONS, config.CF_EXPANSION_FACTOR)
+	if err != nil {
+		return nil, err
+	}
+	Put(key, NewObj(cf, -1, OBJ_TYPE_CUCKOO, OBJ_ENCODING_CUCKOO))
+	return cf, nil
+}
+
+// evalCFReserve implements CF.RESERVE key capacity [BUCKETSIZE size] [MAXITERATIONS iterations] [EXPANSION expansion]
+func evalCFReserve(args []string) []byte {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return Encode(errWrongArgCount("cf.reserve"), false)
+	}
+
+	capacity, ok := parseInt64(args[1])
+	if !ok {
+		return Encode(errBloomBadCapacity, false)
+	}
+	bucketSize, maxIterations, expansion := int64(config.CF_BUCKET_SIZE), int64(config.CF_MAX_ITERATIONS), int64(config.CF_EXPANSION_FACTOR)
+	for i := 2; i < len(args); i += 2 {
+		n, ok := parseInt64(args[i+1])
+		switch strings.ToUpper(args[i]) {
+		case "BUCKETSIZE":
+			if !ok || n < 1 || n > CF_MAX_BUCKET_SIZE {
+				return Encode(errCuckooBucketSize, false)
+			}
+			bucketSize = n
+		case "MAXITERATIONS":
+			if !ok || n < 1 || n > CF_MAX_ITERATIONS_MAX {
+				return Encode(errCuckooMaxIterations, false)
+			}
+			maxIterations = n
+		case "EXPANSION":
+			if !ok || n < 0 || n > CF_MAX_EXPANSION {
+				return Encode(errCuckooExpansion, false)
+			}
+			expansion = n
+		default:
+			return Encode(errSyntax, false)
+		}
+	}
+	if capacity < bucketSize*2 {
+		return Encode(errCuckooCapacity, false)
+	}
+
+	cf, err := lookupCuckoo(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if cf != nil {
+		return Encode(errBloomExists, false)
+	}
+	cf, err = newCuckooFilter(capacity, int(bucketSize), int(maxIterations), int(expansion))
+	if err != nil {
+		return Encode(err, false)
+	}
+	Put(args[0], NewObj(cf, -1, OBJ_TYPE_CUCKOO, OBJ_ENCODING_CUCKOO))
+	return Encode("OK", true)
+}
+
+// evalCFAdd implements CF.ADD, which adds the item once more when it is already in the filter, and CF.ADDNX,
+// which does not
+func evalCFAdd(cmd string, args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	cf, err := cuckooForAdd(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if cmd == "cf.addnx" && cf.exists(args[1]) {
+		return Encode(0, false)
+	}
+	if err := cf.add(args[1]); err != nil {
+		return Encode(err, false)
+	}
+	return Encode(1, false)
+}
+
+func evalCFDel(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("cf.del"), false)
+	}
+
+	cf, err := lookupCuckoo(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if cf == nil {
+		return Encode(errCuckooNotFound, false)
+	}
+	if cf.del(args[1]) {
+		return Encode(1, false)
+	}
+	return Encode(0, false)
+}
+
+func evalCFExists(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("cf.exists"), false)
+	}
+
+	cf, err := lookupCuckoo(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if cf != nil && cf.exists(args[1]) {
+		return Encode(1, false)
+	}
+	return Encode(0, false)
+}
+
+// evalCFCount replies the number of fingerprints matching the item's, which may count other items too
+func evalCFCount(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("cf.count"), false)
+	}
+
+	cf, err := lookupCuckoo(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if cf == nil {
+		return Encode(0, false)
+	}
+	return Encode(cf.count(args[1]), false)
+}
+
+func evalCFInfo(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("cf.info"), false)
+	}
+
+	cf, err := lookupCuckoo(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if cf == nil {
+		return Encode(errCuckooNotFound, false)
+	}
+	return Encode([]interface{}{
+		"Size", cf.size(),
+		"Number of buckets", int64(cf.numBuckets()),
+		"Number of filters", len(cf.filters),
+		"Number of items inserted", cf.items,
+		"Number of items deleted", cf.deletes,
+		"Bucket size", cf.bucketSize,
+		"Expansion rate", cf.expansion,
+		"Max iterations", cf.maxIterations,
+	}, false)
+}
+
+func evalCFScanDump(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("cf.scandump"), false)
+	}
+
+	iter, ok := parseInt64(args[1])
+	if !ok || iter < 0 {
+		return Encode(errFilterIterator, false)
+	}
+	cf, err := lookupCuckoo(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if cf == nil {
+		return Encode(errCuckooNotFound, false)
+	}
+	return evalScanDump(cf.segments(), cf.header(), iter)
+}
+
+// evalCFLoadChunk restores a chunk dumped by CF.SCANDUMP the way BF.LOADCHUNK does
+func evalCFLoadChunk(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("cf.loadchunk"), false)
+	}
+
+	iter, ok := parseInt64(args[1])
+	if !ok || iter < 1 {
+		return Encode(errFilterIterator, false)
+	}
+	cf, err := lookupCuckoo(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	if iter == 1 {
+		if cf != nil {
+			return Encode(errBloomExists, false)
+		}
+		cf, err = cuckooFromHeader([]byte(args[2]))
+		if err != nil {
+			return Encode(err, false)
+		}
+		Put(args[0], NewObj(cf, -1, OBJ_TYPE_CUCKOO, OBJ_ENCODING_CUCKOO))
+		return Encode("OK", true)
+	}
+
+	if cf == nil {
+		return Encode(errCuckooNotFound, false)
+	}
+	if err := loadChunk(cf.segments(), iter, []byte(args[2])); err != nil {
+		return Encode(err, false)
+	}
+	return Encode("OK", true)
+}
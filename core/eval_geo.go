@@ -0,0 +1,427 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errUnsupportedUnit = errors.New("ERR unsupported unit provided. please use M, KM, FT, MI")
+
+func errInvalidLongLat(longitude, latitude float64) error {
+	return fmt.Errorf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude)
+}
+
+// parseGeoUnit returns how many meters a unit is
+func parseGeoUnit(s string) (float64, bool) {
+	switch strings.ToLower(s) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	}
+	return 0, false
+}
+
+func parseLongLat(longitude, latitude string) (float64, float64, error) {
+	lon, ok := parseFloat(longitude)
+	if !ok {
+		return 0, 0, errNotFloat
+	}
+	lat, ok := parseFloat(latitude)
+	if !ok {
+		return 0, 0, errNotFloat
+	}
+	if !validGeoCoordinates(lon, lat) {
+		return 0, 0, errInvalidLongLat(lon, lat)
+	}
+	return lon, lat, nil
+}
+
+// formatGeoCoordinate renders a coordinate with 17 decimals without the trailing zeros
+func formatGeoCoordinate(f float64) string {
+	s := strings.TrimRight(strconv.FormatFloat(f, 'f', 17, 64), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// formatGeoDistance renders a distance with the 4 decimals redis replies
+func formatGeoDistance(d float64) string {
+	return strconv.FormatFloat(d, 'f', 4, 64)
+}
+
+// evalGeoAdd implements GEOADD key [NX | XX] [CH] longitude latitude member [...] on top of ZADD,
+// the members are scored with the 52-bit geohash of their position
+func evalGeoAdd(args []string) []byte {
+	if len(args) < 4 {
+		return Encode(errWrongArgCount("geoadd"), false)
+	}
+
+	zaddArgs := []string{args[0]}
+	var nx, xx bool
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "CH":
+		default:
+			break flags
+		}
+		zaddArgs = append(zaddArgs, args[i])
+	}
+
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 {
+		return Encode(errSyntax, false)
+	}
+	if nx && xx {
+		return Encode(errors.New("ERR XX and NX options at the same time are not compatible"), false)
+	}
+
+	for j := 0; j < len(triples); j += 3 {
+		lon, lat, err := parseLongLat(triples[j], triples[j+1])
+		if err != nil {
+			return Encode(err, false)
+		}
+		zaddArgs = append(zaddArgs, strconv.FormatFloat(geoScore(lon, lat), 'f', -1, 64), triples[j+2])
+	}
+	return zaddGeneric("geoadd", zaddArgs)
+}
+
+// lookupGeo returns the sorted set at key, an empty one when the key does not exist
+func lookupGeo(key string) (zsetValue, error) {
+	obj, err := getOfType(key, OBJ_TYPE_ZSET)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return &zsetListpack{lp: newListpack()}, nil
+	}
+	return zsetOf(obj), nil
+}
+
+func evalGeoPos(args []string) []byte {
+	if len(args) < 1 {
+		return Encode(errWrongArgCount("geopos"), false)
+	}
+	z, err := lookupGeo(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	// missing members are null arrays, which Encode cannot nest
+	var b bytes.Buffer
+	b.WriteString(fmt.Sprintf("*%d\r\n", len(args)-1))
+	for _, member := range args[1:] {
+		score, ok := z.Score(member)
+		if !ok {
+			b.Write(RESP_NIL_ARRAY)
+			continue
+		}
+		lon, lat := geoDecodeScore(score)
+		b.Write(Encode([]string{formatGeoCoordinate(lon), formatGeoCoordinate(lat)}, false))
+	}
+	return b.Bytes()
+}
+
+func evalGeoDist(args []string) []byte {
+	if len(args) != 3 && len(args) != 4 {
+		return Encode(errWrongArgCount("geodist"), false)
+	}
+	conversion := 1.0
+	if len(args) == 4 {
+		var ok bool
+		if conversion, ok = parseGeoUnit(args[3]); !ok {
+			return Encode(errUnsupportedUnit, false)
+		}
+	}
+
+	z, err := lookupGeo(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	score1, ok1 := z.Score(args[1])
+	score2, ok2 := z.Score(args[2])
+	if !ok1 || !ok2 {
+		return Encode(nil, false)
+	}
+	lon1, lat1 := geoDecodeScore(score1)
+	lon2, lat2 := geoDecodeScore(score2)
+	return Encode(formatGeoDistance(geoDistance(lon1, lat1, lon2, lat2)/conversion), false)
+}
+
+func evalGeoHash(args []string) []byte {
+	if len(args) < 1 {
+		return Encode(errWrongArgCount("geohash"), false)
+	}
+	z, err := lookupGeo(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	out := make([]interface{}, len(args)-1)
+	for i, member := range args[1:] {
+		if score, ok := z.Score(member); ok {
+			out[i] = geoHashString(score)
+		}
+	}
+	return Encode(out, false)
+}
+
+// geoPoint is a member found by GEOSEARCH, dist is in meters
+type geoPoint struct {
+	member              string
+	score               float64
+	longitude, latitude float64
+	dist                float64
+}
+
+// geoSearchSpec holds the options of GEOSEARCH and GEOSEARCHSTORE, sort is -1 for DESC, 1 for ASC and
+// 0 when the order does not matter
+type geoSearchSpec struct {
+	shape                          geoShape
+	fromMember                     string
+	hasMember, hasLongLat, hasBy   bool
+	sort                           int
+	count                          int64
+	any                            bool
+	withDist, withHash, withCoords bool
+	storeDist                      bool
+}
+
+// parseGeoSearch parses the options following the source key of GEOSEARCH and GEOSEARCHSTORE
+func parseGeoSearch(cmd string, args []string, store bool) (*geoSearchSpec, error) {
+	spec := &geoSearchSpec{}
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch option := strings.ToUpper(args[i]); {
+		case option == "FROMMEMBER" && remaining >= 1:
+			if spec.hasMember || spec.hasLongLat {
+				return nil, fmt.Errorf("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", cmd)
+			}
+			spec.fromMember, spec.hasMember = args[i+1], true
+			i++
+		case option == "FROMLONLAT" && remaining >= 2:
+			if spec.hasMember || spec.hasLongLat {
+				return nil, fmt.Errorf("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", cmd)
+			}
+			lon, lat, err := parseLongLat(args[i+1], args[i+2])
+			if err != nil {
+				return nil, err
+			}
+			spec.shape.longitude, spec.shape.latitude, spec.hasLongLat = lon, lat, true
+			i += 2
+		case option == "BYRADIUS" && remaining >= 2:
+			if spec.hasBy {
+				return nil, fmt.Errorf("ERR exactly one of BYRADIUS and BYBOX can be specified for %s", cmd)
+			}
+			radius, ok := parseFloat(args[i+1])
+			if !ok {
+				return nil, errors.New("ERR need numeric radius")
+			}
+			if radius < 0 {
+				return nil, errors.New("ERR radius cannot be negative")
+			}
+			conversion, ok := parseGeoUnit(args[i+2])
+			if !ok {
+				return nil, errUnsupportedUnit
+			}
+			spec.shape.radius, spec.shape.conversion, spec.hasBy = radius, conversion, true
+			i += 2
+		case option == "BYBOX" && remaining >= 3:
+			if spec.hasBy {
+				return nil, fmt.Errorf("ERR exactly one of BYRADIUS and BYBOX can be specified for %s", cmd)
+			}
+			width, ok := parseFloat(args[i+1])
+			if !ok {
+				return nil, errors.New("ERR need numeric width")
+			}
+			height, ok := parseFloat(args[i+2])
+			if !ok {
+				return nil, errors.New("ERR need numeric height")
+			}
+			if width < 0 || height < 0 {
+				return nil, errors.New("ERR height or width cannot be negative")
+			}
+			conversion, ok := parseGeoUnit(args[i+3])
+			if !ok {
+				return nil, errUnsupportedUnit
+			}
+			spec.shape.byBox, spec.shape.width, spec.shape.height = true, width, height
+			spec.shape.conversion, spec.hasBy = conversion, true
+			i += 3
+		case option == "ASC":
+			spec.sort = 1
+		case option == "DESC":
+			spec.sort = -1
+		case option == "COUNT" && remaining >= 1:
+			count, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+			if count <= 0 {
+				return nil, errors.New("ERR COUNT must be > 0")
+			}
+			spec.count = count
+			i++
+			if i+1 < len(args) && strings.ToUpper(args[i+1]) == "ANY" {
+				spec.any = true
+				i++
+			}
+		case option == "WITHDIST":
+			spec.withDist = true
+		case option == "WITHHASH":
+			spec.withHash = true
+		case option == "WITHCOORD":
+			spec.withCoords = true
+		case store && option == "STOREDIST":
+			spec.storeDist = true
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	if !spec.hasMember && !spec.hasLongLat {
+		return nil, fmt.Errorf("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", cmd)
+	}
+	if !spec.hasBy {
+		return nil, fmt.Errorf("ERR exactly one of BYRADIUS and BYBOX can be specified for %s", cmd)
+	}
+	if store && (spec.withDist || spec.withHash || spec.withCoords) {
+		return nil, fmt.Errorf("ERR %s is not compatible with WITHDIST, WITHHASH and WITHCOORD options", cmd)
+	}
+	// without an explicit order COUNT returns the closest members
+	if spec.count > 0 && !spec.any && spec.sort == 0 {
+		spec.sort = 1
+	}
+	return spec, nil
+}
+
+// geoSearch returns the members of z inside the shape, with COUNT ANY it stops at the first count found
+func geoSearch(z zsetValue, spec *geoSearchSpec) []geoPoint {
+	var points []geoPoint
+	for _, cell := range spec.shape.searchCells() {
+		min, max := cell.scoreRange()
+		first, last := (&zscoreRange{min: min, max: max, maxEx: true}).ranks(z)
+		if first > last {
+			continue
+		}
+		for _, e := range z.Range(first, last) {
+			lon, lat := geoDecodeScore(e.score)
+			dist, ok := spec.shape.contains(lon, lat)
+			if !ok {
+				continue
+			}
+			points = append(points, geoPoint{member: e.member, score: e.score, longitude: lon, latitude: lat, dist: dist})
+			if spec.any && int64(len(points)) == spec.count {
+				return points
+			}
+		}
+	}
+
+	if spec.sort != 0 {
+		sort.SliceStable(points, func(i, j int) bool {
+			if spec.sort < 0 {
+				return points[i].dist > points[j].dist
+			}
+			return points[i].dist < points[j].dist
+		})
+	}
+	if spec.count > 0 && int64(len(points)) > spec.count {
+		points = points[:spec.count]
+	}
+	return points
+}
+
+// geoSearchGeneric runs the search of GEOSEARCH and GEOSEARCHSTORE on the sorted set at key
+func geoSearchGeneric(key string, spec *geoSearchSpec) ([]geoPoint, error) {
+	obj, err := getOfType(key, OBJ_TYPE_ZSET)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	z := zsetOf(obj)
+	if spec.hasMember {
+		score, ok := z.Score(spec.fromMember)
+		if !ok {
+			return nil, errors.New("ERR could not decode requested zset member")
+		}
+		spec.shape.longitude, spec.shape.latitude = geoDecodeScore(score)
+	}
+	return geoSearch(z, spec), nil
+}
+
+// evalGeoSearch implements GEOSEARCH key FROMMEMBER member | FROMLONLAT longitude latitude
+// BYRADIUS radius unit | BYBOX width height unit [ASC | DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func evalGeoSearch(args []string) []byte {
+	if len(args) < 1 {
+		return Encode(errWrongArgCount("geosearch"), false)
+	}
+	spec, err := parseGeoSearch("GEOSEARCH", args[1:], false)
+	if err != nil {
+		return Encode(err, false)
+	}
+	points, err := geoSearchGeneric(args[0], spec)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	out := make([]interface{}, len(points))
+	for i, p := range points {
+		if !spec.withDist && !spec.withHash && !spec.withCoords {
+			out[i] = p.member
+			continue
+		}
+		item := []interface{}{p.member}
+		if spec.withDist {
+			item = append(item, formatGeoDistance(p.dist/spec.shape.conversion))
+		}
+		if spec.withHash {
+			item = append(item, int64(p.score))
+		}
+		if spec.withCoords {
+			item = append(item, []interface{}{formatGeoCoordinate(p.longitude), formatGeoCoordinate(p.latitude)})
+		}
+		out[i] = item
+	}
+	return Encode(out, false)
+}
+
+// evalGeoSearchStore stores the members GEOSEARCH finds at the destination, scored with their geohash
+// or with STOREDIST with their distance, an empty result deletes the destination
+func evalGeoSearchStore(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("geosearchstore"), false)
+	}
+	spec, err := parseGeoSearch("GEOSEARCHSTORE", args[2:], true)
+	if err != nil {
+		return Encode(err, false)
+	}
+	points, err := geoSearchGeneric(args[1], spec)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	if len(points) == 0 {
+		Delete(args[0])
+		return Encode(0, false)
+	}
+	entries := make([]zsetEntry, len(points))
+	for i, p := range points {
+		entries[i] = zsetEntry{member: p.member, score: p.score}
+		if spec.storeDist {
+			entries[i].score = p.dist / spec.shape.conversion
+		}
+	}
+	Put(args[0], newZsetObjFrom(entries))
+	return Encode(len(entries), false)
+}
@@ -0,0 +1,702 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lookupHash returns the hash stored at key after deleting its expired fields,
+// a hash left without fields is deleted and reported as missing
+func lookupHash(key string) (*Obj, error) {
+	obj, err := getOfType(key, OBJ_TYPE_HASH)
+	if err != nil || obj == nil {
+		return obj, err
+	}
+	if _, deleted := hashDeleteExpiredFields(key, obj); deleted {
+		return nil, nil
+	}
+	return obj, nil
+}
+
+// hashOf returns the hash stored at key, creating it when create is set and the key does not exist
+func hashOf(key string, create bool) (*Obj, error) {
+	obj, err := lookupHash(key)
+	if err != nil || obj != nil || !create {
+		return obj, err
+	}
+	obj = newHashObj()
+	Put(key, obj)
+	return obj, nil
+}
+
+func evalHSet(args []string) []byte {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return Encode(errWrongArgCount("hset"), false)
+	}
+
+	obj, err := hashOf(args[0], true)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	hashTypeTryConversion(obj, args[1:]...)
+	created := 0
+	for i := 1; i < len(args); i += 2 {
+		if hashSet(obj, args[i], args[i+1]) {
+			created++
+		}
+	}
+	updateIndexes(args[0])
+	return Encode(created, false)
+}
+
+func evalHMSet(args []string) []byte {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return Encode(errWrongArgCount("hmset"), false)
+	}
+
+	obj, err := hashOf(args[0], true)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	hashTypeTryConversion(obj, args[1:]...)
+	for i := 1; i < len(args); i += 2 {
+		hashSet(obj, args[i], args[i+1])
+	}
+	updateIndexes(args[0])
+	return Encode("OK", true)
+}
+
+func evalHSetNX(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("hsetnx"), false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj != nil {
+		if _, ok := hashGet(obj, args[1]); ok {
+			return Encode(0, false)
+		}
+	} else {
+		obj, _ = hashOf(args[0], true)
+	}
+
+	hashSet(obj, args[1], args[2])
+	updateIndexes(args[0])
+	return Encode(1, false)
+}
+
+func evalHGet(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("hget"), false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(nil, false)
+	}
+	value, ok := hashGet(obj, args[1])
+	if !ok {
+		return Encode(nil, false)
+	}
+	return Encode(value, false)
+}
+
+func evalHMGet(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("hmget"), false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	values := make([]interface{}, len(args)-1)
+	for i, field := range args[1:] {
+		if obj == nil {
+			continue
+		}
+		if value, ok := hashGet(obj, field); ok {
+			values[i] = value
+		}
+	}
+	return Encode(values, false)
+}
+
+func evalHDel(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("hdel"), false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+
+	deleted := 0
+	for _, field := range args[1:] {
+		if hashDelete(obj, field) {
+			deleted++
+		}
+	}
+	deleteIfEmptyHash(args[0], obj)
+	return Encode(deleted, false)
+}
+
+// deleteIfEmptyHash removes the key once its hash has no fields left, and otherwise updates the indexes
+// of the key after fields were deleted
+func deleteIfEmptyHash(key string, obj *Obj) {
+	if hashLen(obj) == 0 {
+		Delete(key)
+		return
+	}
+	updateIndexes(key)
+}
+
+func evalHExists(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("hexists"), false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+	if _, ok := hashGet(obj, args[1]); ok {
+		return Encode(1, false)
+	}
+	return Encode(0, false)
+}
+
+func evalHLen(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("hlen"), false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+	return Encode(hashLen(obj), false)
+}
+
+func evalHStrlen(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("hstrlen"), false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+	value, _ := hashGet(obj, args[1])
+	return Encode(len(value), false)
+}
+
+const (
+	HASH_GET_FIELDS = 1 << iota
+	HASH_GET_VALUES
+)
+
+// hashGetAll serves HGETALL, HKEYS and HVALS, what selects the fields, the values or both
+func hashGetAll(cmd string, args []string, what int) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode([]string{}, false)
+	}
+
+	entries := hashEntries(obj)
+	if what == HASH_GET_FIELDS|HASH_GET_VALUES {
+		return Encode(entries, false)
+	}
+	offset := 0
+	if what == HASH_GET_VALUES {
+		offset = 1
+	}
+	out := make([]string, 0, len(entries)/2)
+	for i := offset; i < len(entries); i += 2 {
+		out = append(out, entries[i])
+	}
+	return Encode(out, false)
+}
+
+func evalHGetAll(args []string) []byte {
+	return hashGetAll("hgetall", args, HASH_GET_FIELDS|HASH_GET_VALUES)
+}
+
+func evalHKeys(args []string) []byte {
+	return hashGetAll("hkeys", args, HASH_GET_FIELDS)
+}
+
+func evalHVals(args []string) []byte {
+	return hashGetAll("hvals", args, HASH_GET_VALUES)
+}
+
+func evalHIncrBy(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("hincrby"), false)
+	}
+
+	delta, ok := parseInt64(args[2])
+	if !ok {
+		return Encode(errNotInteger, false)
+	}
+
+	obj, err := hashOf(args[0], true)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	current := int64(0)
+	if value, exists := hashGet(obj, args[1]); exists {
+		if current, ok = parseInt64(value); !ok {
+			return Encode(errors.New("ERR hash value is not an integer"), false)
+		}
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return Encode(errors.New("ERR increment or decrement would overflow"), false)
+	}
+
+	result := current + delta
+	hashSetKeepTTL(obj, args[1], strconv.FormatInt(result, 10))
+	updateIndexes(args[0])
+	return Encode(result, false)
+}
+
+func evalHIncrByFloat(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("hincrbyfloat"), false)
+	}
+
+	incr, ok := parseFloat(args[2])
+	if !ok {
+		return Encode(errNotFloat, false)
+	}
+
+	obj, err := hashOf(args[0], true)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	current, text := 0.0, "0"
+	if value, exists := hashGet(obj, args[1]); exists {
+		text = value
+		if current, ok = parseFloat(value); !ok {
+			return Encode(errors.New("ERR hash value is not a float"), false)
+		}
+	}
+
+	result := current + incr
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return Encode(errors.New("ERR increment would produce NaN or Infinity"), false)
+	}
+
+	formatted := addFloats(text, current, args[2], incr)
+	hashSetKeepTTL(obj, args[1], formatted)
+	updateIndexes(args[0])
+	return Encode(formatted, false)
+}
+
+func evalHRandField(args []string) []byte {
+	if len(args) < 1 || len(args) > 3 {
+		return Encode(errWrongArgCount("hrandfield"), false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	if len(args) == 1 {
+		if obj == nil {
+			return Encode(nil, false)
+		}
+		entries := hashEntries(obj)
+		return Encode(entries[2*rand.Intn(len(entries)/2)], false)
+	}
+
+	count, ok := parseInt64(args[1])
+	if !ok {
+		return Encode(errNotInteger, false)
+	}
+	withValues := false
+	if len(args) == 3 {
+		if strings.ToUpper(args[2]) != "WITHVALUES" {
+			return Encode(errSyntax, false)
+		}
+		withValues = true
+	}
+	if count < -math.MaxInt64/2 || count > math.MaxInt64/2 {
+		return Encode(errors.New("ERR value is out of range"), false)
+	}
+	if obj == nil || count == 0 {
+		return Encode([]string{}, false)
+	}
+
+	entries := hashEntries(obj)
+	picks, err := randomPicks(len(entries)/2, count)
+	if err != nil {
+		return Encode(err, false)
+	}
+	out := make([]string, 0, len(picks)*2)
+	for _, i := range picks {
+		out = append(out, entries[2*i])
+		if withValues {
+			out = append(out, entries[2*i+1])
+		}
+	}
+	return Encode(out, false)
+}
+
+// maxRandomPicks bounds the number of elements a negative count picks, the picks are allocated before the
+// reply is encoded and a count such as -4611686018427387903 would otherwise crash the server
+const maxRandomPicks = 1 << 24
+
+var errTooManyPicks = fmt.Errorf("ERR value is out of range, a negative count picks at most %d elements", maxRandomPicks)
+
+// randomPicks returns the indexes picked among n elements by the random member commands,
+// a positive count picks up to count distinct elements and a negative one picks -count elements that may repeat
+func randomPicks(n int, count int64) ([]int, error) {
+	if count < 0 {
+		if count < -maxRandomPicks {
+			return nil, errTooManyPicks
+		}
+		picks := make([]int, -count)
+		for i := range picks {
+			picks[i] = rand.Intn(n)
+		}
+		return picks, nil
+	}
+	picks := rand.Perm(n)
+	if count < int64(n) {
+		picks = picks[:count]
+	}
+	return picks, nil
+}
+
+func evalHScan(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("hscan"), false)
+	}
+
+	opts, err := parseScanArgs(args[1:], true)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return encodeScanReply(0, nil)
+	}
+
+	// compact encodings are returned whole in a single call, like redis does
+	var cursor uint64
+	var fields []string
+	if obj.Encoding() == OBJ_ENCODING_LISTPACK {
+		entries := hashEntries(obj)
+		for i := 0; i < len(entries); i += 2 {
+			fields = append(fields, entries[i])
+		}
+	} else {
+		cursor, fields = scanByHash(args[0], obj, opts.cursor, opts.count)
+	}
+
+	var out []string
+	for _, field := range fields {
+		if !opts.matches(field) {
+			continue
+		}
+		out = append(out, field)
+		if !opts.noValues {
+			value, _ := hashGet(obj, field)
+			out = append(out, value)
+		}
+	}
+	return encodeScanReply(cursor, out)
+}
+
+// parseHashFields parses the FIELDS numfields field [field ...] arguments of the field expiry commands
+func parseHashFields(args []string) ([]string, error) {
+	if len(args) < 2 || strings.ToUpper(args[0]) != "FIELDS" {
+		return nil, errors.New("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	numFields, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || numFields <= 0 {
+		return nil, errors.New("ERR Parameter `numFields` should be greater than 0")
+	}
+	if numFields != int64(len(args)-2) {
+		return nil, errors.New("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+// replies of the field expiry commands for every field
+const (
+	HFE_NO_FIELD      = -2
+	HFE_NO_TTL        = -1
+	HFE_NOT_SET       = 0
+	HFE_SET           = 1
+	HFE_DELETED       = 2
+	HFE_PERSISTED     = 1
+	HFE_TIME_UNIT_SEC = 1000
+	HFE_TIME_UNIT_MS  = 1
+)
+
+func hashFieldReplies(n int, reply int) []interface{} {
+	replies := make([]interface{}, n)
+	for i := range replies {
+		replies[i] = reply
+	}
+	return replies
+}
+
+func evalHExpire(args []string) []byte {
+	return hashExpireGeneric("hexpire", args, HFE_TIME_UNIT_SEC, false)
+}
+
+func evalHPExpire(args []string) []byte {
+	return hashExpireGeneric("hpexpire", args, HFE_TIME_UNIT_MS, false)
+}
+
+func evalHExpireAt(args []string) []byte {
+	return hashExpireGeneric("hexpireat", args, HFE_TIME_UNIT_SEC, true)
+}
+
+func evalHPExpireAt(args []string) []byte {
+	return hashExpireGeneric("hpexpireat", args, HFE_TIME_UNIT_MS, true)
+}
+
+// hashExpireGeneric implements key time [NX | XX | GT | LT] FIELDS numfields field [field ...],
+// a time already in the past deletes the fields right away
+func hashExpireGeneric(cmd string, args []string, unit int64, absolute bool) []byte {
+	if len(args) < 5 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	errInvalidExpire := fmt.Errorf("ERR invalid expire time, must be >= 0 and <= %d", int64(HASH_FIELD_EXPIRE_MAX))
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
+	if n < 0 || n > HASH_FIELD_EXPIRE_MAX/unit {
+		return Encode(errInvalidExpire, false)
+	}
+	now := time.Now().UnixMilli()
+	at := n * unit
+	if !absolute {
+		at += now
+	}
+	if at > HASH_FIELD_EXPIRE_MAX {
+		return Encode(errInvalidExpire, false)
+	}
+
+	rest := args[2:]
+	condition := ""
+	switch option := strings.ToUpper(rest[0]); option {
+	case "NX", "XX", "GT", "LT":
+		condition = option
+		rest = rest[1:]
+	}
+	fields, err := parseHashFields(rest)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(hashFieldReplies(len(fields), HFE_NO_FIELD), false)
+	}
+
+	replies := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if _, ok := hashGet(obj, field); !ok {
+			replies[i] = HFE_NO_FIELD
+			continue
+		}
+
+		// a field without ttl behaves as if it expired at infinity
+		current := hashFieldExpireAt(obj, field)
+		if (condition == "NX" && current != -1) ||
+			(condition == "XX" && current == -1) ||
+			(condition == "GT" && (current == -1 || at <= current)) ||
+			(condition == "LT" && current != -1 && at >= current) {
+			replies[i] = HFE_NOT_SET
+			continue
+		}
+
+		if at <= now {
+			hashDelete(obj, field)
+			replies[i] = HFE_DELETED
+			continue
+		}
+		hashFieldSetExpire(args[0], obj, field, at)
+		replies[i] = HFE_SET
+	}
+	deleteIfEmptyHash(args[0], obj)
+	return Encode(replies, false)
+}
+
+const (
+	HFE_TTL_SEC = iota
+	HFE_TTL_MS
+	HFE_EXPIRETIME_SEC
+	HFE_EXPIRETIME_MS
+)
+
+func evalHTtl(args []string) []byte {
+	return hashTtlGeneric("httl", args, HFE_TTL_SEC)
+}
+
+func evalHPTtl(args []string) []byte {
+	return hashTtlGeneric("hpttl", args, HFE_TTL_MS)
+}
+
+func evalHExpireTime(args []string) []byte {
+	return hashTtlGeneric("hexpiretime", args, HFE_EXPIRETIME_SEC)
+}
+
+func evalHPExpireTime(args []string) []byte {
+	return hashTtlGeneric("hpexpiretime", args, HFE_EXPIRETIME_MS)
+}
+
+func hashTtlGeneric(cmd string, args []string, mode int) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	fields, err := parseHashFields(args[1:])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(hashFieldReplies(len(fields), HFE_NO_FIELD), false)
+	}
+
+	now := time.Now().UnixMilli()
+	replies := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if _, ok := hashGet(obj, field); !ok {
+			replies[i] = HFE_NO_FIELD
+			continue
+		}
+		at := hashFieldExpireAt(obj, field)
+		if at == -1 {
+			replies[i] = HFE_NO_TTL
+			continue
+		}
+		switch mode {
+		case HFE_TTL_SEC:
+			replies[i] = (at - now + 999) / 1000
+		case HFE_TTL_MS:
+			replies[i] = at - now
+		case HFE_EXPIRETIME_SEC:
+			replies[i] = at / 1000
+		case HFE_EXPIRETIME_MS:
+			replies[i] = at
+		}
+	}
+	return Encode(replies, false)
+}
+
+func evalHPersist(args []string) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount("hpersist"), false)
+	}
+
+	fields, err := parseHashFields(args[1:])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(hashFieldReplies(len(fields), HFE_NO_FIELD), false)
+	}
+
+	replies := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if _, ok := hashGet(obj, field); !ok {
+			replies[i] = HFE_NO_FIELD
+		} else if hashFieldPersist(obj, field) {
+			replies[i] = HFE_PERSISTED
+		} else {
+			replies[i] = HFE_NO_TTL
+		}
+	}
+	return Encode(replies, false)
+}
+
+func evalHGetDel(args []string) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount("hgetdel"), false)
+	}
+
+	fields, err := parseHashFields(args[1:])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	obj, err := lookupHash(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	values := make([]interface{}, len(fields))
+	if obj == nil {
+		return Encode(values, false)
+	}
+	for i, field := range fields {
+		if value, ok := hashGet(obj, field); ok {
+			values[i] = value
+			hashDelete(obj, field)
+		}
+	}
+	deleteIfEmptyHash(args[0], obj)
+	return Encode(values, false)
+}
@@ -0,0 +1,206 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// lookupHLL returns the string at key, failing when it does not hold a HyperLogLog
+func lookupHLL(key string) (*Obj, error) {
+	obj, err := getOfType(key, OBJ_TYPE_STRING)
+	if err != nil || obj == nil {
+		return obj, err
+	}
+	if !isValidHLL([]byte(stringValueOf(obj))) {
+		return nil, errInvalidHLL
+	}
+	return obj, nil
+}
+
+func evalPFAdd(args []string) []byte {
+	if len(args) < 1 {
+		return Encode(errWrongArgCount("pfadd"), false)
+	}
+
+	obj, err := lookupHLL(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	created := obj == nil
+	if created {
+		obj = NewObj(newHLL(), -1, OBJ_TYPE_STRING, OBJ_ENCODING_RAW)
+		Put(args[0], obj)
+	}
+
+	b, updated, err := hllAdd(rawBytesOf(obj), args[1:])
+	if err != nil {
+		return Encode(err, false)
+	}
+	obj.Value = b
+	if updated {
+		signalKeyModified(args[0])
+	}
+	if created || updated {
+		return Encode(1, false)
+	}
+	return Encode(0, false)
+}
+
+// evalPFCount estimates the cardinality of the union of the HyperLogLogs, the estimate of a single
+// HyperLogLog is cached in its header until it is modified
+func evalPFCount(args []string) []byte {
+	if len(args) < 1 {
+		return Encode(errWrongArgCount("pfcount"), false)
+	}
+
+	if len(args) == 1 {
+		obj, err := lookupHLL(args[0])
+		if err != nil {
+			return Encode(err, false)
+		}
+		if obj == nil {
+			return Encode(0, false)
+		}
+
+		b := rawBytesOf(obj)
+		if count, ok := hllCachedCount(b); ok {
+			return Encode(int64(count), false)
+		}
+		regs, err := hllRegisters(b)
+		if err != nil {
+			return Encode(err, false)
+		}
+		count := hllCount(regs)
+		hllSetCachedCount(b, count)
+		return Encode(int64(count), false)
+	}
+
+	merged, _, err := hllMergeKeys(args)
+	if err != nil {
+		return Encode(err, false)
+	}
+	return Encode(int64(hllCount(merged)), false)
+}
+
+// hllMergeKeys returns the maximum of every register across the HyperLogLogs and whether one of them is dense
+func hllMergeKeys(keys []string) ([]uint8, bool, error) {
+	merged := make([]uint8, HLL_REGISTERS)
+	dense := false
+	for _, key := range keys {
+		obj, err := lookupHLL(key)
+		if err != nil {
+			return nil, false, err
+		}
+		if obj == nil {
+			continue
+		}
+
+		b := rawBytesOf(obj)
+		dense = dense || b[4] == HLL_DENSE
+		regs, err := hllRegisters(b)
+		if err != nil {
+			return nil, false, err
+		}
+		for i, v := range regs {
+			merged[i] = max(merged[i], v)
+		}
+	}
+	return merged, dense, nil
+}
+
+// evalPFMerge stores the union of the source HyperLogLogs and the destination in the destination,
+// which stays sparse unless one of them is dense
+func evalPFMerge(args []string) []byte {
+	if len(args) < 1 {
+		return Encode(errWrongArgCount("pfmerge"), false)
+	}
+
+	merged, dense, err := hllMergeKeys(args)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	b := hllFromRegisters(merged, !dense)
+	obj, _ := lookupHLL(args[0])
+	if obj == nil {
+		Put(args[0], NewObj(b, -1, OBJ_TYPE_STRING, OBJ_ENCODING_RAW))
+	} else {
+		obj.Value = b
+		obj.setEncoding(OBJ_ENCODING_RAW)
+		signalKeyModified(args[0])
+	}
+	return Encode("OK", true)
+}
+
+func evalPFDebug(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("pfdebug"), false)
+	}
+
+	obj, err := lookupHLL(args[1])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(errors.New("ERR The specified key does not exist"), false)
+	}
+	b := rawBytesOf(obj)
+	regs, err := hllRegisters(b)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "GETREG":
+		if b[4] == HLL_SPARSE {
+			obj.Value = hllFromRegisters(regs, false)
+		}
+		out := make([]interface{}, len(regs))
+		for i, v := range regs {
+			out[i] = int(v)
+		}
+		return Encode(out, false)
+	case "DECODE":
+		if b[4] != HLL_SPARSE {
+			return Encode(errors.New("ERR HLL encoding is not sparse"), false)
+		}
+		var ops []string
+		for p := HLL_HDR_SIZE; p < len(b); p++ {
+			op := b[p]
+			switch {
+			case op&0xc0 == 0:
+				ops = append(ops, fmt.Sprintf("Z:%d", op&0x3f+1))
+			case op&0xc0 == 0x40:
+				ops = append(ops, fmt.Sprintf("XZ:%d", (int(op&0x3f)<<8|int(b[p+1]))+1))
+				p++
+			default:
+				ops = append(ops, fmt.Sprintf("v:%d,%d", (op>>2)&0x1f+1, op&0x3+1))
+			}
+		}
+		return Encode(strings.Join(ops, " "), true)
+	case "ENCODING":
+		if b[4] == HLL_SPARSE {
+			return Encode("sparse", true)
+		}
+		return Encode("dense", true)
+	case "TODENSE":
+		if b[4] == HLL_DENSE {
+			return Encode(0, false)
+		}
+		obj.Value = hllFromRegisters(regs, false)
+		return Encode(1, false)
+	default:
+		return Encode(fmt.Errorf("ERR Unknown PFDEBUG subcommand '%s'", args[0]), false)
+	}
+}
+
+func evalPFSelfTest(args []string) []byte {
+	if len(args) != 0 {
+		return Encode(errWrongArgCount("pfselftest"), false)
+	}
+	if err := hllSelfTest(); err != nil {
+		return Encode(err, false)
+	}
+	return Encode("OK", true)
+}
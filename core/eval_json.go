@@ -0,0 +1,570 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var errJSONMissingKey = errors.New("ERR could not perform this operation on a key that doesn't exist")
+
+func errJSONPathMissing(path string) error {
+	return fmt.Errorf("ERR Path '%s' does not exist", path)
+}
+
+// jsonTypeError is the failure of a command on a value of the wrong type, JSONPath paths reply
+// a nil for such values where legacy paths fail
+type jsonTypeError struct {
+	expected string
+	found    *jsonValue
+}
+
+func (e *jsonTypeError) Error() string {
+	return fmt.Sprintf("WRONGTYPE wrong type of path value - expected %s but found %s", e.expected, e.found.typeName())
+}
+
+func lookupJSON(key string) (*jsonValue, error) {
+	obj, err := getOfType(key, OBJ_TYPE_JSON)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	return obj.Value.(*jsonValue), nil
+}
+
+// jsonPathArg parses the optional path at args[i], the legacy root when it is absent
+func jsonPathArg(args []string, i int) (*jsonPath, error) {
+	if i >= len(args) {
+		return parseJSONPath(".")
+	}
+	return parseJSONPath(args[i])
+}
+
+// jsonPerMatch calls fn on every match of the path, replying one result per match for JSONPath paths
+// and the result of the first match for legacy paths, which fail when nothing matches
+func jsonPerMatch(path *jsonPath, root *jsonValue, fn func(m jsonMatch) (interface{}, error)) []byte {
+	matches := path.eval(root)
+	if path.legacy && len(matches) == 0 {
+		return Encode(errJSONPathMissing(path.text), false)
+	}
+
+	out := make([]interface{}, len(matches))
+	for i, m := range matches {
+		r, err := fn(m)
+		var typeErr *jsonTypeError
+		if err != nil && (path.legacy || !errors.As(err, &typeErr)) {
+			return Encode(err, false)
+		}
+		out[i] = r
+	}
+	if path.legacy {
+		return Encode(out[0], false)
+	}
+	return Encode(out, false)
+}
+
+// jsonMatchesArray gathers the matched values in an array for replies, the values are not copied
+func jsonMatchesArray(matches []jsonMatch) *jsonValue {
+	arr := &jsonValue{kind: JSON_ARRAY, arr: make([]*jsonValue, len(matches))}
+	for i, m := range matches {
+		arr.arr[i] = m.value
+	}
+	return arr
+}
+
+// evalJSONSet implements JSON.SET key path value [NX | XX], a path that matches nothing adds the
+// key it ends with to the objects its parent path matches
+func evalJSONSet(args []string) []byte {
+	if len(args) != 3 && len(args) != 4 {
+		return Encode(errWrongArgCount("json.set"), false)
+	}
+	path, err := parseJSONPath(args[1])
+	if err != nil {
+		return Encode(err, false)
+	}
+	value, err := parseJSON(args[2])
+	if err != nil {
+		return Encode(err, false)
+	}
+	var nx, xx bool
+	if len(args) == 4 {
+		switch strings.ToUpper(args[3]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return Encode(errSyntax, false)
+		}
+	}
+
+	root, err := lookupJSON(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if root == nil {
+		if !path.isRoot() {
+			return Encode(errors.New("ERR new objects must be created at the root"), false)
+		}
+		if xx {
+			return Encode(nil, false)
+		}
+		Put(args[0], NewObj(value, -1, OBJ_TYPE_JSON, OBJ_ENCODING_JSON))
+		return Encode("OK", true)
+	}
+
+	if matches := path.eval(root); len(matches) > 0 {
+		if nx {
+			return Encode(nil, false)
+		}
+		for _, m := range matches {
+			*m.value = *value.clone()
+		}
+		return Encode("OK", true)
+	}
+	if xx {
+		return Encode(nil, false)
+	}
+
+	last := path.steps[len(path.steps)-1]
+	if last.kind != JSON_STEP_KEY || last.recursive || len(last.keys) != 1 {
+		return Encode(nil, false)
+	}
+	parent := &jsonPath{text: path.text, legacy: path.legacy, steps: path.steps[:len(path.steps)-1]}
+	created := false
+	for _, m := range parent.eval(root) {
+		if m.value.kind == JSON_OBJECT {
+			m.value.set(last.keys[0], value.clone())
+			created = true
+		}
+	}
+	if !created {
+		return Encode(nil, false)
+	}
+	return Encode("OK", true)
+}
+
+// evalJSONGet implements JSON.GET key [INDENT indent] [NEWLINE newline] [SPACE space] [path ...],
+// several paths reply an object from each path to what it matches
+func evalJSONGet(args []string) []byte {
+	if len(args) < 1 {
+		return Encode(errWrongArgCount("json.get"), false)
+	}
+
+	f := &jsonFormat{}
+	i := 1
+options:
+	for ; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "INDENT":
+			f.indent = args[i+1]
+		case "NEWLINE":
+			f.newline = args[i+1]
+		case "SPACE":
+			f.space = args[i+1]
+		default:
+			break options
+		}
+	}
+	texts := args[i:]
+	if len(texts) == 0 {
+		texts = []string{"."}
+	}
+	paths := make([]*jsonPath, len(texts))
+	legacy := true
+	for j, text := range texts {
+		path, err := parseJSONPath(text)
+		if err != nil {
+			return Encode(err, false)
+		}
+		paths[j] = path
+		legacy = legacy && path.legacy
+	}
+
+	root, err := lookupJSON(args[0])
+	if err != nil || root == nil {
+		return Encode(err, false)
+	}
+
+	if len(paths) == 1 {
+		matches := paths[0].eval(root)
+		if !legacy {
+			return Encode(jsonMatchesArray(matches).format(f), false)
+		}
+		if len(matches) == 0 {
+			return Encode(errJSONPathMissing(paths[0].text), false)
+		}
+		return Encode(matches[0].value.format(f), false)
+	}
+
+	// with a JSONPath among the paths every path replies all its matches
+	out := newJSONObject()
+	for _, path := range paths {
+		matches := path.eval(root)
+		switch {
+		case !legacy:
+			out.set(path.text, jsonMatchesArray(matches))
+		case len(matches) == 0:
+			return Encode(errJSONPathMissing(path.text), false)
+		default:
+			out.set(path.text, matches[0].value)
+		}
+	}
+	return Encode(out.format(f), false)
+}
+
+// evalJSONDel implements JSON.DEL and JSON.FORGET, deleting the root deletes the key
+func evalJSONDel(cmd string, args []string) []byte {
+	if len(args) != 1 && len(args) != 2 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+	path, err := jsonPathArg(args, 1)
+	if err != nil {
+		return Encode(err, false)
+	}
+	root, err := lookupJSON(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if root == nil {
+		return Encode(0, false)
+	}
+	if path.isRoot() {
+		Delete(args[0])
+		return Encode(1, false)
+	}
+
+	deleted := 0
+	for _, m := range path.eval(root) {
+		switch m.parent.kind {
+		case JSON_OBJECT:
+			// a value matched twice or below a deleted one may be gone already
+			if m.parent.fields[m.key] == m.value && m.parent.remove(m.key) {
+				deleted++
+			}
+		case JSON_ARRAY:
+			for i, e := range m.parent.arr {
+				if e == m.value {
+					m.parent.arr = append(m.parent.arr[:i], m.parent.arr[i+1:]...)
+					deleted++
+					break
+				}
+			}
+		}
+	}
+	return Encode(deleted, false)
+}
+
+func evalJSONType(args []string) []byte {
+	if len(args) != 1 && len(args) != 2 {
+		return Encode(errWrongArgCount("json.type"), false)
+	}
+	path, err := jsonPathArg(args, 1)
+	if err != nil {
+		return Encode(err, false)
+	}
+	root, err := lookupJSON(args[0])
+	if err != nil || root == nil {
+		return Encode(err, false)
+	}
+	return jsonPerMatch(path, root, func(m jsonMatch) (interface{}, error) {
+		return m.value.typeName(), nil
+	})
+}
+
+// jsonIncr adds incr to the number v, which stays an integer when both are and the sum does not overflow
+func jsonIncr(v *jsonValue, incr *jsonValue) error {
+	if v.kind == JSON_INT && incr.kind == JSON_INT {
+		sum := v.i + incr.i
+		if (sum > v.i) == (incr.i > 0) {
+			v.i = sum
+			return nil
+		}
+	}
+	sum := v.float() + incr.float()
+	if math.IsInf(sum, 0) {
+		return errors.New("ERR result is not a finite number")
+	}
+	*v = jsonValue{kind: JSON_FLOAT, f: sum}
+	return nil
+}
+
+// evalJSONNumIncrBy replies the new values as a JSON text, an array holding null for the values that
+// are not numbers with JSONPath paths
+func evalJSONNumIncrBy(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("json.numincrby"), false)
+	}
+	path, err := parseJSONPath(args[1])
+	if err != nil {
+		return Encode(err, false)
+	}
+	incr, err := parseJSON(args[2])
+	if err != nil || !incr.isNumber() {
+		return Encode(errors.New("ERR the increment must be a number"), false)
+	}
+	root, err := lookupJSON(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if root == nil {
+		return Encode(errJSONMissingKey, false)
+	}
+
+	matches := path.eval(root)
+	if path.legacy && len(matches) == 0 {
+		return Encode(errJSONPathMissing(path.text), false)
+	}
+	results := &jsonValue{kind: JSON_ARRAY, arr: []*jsonValue{}}
+	for _, m := range matches {
+		if !m.value.isNumber() {
+			if path.legacy {
+				return Encode(&jsonTypeError{"a number", m.value}, false)
+			}
+			results.arr = append(results.arr, &jsonValue{kind: JSON_NULL})
+			continue
+		}
+		if err := jsonIncr(m.value, incr); err != nil {
+			return Encode(err, false)
+		}
+		results.arr = append(results.arr, m.value)
+	}
+	if path.legacy {
+		return Encode(results.arr[0].String(), false)
+	}
+	return Encode(results.String(), false)
+}
+
+// evalJSONStrAppend implements JSON.STRAPPEND key [path] value where value is a JSON string
+func evalJSONStrAppend(args []string) []byte {
+	if len(args) != 2 && len(args) != 3 {
+		return Encode(errWrongArgCount("json.strappend"), false)
+	}
+	path, err := jsonPathArg(args[:len(args)-1], 1)
+	if err != nil {
+		return Encode(err, false)
+	}
+	value, err := parseJSON(args[len(args)-1])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if value.kind != JSON_STRING {
+		return Encode(errors.New("ERR the value to append must be a JSON string"), false)
+	}
+	root, err := lookupJSON(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if root == nil {
+		return Encode(errJSONMissingKey, false)
+	}
+
+	return jsonPerMatch(path, root, func(m jsonMatch) (interface{}, error) {
+		if m.value.kind != JSON_STRING {
+			return nil, &jsonTypeError{"string", m.value}
+		}
+		m.value.s += value.s
+		return len(m.value.s), nil
+	})
+}
+
+// parseJSONValues parses the values given to the array commands
+func parseJSONValues(args []string) ([]*jsonValue, error) {
+	values := make([]*jsonValue, len(args))
+	for i, arg := range args {
+		v, err := parseJSON(arg)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// jsonArrInsert inserts copies of the values in the array before index, which may count from the end
+func jsonArrInsert(m jsonMatch, index int, values []*jsonValue) (interface{}, error) {
+	arr := m.value
+	if arr.kind != JSON_ARRAY {
+		return nil, &jsonTypeError{"array", arr}
+	}
+	if index < 0 {
+		index += len(arr.arr)
+	}
+	if index < 0 || index > len(arr.arr) {
+		return nil, errors.New("ERR index out of bounds")
+	}
+	inserted := make([]*jsonValue, len(values))
+	for i, v := range values {
+		inserted[i] = v.clone()
+	}
+	arr.arr = append(arr.arr[:index], append(inserted, arr.arr[index:]...)...)
+	return len(arr.arr), nil
+}
+
+func evalJSONArrAppend(args []string) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount("json.arrappend"), false)
+	}
+	path, err := parseJSONPath(args[1])
+	if err != nil {
+		return Encode(err, false)
+	}
+	values, err := parseJSONValues(args[2:])
+	if err != nil {
+		return Encode(err, false)
+	}
+	root, err := lookupJSON(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if root == nil {
+		return Encode(errJSONMissingKey, false)
+	}
+
+	return jsonPerMatch(path, root, func(m jsonMatch) (interface{}, error) {
+		if m.value.kind != JSON_ARRAY {
+			return nil, &jsonTypeError{"array", m.value}
+		}
+		return jsonArrInsert(m, len(m.value.arr), values)
+	})
+}
+
+func evalJSONArrInsert(args []string) []byte {
+	if len(args) < 4 {
+		return Encode(errWrongArgCount("json.arrinsert"), false)
+	}
+	path, err := parseJSONPath(args[1])
+	if err != nil {
+		return Encode(err, false)
+	}
+	index, err := strconv.Atoi(args[2])
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
+	values, err := parseJSONValues(args[3:])
+	if err != nil {
+		return Encode(err, false)
+	}
+	root, err := lookupJSON(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if root == nil {
+		return Encode(errJSONMissingKey, false)
+	}
+
+	return jsonPerMatch(path, root, func(m jsonMatch) (interface{}, error) {
+		return jsonArrInsert(m, index, values)
+	})
+}
+
+// evalJSONArrPop implements JSON.ARRPOP key [path [index]], the index is clamped to the array and
+// defaults to the last element, popping from an empty array replies nil
+func evalJSONArrPop(args []string) []byte {
+	if len(args) < 1 || len(args) > 3 {
+		return Encode(errWrongArgCount("json.arrpop"), false)
+	}
+	path, err := jsonPathArg(args, 1)
+	if err != nil {
+		return Encode(err, false)
+	}
+	index := -1
+	if len(args) == 3 {
+		if index, err = strconv.Atoi(args[2]); err != nil {
+			return Encode(errNotInteger, false)
+		}
+	}
+	root, err := lookupJSON(args[0])
+	if err != nil || root == nil {
+		return Encode(err, false)
+	}
+
+	return jsonPerMatch(path, root, func(m jsonMatch) (interface{}, error) {
+		arr := m.value
+		if arr.kind != JSON_ARRAY {
+			return nil, &jsonTypeError{"array", arr}
+		}
+		n := len(arr.arr)
+		if n == 0 {
+			return nil, nil
+		}
+		i := index
+		if i < 0 {
+			i += n
+		}
+		i = min(max(i, 0), n-1)
+		popped := arr.arr[i]
+		arr.arr = append(arr.arr[:i], arr.arr[i+1:]...)
+		return popped.String(), nil
+	})
+}
+
+func evalJSONArrLen(args []string) []byte {
+	if len(args) != 1 && len(args) != 2 {
+		return Encode(errWrongArgCount("json.arrlen"), false)
+	}
+	path, err := jsonPathArg(args, 1)
+	if err != nil {
+		return Encode(err, false)
+	}
+	root, err := lookupJSON(args[0])
+	if err != nil || root == nil {
+		return Encode(err, false)
+	}
+	return jsonPerMatch(path, root, func(m jsonMatch) (interface{}, error) {
+		if m.value.kind != JSON_ARRAY {
+			return nil, &jsonTypeError{"array", m.value}
+		}
+		return len(m.value.arr), nil
+	})
+}
+
+func evalJSONObjKeys(args []string) []byte {
+	if len(args) != 1 && len(args) != 2 {
+		return Encode(errWrongArgCount("json.objkeys"), false)
+	}
+	path, err := jsonPathArg(args, 1)
+	if err != nil {
+		return Encode(err, false)
+	}
+	root, err := lookupJSON(args[0])
+	if err != nil || root == nil {
+		return Encode(err, false)
+	}
+	return jsonPerMatch(path, root, func(m jsonMatch) (interface{}, error) {
+		if m.value.kind != JSON_OBJECT {
+			return nil, &jsonTypeError{"object", m.value}
+		}
+		return append([]string{}, m.value.keys...), nil
+	})
+}
+
+// evalJSONMGet implements JSON.MGET key [key ...] path, replying nil for the keys that do not hold
+// a JSON document and for legacy paths that match nothing
+func evalJSONMGet(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("json.mget"), false)
+	}
+	path, err := parseJSONPath(args[len(args)-1])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	keys := args[:len(args)-1]
+	out := make([]interface{}, len(keys))
+	for i, key := range keys {
+		root, err := lookupJSON(key)
+		if err != nil || root == nil {
+			continue
+		}
+		matches := path.eval(root)
+		switch {
+		case !path.legacy:
+			out[i] = jsonMatchesArray(matches).String()
+		case len(matches) > 0:
+			out[i] = matches[0].value.String()
+		}
+	}
+	return Encode(out, false)
+}
@@ -0,0 +1,697 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var errNoSuchKey = errors.New("ERR no such key")
+var errIndexOutOfRange = errors.New("ERR index out of range")
+
+// normalizeListIndex turns a negative index into an offset from the start of the list
+func normalizeListIndex(i int64, length int) int64 {
+	if i < 0 {
+		return int64(length) + i
+	}
+	return i
+}
+
+func parseListWhere(s string) (int, bool) {
+	switch strings.ToUpper(s) {
+	case "LEFT":
+		return LIST_HEAD, true
+	case "RIGHT":
+		return LIST_TAIL, true
+	default:
+		return 0, false
+	}
+}
+
+// deleteIfEmptyList removes the key once its list has no elements left
+func deleteIfEmptyList(key string, obj *Obj) {
+	if listOf(obj).Len() == 0 {
+		Delete(key)
+		return
+	}
+	listTypeTryConversion(obj)
+}
+
+func evalLPush(args []string) []byte {
+	return pushGeneric("lpush", args, LIST_HEAD, false)
+}
+
+func evalRPush(args []string) []byte {
+	return pushGeneric("rpush", args, LIST_TAIL, false)
+}
+
+func evalLPushX(args []string) []byte {
+	return pushGeneric("lpushx", args, LIST_HEAD, true)
+}
+
+func evalRPushX(args []string) []byte {
+	return pushGeneric("rpushx", args, LIST_TAIL, true)
+}
+
+func pushGeneric(cmd string, args []string, where int, onlyIfExists bool) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_LIST)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		if onlyIfExists {
+			return Encode(0, false)
+		}
+		obj = newListObj()
+		Put(args[0], obj)
+	}
+
+	for _, value := range args[1:] {
+		listPush(obj, value, where)
+	}
+	listTypeTryConversion(obj)
+
+	return Encode(listOf(obj).Len(), false)
+}
+
+func evalLPop(args []string) []byte {
+	return popGeneric("lpop", args, LIST_HEAD)
+}
+
+func evalRPop(args []string) []byte {
+	return popGeneric("rpop", args, LIST_TAIL)
+}
+
+func popGeneric(cmd string, args []string, where int) []byte {
+	if len(args) < 1 || len(args) > 2 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	count := int64(-1)
+	if len(args) == 2 {
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || n < 0 {
+			return Encode(errors.New("ERR value is out of range, must be positive"), false)
+		}
+		count = n
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_LIST)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		if count >= 0 {
+			return RESP_NIL_ARRAY
+		}
+		return Encode(nil, false)
+	}
+
+	if count < 0 {
+		value, _ := listPop(obj, where)
+		deleteIfEmptyList(args[0], obj)
+		return Encode(value, false)
+	}
+
+	values := popCount(obj, where, count)
+	deleteIfEmptyList(args[0], obj)
+	return Encode(values, false)
+}
+
+func popCount(obj *Obj, where int, count int64) []string {
+	values := []string{}
+	for ; count > 0; count-- {
+		value, ok := listPop(obj, where)
+		if !ok {
+			break
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func evalLLen(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("llen"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_LIST)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+	return Encode(listOf(obj).Len(), false)
+}
+
+func evalLRange(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("lrange"), false)
+	}
+
+	start, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
+	end, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_LIST)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode([]string{}, false)
+	}
+
+	list := listOf(obj)
+	start = max(normalizeListIndex(start, list.Len()), 0)
+	end = min(normalizeListIndex(end, list.Len()), int64(list.Len()-1))
+	if start > end {
+		return Encode([]string{}, false)
+	}
+	return Encode(list.Range(int(start), int(end)), false)
+}
+
+func evalLIndex(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("lindex"), false)
+	}
+
+	index, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_LIST)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(nil, false)
+	}
+
+	list := listOf(obj)
+	value, ok := list.Index(int(normalizeListIndex(index, list.Len())))
+	if !ok {
+		return Encode(nil, false)
+	}
+	return Encode(value, false)
+}
+
+func evalLSet(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("lset"), false)
+	}
+
+	index, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_LIST)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(errNoSuchKey, false)
+	}
+
+	list := listOf(obj)
+	index = normalizeListIndex(index, list.Len())
+	if index < 0 || index >= int64(list.Len()) {
+		return Encode(errIndexOutOfRange, false)
+	}
+	list.Replace(int(index), args[2])
+	listTypeTryConversion(obj)
+	return Encode("OK", true)
+}
+
+func evalLInsert(args []string) []byte {
+	if len(args) != 4 {
+		return Encode(errWrongArgCount("linsert"), false)
+	}
+
+	var after bool
+	switch strings.ToUpper(args[1]) {
+	case "BEFORE":
+		after = false
+	case "AFTER":
+		after = true
+	default:
+		return Encode(errSyntax, false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_LIST)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+
+	list := listOf(obj)
+	pivot := -1
+	for i, value := range list.Range(0, list.Len()-1) {
+		if value == args[2] {
+			pivot = i
+			break
+		}
+	}
+	if pivot == -1 {
+		return Encode(-1, false)
+	}
+
+	if after {
+		pivot++
+	}
+	list.Insert(pivot, args[3])
+	listTypeTryConversion(obj)
+	return Encode(list.Len(), false)
+}
+
+func evalLRem(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("lrem"), false)
+	}
+
+	count, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_LIST)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+
+	// a positive count removes from head to tail, a negative one from tail to head and 0 removes all
+	list := listOf(obj)
+	values := list.Range(0, list.Len()-1)
+	var matches []int
+	if count >= 0 {
+		for i := 0; i < len(values) && (count == 0 || int64(len(matches)) < count); i++ {
+			if values[i] == args[2] {
+				matches = append(matches, i)
+			}
+		}
+	} else {
+		for i := len(values) - 1; i >= 0 && int64(len(matches)) < -count; i-- {
+			if values[i] == args[2] {
+				matches = append(matches, i)
+			}
+		}
+	}
+
+	// delete from the highest index so that the lower ones stay valid
+	if count >= 0 {
+		for i := len(matches) - 1; i >= 0; i-- {
+			list.Delete(matches[i], 1)
+		}
+	} else {
+		for _, i := range matches {
+			list.Delete(i, 1)
+		}
+	}
+
+	deleteIfEmptyList(args[0], obj)
+	return Encode(len(matches), false)
+}
+
+func evalLTrim(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("ltrim"), false)
+	}
+
+	start, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
+	end, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_LIST)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode("OK", true)
+	}
+
+	list := listOf(obj)
+	length := int64(list.Len())
+	start = max(normalizeListIndex(start, list.Len()), 0)
+	end = normalizeListIndex(end, list.Len())
+
+	if start > end || start >= length {
+		list.Delete(0, int(length))
+	} else {
+		end = min(end, length-1)
+		list.Delete(int(end+1), int(length-end-1))
+		list.Delete(0, int(start))
+	}
+
+	deleteIfEmptyList(args[0], obj)
+	return Encode("OK", true)
+}
+
+func evalLPos(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("lpos"), false)
+	}
+
+	rank, count, maxlen := int64(1), int64(-1), int64(0)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return Encode(errSyntax, false)
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return Encode(errNotInteger, false)
+		}
+		switch strings.ToUpper(args[i]) {
+		case "RANK":
+			if n == 0 {
+				return Encode(errors.New("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list"), false)
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return Encode(errors.New("ERR COUNT can't be negative"), false)
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return Encode(errors.New("ERR MAXLEN can't be negative"), false)
+			}
+			maxlen = n
+		default:
+			return Encode(errSyntax, false)
+		}
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_LIST)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		if count >= 0 {
+			return Encode([]interface{}{}, false)
+		}
+		return Encode(nil, false)
+	}
+
+	list := listOf(obj)
+	values := list.Range(0, list.Len()-1)
+	matches := []interface{}{}
+	skip := max(rank, -rank) - 1
+	wanted := count
+	if wanted < 0 {
+		wanted = 1
+	}
+
+	for compared := int64(0); compared < int64(len(values)); compared++ {
+		if maxlen != 0 && compared >= maxlen {
+			break
+		}
+		i := compared
+		if rank < 0 {
+			i = int64(len(values)) - 1 - compared
+		}
+		if values[i] != args[1] {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		matches = append(matches, i)
+		if wanted != 0 && int64(len(matches)) >= wanted {
+			break
+		}
+	}
+
+	if count >= 0 {
+		return Encode(matches, false)
+	}
+	if len(matches) == 0 {
+		return Encode(nil, false)
+	}
+	return Encode(matches[0], false)
+}
+
+func evalLMove(args []string) []byte {
+	if len(args) != 4 {
+		return Encode(errWrongArgCount("lmove"), false)
+	}
+
+	from, ok := parseListWhere(args[2])
+	if !ok {
+		return Encode(errSyntax, false)
+	}
+	to, ok := parseListWhere(args[3])
+	if !ok {
+		return Encode(errSyntax, false)
+	}
+
+	value, err := listMove(args[0], args[1], from, to)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if value == nil {
+		return Encode(nil, false)
+	}
+	return Encode(*value, false)
+}
+
+// listMove pops an element from the source list and pushes it to the destination list,
+// it returns nil when the source list does not exist
+func listMove(src string, dst string, from int, to int) (*string, error) {
+	srcObj, err := getOfType(src, OBJ_TYPE_LIST)
+	if err != nil {
+		return nil, err
+	}
+	if srcObj == nil {
+		return nil, nil
+	}
+
+	dstObj, err := getOfType(dst, OBJ_TYPE_LIST)
+	if err != nil {
+		return nil, err
+	}
+
+	value, _ := listPop(srcObj, from)
+	if dstObj == nil {
+		dstObj = newListObj()
+		Put(dst, dstObj)
+	}
+	listPush(dstObj, value, to)
+	listTypeTryConversion(dstObj)
+
+	// when source and destination are the same list it is not empty at this point
+	deleteIfEmptyList(src, srcObj)
+	return &value, nil
+}
+
+func evalLMPop(args []string) []byte {
+	keys, where, count, err := parseMPopArgs("lmpop", args, parseListWhere)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	reply, err := listPopFromKeys(keys, where, count)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if reply == nil {
+		return RESP_NIL_ARRAY
+	}
+	return Encode(reply, false)
+}
+
+// parseMPopArgs parses the numkeys key [key ...] where [COUNT count] arguments of the multi-key pops,
+// where is LEFT or RIGHT for lists and MIN or MAX for sorted sets and is parsed by parseWhere
+func parseMPopArgs(cmd string, args []string, parseWhere func(string) (int, bool)) ([]string, int, int64, error) {
+	if len(args) < 3 {
+		return nil, 0, 0, errWrongArgCount(cmd)
+	}
+
+	numKeys, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || numKeys <= 0 {
+		return nil, 0, 0, errors.New("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(args)-2) {
+		return nil, 0, 0, errSyntax
+	}
+	keys := args[1 : 1+numKeys]
+	rest := args[1+numKeys:]
+
+	where, ok := parseWhere(rest[0])
+	if !ok {
+		return nil, 0, 0, errSyntax
+	}
+
+	count := int64(1)
+	switch {
+	case len(rest) == 1:
+	case len(rest) == 3 && strings.ToUpper(rest[1]) == "COUNT":
+		count, err = strconv.ParseInt(rest[2], 10, 64)
+		if err != nil || count <= 0 {
+			return nil, 0, 0, errors.New("ERR count should be greater than 0")
+		}
+	default:
+		return nil, 0, 0, errSyntax
+	}
+	return keys, where, count, nil
+}
+
+// listPopFromKeys pops from the first non empty list among the keys and returns the key with the popped
+// element, or with the popped elements when count is positive, it returns nil when all the lists are empty
+func listPopFromKeys(keys []string, where int, count int64) ([]interface{}, error) {
+	for _, key := range keys {
+		obj, err := getOfType(key, OBJ_TYPE_LIST)
+		if err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			continue
+		}
+		return listPopFromKey(key, obj, where, count), nil
+	}
+	return nil, nil
+}
+
+func listPopFromKey(key string, obj *Obj, where int, count int64) []interface{} {
+	var reply []interface{}
+	if count < 0 {
+		value, _ := listPop(obj, where)
+		reply = []interface{}{key, value}
+	} else {
+		reply = []interface{}{key, popCount(obj, where, count)}
+	}
+	deleteIfEmptyList(key, obj)
+	return reply
+}
+
+// serveBlockedListPop pops from the key that became ready, the client keeps waiting while the key
+// does not hold a list
+func serveBlockedListPop(where int, count int64) func(key string) []byte {
+	return func(key string) []byte {
+		obj, err := getOfType(key, OBJ_TYPE_LIST)
+		if err != nil || obj == nil {
+			return nil
+		}
+		return Encode(listPopFromKey(key, obj, where, count), false)
+	}
+}
+
+func evalBLPop(args []string, c io.ReadWriter) []byte {
+	return blockingPopGeneric("blpop", args, c, LIST_HEAD)
+}
+
+func evalBRPop(args []string, c io.ReadWriter) []byte {
+	return blockingPopGeneric("brpop", args, c, LIST_TAIL)
+}
+
+func blockingPopGeneric(cmd string, args []string, c io.ReadWriter, where int) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	keys := args[:len(args)-1]
+	timeout, err := parseBlockingTimeout(args[len(args)-1])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	reply, err := listPopFromKeys(keys, where, -1)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if reply != nil {
+		return Encode(reply, false)
+	}
+
+	blockClient(c, keys, timeout, serveBlockedListPop(where, -1), RESP_NIL_ARRAY)
+	return nil
+}
+
+func evalBLMPop(args []string, c io.ReadWriter) []byte {
+	if len(args) < 1 {
+		return Encode(errWrongArgCount("blmpop"), false)
+	}
+
+	timeout, err := parseBlockingTimeout(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	keys, where, count, err := parseMPopArgs("blmpop", args[1:], parseListWhere)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	reply, err := listPopFromKeys(keys, where, count)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if reply != nil {
+		return Encode(reply, false)
+	}
+
+	blockClient(c, keys, timeout, serveBlockedListPop(where, count), RESP_NIL_ARRAY)
+	return nil
+}
+
+func evalBLMove(args []string, c io.ReadWriter) []byte {
+	if len(args) != 5 {
+		return Encode(errWrongArgCount("blmove"), false)
+	}
+
+	from, ok := parseListWhere(args[2])
+	if !ok {
+		return Encode(errSyntax, false)
+	}
+	to, ok := parseListWhere(args[3])
+	if !ok {
+		return Encode(errSyntax, false)
+	}
+	timeout, err := parseBlockingTimeout(args[4])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	value, err := listMove(args[0], args[1], from, to)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if value != nil {
+		return Encode(*value, false)
+	}
+
+	serve := func(key string) []byte {
+		if obj, err := getOfType(key, OBJ_TYPE_LIST); err != nil || obj == nil {
+			return nil
+		}
+		// the destination may hold another type by now, which ends the wait with an error
+		value, err := listMove(args[0], args[1], from, to)
+		if err != nil {
+			return Encode(err, false)
+		}
+		return Encode(*value, false)
+	}
+	blockClient(c, []string{args[0]}, timeout, serve, Encode(nil, false))
+	return nil
+}
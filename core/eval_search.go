@@ -0,0 +1,520 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// evalFTCreate creates an index over the hashes with one of the prefixes, the existing hashes are indexed
+// right away:
+// FT.CREATE index [ON HASH] [PREFIX count prefix ...] SCHEMA field [AS alias] type [options] ...
+func evalFTCreate(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("ft.create"), false)
+	}
+	if _, ok := searchIndexes[args[0]]; ok {
+		return Encode(errFTIndexExists, false)
+	}
+
+	idx := &ftIndex{name: args[0], definition: args[1:]}
+	i := 1
+	for ; i < len(args) && strings.ToUpper(args[i]) != "SCHEMA"; i++ {
+		switch strings.ToUpper(args[i]) {
+		case "ON":
+			if i+1 == len(args) || strings.ToUpper(args[i+1]) != "HASH" {
+				return Encode(errSyntax, false)
+			}
+			i++
+		case "PREFIX":
+			if i+1 == len(args) {
+				return Encode(errSyntax, false)
+			}
+			n, ok := parseInt64(args[i+1])
+			if !ok || n < 0 || int64(len(args)-i-2) < n {
+				return Encode(errSyntax, false)
+			}
+			for _, p := range args[i+2 : i+2+int(n)] {
+				idx.prefixes = append(idx.prefixes, strings.ToUpper(p))
+			}
+			i += 1 + int(n)
+		default:
+			return Encode(errSyntax, false)
+		}
+	}
+	if i >= len(args)-1 {
+		return Encode(errFTNoFields, false)
+	}
+
+	fields, err := parseFTSchema(args[i+1:])
+	if err != nil {
+		return Encode(err, false)
+	}
+	idx.fields = fields
+	idx.reset()
+	searchIndexes[idx.name] = idx
+	return Encode("OK", true)
+}
+
+func parseFTSchema(args []string) ([]*ftField, error) {
+	var fields []*ftField
+	for i := 0; i < len(args); {
+		f := &ftField{name: args[i], alias: args[i]}
+		i++
+		if i+1 < len(args) && strings.ToUpper(args[i]) == "AS" {
+			f.alias = args[i+1]
+			i += 2
+		}
+		if i == len(args) {
+			return nil, errFTBadFieldType
+		}
+		for _, other := range fields {
+			if other.alias == f.alias {
+				return nil, errFTDuplicateField
+			}
+		}
+
+		f.kind = strings.ToUpper(args[i])
+		i++
+		switch f.kind {
+		case "TAG":
+			f.separator = ','
+		options:
+			for ; i < len(args); i++ {
+				switch strings.ToUpper(args[i]) {
+				case "SEPARATOR":
+					if i+1 == len(args) || len(args[i+1]) != 1 {
+						return nil, errFTBadSeparator
+					}
+					f.separator = args[i+1][0]
+					i++
+				case "CASESENSITIVE":
+					f.caseSensitive = true
+				case "SORTABLE":
+				default:
+					break options
+				}
+			}
+		case "TEXT":
+			for i < len(args) && (strings.ToUpper(args[i]) == "NOSTEM" || strings.ToUpper(args[i]) == "SORTABLE") {
+				i++
+			}
+		case "NUMERIC":
+			if i < len(args) && strings.ToUpper(args[i]) == "SORTABLE" {
+				i++
+			}
+		case "VECTOR":
+			n, err := parseVectorField(f, args[i:])
+			if err != nil {
+				return nil, err
+			}
+			i += n
+		default:
+			return nil, errFTBadFieldType
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// parseVectorField reads the algorithm and its attributes, FLAT|HNSW count TYPE FLOAT32 DIM dim
+// DISTANCE_METRIC L2|IP|COSINE [M m] [EF_CONSTRUCTION ef] [EF_RUNTIME ef], and returns the number of
+// arguments read
+func parseVectorField(f *ftField, args []string) (int, error) {
+	if len(args) < 2 {
+		return 0, errFTBadVectorArgs
+	}
+	f.algorithm = strings.ToUpper(args[0])
+	if f.algorithm != "FLAT" && f.algorithm != "HNSW" {
+		return 0, errFTBadVectorArgs
+	}
+	n, ok := parseInt64(args[1])
+	if !ok || n < 0 || n%2 != 0 || int64(len(args)-2) < n {
+		return 0, errFTBadVectorArgs
+	}
+	f.m, f.efConstruction, f.efRuntime = HNSW_DEFAULT_M, HNSW_DEFAULT_EF_CONSTRUCTION, HNSW_DEFAULT_EF_RUNTIME
+
+	attrs := args[2 : 2+n]
+	for i := 0; i < len(attrs); i += 2 {
+		name, value := strings.ToUpper(attrs[i]), attrs[i+1]
+		switch name {
+		case "TYPE":
+			if strings.ToUpper(value) != "FLOAT32" {
+				return 0, errFTBadVectorArgs
+			}
+		case "DISTANCE_METRIC":
+			f.metric = strings.ToUpper(value)
+			if !slices.Contains(vectorMetrics, f.metric) {
+				return 0, errFTBadVectorArgs
+			}
+		case "DIM", "INITIAL_CAP", "BLOCK_SIZE", "M", "EF_CONSTRUCTION", "EF_RUNTIME":
+			v, ok := parseInt64(value)
+			if !ok || v <= 0 || v > 1<<20 {
+				return 0, errFTBadVectorArgs
+			}
+			switch {
+			case name == "DIM":
+				f.dim = int(v)
+			case f.algorithm == "FLAT" && (name == "M" || name == "EF_CONSTRUCTION" || name == "EF_RUNTIME"):
+				return 0, errFTBadVectorArgs
+			case name == "M":
+				// a node needs at least two links for the levels of the graph to make sense
+				if v < 2 {
+					return 0, errFTBadVectorArgs
+				}
+				f.m = int(v)
+			case name == "EF_CONSTRUCTION":
+				f.efConstruction = int(v)
+			case name == "EF_RUNTIME":
+				f.efRuntime = int(v)
+			}
+		default:
+			return 0, errFTBadVectorArgs
+		}
+	}
+	if f.dim == 0 || f.metric == "" {
+		return 0, errFTBadVectorArgs
+	}
+	return 2 + int(n), nil
+}
+
+// ftSearch holds the options of FT.SEARCH
+type ftSearch struct {
+	params     map[string]string
+	sortBy     string
+	descending bool
+	offset     int
+	limit      int
+	// returnFields lists the fields of the results to reply with, every field when nil
+	returnFields []string
+	noContent    bool
+}
+
+// countedArgs returns the arguments following the option at i and the count of arguments following it
+func countedArgs(args []string, i int) ([]string, error) {
+	if i+1 == len(args) {
+		return nil, errSyntax
+	}
+	n, ok := parseInt64(args[i+1])
+	if !ok || n < 0 || int64(len(args)-i-2) < n {
+		return nil, errSyntax
+	}
+	return args[i+2 : i+2+int(n)], nil
+}
+
+func parseFTSearchOptions(args []string) (*ftSearch, error) {
+	opts := &ftSearch{params: make(map[string]string), limit: 10}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "PARAMS":
+			values, err := countedArgs(args, i)
+			if err != nil || len(values)%2 != 0 {
+				return nil, errSyntax
+			}
+			for j := 0; j < len(values); j += 2 {
+				opts.params[values[j]] = values[j+1]
+			}
+			i += 1 + len(values)
+		case "RETURN":
+			values, err := countedArgs(args, i)
+			if err != nil {
+				return nil, err
+			}
+			// RETURN 0 replies with the keys alone
+			opts.returnFields, opts.noContent = values, len(values) == 0
+			i += 1 + len(values)
+		case "SORTBY":
+			if i+1 == len(args) {
+				return nil, errSyntax
+			}
+			opts.sortBy = args[i+1]
+			i++
+			if i+1 < len(args) && (strings.ToUpper(args[i+1]) == "ASC" || strings.ToUpper(args[i+1]) == "DESC") {
+				opts.descending = strings.ToUpper(args[i+1]) == "DESC"
+				i++
+			}
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return nil, errSyntax
+			}
+			offset, ok1 := parseInt64(args[i+1])
+			limit, ok2 := parseInt64(args[i+2])
+			if !ok1 || !ok2 || offset < 0 || limit < 0 {
+				return nil, errSyntax
+			}
+			opts.offset, opts.limit = int(offset), int(limit)
+			i += 2
+		case "NOCONTENT":
+			opts.noContent = true
+		case "DIALECT":
+			if i+1 == len(args) {
+				return nil, errSyntax
+			}
+			if _, ok := parseInt64(args[i+1]); !ok {
+				return nil, errSyntax
+			}
+			i++
+		default:
+			return nil, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+// evalFTSearch replies with the number of results followed by the key and the fields of each result:
+// FT.SEARCH index query [NOCONTENT] [RETURN count field ...] [SORTBY field [ASC|DESC]] [LIMIT offset num]
+// [PARAMS count name value ...] [DIALECT dialect]
+// The query filters the documents, and may end with =>[KNN ...] to keep the documents passing the filters
+// whose vector is the closest to a vector given as parameter, by increasing distance. Other results come
+// ordered by key unless sorted by a field, documents without the field come last. The count is the number
+// of results before LIMIT, which keeps the first 10 by default
+func evalFTSearch(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("ft.search"), false)
+	}
+	idx, ok := searchIndexes[args[0]]
+	if !ok {
+		return Encode(errFTUnknownIndex, false)
+	}
+	opts, err := parseFTSearchOptions(args[2:])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	query, clause, hasKNN := strings.Cut(args[1], "=>")
+	filter, err := parseFTQuery(idx, query, opts.params)
+	if err != nil {
+		return Encode(err, false)
+	}
+	var knn *ftKNN
+	if hasKNN {
+		p := &ftQueryParser{idx: idx, params: opts.params}
+		if knn, err = p.parseKNN(clause); err != nil {
+			return Encode(err, false)
+		}
+	}
+
+	var keys []string
+	var scores map[string]float64
+	for stale := true; stale; stale = expireStaleDocs(keys) {
+		keys, scores = runFTQuery(idx, filter, knn)
+	}
+
+	scoreField := ""
+	if knn != nil {
+		scoreField = knn.scoreField
+	}
+	if opts.sortBy != "" {
+		if err := sortFTResults(idx, keys, scores, scoreField, opts.sortBy, opts.descending); err != nil {
+			return Encode(err, false)
+		}
+	}
+
+	total := len(keys)
+	keys = keys[min(opts.offset, len(keys)):]
+	keys = keys[:min(opts.limit, len(keys))]
+	return encodeFTResults(idx, total, keys, scores, scoreField, opts)
+}
+
+// runFTQuery returns the keys of the documents matching the query, with their distance to the query vector
+// for a KNN query
+func runFTQuery(idx *ftIndex, filter ftNode, knn *ftKNN) ([]string, map[string]float64) {
+	if knn == nil {
+		keys := make([]string, 0)
+		for key := range filter.eval(idx) {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys, nil
+	}
+
+	var allowed map[string]struct{}
+	if _, all := filter.(*ftAllNode); !all {
+		allowed = filter.eval(idx)
+	}
+	matches := knn.field.vectors.search(knn.vector, knn.k, knn.efRuntime, allowed)
+	keys := make([]string, len(matches))
+	scores := make(map[string]float64, len(matches))
+	for i, m := range matches {
+		keys[i] = m.key
+		scores[m.key] = m.distance
+	}
+	return keys, scores
+}
+
+// expireStaleDocs deletes the keys and the hash fields among the results whose ttl elapsed, which updates
+// the indexes, and tells whether there were any so that the query runs again
+func expireStaleDocs(keys []string) bool {
+	stale := false
+	for _, key := range keys {
+		obj := store[key]
+		if obj == nil {
+			continue
+		}
+		if obj.HasExpired() {
+			expireKey(key)
+			stale = true
+		} else if expired, _ := hashDeleteExpiredFields(key, obj); expired > 0 {
+			stale = true
+		}
+	}
+	return stale
+}
+
+// sortFTResults sorts the results by the value of the field, numerically for NUMERIC fields and the
+// distance of KNN queries and lexicographically for the others
+func sortFTResults(idx *ftIndex, keys []string, scores map[string]float64, scoreField string, by string, descending bool) error {
+	type sortValue struct {
+		number  float64
+		text    string
+		present bool
+	}
+	f := idx.field(by)
+	if (f == nil && (scores == nil || by != scoreField)) || (f != nil && f.kind == "VECTOR") {
+		return fmt.Errorf("ERR Property '%s' not loaded nor in schema", by)
+	}
+
+	values := make(map[string]sortValue, len(keys))
+	for _, key := range keys {
+		var v sortValue
+		switch {
+		case f == nil:
+			v.number, v.present = scores[key], true
+		case f.kind == "NUMERIC":
+			v.number, v.present = idx.docs[key].numbers[f.alias]
+		default:
+			v.text, v.present = hashGet(store[key], f.name)
+		}
+		values[key] = v
+	}
+	numeric := f == nil || f.kind == "NUMERIC"
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, b := values[keys[i]], values[keys[j]]
+		if !a.present || !b.present {
+			return a.present && !b.present
+		}
+		if numeric {
+			return (a.number < b.number) != descending && a.number != b.number
+		}
+		return (a.text < b.text) != descending && a.text != b.text
+	})
+	return nil
+}
+
+// encodeFTResults replies with the count of results then the keys and the fields of their hashes, preceded
+// by the distance to the query vector for a KNN query. Vector distances are computed on float32 vectors and
+// rendered as float32
+func encodeFTResults(idx *ftIndex, total int, keys []string, scores map[string]float64, scoreField string, opts *ftSearch) []byte {
+	reply := []interface{}{total}
+	for _, key := range keys {
+		if opts.noContent {
+			reply = append(reply, key)
+			continue
+		}
+		obj := store[key]
+		score := strconv.FormatFloat(scores[key], 'g', -1, 32)
+		var fields []interface{}
+		if opts.returnFields == nil {
+			if scores != nil {
+				fields = append(fields, scoreField, score)
+			}
+			for _, entry := range hashEntries(obj) {
+				fields = append(fields, entry)
+			}
+		}
+		for _, name := range opts.returnFields {
+			if scores != nil && name == scoreField {
+				fields = append(fields, name, score)
+				continue
+			}
+			field := name
+			if f := idx.field(name); f != nil {
+				field = f.name
+			}
+			if value, ok := hashGet(obj, field); ok {
+				fields = append(fields, name, value)
+			}
+		}
+		reply = append(reply, key, fields)
+	}
+	return Encode(reply, false)
+}
+
+func evalFTInfo(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("ft.info"), false)
+	}
+	idx, ok := searchIndexes[args[0]]
+	if !ok {
+		return Encode(errFTUnknownIndex, false)
+	}
+
+	prefixes := make([]interface{}, len(idx.prefixes))
+	for i, p := range idx.prefixes {
+		prefixes[i] = p
+	}
+	attributes := make([]interface{}, len(idx.fields))
+	for i, f := range idx.fields {
+		attr := []interface{}{"identifier", f.name, "attribute", f.alias, "type", f.kind}
+		switch f.kind {
+		case "TAG":
+			attr = append(attr, "SEPARATOR", string(f.separator))
+			if f.caseSensitive {
+				attr = append(attr, "CASESENSITIVE")
+			}
+		case "VECTOR":
+			attr = append(attr, "algorithm", f.algorithm, "data_type", "FLOAT32", "dim", f.dim, "distance_metric", f.metric)
+			if f.algorithm == "HNSW" {
+				attr = append(attr, "M", f.m, "ef_construction", f.efConstruction, "ef_runtime", f.efRuntime)
+			}
+		}
+		attributes[i] = attr
+	}
+	return Encode([]interface{}{
+		"index_name", idx.name,
+		"index_definition", []interface{}{"key_type", "HASH", "prefixes", prefixes},
+		"attributes", attributes,
+		"num_docs", len(idx.docs),
+		"hash_indexing_failures", idx.failures,
+	}, false)
+}
+
+// evalFTDropIndex drops the index, and the hashes it indexes with DD
+func evalFTDropIndex(args []string) []byte {
+	if len(args) != 1 && len(args) != 2 {
+		return Encode(errWrongArgCount("ft.dropindex"), false)
+	}
+	idx, ok := searchIndexes[args[0]]
+	if !ok {
+		return Encode(errFTUnknownIndex, false)
+	}
+	deleteDocs := false
+	if len(args) == 2 {
+		if strings.ToUpper(args[1]) != "DD" {
+			return Encode(errSyntax, false)
+		}
+		deleteDocs = true
+	}
+
+	delete(searchIndexes, idx.name)
+	if deleteDocs {
+		for key := range idx.docs {
+			Delete(key)
+		}
+	}
+	return Encode("OK", true)
+}
+
+func evalFTList(args []string) []byte {
+	if len(args) != 0 {
+		return Encode(errWrongArgCount("ft._list"), false)
+	}
+	names := make([]string, 0, len(searchIndexes))
+	for name := range searchIndexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return Encode(names, false)
+}
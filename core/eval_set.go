@@ -0,0 +1,452 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// deleteIfEmptySet removes the key once its set has no members left
+func deleteIfEmptySet(key string, obj *Obj) {
+	if setLen(obj) == 0 {
+		Delete(key)
+	}
+}
+
+func evalSAdd(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("sadd"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_SET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		obj = newSetObj()
+		Put(args[0], obj)
+	}
+
+	added := 0
+	for _, member := range args[1:] {
+		if setAdd(obj, member) {
+			added++
+		}
+	}
+	return Encode(added, false)
+}
+
+func evalSRem(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("srem"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_SET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+
+	removed := 0
+	for _, member := range args[1:] {
+		if setRemove(obj, member) {
+			removed++
+		}
+	}
+	deleteIfEmptySet(args[0], obj)
+	return Encode(removed, false)
+}
+
+func evalSIsMember(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("sismember"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_SET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj != nil && setIsMember(obj, args[1]) {
+		return Encode(1, false)
+	}
+	return Encode(0, false)
+}
+
+func evalSMIsMember(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("smismember"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_SET)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	replies := make([]interface{}, len(args)-1)
+	for i, member := range args[1:] {
+		replies[i] = 0
+		if obj != nil && setIsMember(obj, member) {
+			replies[i] = 1
+		}
+	}
+	return Encode(replies, false)
+}
+
+func evalSMembers(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("smembers"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_SET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode([]string{}, false)
+	}
+	return Encode(setMembers(obj), false)
+}
+
+func evalSCard(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("scard"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_SET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+	return Encode(setLen(obj), false)
+}
+
+func evalSPop(args []string) []byte {
+	if len(args) < 1 || len(args) > 2 {
+		return Encode(errWrongArgCount("spop"), false)
+	}
+
+	count := int64(-1)
+	if len(args) == 2 {
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || n < 0 {
+			return Encode(errors.New("ERR value is out of range, must be positive"), false)
+		}
+		count = n
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_SET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		if count >= 0 {
+			return Encode([]string{}, false)
+		}
+		return Encode(nil, false)
+	}
+
+	if count < 0 {
+		member := setRandomMember(obj)
+		setRemove(obj, member)
+		deleteIfEmptySet(args[0], obj)
+		return Encode(member, false)
+	}
+
+	members := setMembers(obj)
+	picks, err := randomPicks(len(members), count)
+	if err != nil {
+		return Encode(err, false)
+	}
+	popped := make([]string, len(picks))
+	for i, p := range picks {
+		popped[i] = members[p]
+		setRemove(obj, members[p])
+	}
+	deleteIfEmptySet(args[0], obj)
+	return Encode(popped, false)
+}
+
+func evalSRandMember(args []string) []byte {
+	if len(args) < 1 || len(args) > 2 {
+		return Encode(errWrongArgCount("srandmember"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_SET)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	if len(args) == 1 {
+		if obj == nil {
+			return Encode(nil, false)
+		}
+		return Encode(setRandomMember(obj), false)
+	}
+
+	count, ok := parseInt64(args[1])
+	if !ok {
+		return Encode(errNotInteger, false)
+	}
+	if count < -math.MaxInt64/2 {
+		return Encode(errors.New("ERR value is out of range"), false)
+	}
+	if obj == nil || count == 0 {
+		return Encode([]string{}, false)
+	}
+
+	members := setMembers(obj)
+	picks, err := randomPicks(len(members), count)
+	if err != nil {
+		return Encode(err, false)
+	}
+	out := make([]string, len(picks))
+	for i, p := range picks {
+		out[i] = members[p]
+	}
+	return Encode(out, false)
+}
+
+func evalSMove(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("smove"), false)
+	}
+
+	src, err := getOfType(args[0], OBJ_TYPE_SET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	dst, err := getOfType(args[1], OBJ_TYPE_SET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if src == nil || !setIsMember(src, args[2]) {
+		return Encode(0, false)
+	}
+	if src == dst {
+		return Encode(1, false)
+	}
+
+	setRemove(src, args[2])
+	deleteIfEmptySet(args[0], src)
+	if dst == nil {
+		dst = newSetObj()
+		Put(args[1], dst)
+	}
+	setAdd(dst, args[2])
+	return Encode(1, false)
+}
+
+const (
+	SET_OP_UNION = iota
+	SET_OP_DIFF
+	SET_OP_INTER
+)
+
+// setsOf returns the sets stored at the keys, missing keys are nil sets
+func setsOf(keys []string) ([]*Obj, error) {
+	sets := make([]*Obj, len(keys))
+	for i, key := range keys {
+		obj, err := getOfType(key, OBJ_TYPE_SET)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = obj
+	}
+	return sets, nil
+}
+
+// setAlgebra computes the union, the difference of the first set with the others or the intersection of
+// the sets, limit stops an intersection once it found that many members, 0 means no limit
+func setAlgebra(sets []*Obj, op int, limit int) []string {
+	switch op {
+	case SET_OP_UNION:
+		seen := make(map[string]struct{})
+		var out []string
+		for _, s := range sets {
+			if s == nil {
+				continue
+			}
+			for _, m := range setMembers(s) {
+				if _, ok := seen[m]; !ok {
+					seen[m] = struct{}{}
+					out = append(out, m)
+				}
+			}
+		}
+		return out
+	case SET_OP_DIFF:
+		if sets[0] == nil {
+			return nil
+		}
+		var out []string
+	diff:
+		for _, m := range setMembers(sets[0]) {
+			for _, s := range sets[1:] {
+				if s != nil && setIsMember(s, m) {
+					continue diff
+				}
+			}
+			out = append(out, m)
+		}
+		return out
+	default:
+		// iterate the smallest set and probe the others
+		smallest := 0
+		for i, s := range sets {
+			if s == nil {
+				return nil
+			}
+			if setLen(s) < setLen(sets[smallest]) {
+				smallest = i
+			}
+		}
+		var out []string
+	inter:
+		for _, m := range setMembers(sets[smallest]) {
+			for i, s := range sets {
+				if i != smallest && !setIsMember(s, m) {
+					continue inter
+				}
+			}
+			out = append(out, m)
+			if limit > 0 && len(out) >= limit {
+				break
+			}
+		}
+		return out
+	}
+}
+
+func evalSInter(args []string) []byte {
+	return setAlgebraGeneric("sinter", args, SET_OP_INTER)
+}
+
+func evalSUnion(args []string) []byte {
+	return setAlgebraGeneric("sunion", args, SET_OP_UNION)
+}
+
+func evalSDiff(args []string) []byte {
+	return setAlgebraGeneric("sdiff", args, SET_OP_DIFF)
+}
+
+func setAlgebraGeneric(cmd string, args []string, op int) []byte {
+	if len(args) < 1 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	sets, err := setsOf(args)
+	if err != nil {
+		return Encode(err, false)
+	}
+	members := setAlgebra(sets, op, 0)
+	if members == nil {
+		members = []string{}
+	}
+	return Encode(members, false)
+}
+
+func evalSInterStore(args []string) []byte {
+	return setAlgebraStoreGeneric("sinterstore", args, SET_OP_INTER)
+}
+
+func evalSUnionStore(args []string) []byte {
+	return setAlgebraStoreGeneric("sunionstore", args, SET_OP_UNION)
+}
+
+func evalSDiffStore(args []string) []byte {
+	return setAlgebraStoreGeneric("sdiffstore", args, SET_OP_DIFF)
+}
+
+// setAlgebraStoreGeneric stores the result at the destination whatever it held before, an empty result deletes it
+func setAlgebraStoreGeneric(cmd string, args []string, op int) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	sets, err := setsOf(args[1:])
+	if err != nil {
+		return Encode(err, false)
+	}
+	members := setAlgebra(sets, op, 0)
+	if len(members) == 0 {
+		Delete(args[0])
+		return Encode(0, false)
+	}
+	Put(args[0], newSetObjFrom(members))
+	return Encode(len(members), false)
+}
+
+func evalSInterCard(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("sintercard"), false)
+	}
+
+	numKeys, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || numKeys <= 0 {
+		return Encode(errors.New("ERR numkeys should be greater than 0"), false)
+	}
+	if numKeys > int64(len(args)-1) {
+		return Encode(errors.New("ERR Number of keys can't be greater than number of args"), false)
+	}
+	keys := args[1 : 1+numKeys]
+	rest := args[1+numKeys:]
+
+	limit := int64(0)
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToUpper(rest[0]) == "LIMIT":
+		limit, err = strconv.ParseInt(rest[1], 10, 64)
+		if err != nil || limit < 0 {
+			return Encode(errors.New("ERR LIMIT can't be negative"), false)
+		}
+	default:
+		return Encode(errSyntax, false)
+	}
+
+	sets, err := setsOf(keys)
+	if err != nil {
+		return Encode(err, false)
+	}
+	return Encode(len(setAlgebra(sets, SET_OP_INTER, int(limit))), false)
+}
+
+func evalSScan(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("sscan"), false)
+	}
+
+	opts, err := parseScanArgs(args[1:], false)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_SET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return encodeScanReply(0, nil)
+	}
+
+	// compact encodings are returned whole in a single call, like redis does
+	var cursor uint64
+	var members []string
+	if obj.Encoding() == OBJ_ENCODING_INTSET {
+		members = setMembers(obj)
+	} else {
+		cursor, members = scanByHash(args[0], obj, opts.cursor, opts.count)
+	}
+
+	var out []string
+	for _, m := range members {
+		if opts.matches(m) {
+			out = append(out, m)
+		}
+	}
+	return encodeScanReply(cursor, out)
+}
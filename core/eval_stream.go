@@ -0,0 +1,1205 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errStreamTopItem = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+
+func lookupStream(key string) (*Obj, error) {
+	return getOfType(key, OBJ_TYPE_STREAM)
+}
+
+// lookupStreamGroup returns the stream at key with its consumer group, failing with NOGROUP when either is missing
+func lookupStreamGroup(key string, group string) (*stream, *streamGroup, error) {
+	obj, err := lookupStream(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if obj != nil {
+		s := streamOf(obj)
+		if g, ok := s.groups[group]; ok {
+			return s, g, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+}
+
+// streamNextID returns the id of the entry XADD appends, arg is either "*", "ms-*" or an explicit id
+func streamNextID(s *stream, arg string) (streamID, error) {
+	if arg == "*" {
+		if ms := uint64(time.Now().UnixMilli()); ms > s.lastID.ms {
+			return streamID{ms, 0}, nil
+		}
+		id, ok := s.lastID.incr()
+		if !ok {
+			return id, errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, nil
+	}
+
+	if msPart, ok := strings.CutSuffix(arg, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return streamID{}, errInvalidStreamID
+		}
+		switch {
+		case ms > s.lastID.ms:
+			return streamID{ms, 0}, nil
+		case ms == s.lastID.ms && s.lastID.seq < math.MaxUint64:
+			return streamID{ms, s.lastID.seq + 1}, nil
+		default:
+			return streamID{}, errStreamTopItem
+		}
+	}
+
+	id, err := parseStreamID(arg, 0)
+	if err != nil {
+		return id, err
+	}
+	if id.isZero() {
+		return id, errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	}
+	if id.compare(s.lastID) <= 0 {
+		return id, errStreamTopItem
+	}
+	return id, nil
+}
+
+// parseStreamTrim parses MAXLEN|MINID [=|~] threshold [LIMIT count] and returns the number of arguments consumed
+func parseStreamTrim(args []string) (*streamTrimSpec, int, error) {
+	spec := &streamTrimSpec{}
+	if strings.ToUpper(args[0]) == "MINID" {
+		spec.strategy = STREAM_TRIM_MINID
+	}
+
+	i := 1
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		spec.approx = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return nil, 0, errSyntax
+	}
+	if spec.strategy == STREAM_TRIM_MAXLEN {
+		n, ok := parseInt64(args[i])
+		if !ok {
+			return nil, 0, errNotInteger
+		}
+		if n < 0 {
+			return nil, 0, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+		spec.maxLen = n
+	} else {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			return nil, 0, err
+		}
+		spec.minID = id
+	}
+	i++
+
+	if i < len(args) && strings.ToUpper(args[i]) == "LIMIT" {
+		if i+1 >= len(args) {
+			return nil, 0, errSyntax
+		}
+		n, ok := parseInt64(args[i+1])
+		if !ok {
+			return nil, 0, errNotInteger
+		}
+		if n < 0 {
+			return nil, 0, errors.New("ERR The LIMIT argument must be >= 0.")
+		}
+		if !spec.approx {
+			return nil, 0, errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		spec.limit, spec.hasLimit = n, true
+		i += 2
+	}
+	return spec, i, nil
+}
+
+func evalXAdd(args []string) []byte {
+	if len(args) < 4 {
+		return Encode(errWrongArgCount("xadd"), false)
+	}
+
+	key := args[0]
+	noMkStream := false
+	var trim *streamTrimSpec
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			noMkStream = true
+		case "MAXLEN", "MINID":
+			spec, n, err := parseStreamTrim(args[i:])
+			if err != nil {
+				return Encode(err, false)
+			}
+			trim = spec
+			i += n - 1
+		default:
+			break options
+		}
+	}
+	if i >= len(args) || len(args[i+1:]) == 0 || len(args[i+1:])%2 != 0 {
+		return Encode(errWrongArgCount("xadd"), false)
+	}
+
+	obj, err := lookupStream(key)
+	if err != nil {
+		return Encode(err, false)
+	}
+	created := obj == nil
+	if created {
+		if noMkStream {
+			return Encode(nil, false)
+		}
+		obj = newStreamObj()
+	}
+
+	// the id is validated before a new stream is stored
+	s := streamOf(obj)
+	id, err := streamNextID(s, args[i])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if created {
+		Put(key, obj)
+	}
+	s.add(id, append([]string{}, args[i+1:]...))
+	if trim != nil {
+		s.trim(trim)
+	}
+	signalKeyAsReady(key)
+	return Encode(id.String(), false)
+}
+
+func evalXLen(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("xlen"), false)
+	}
+
+	obj, err := lookupStream(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+	return Encode(streamOf(obj).length, false)
+}
+
+// xrangeGeneric implements XRANGE key start end and XREVRANGE key end start
+func xrangeGeneric(cmd string, args []string, rev bool) []byte {
+	if len(args) != 3 && len(args) != 5 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	startArg, endArg := args[1], args[2]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, err := parseStreamRangeID(startArg, true)
+	if err != nil {
+		return Encode(err, false)
+	}
+	end, err := parseStreamRangeID(endArg, false)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	count := 0
+	if len(args) == 5 {
+		if strings.ToUpper(args[3]) != "COUNT" {
+			return Encode(errSyntax, false)
+		}
+		n, ok := parseInt64(args[4])
+		if !ok {
+			return Encode(errNotInteger, false)
+		}
+		if n <= 0 {
+			return Encode([]interface{}{}, false)
+		}
+		count = int(min(n, math.MaxInt32))
+	}
+
+	obj, err := lookupStream(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode([]interface{}{}, false)
+	}
+	return Encode(streamEntriesReply(streamOf(obj).rangeEntries(start, end, count, rev)), false)
+}
+
+func evalXDel(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("xdel"), false)
+	}
+
+	ids := make([]streamID, len(args)-1)
+	for i, arg := range args[1:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return Encode(err, false)
+		}
+		ids[i] = id
+	}
+
+	obj, err := lookupStream(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+
+	deleted := 0
+	s := streamOf(obj)
+	for _, id := range ids {
+		if s.delete(id) {
+			deleted++
+		}
+	}
+	return Encode(deleted, false)
+}
+
+func evalXTrim(args []string) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount("xtrim"), false)
+	}
+
+	switch strings.ToUpper(args[1]) {
+	case "MAXLEN", "MINID":
+	default:
+		return Encode(errSyntax, false)
+	}
+	spec, n, err := parseStreamTrim(args[1:])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if n != len(args)-1 {
+		return Encode(errSyntax, false)
+	}
+
+	obj, err := lookupStream(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+	return Encode(streamOf(obj).trim(spec), false)
+}
+
+// streamReadOptions holds the arguments of XREAD and XREADGROUP
+type streamReadOptions struct {
+	group    string
+	consumer string
+	count    int
+	block    time.Duration
+	blocking bool
+	noAck    bool
+	keys     []string
+	ids      []string
+}
+
+func parseStreamReadArgs(cmd string, args []string, withGroup bool) (*streamReadOptions, error) {
+	opts := &streamReadOptions{}
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "COUNT" && i+1 < len(args):
+			n, ok := parseInt64(args[i+1])
+			if !ok {
+				return nil, errNotInteger
+			}
+			opts.count = int(min(max(n, 0), math.MaxInt32))
+			i++
+		case opt == "BLOCK" && i+1 < len(args):
+			n, ok := parseInt64(args[i+1])
+			if !ok {
+				return nil, errors.New("ERR timeout is not an integer or out of range")
+			}
+			if n < 0 {
+				return nil, errors.New("ERR timeout is negative")
+			}
+			opts.block, opts.blocking = time.Duration(n)*time.Millisecond, true
+			i++
+		case opt == "GROUP" && withGroup && i+2 < len(args):
+			opts.group, opts.consumer = args[i+1], args[i+2]
+			i += 2
+		case opt == "NOACK" && withGroup:
+			opts.noAck = true
+		case opt == "STREAMS":
+			streams := args[i+1:]
+			if len(streams) == 0 || len(streams)%2 != 0 {
+				return nil, fmt.Errorf("ERR Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", cmd)
+			}
+			opts.keys, opts.ids = streams[:len(streams)/2], streams[len(streams)/2:]
+			return opts, nil
+		default:
+			return nil, errSyntax
+		}
+	}
+	return nil, errSyntax
+}
+
+// streamReadAfter returns the entries of every stream following the matching id, streams without such
+// entries are left out and a nil reply means none of them had any
+func streamReadAfter(keys []string, after []streamID, count int) ([]interface{}, error) {
+	var out []interface{}
+	for i, key := range keys {
+		obj, err := lookupStream(key)
+		if err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			continue
+		}
+		start, ok := after[i].incr()
+		if !ok {
+			continue
+		}
+		entries := streamOf(obj).rangeEntries(start, streamIDMax, count, false)
+		if len(entries) > 0 {
+			out = append(out, []interface{}{key, streamEntriesReply(entries)})
+		}
+	}
+	return out, nil
+}
+
+func evalXRead(args []string, c io.ReadWriter) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount("xread"), false)
+	}
+
+	opts, err := parseStreamReadArgs("xread", args, false)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	// "$" reads what is added after the call, so it stands for the last id at the time of the call
+	after := make([]streamID, len(opts.keys))
+	for i, key := range opts.keys {
+		if opts.ids[i] != "$" {
+			if after[i], err = parseStreamID(opts.ids[i], 0); err != nil {
+				return Encode(err, false)
+			}
+			continue
+		}
+		obj, err := lookupStream(key)
+		if err != nil {
+			return Encode(err, false)
+		}
+		if obj != nil {
+			after[i] = streamOf(obj).lastID
+		}
+	}
+
+	reply, err := streamReadAfter(opts.keys, after, opts.count)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if reply != nil {
+		return Encode(reply, false)
+	}
+	if !opts.blocking {
+		return RESP_NIL_ARRAY
+	}
+
+	blockClient(c, opts.keys, opts.block, func(key string) []byte {
+		reply, err := streamReadAfter(opts.keys, after, opts.count)
+		if err != nil || reply == nil {
+			return nil
+		}
+		return Encode(reply, false)
+	}, RESP_NIL_ARRAY)
+	return nil
+}
+
+// streamReadGroup serves XREADGROUP, ">" delivers the entries the group has not delivered yet and
+// adds them to the pending entries, any other id replays the pending entries of the consumer after it
+func streamReadGroup(opts *streamReadOptions, after []streamID) ([]interface{}, error) {
+	now := time.Now().UnixMilli()
+	var out []interface{}
+	for i, key := range opts.keys {
+		s, g, err := lookupStreamGroup(key, opts.group)
+		if err != nil {
+			return nil, err
+		}
+		consumer, _ := g.consumer(opts.consumer, true, now)
+		consumer.seenTime = now
+
+		if opts.ids[i] == ">" {
+			start, ok := g.lastID.incr()
+			if !ok {
+				continue
+			}
+			entries := s.rangeEntries(start, streamIDMax, opts.count, false)
+			if len(entries) == 0 {
+				continue
+			}
+			consumer.activeTime = now
+			for _, e := range entries {
+				g.lastID = e.id
+				g.entriesRead++
+				if !opts.noAck {
+					g.assign(e.id, consumer, now).deliveryCount = 1
+				}
+			}
+			out = append(out, []interface{}{key, streamEntriesReply(entries)})
+			continue
+		}
+
+		// entries deleted from the stream while pending are replied with their id only
+		history := []interface{}{}
+		for _, id := range pendingIDs(consumer.pending) {
+			if id.compare(after[i]) <= 0 {
+				continue
+			}
+			if opts.count > 0 && len(history) >= opts.count {
+				break
+			}
+			nack := consumer.pending[id]
+			nack.deliveryTime = now
+			nack.deliveryCount++
+			if e, ok := s.get(id); ok {
+				history = append(history, e.reply())
+			} else {
+				history = append(history, []interface{}{id.String(), nil})
+			}
+		}
+		out = append(out, []interface{}{key, history})
+	}
+	return out, nil
+}
+
+var errStreamDeleted = errors.New("UNBLOCKED the stream key no longer exists")
+var errStreamGroupDestroyed = errors.New("NOGROUP the consumer group this client was blocked on no longer exists")
+
+func evalXReadGroup(args []string, c io.ReadWriter) []byte {
+	if len(args) < 6 {
+		return Encode(errWrongArgCount("xreadgroup"), false)
+	}
+
+	opts, err := parseStreamReadArgs("xreadgroup", args, true)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if opts.group == "" {
+		return Encode(errors.New("ERR Missing GROUP option for XREADGROUP"), false)
+	}
+
+	after := make([]streamID, len(opts.keys))
+	for i, key := range opts.keys {
+		switch opts.ids[i] {
+		case ">":
+		case "$":
+			return Encode(errors.New("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set."), false)
+		default:
+			if after[i], err = parseStreamID(opts.ids[i], 0); err != nil {
+				return Encode(err, false)
+			}
+		}
+		if _, _, err := lookupStreamGroup(key, opts.group); err != nil {
+			return Encode(fmt.Errorf("%s in XREADGROUP with GROUP option", err), false)
+		}
+	}
+
+	reply, err := streamReadGroup(opts, after)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if reply != nil {
+		return Encode(reply, false)
+	}
+	if !opts.blocking {
+		return RESP_NIL_ARRAY
+	}
+
+	blockClient(c, opts.keys, opts.block, func(key string) []byte {
+		// a stream deleted or a group destroyed while the client waits ends the wait with an error
+		obj := peek(key)
+		if obj == nil || obj.Type() != OBJ_TYPE_STREAM {
+			return Encode(errStreamDeleted, false)
+		}
+		if _, ok := streamOf(obj).groups[opts.group]; !ok {
+			return Encode(errStreamGroupDestroyed, false)
+		}
+		reply, err := streamReadGroup(opts, after)
+		if err != nil {
+			return Encode(err, false)
+		}
+		if reply == nil {
+			return nil
+		}
+		return Encode(reply, false)
+	}, RESP_NIL_ARRAY)
+	return nil
+}
+
+func evalXAck(args []string) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount("xack"), false)
+	}
+
+	ids := make([]streamID, len(args)-2)
+	for i, arg := range args[2:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return Encode(err, false)
+		}
+		ids[i] = id
+	}
+
+	obj, err := lookupStream(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+	g, ok := streamOf(obj).groups[args[1]]
+	if !ok {
+		return Encode(0, false)
+	}
+
+	acked := 0
+	for _, id := range ids {
+		if g.ack(id) {
+			acked++
+		}
+	}
+	return Encode(acked, false)
+}
+
+func evalXGroup(args []string) []byte {
+	if len(args) < 1 {
+		return Encode(errWrongArgCount("xgroup"), false)
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "CREATE":
+		return evalXGroupCreate(args[1:])
+	case "SETID":
+		return evalXGroupSetID(args[1:])
+	case "DESTROY":
+		return evalXGroupDestroy(args[1:])
+	case "CREATECONSUMER":
+		return evalXGroupCreateConsumer(args[1:])
+	case "DELCONSUMER":
+		return evalXGroupDelConsumer(args[1:])
+	default:
+		return Encode(fmt.Errorf("ERR unknown subcommand '%s'. Try XGROUP HELP.", args[0]), false)
+	}
+}
+
+var errXGroupKeyMissing = errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+
+// lookupXGroupStream returns the stream XGROUP works on, which must exist
+func lookupXGroupStream(key string) (*stream, error) {
+	obj, err := lookupStream(key)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, errXGroupKeyMissing
+	}
+	return streamOf(obj), nil
+}
+
+func errNoSuchGroup(key string, group string) error {
+	return fmt.Errorf("NOGROUP No such consumer group '%s' for key name '%s'", group, key)
+}
+
+// parseGroupStart parses the last delivered id of XGROUP CREATE and SETID with its ENTRIESREAD option.
+// Unless given, the number of entries read is derived from the entries the stream holds up to the id
+func parseGroupStart(s *stream, args []string) (streamID, int64, error) {
+	var id streamID
+	if args[0] == "$" {
+		id = s.lastID
+	} else {
+		var err error
+		if id, err = parseStreamID(args[0], 0); err != nil {
+			return id, 0, err
+		}
+	}
+
+	entriesRead := int64(-1)
+	if len(args) == 3 && strings.ToUpper(args[1]) == "ENTRIESREAD" {
+		n, ok := parseInt64(args[2])
+		if !ok {
+			return id, 0, errNotInteger
+		}
+		if n < 0 {
+			return id, 0, errors.New("ERR value for ENTRIESREAD must be positive or -1")
+		}
+		entriesRead = n
+	} else if len(args) != 1 {
+		return id, 0, errSyntax
+	}
+
+	if entriesRead < 0 {
+		next, ok := id.incr()
+		entriesRead = s.entriesAdded
+		if ok {
+			entriesRead -= int64(len(s.rangeEntries(next, streamIDMax, 0, false)))
+		}
+	}
+	return id, entriesRead, nil
+}
+
+func evalXGroupCreate(args []string) []byte {
+	if len(args) < 3 || len(args) > 6 {
+		return Encode(errWrongArgCount("xgroup|create"), false)
+	}
+
+	opts := args[2:]
+	mkStream := false
+	for i, opt := range opts {
+		if strings.ToUpper(opt) == "MKSTREAM" {
+			mkStream = true
+			opts = append(opts[:i:i], opts[i+1:]...)
+			break
+		}
+	}
+
+	obj, err := lookupStream(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		if !mkStream {
+			return Encode(errXGroupKeyMissing, false)
+		}
+		obj = newStreamObj()
+		Put(args[0], obj)
+	}
+
+	s := streamOf(obj)
+	id, entriesRead, err := parseGroupStart(s, opts)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if _, ok := s.groups[args[1]]; ok {
+		return Encode(errors.New("BUSYGROUP Consumer Group name already exists"), false)
+	}
+	s.groups[args[1]] = newStreamGroup(args[1], id, entriesRead)
+	return Encode("OK", true)
+}
+
+func evalXGroupSetID(args []string) []byte {
+	if len(args) != 3 && len(args) != 5 {
+		return Encode(errWrongArgCount("xgroup|setid"), false)
+	}
+
+	s, err := lookupXGroupStream(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	g, ok := s.groups[args[1]]
+	if !ok {
+		return Encode(errNoSuchGroup(args[0], args[1]), false)
+	}
+	id, entriesRead, err := parseGroupStart(s, args[2:])
+	if err != nil {
+		return Encode(err, false)
+	}
+	g.lastID, g.entriesRead = id, entriesRead
+	return Encode("OK", true)
+}
+
+func evalXGroupDestroy(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("xgroup|destroy"), false)
+	}
+
+	s, err := lookupXGroupStream(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if _, ok := s.groups[args[1]]; !ok {
+		return Encode(0, false)
+	}
+	delete(s.groups, args[1])
+	// the clients blocked in XREADGROUP on the group are told it is gone
+	signalKeyAsReady(args[0])
+	return Encode(1, false)
+}
+
+func evalXGroupCreateConsumer(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("xgroup|createconsumer"), false)
+	}
+
+	s, err := lookupXGroupStream(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	g, ok := s.groups[args[1]]
+	if !ok {
+		return Encode(errNoSuchGroup(args[0], args[1]), false)
+	}
+	if _, created := g.consumer(args[2], true, time.Now().UnixMilli()); created {
+		return Encode(1, false)
+	}
+	return Encode(0, false)
+}
+
+func evalXGroupDelConsumer(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("xgroup|delconsumer"), false)
+	}
+
+	s, err := lookupXGroupStream(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	g, ok := s.groups[args[1]]
+	if !ok {
+		return Encode(errNoSuchGroup(args[0], args[1]), false)
+	}
+	return Encode(g.deleteConsumer(args[2]), false)
+}
+
+func evalXSetID(args []string) []byte {
+	if len(args) != 2 && len(args) != 4 && len(args) != 6 {
+		return Encode(errWrongArgCount("xsetid"), false)
+	}
+
+	id, err := parseStreamID(args[1], 0)
+	if err != nil {
+		return Encode(err, false)
+	}
+	entriesAdded := int64(-1)
+	var maxDeletedID *streamID
+	for i := 2; i < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "ENTRIESADDED":
+			n, ok := parseInt64(args[i+1])
+			if !ok {
+				return Encode(errNotInteger, false)
+			}
+			if n < 0 {
+				return Encode(errors.New("ERR entries_added must be positive"), false)
+			}
+			entriesAdded = n
+		case "MAXDELETEDID":
+			deleted, err := parseStreamID(args[i+1], 0)
+			if err != nil {
+				return Encode(err, false)
+			}
+			if id.compare(deleted) < 0 {
+				return Encode(errors.New("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id"), false)
+			}
+			maxDeletedID = &deleted
+		default:
+			return Encode(errSyntax, false)
+		}
+	}
+
+	obj, err := lookupStream(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(errors.New("ERR no such key"), false)
+	}
+
+	s := streamOf(obj)
+	if last, ok := s.lastEntry(); ok && id.compare(last.id) < 0 {
+		return Encode(errors.New("ERR The ID specified in XSETID is smaller than the target stream top item"), false)
+	}
+	if entriesAdded >= 0 && entriesAdded < int64(s.length) {
+		return Encode(errors.New("ERR The entries_added specified in XSETID is smaller than the target stream length"), false)
+	}
+
+	s.lastID = id
+	if entriesAdded >= 0 {
+		s.entriesAdded = entriesAdded
+	}
+	if maxDeletedID != nil {
+		s.maxDeletedID = *maxDeletedID
+	}
+	return Encode("OK", true)
+}
+
+// evalXPending replies the summary of the pending entries of a group, or with the IDLE, start, end and
+// count arguments the details of each pending entry, optionally of a single consumer
+func evalXPending(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("xpending"), false)
+	}
+
+	opts := args[2:]
+	minIdle := int64(0)
+	if len(opts) >= 2 && strings.ToUpper(opts[0]) == "IDLE" {
+		n, ok := parseInt64(opts[1])
+		if !ok {
+			return Encode(errNotInteger, false)
+		}
+		minIdle = n
+		opts = opts[2:]
+		if len(opts) == 0 {
+			return Encode(errSyntax, false)
+		}
+	}
+	if len(opts) != 0 && len(opts) != 3 && len(opts) != 4 {
+		return Encode(errSyntax, false)
+	}
+
+	var start, end streamID
+	count := int64(0)
+	if len(opts) > 0 {
+		var err error
+		if start, err = parseStreamRangeID(opts[0], true); err != nil {
+			return Encode(err, false)
+		}
+		if end, err = parseStreamRangeID(opts[1], false); err != nil {
+			return Encode(err, false)
+		}
+		n, ok := parseInt64(opts[2])
+		if !ok {
+			return Encode(errNotInteger, false)
+		}
+		count = max(n, 0)
+	}
+
+	_, g, err := lookupStreamGroup(args[0], args[1])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	if len(opts) == 0 {
+		if len(g.pel) == 0 {
+			return Encode([]interface{}{0, nil, nil, nil}, false)
+		}
+		ids := pendingIDs(g.pel)
+		names := make([]string, 0, len(g.consumers))
+		for name, c := range g.consumers {
+			if len(c.pending) > 0 {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		consumers := make([]interface{}, len(names))
+		for i, name := range names {
+			consumers[i] = []string{name, strconv.Itoa(len(g.consumers[name].pending))}
+		}
+		return Encode([]interface{}{len(ids), ids[0].String(), ids[len(ids)-1].String(), consumers}, false)
+	}
+
+	pel := g.pel
+	if len(opts) == 4 {
+		c, ok := g.consumers[opts[3]]
+		if !ok {
+			return Encode([]interface{}{}, false)
+		}
+		pel = c.pending
+	}
+
+	now := time.Now().UnixMilli()
+	out := []interface{}{}
+	for _, id := range pendingIDs(pel) {
+		if int64(len(out)) >= count || id.compare(end) > 0 {
+			break
+		}
+		nack := pel[id]
+		idle := now - nack.deliveryTime
+		if id.compare(start) < 0 || idle < minIdle {
+			continue
+		}
+		out = append(out, []interface{}{id.String(), nack.consumer.name, idle, nack.deliveryCount})
+	}
+	return Encode(out, false)
+}
+
+// evalXClaim transfers the ownership of pending entries idle for at least min-idle-time to the consumer
+func evalXClaim(args []string) []byte {
+	if len(args) < 5 {
+		return Encode(errWrongArgCount("xclaim"), false)
+	}
+
+	minIdle, ok := parseInt64(args[3])
+	if !ok {
+		return Encode(errors.New("ERR Invalid min-idle-time argument for XCLAIM"), false)
+	}
+
+	// ids come first and the options follow them
+	i := 4
+	var ids []streamID
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return Encode(errInvalidStreamID, false)
+	}
+
+	now := time.Now().UnixMilli()
+	deliveryTime := now
+	retryCount := int64(-1)
+	force, justID := false, false
+	var lastID *streamID
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "FORCE":
+			force = true
+		case opt == "JUSTID":
+			justID = true
+		case (opt == "IDLE" || opt == "TIME" || opt == "RETRYCOUNT") && i+1 < len(args):
+			n, ok := parseInt64(args[i+1])
+			if !ok {
+				return Encode(fmt.Errorf("ERR Invalid %s option argument for XCLAIM", opt), false)
+			}
+			switch opt {
+			case "IDLE":
+				deliveryTime = now - n
+			case "TIME":
+				deliveryTime = n
+			default:
+				retryCount = n
+			}
+			i++
+		case opt == "LASTID" && i+1 < len(args):
+			id, err := parseStreamID(args[i+1], 0)
+			if err != nil {
+				return Encode(err, false)
+			}
+			lastID = &id
+			i++
+		default:
+			return Encode(fmt.Errorf("ERR Unrecognized XCLAIM option '%s'", args[i]), false)
+		}
+	}
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+
+	s, g, err := lookupStreamGroup(args[0], args[1])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if lastID != nil && lastID.compare(g.lastID) > 0 {
+		g.lastID = *lastID
+	}
+
+	var consumer *streamConsumer
+	out := []interface{}{}
+	for _, id := range ids {
+		nack, pending := g.pel[id]
+		entry, exists := s.get(id)
+		if !pending && (!force || !exists) {
+			continue
+		}
+		// entries deleted from the stream can't be delivered anymore, they leave the pending entries
+		if !exists {
+			g.ack(id)
+			continue
+		}
+		if pending && minIdle > 0 && now-nack.deliveryTime < minIdle {
+			continue
+		}
+
+		if consumer == nil {
+			consumer, _ = g.consumer(args[2], true, now)
+			consumer.seenTime, consumer.activeTime = now, now
+		}
+		nack = g.assign(id, consumer, deliveryTime)
+		if retryCount >= 0 {
+			nack.deliveryCount = retryCount
+		} else if !justID {
+			nack.deliveryCount++
+		}
+
+		if justID {
+			out = append(out, id.String())
+		} else {
+			out = append(out, entry.reply())
+		}
+	}
+	return Encode(out, false)
+}
+
+// evalXAutoClaim claims up to count pending entries idle for at least min-idle-time, scanning the pending
+// entries from start. It replies the id to resume the scan from, the claimed entries and the ids of the
+// pending entries that were deleted from the stream
+func evalXAutoClaim(args []string) []byte {
+	if len(args) < 5 {
+		return Encode(errWrongArgCount("xautoclaim"), false)
+	}
+
+	minIdle, ok := parseInt64(args[3])
+	if !ok {
+		return Encode(errors.New("ERR Invalid min-idle-time argument for XAUTOCLAIM"), false)
+	}
+	start, err := parseStreamRangeID(args[4], true)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	count := int64(100)
+	justID := false
+	for i := 5; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "JUSTID":
+			justID = true
+		case opt == "COUNT" && i+1 < len(args):
+			n, ok := parseInt64(args[i+1])
+			if !ok || n < 1 || n > math.MaxInt64/10 {
+				return Encode(errors.New("ERR COUNT must be > 0"), false)
+			}
+			count = n
+			i++
+		default:
+			return Encode(errSyntax, false)
+		}
+	}
+
+	s, g, err := lookupStreamGroup(args[0], args[1])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	now := time.Now().UnixMilli()
+	ids := pendingIDs(g.pel)
+	j := sort.Search(len(ids), func(i int) bool { return ids[i].compare(start) >= 0 })
+	attempts := count * 10
+	var consumer *streamConsumer
+	claimed, deleted := []interface{}{}, []interface{}{}
+	for ; j < len(ids) && attempts > 0 && int64(len(claimed)) < count; j++ {
+		attempts--
+		id := ids[j]
+		nack := g.pel[id]
+		entry, exists := s.get(id)
+		if !exists {
+			g.ack(id)
+			deleted = append(deleted, id.String())
+			continue
+		}
+		if minIdle > 0 && now-nack.deliveryTime < minIdle {
+			continue
+		}
+
+		if consumer == nil {
+			consumer, _ = g.consumer(args[2], true, now)
+			consumer.seenTime, consumer.activeTime = now, now
+		}
+		g.assign(id, consumer, now)
+		if justID {
+			claimed = append(claimed, id.String())
+		} else {
+			nack.deliveryCount++
+			claimed = append(claimed, entry.reply())
+		}
+	}
+
+	next := streamID{}
+	if j < len(ids) {
+		next = ids[j]
+	}
+	return Encode([]interface{}{next.String(), claimed, deleted}, false)
+}
+
+func evalXInfo(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("xinfo"), false)
+	}
+
+	sub := strings.ToUpper(args[0])
+	switch {
+	case sub == "STREAM" && len(args) == 2, sub == "GROUPS" && len(args) == 2, sub == "CONSUMERS" && len(args) == 3:
+	case sub == "STREAM" || sub == "GROUPS" || sub == "CONSUMERS":
+		return Encode(errWrongArgCount("xinfo|"+strings.ToLower(sub)), false)
+	default:
+		return Encode(fmt.Errorf("ERR unknown subcommand '%s'. Try XINFO HELP.", args[0]), false)
+	}
+
+	obj, err := lookupStream(args[1])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(errors.New("ERR no such key"), false)
+	}
+	s := streamOf(obj)
+	now := time.Now().UnixMilli()
+
+	switch sub {
+	case "STREAM":
+		var firstEntry, lastEntry interface{}
+		firstID := streamID{}
+		if e, ok := s.firstEntry(); ok {
+			firstEntry, firstID = e.reply(), e.id
+		}
+		if e, ok := s.lastEntry(); ok {
+			lastEntry = e.reply()
+		}
+		return Encode([]interface{}{
+			"length", s.length,
+			"radix-tree-keys", len(s.nodes),
+			"last-generated-id", s.lastID.String(),
+			"max-deleted-entry-id", s.maxDeletedID.String(),
+			"entries-added", s.entriesAdded,
+			"recorded-first-entry-id", firstID.String(),
+			"groups", len(s.groups),
+			"first-entry", firstEntry,
+			"last-entry", lastEntry,
+		}, false)
+	case "GROUPS":
+		out := []interface{}{}
+		for _, name := range s.sortedGroupNames() {
+			g := s.groups[name]
+			out = append(out, []interface{}{
+				"name", g.name,
+				"consumers", len(g.consumers),
+				"pending", len(g.pel),
+				"last-delivered-id", g.lastID.String(),
+				"entries-read", g.entriesRead,
+				"lag", s.lag(g),
+			})
+		}
+		return Encode(out, false)
+	default:
+		g, ok := s.groups[args[2]]
+		if !ok {
+			return Encode(errNoSuchGroup(args[1], args[2]), false)
+		}
+		names := make([]string, 0, len(g.consumers))
+		for name := range g.consumers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		out := []interface{}{}
+		for _, name := range names {
+			c := g.consumers[name]
+			inactive := int64(-1)
+			if c.activeTime >= 0 {
+				inactive = now - c.activeTime
+			}
+			out = append(out, []interface{}{
+				"name", c.name,
+				"pending", len(c.pending),
+				"idle", now - c.seenTime,
+				"inactive", inactive,
+			})
+		}
+		return Encode(out, false)
+	}
+}
@@ -0,0 +1,431 @@
+package core
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// PROTO_MAX_BULK_LEN caps the size of a string value, as proto-max-bulk-len does in redis
+const PROTO_MAX_BULK_LEN = 512 * 1024 * 1024
+
+var errStringTooLong = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+
+// rawBytesOf converts a string object to the raw encoding so that it can be modified in place
+func rawBytesOf(obj *Obj) []byte {
+	if b, ok := obj.Value.([]byte); ok {
+		return b
+	}
+	b := []byte(stringValueOf(obj))
+	obj.Value = b
+	obj.setEncoding(OBJ_ENCODING_RAW)
+	return b
+}
+
+func evalAppend(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("append"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_STRING)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	if obj == nil {
+		Put(args[0], newStringObj(args[1], -1))
+		return Encode(len(args[1]), false)
+	}
+
+	b := rawBytesOf(obj)
+	if len(b)+len(args[1]) > PROTO_MAX_BULK_LEN {
+		return Encode(errStringTooLong, false)
+	}
+	b = append(b, args[1]...)
+	obj.Value = b
+	signalKeyModified(args[0])
+
+	return Encode(len(b), false)
+}
+
+func evalStrlen(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("strlen"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_STRING)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+
+	if b, ok := obj.Value.([]byte); ok {
+		return Encode(len(b), false)
+	}
+	return Encode(len(stringValueOf(obj)), false)
+}
+
+func evalGetRange(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("getrange"), false)
+	}
+
+	start, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
+	end, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_STRING)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode("", false)
+	}
+
+	value := stringValueOf(obj)
+	strlen := int64(len(value))
+
+	if start < 0 && end < 0 && start > end {
+		return Encode("", false)
+	}
+	if start < 0 {
+		start = strlen + start
+	}
+	if end < 0 {
+		end = strlen + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= strlen {
+		end = strlen - 1
+	}
+	if start > end || strlen == 0 {
+		return Encode("", false)
+	}
+
+	return Encode(value[start:end+1], false)
+}
+
+func evalSetRange(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("setrange"), false)
+	}
+
+	offset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
+	if offset < 0 {
+		return Encode(errors.New("ERR offset is out of range"), false)
+	}
+
+	value := args[2]
+	obj, err := getOfType(args[0], OBJ_TYPE_STRING)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	if obj == nil {
+		// setting an empty range on a missing key does not create it
+		if len(value) == 0 {
+			return Encode(0, false)
+		}
+		if offset+int64(len(value)) > PROTO_MAX_BULK_LEN {
+			return Encode(errStringTooLong, false)
+		}
+		obj = NewObj([]byte{}, -1, OBJ_TYPE_STRING, OBJ_ENCODING_RAW)
+		Put(args[0], obj)
+	}
+
+	b := rawBytesOf(obj)
+	if len(value) == 0 {
+		return Encode(len(b), false)
+	}
+	if offset+int64(len(value)) > PROTO_MAX_BULK_LEN {
+		return Encode(errStringTooLong, false)
+	}
+
+	// the gap between the end of the string and the offset is padded with zero bytes
+	if needed := int(offset) + len(value); needed > len(b) {
+		b = append(b, make([]byte, needed-len(b))...)
+	}
+	copy(b[offset:], value)
+	obj.Value = b
+	signalKeyModified(args[0])
+
+	return Encode(len(b), false)
+}
+
+func evalLcs(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("lcs"), false)
+	}
+
+	var getLen, getIdx, withMatchLen bool
+	var minMatchLen int64
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "LEN":
+			getLen = true
+		case "IDX":
+			getIdx = true
+		case "WITHMATCHLEN":
+			withMatchLen = true
+		case "MINMATCHLEN":
+			if i+1 >= len(args) {
+				return Encode(errSyntax, false)
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return Encode(errNotInteger, false)
+			}
+			minMatchLen = max(n, 0)
+			i++
+		default:
+			return Encode(errSyntax, false)
+		}
+	}
+
+	if getLen && getIdx {
+		return Encode(errors.New("ERR If you want both the length and indexes, please just use IDX."), false)
+	}
+
+	var values [2]string
+	for i := range values {
+		obj, err := getOfType(args[i], OBJ_TYPE_STRING)
+		if err != nil {
+			return Encode(errors.New("ERR The specified keys must contain string values"), false)
+		}
+		if obj != nil {
+			values[i] = stringValueOf(obj)
+		}
+	}
+
+	a, b := values[0], values[1]
+	alen, blen := len(a), len(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[:i] and b[:j]
+	lcs := make([][]uint32, alen+1)
+	for i := range lcs {
+		lcs[i] = make([]uint32, blen+1)
+	}
+	for i := 1; i <= alen; i++ {
+		for j := 1; j <= blen; j++ {
+			if a[i-1] == b[j-1] {
+				lcs[i][j] = lcs[i-1][j-1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i-1][j], lcs[i][j-1])
+			}
+		}
+	}
+
+	idx := lcs[alen][blen]
+	if getLen {
+		return Encode(int64(idx), false)
+	}
+
+	// walk the table back from the end to rebuild the subsequence and the matching ranges,
+	// the ranges are therefore reported from the last one to the first one
+	result := make([]byte, idx)
+	matches := []interface{}{}
+	arangeStart, arangeEnd, brangeStart, brangeEnd := alen, 0, 0, 0
+
+	i, j := alen, blen
+	for i > 0 && j > 0 {
+		emitRange := false
+		if a[i-1] == b[j-1] {
+			result[idx-1] = a[i-1]
+			if arangeStart == alen {
+				arangeStart, arangeEnd = i-1, i-1
+				brangeStart, brangeEnd = j-1, j-1
+			} else if arangeStart == i && brangeStart == j {
+				// the range is contiguous, extend it backward
+				arangeStart--
+				brangeStart--
+			} else {
+				emitRange = true
+			}
+			if arangeStart == 0 || brangeStart == 0 {
+				emitRange = true
+			}
+			idx--
+			i--
+			j--
+		} else {
+			if lcs[i-1][j] > lcs[i][j-1] {
+				i--
+			} else {
+				j--
+			}
+			if arangeStart != alen {
+				emitRange = true
+			}
+		}
+
+		matchLen := int64(arangeEnd - arangeStart + 1)
+		if emitRange {
+			if getIdx && (minMatchLen == 0 || matchLen >= minMatchLen) {
+				match := []interface{}{
+					[]interface{}{int64(arangeStart), int64(arangeEnd)},
+					[]interface{}{int64(brangeStart), int64(brangeEnd)},
+				}
+				if withMatchLen {
+					match = append(match, matchLen)
+				}
+				matches = append(matches, match)
+			}
+			arangeStart = alen
+		}
+	}
+
+	if getIdx {
+		return Encode([]interface{}{"matches", matches, "len", int64(lcs[alen][blen])}, false)
+	}
+	return Encode(string(result), false)
+}
+
+func evalMGet(args []string) []byte {
+	if len(args) == 0 {
+		return Encode(errWrongArgCount("mget"), false)
+	}
+
+	values := make([]interface{}, len(args))
+	for i, k := range args {
+		// keys holding other types are reported as missing instead of failing the whole command
+		obj, err := getOfType(k, OBJ_TYPE_STRING)
+		if err == nil && obj != nil {
+			values[i] = stringValueOf(obj)
+		}
+	}
+	return Encode(values, false)
+}
+
+func evalMSet(args []string) []byte {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return Encode(errWrongArgCount("mset"), false)
+	}
+
+	// room was made once before the command ran, evicting between the writes could drop some of the keys
+	for i := 0; i < len(args); i += 2 {
+		put(args[i], newStringObj(args[i+1], -1))
+	}
+	return Encode("OK", true)
+}
+
+func evalMSetNX(args []string) []byte {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return Encode(errWrongArgCount("msetnx"), false)
+	}
+
+	// nothing is set when any of the keys already exists
+	for i := 0; i < len(args); i += 2 {
+		if peek(args[i]) != nil {
+			return Encode(0, false)
+		}
+	}
+	for i := 0; i < len(args); i += 2 {
+		put(args[i], newStringObj(args[i+1], -1))
+	}
+	return Encode(1, false)
+}
+
+func evalGetDel(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("getdel"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_STRING)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(nil, false)
+	}
+
+	value := stringValueOf(obj)
+	Delete(args[0])
+	return Encode(value, false)
+}
+
+func evalGetEx(args []string, t TimeProvider) []byte {
+	if len(args) == 0 {
+		return Encode(errWrongArgCount("getex"), false)
+	}
+
+	validTill := 0
+	persist := false
+	optionSet := false
+	for i := 1; i < len(args); i++ {
+		if optionSet {
+			return Encode(errSyntax, false)
+		}
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "PERSIST":
+			persist = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 >= len(args) {
+				return Encode(errSyntax, false)
+			}
+			var err error
+			validTill, err = expiryFromOption("getex", option, args[i+1], t)
+			if err != nil {
+				return Encode(err, false)
+			}
+			i++
+		default:
+			return Encode(errSyntax, false)
+		}
+		optionSet = true
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_STRING)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(nil, false)
+	}
+
+	value := stringValueOf(obj)
+	if persist {
+		obj.ValidTill = -1
+	} else if validTill != 0 {
+		if int64(validTill) <= t.Now().UnixMilli() {
+			// an expiry in the past deletes the key right away
+			Delete(args[0])
+		} else {
+			obj.ValidTill = validTill
+		}
+	}
+	return Encode(value, false)
+}
+
+func evalGetSet(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("getset"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_STRING)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	var old interface{}
+	if obj != nil {
+		old = stringValueOf(obj)
+	}
+	Put(args[0], newStringObj(args[1], -1))
+	return Encode(old, false)
+}
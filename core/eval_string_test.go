@@ -0,0 +1,132 @@
+package core_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/diceclone/core"
+)
+
+type commandCase struct {
+	name string
+	cmd  *core.RedisCmd
+	want []byte
+}
+
+// runCommandCases evaluates the cases in order against the same store and checks every reply
+func runCommandCases(t *testing.T, cases []commandCase) {
+	mockReadWriter, _ := setupTest()
+	timeProvider := core.RealTimeProvider{}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			core.EvalAndRespond(tc.cmd, mockReadWriter, timeProvider)
+			if !bytes.Equal(mockReadWriter.LastWrite, tc.want) {
+				t.Errorf("got %q, want %q", string(mockReadWriter.LastWrite), string(tc.want))
+			}
+		})
+	}
+}
+
+func TestAPPENDAndSTRLENCommands(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"append to a missing key", &core.RedisCmd{Cmd: "APPEND", Args: []string{"greeting", "Hello"}}, []byte(":5\r\n")},
+		{"append to an existing key", &core.RedisCmd{Cmd: "APPEND", Args: []string{"greeting", " World"}}, []byte(":11\r\n")},
+		{"get the appended value", &core.RedisCmd{Cmd: "GET", Args: []string{"greeting"}}, []byte("$11\r\nHello World\r\n")},
+		{"appended strings are raw encoded", &core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "greeting"}}, []byte("$3\r\nraw\r\n")},
+		{"length of the string", &core.RedisCmd{Cmd: "STRLEN", Args: []string{"greeting"}}, []byte(":11\r\n")},
+		{"length of a missing key", &core.RedisCmd{Cmd: "STRLEN", Args: []string{"nonexistent"}}, []byte(":0\r\n")},
+		{"set an integer", &core.RedisCmd{Cmd: "SET", Args: []string{"appendnum", "12"}}, []byte("+OK\r\n")},
+		{"append to an integer", &core.RedisCmd{Cmd: "APPEND", Args: []string{"appendnum", "34"}}, []byte(":4\r\n")},
+		{"increment the appended integer", &core.RedisCmd{Cmd: "INCR", Args: []string{"appendnum"}}, []byte(":1235\r\n")},
+	})
+}
+
+func TestGETRANGEAndSETRANGECommands(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"set the string", &core.RedisCmd{Cmd: "SET", Args: []string{"rangekey", "This is a string"}}, []byte("+OK\r\n")},
+		{"get a range", &core.RedisCmd{Cmd: "GETRANGE", Args: []string{"rangekey", "0", "3"}}, []byte("$4\r\nThis\r\n")},
+		{"get a range with negative offsets", &core.RedisCmd{Cmd: "GETRANGE", Args: []string{"rangekey", "-3", "-1"}}, []byte("$3\r\ning\r\n")},
+		{"get the whole string", &core.RedisCmd{Cmd: "GETRANGE", Args: []string{"rangekey", "0", "-1"}}, []byte("$16\r\nThis is a string\r\n")},
+		{"get a range beyond the end", &core.RedisCmd{Cmd: "GETRANGE", Args: []string{"rangekey", "10", "100"}}, []byte("$6\r\nstring\r\n")},
+		{"overwrite part of the string", &core.RedisCmd{Cmd: "SETRANGE", Args: []string{"rangekey", "10", "thing!"}}, []byte(":16\r\n")},
+		{"get the overwritten string", &core.RedisCmd{Cmd: "GET", Args: []string{"rangekey"}}, []byte("$16\r\nThis is a thing!\r\n")},
+		{"set a range on a missing key", &core.RedisCmd{Cmd: "SETRANGE", Args: []string{"paddedkey", "3", "abc"}}, []byte(":6\r\n")},
+		{"the gap is zero padded", &core.RedisCmd{Cmd: "GET", Args: []string{"paddedkey"}}, []byte("$6\r\n\x00\x00\x00abc\r\n")},
+		{"negative offset", &core.RedisCmd{Cmd: "SETRANGE", Args: []string{"paddedkey", "-1", "abc"}}, []byte("-ERR offset is out of range\r\n")},
+		{"offset past the maximum size", &core.RedisCmd{Cmd: "SETRANGE", Args: []string{"paddedkey", "536870911", "abc"}}, []byte("-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n")},
+		{"empty range on a missing key", &core.RedisCmd{Cmd: "SETRANGE", Args: []string{"nonexistent", "5", ""}}, []byte(":0\r\n")},
+	})
+}
+
+func TestLCSCommand(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"set the first string", &core.RedisCmd{Cmd: "SET", Args: []string{"lcs1", "ohmytext"}}, []byte("+OK\r\n")},
+		{"set the second string", &core.RedisCmd{Cmd: "SET", Args: []string{"lcs2", "mynewtext"}}, []byte("+OK\r\n")},
+		{"longest common subsequence", &core.RedisCmd{Cmd: "LCS", Args: []string{"lcs1", "lcs2"}}, []byte("$6\r\nmytext\r\n")},
+		{"length only", &core.RedisCmd{Cmd: "LCS", Args: []string{"lcs1", "lcs2", "LEN"}}, []byte(":6\r\n")},
+		{
+			"matching ranges",
+			&core.RedisCmd{Cmd: "LCS", Args: []string{"lcs1", "lcs2", "IDX"}},
+			[]byte("*4\r\n$7\r\nmatches\r\n*2\r\n*2\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n*2\r\n*2\r\n:2\r\n:3\r\n*2\r\n:0\r\n:1\r\n$3\r\nlen\r\n:6\r\n"),
+		},
+		{
+			"matching ranges with a minimum length",
+			&core.RedisCmd{Cmd: "LCS", Args: []string{"lcs1", "lcs2", "IDX", "MINMATCHLEN", "4", "WITHMATCHLEN"}},
+			[]byte("*4\r\n$7\r\nmatches\r\n*1\r\n*3\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n:4\r\n$3\r\nlen\r\n:6\r\n"),
+		},
+		{"length and indexes together", &core.RedisCmd{Cmd: "LCS", Args: []string{"lcs1", "lcs2", "LEN", "IDX"}}, []byte("-ERR If you want both the length and indexes, please just use IDX.\r\n")},
+	})
+}
+
+func TestMultiKeyStringCommands(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"set multiple keys", &core.RedisCmd{Cmd: "MSET", Args: []string{"mk1", "v1", "mk2", "v2"}}, []byte("+OK\r\n")},
+		{"set with a missing value", &core.RedisCmd{Cmd: "MSET", Args: []string{"mk1", "v1", "mk2"}}, []byte("-ERR wrong number of arguments for 'mset' command\r\n")},
+		{"get multiple keys", &core.RedisCmd{Cmd: "MGET", Args: []string{"mk1", "nonexistent", "mk2"}}, []byte("*3\r\n$2\r\nv1\r\n$-1\r\n$2\r\nv2\r\n")},
+		{"msetnx when a key exists", &core.RedisCmd{Cmd: "MSETNX", Args: []string{"mk3", "v3", "mk1", "changed"}}, []byte(":0\r\n")},
+		{"msetnx sets nothing when it fails", &core.RedisCmd{Cmd: "MGET", Args: []string{"mk1", "mk3"}}, []byte("*2\r\n$2\r\nv1\r\n$-1\r\n")},
+		{"msetnx with new keys", &core.RedisCmd{Cmd: "MSETNX", Args: []string{"mk3", "v3", "mk4", "v4"}}, []byte(":1\r\n")},
+		{"getset returns the old value", &core.RedisCmd{Cmd: "GETSET", Args: []string{"mk3", "new"}}, []byte("$2\r\nv3\r\n")},
+		{"getset on a missing key", &core.RedisCmd{Cmd: "GETSET", Args: []string{"mk5", "v5"}}, []byte("$-1\r\n")},
+		{"getdel returns the value", &core.RedisCmd{Cmd: "GETDEL", Args: []string{"mk4"}}, []byte("$2\r\nv4\r\n")},
+		{"getdel deletes the key", &core.RedisCmd{Cmd: "GET", Args: []string{"mk4"}}, []byte("$-1\r\n")},
+	})
+}
+
+func TestMSetEvictsBeforeWriting(t *testing.T) {
+	mockReadWriter, timeProvider := setupTest()
+	withKeysLimit(t, 4, "allkeys-random")
+	eval := func(cmd string, args ...string) string {
+		core.EvalAndRespond(&core.RedisCmd{Cmd: cmd, Args: args}, mockReadWriter, timeProvider)
+		return string(mockReadWriter.LastWrite)
+	}
+	for round := 0; round < 20; round++ {
+		for _, cmd := range []string{"MSET", "MSETNX"} {
+			eval("FLUSHDB")
+			eval("MSET", "old1", "v", "old2", "v", "old3", "v")
+			eval(cmd, "new1", "v", "new2", "v", "new3", "v")
+			if got := eval("MGET", "new1", "new2", "new3"); got != "*3\r\n$1\r\nv\r\n$1\r\nv\r\n$1\r\nv\r\n" {
+				t.Fatalf("%s evicted its own keys: MGET returned %q", cmd, got)
+			}
+		}
+	}
+}
+
+func TestGETEXCommand(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"set the key", &core.RedisCmd{Cmd: "SET", Args: []string{"exkey", "value"}}, []byte("+OK\r\n")},
+		{"getex without options", &core.RedisCmd{Cmd: "GETEX", Args: []string{"exkey"}}, []byte("$5\r\nvalue\r\n")},
+		{"the key has no ttl", &core.RedisCmd{Cmd: "TTL", Args: []string{"exkey"}}, []byte(":-1\r\n")},
+		{"getex with EX", &core.RedisCmd{Cmd: "GETEX", Args: []string{"exkey", "EX", "100"}}, []byte("$5\r\nvalue\r\n")},
+		{"the ttl is set", &core.RedisCmd{Cmd: "TTL", Args: []string{"exkey"}}, []byte(":100\r\n")},
+		{"getex with PX", &core.RedisCmd{Cmd: "GETEX", Args: []string{"exkey", "PX", "50000"}}, []byte("$5\r\nvalue\r\n")},
+		{"the ttl is set in milliseconds", &core.RedisCmd{Cmd: "TTL", Args: []string{"exkey"}}, []byte(":50\r\n")},
+		{"getex with PERSIST", &core.RedisCmd{Cmd: "GETEX", Args: []string{"exkey", "PERSIST"}}, []byte("$5\r\nvalue\r\n")},
+		{"the ttl is removed", &core.RedisCmd{Cmd: "TTL", Args: []string{"exkey"}}, []byte(":-1\r\n")},
+		{"getex with an invalid expire", &core.RedisCmd{Cmd: "GETEX", Args: []string{"exkey", "EX", "0"}}, []byte("-ERR invalid expire time in 'getex' command\r\n")},
+		{"getex with two options", &core.RedisCmd{Cmd: "GETEX", Args: []string{"exkey", "EX", "10", "PERSIST"}}, []byte("-ERR syntax error\r\n")},
+		{"getex with EXAT in the past", &core.RedisCmd{Cmd: "GETEX", Args: []string{"exkey", "EXAT", "1"}}, []byte("$5\r\nvalue\r\n")},
+		{"the key is deleted", &core.RedisCmd{Cmd: "GET", Args: []string{"exkey"}}, []byte("$-1\r\n")},
+		{"getex on a missing key", &core.RedisCmd{Cmd: "GETEX", Args: []string{"exkey", "PERSIST"}}, []byte("$-1\r\n")},
+	})
+}
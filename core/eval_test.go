@@ -3,6 +3,7 @@ package core_test
 import (
 	"bytes"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
@@ -425,6 +426,32 @@ func TestBGREWRITEAOFCommand(t *testing.T) {
 	})
 }
 
+func TestPEXPIREATCommand(t *testing.T) {
+	mockReadWriter, _ := setupTest()
+	timeProvider := core.RealTimeProvider{}
+	deadline := strconv.FormatInt(time.Now().UnixMilli()+100400, 10)
+
+	cases := []struct {
+		cmd  *core.RedisCmd
+		want string
+	}{
+		{&core.RedisCmd{Cmd: "SET", Args: []string{"pexp", "v"}}, "+OK\r\n"},
+		{&core.RedisCmd{Cmd: "PEXPIREAT", Args: []string{"pexp", deadline}}, ":1\r\n"},
+		// the deadline is kept in milliseconds and the ttl is rounded to the closest second
+		{&core.RedisCmd{Cmd: "TTL", Args: []string{"pexp"}}, ":100\r\n"},
+		{&core.RedisCmd{Cmd: "PEXPIREAT", Args: []string{"pexp", "1"}}, ":1\r\n"},
+		{&core.RedisCmd{Cmd: "GET", Args: []string{"pexp"}}, "$-1\r\n"},
+		{&core.RedisCmd{Cmd: "PEXPIREAT", Args: []string{"pexp", deadline}}, ":0\r\n"},
+		{&core.RedisCmd{Cmd: "PEXPIREAT", Args: []string{"pexp", "soon"}}, "-ERR value is not an integer or out of range\r\n"},
+	}
+	for _, tc := range cases {
+		core.EvalAndRespond(tc.cmd, mockReadWriter, timeProvider)
+		if string(mockReadWriter.LastWrite) != tc.want {
+			t.Errorf("%s %v: got %q, want %q", tc.cmd.Cmd, tc.cmd.Args, string(mockReadWriter.LastWrite), tc.want)
+		}
+	}
+}
+
 func TestINCRCommand(t *testing.T) {
 	t.Run("increment the value of an existing key", func(t *testing.T) {
 
@@ -454,7 +481,7 @@ func TestINCRCommand(t *testing.T) {
 
 	t.Run("increment when value is not an integer", func(t *testing.T) {
 		mockReadWriter, timeProvider := setupTest()
-		want := []byte("-operation not permitted on this encoding\r\n")
+		want := []byte("-ERR value is not an integer or out of range\r\n")
 
 		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"K1", "V1"}}, mockReadWriter, timeProvider)
 
@@ -503,3 +530,224 @@ func TestFLUSHDBCommand(t *testing.T) {
 
 	})
 }
+
+func TestTYPECommand(t *testing.T) {
+	mockReadWriter, timeProvider := setupTest()
+	core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"typedkey", "value"}}, mockReadWriter, timeProvider)
+
+	cases := []struct {
+		name string
+		key  string
+		want []byte
+	}{
+		{name: "type of a string", key: "typedkey", want: []byte("+string\r\n")},
+		{name: "type of a key that does not exist", key: "nonexistent", want: []byte("+none\r\n")},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			core.EvalAndRespond(&core.RedisCmd{Cmd: "TYPE", Args: []string{tc.key}}, mockReadWriter, timeProvider)
+			if !bytes.Equal(mockReadWriter.LastWrite, tc.want) {
+				t.Errorf("got %v, want %v", string(mockReadWriter.LastWrite), string(tc.want))
+			}
+		})
+	}
+}
+
+func TestOBJECTENCODINGCommand(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		want  []byte
+	}{
+		{name: "integer string", value: "12345", want: []byte("$3\r\nint\r\n")},
+		{name: "short string", value: "hello", want: []byte("$6\r\nembstr\r\n")},
+		{name: "long string", value: "a string that is longer than forty four bytes", want: []byte("$3\r\nraw\r\n")},
+	}
+
+	mockReadWriter, timeProvider := setupTest()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"encodedkey", tc.value}}, mockReadWriter, timeProvider)
+			core.EvalAndRespond(&core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "encodedkey"}}, mockReadWriter, timeProvider)
+			if !bytes.Equal(mockReadWriter.LastWrite, tc.want) {
+				t.Errorf("got %v, want %v", string(mockReadWriter.LastWrite), string(tc.want))
+			}
+		})
+	}
+
+	t.Run("encoding of a key that does not exist", func(t *testing.T) {
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "nonexistent"}}, mockReadWriter, timeProvider)
+		if !bytes.Equal(mockReadWriter.LastWrite, []byte("$-1\r\n")) {
+			t.Errorf("got %v, want $-1", string(mockReadWriter.LastWrite))
+		}
+	})
+}
+
+func TestINCRCommandIntegerEncoding(t *testing.T) {
+	t.Run("increment beyond the int64 range", func(t *testing.T) {
+		mockReadWriter, timeProvider := setupTest()
+		want := []byte("-ERR increment or decrement would overflow\r\n")
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"maxint", "9223372036854775807"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "INCR", Args: []string{"maxint"}}, mockReadWriter, timeProvider)
+
+		if !bytes.Equal(mockReadWriter.LastWrite, want) {
+			t.Errorf("got: %v, want: %v", string(mockReadWriter.LastWrite), string(want))
+		}
+	})
+
+	t.Run("non canonical integers are kept as strings", func(t *testing.T) {
+		mockReadWriter, timeProvider := setupTest()
+		want := []byte("$3\r\n007\r\n")
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"padded", "007"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "GET", Args: []string{"padded"}}, mockReadWriter, timeProvider)
+
+		if !bytes.Equal(mockReadWriter.LastWrite, want) {
+			t.Errorf("got: %v, want: %v", string(mockReadWriter.LastWrite), string(want))
+		}
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "padded"}}, mockReadWriter, timeProvider)
+		if !bytes.Equal(mockReadWriter.LastWrite, []byte("$6\r\nembstr\r\n")) {
+			t.Errorf("got: %v, want embstr", string(mockReadWriter.LastWrite))
+		}
+	})
+
+	t.Run("increment keeps the ttl of the key", func(t *testing.T) {
+		mockReadWriter, _ := setupTest()
+		timeProvider := core.RealTimeProvider{}
+		want := []byte(":100\r\n")
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"counter", "41", "ex", "100"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "INCR", Args: []string{"counter"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "TTL", Args: []string{"counter"}}, mockReadWriter, timeProvider)
+
+		if !bytes.Equal(mockReadWriter.LastWrite, want) {
+			t.Errorf("got: %v, want: %v", string(mockReadWriter.LastWrite), string(want))
+		}
+	})
+}
+
+func TestNumericStringCommands(t *testing.T) {
+	cases := []struct {
+		name  string
+		setup []string
+		cmd   *core.RedisCmd
+		want  []byte
+	}{
+		{
+			name:  "INCRBY adds the increment",
+			setup: []string{"num", "10"},
+			cmd:   &core.RedisCmd{Cmd: "INCRBY", Args: []string{"num", "5"}},
+			want:  []byte(":15\r\n"),
+		},
+		{
+			name:  "INCRBY with an increment that is not an integer",
+			setup: []string{"num", "10"},
+			cmd:   &core.RedisCmd{Cmd: "INCRBY", Args: []string{"num", "1.5"}},
+			want:  []byte("-ERR value is not an integer or out of range\r\n"),
+		},
+		{
+			name:  "DECR subtracts one",
+			setup: []string{"num", "10"},
+			cmd:   &core.RedisCmd{Cmd: "DECR", Args: []string{"num"}},
+			want:  []byte(":9\r\n"),
+		},
+		{
+			name:  "DECRBY goes below zero",
+			setup: []string{"num", "10"},
+			cmd:   &core.RedisCmd{Cmd: "DECRBY", Args: []string{"num", "25"}},
+			want:  []byte(":-15\r\n"),
+		},
+		{
+			name:  "DECRBY below the int64 range",
+			setup: []string{"num", "-9223372036854775808"},
+			cmd:   &core.RedisCmd{Cmd: "DECRBY", Args: []string{"num", "1"}},
+			want:  []byte("-ERR increment or decrement would overflow\r\n"),
+		},
+		{
+			name:  "INCRBYFLOAT on an integer",
+			setup: []string{"num", "10"},
+			cmd:   &core.RedisCmd{Cmd: "INCRBYFLOAT", Args: []string{"num", "0.1"}},
+			want:  []byte("$4\r\n10.1\r\n"),
+		},
+		{
+			name:  "INCRBYFLOAT with exponent notation",
+			setup: []string{"num", "5.0e3"},
+			cmd:   &core.RedisCmd{Cmd: "INCRBYFLOAT", Args: []string{"num", "2.0e2"}},
+			want:  []byte("$4\r\n5200\r\n"),
+		},
+		{
+			name:  "INCRBYFLOAT drops the trailing zeros",
+			setup: []string{"num", "10.50"},
+			cmd:   &core.RedisCmd{Cmd: "INCRBYFLOAT", Args: []string{"num", "0.1"}},
+			want:  []byte("$4\r\n10.6\r\n"),
+		},
+		{
+			name:  "INCRBYFLOAT adds like a long double",
+			setup: []string{"num", "0.1"},
+			cmd:   &core.RedisCmd{Cmd: "INCRBYFLOAT", Args: []string{"num", "0.2"}},
+			want:  []byte("$3\r\n0.3\r\n"),
+		},
+		{
+			name:  "INCRBYFLOAT with an exponent increment",
+			setup: []string{"num", "0"},
+			cmd:   &core.RedisCmd{Cmd: "INCRBYFLOAT", Args: []string{"num", "3.0e3"}},
+			want:  []byte("$4\r\n3000\r\n"),
+		},
+		{
+			name:  "INCRBYFLOAT to a negative zero",
+			setup: []string{"num", "-0.5"},
+			cmd:   &core.RedisCmd{Cmd: "INCRBYFLOAT", Args: []string{"num", "0.5"}},
+			want:  []byte("$1\r\n0\r\n"),
+		},
+		{
+			name:  "INCRBYFLOAT on a value that is not a number",
+			setup: []string{"num", "abc"},
+			cmd:   &core.RedisCmd{Cmd: "INCRBYFLOAT", Args: []string{"num", "1"}},
+			want:  []byte("-ERR value is not a valid float\r\n"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockReadWriter, timeProvider := setupTest()
+			core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: tc.setup}, mockReadWriter, timeProvider)
+			core.EvalAndRespond(tc.cmd, mockReadWriter, timeProvider)
+
+			if !bytes.Equal(mockReadWriter.LastWrite, tc.want) {
+				t.Errorf("got: %v, want: %v", string(mockReadWriter.LastWrite), string(tc.want))
+			}
+		})
+	}
+
+	t.Run("INCRBYFLOAT keeps the ttl of the key", func(t *testing.T) {
+		mockReadWriter, _ := setupTest()
+		timeProvider := core.RealTimeProvider{}
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"floatttl", "1.5", "ex", "100"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "INCRBYFLOAT", Args: []string{"floatttl", "1"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "TTL", Args: []string{"floatttl"}}, mockReadWriter, timeProvider)
+
+		if !bytes.Equal(mockReadWriter.LastWrite, []byte(":100\r\n")) {
+			t.Errorf("got: %v, want: :100", string(mockReadWriter.LastWrite))
+		}
+	})
+	// there is no per-command AOF propagation, the value reaches the AOF through BGREWRITEAOF
+	t.Run("INCRBYFLOAT is rewritten to the AOF as a SET of the computed value", func(t *testing.T) {
+		mockReadWriter, timeProvider := setupTest()
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"floataof", "10.5"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "INCRBYFLOAT", Args: []string{"floataof", "0.1"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "INCRBYFLOAT", Args: []string{"floataof", "1e2"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "BGREWRITEAOF", Args: []string{}}, mockReadWriter, timeProvider)
+
+		content, _ := os.ReadFile(config.APPEND_ONLY_FILE)
+		os.Remove(config.APPEND_ONLY_FILE)
+		want := "*3\r\n$3\r\nSET\r\n$8\r\nFLOATAOF\r\n$5\r\n110.6\r\n"
+		if !bytes.Contains(content, []byte(want)) || bytes.Contains(content, []byte("INCRBYFLOAT")) {
+			t.Errorf("AOF content does not hold the computed value:\nGot:\n%q\nWant:\n%q", string(content), want)
+		}
+	})
+}
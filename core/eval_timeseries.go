@@ -0,0 +1,471 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/diceclone/config"
+)
+
+// tsOptions are the options TS.CREATE shares with TS.ADD, which also takes ON_DUPLICATE
+type tsOptions struct {
+	retention       int64
+	duplicatePolicy string
+	onDuplicate     string
+	labels          []tsLabel
+}
+
+func parseTSOptions(args []string, allowOnDuplicate bool) (*tsOptions, error) {
+	opts := &tsOptions{retention: int64(config.TS_RETENTION_POLICY), duplicatePolicy: "block"}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if option == "LABELS" {
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil, errTSBadLabels
+			}
+			for j := 0; j < len(rest); j += 2 {
+				opts.labels = append(opts.labels, tsLabel{rest[j], rest[j+1]})
+			}
+			return opts, nil
+		}
+		if i+1 >= len(args) {
+			return nil, errSyntax
+		}
+		i++
+		switch {
+		case option == "RETENTION":
+			retention, ok := parseInt64(args[i])
+			if !ok || retention < 0 {
+				return nil, errTSBadRetention
+			}
+			opts.retention = retention
+		case option == "DUPLICATE_POLICY" || option == "ON_DUPLICATE" && allowOnDuplicate:
+			policy := strings.ToLower(args[i])
+			if !slices.Contains(tsDuplicatePolicies, policy) {
+				return nil, errTSBadDuplicatePolicy
+			}
+			if option == "ON_DUPLICATE" {
+				opts.onDuplicate = policy
+			} else {
+				opts.duplicatePolicy = policy
+			}
+		default:
+			return nil, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+// parseTSTimestamp parses the timestamp of a sample, * being the current time
+func parseTSTimestamp(s string) (int64, error) {
+	if s == "*" {
+		return time.Now().UnixMilli(), nil
+	}
+	ts, ok := parseInt64(s)
+	if !ok || ts < 0 {
+		return 0, errTSBadTimestamp
+	}
+	return ts, nil
+}
+
+func parseTSAggregation(aggregator string, bucketDuration string) (string, int64, error) {
+	aggregator = strings.ToLower(aggregator)
+	if !slices.Contains(tsAggregators, aggregator) {
+		return "", 0, errTSBadAggregation
+	}
+	bucket, ok := parseInt64(bucketDuration)
+	if !ok || bucket <= 0 {
+		return "", 0, errTSBadBucketDuration
+	}
+	return aggregator, bucket, nil
+}
+
+func evalTSCreate(args []string) []byte {
+	if len(args) < 1 {
+		return Encode(errWrongArgCount("ts.create"), false)
+	}
+
+	opts, err := parseTSOptions(args[1:], false)
+	if err != nil {
+		return Encode(err, false)
+	}
+	obj, err := getOfType(args[0], OBJ_TYPE_TIMESERIES)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj != nil {
+		return Encode(errTSExists, false)
+	}
+	s := newTimeSeries(args[0], opts.retention, opts.duplicatePolicy, opts.labels)
+	Put(args[0], NewObj(s, -1, OBJ_TYPE_TIMESERIES, OBJ_ENCODING_TIMESERIES))
+	return Encode("OK", true)
+}
+
+// evalTSAdd implements TS.ADD key timestamp value [RETENTION period] [DUPLICATE_POLICY policy]
+// [ON_DUPLICATE policy] [LABELS label value ...], the options other than ON_DUPLICATE only apply to the
+// series created when the key does not exist
+func evalTSAdd(args []string) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount("ts.add"), false)
+	}
+
+	ts, err := parseTSTimestamp(args[1])
+	if err != nil {
+		return Encode(err, false)
+	}
+	value, ok := parseFloat(args[2])
+	if !ok || math.IsNaN(value) {
+		return Encode(errTSBadValue, false)
+	}
+	opts, err := parseTSOptions(args[3:], true)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	s, err := lookupTimeSeries(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if s == nil {
+		s = newTimeSeries(args[0], opts.retention, opts.duplicatePolicy, opts.labels)
+		Put(args[0], NewObj(s, -1, OBJ_TYPE_TIMESERIES, OBJ_ENCODING_TIMESERIES))
+	}
+	policy := s.duplicatePolicy
+	if opts.onDuplicate != "" {
+		policy = opts.onDuplicate
+	}
+	if err := s.add(ts, value, policy); err != nil {
+		return Encode(err, false)
+	}
+	return Encode(ts, false)
+}
+
+// evalTSMAdd implements TS.MADD key timestamp value [key timestamp value ...], replying the timestamp
+// or the failure of every sample
+func evalTSMAdd(args []string) []byte {
+	if len(args) < 3 || len(args)%3 != 0 {
+		return Encode(errWrongArgCount("ts.madd"), false)
+	}
+
+	out := make([]interface{}, 0, len(args)/3)
+	for i := 0; i < len(args); i += 3 {
+		ts, err := parseTSTimestamp(args[i+1])
+		if err != nil {
+			out = append(out, err)
+			continue
+		}
+		value, ok := parseFloat(args[i+2])
+		if !ok || math.IsNaN(value) {
+			out = append(out, errTSBadValue)
+			continue
+		}
+		s, err := lookupTimeSeries(args[i])
+		if err == nil && s == nil {
+			err = errTSMissing
+		}
+		if err == nil {
+			err = s.add(ts, value, s.duplicatePolicy)
+		}
+		if err != nil {
+			out = append(out, err)
+			continue
+		}
+		out = append(out, ts)
+	}
+	return Encode(out, false)
+}
+
+// existingTimeSeries returns the series at key, failing when there is none
+func existingTimeSeries(key string) (*timeSeries, error) {
+	s, err := lookupTimeSeries(key)
+	if err == nil && s == nil {
+		err = errTSMissing
+	}
+	return s, err
+}
+
+// writeTSSample writes the sample as a pair of its timestamp and its value as a simple string
+func writeTSSample(b *bytes.Buffer, s tsSample) {
+	b.WriteString("*2\r\n")
+	b.Write(Encode(s.ts, false))
+	b.Write(Encode(formatScore(s.value), true))
+}
+
+func writeTSSamples(b *bytes.Buffer, samples []tsSample) {
+	b.WriteString(fmt.Sprintf("*%d\r\n", len(samples)))
+	for _, s := range samples {
+		writeTSSample(b, s)
+	}
+}
+
+func evalTSGet(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("ts.get"), false)
+	}
+
+	s, err := existingTimeSeries(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if len(s.samples) == 0 {
+		return Encode([]interface{}{}, false)
+	}
+	var b bytes.Buffer
+	writeTSSample(&b, s.samples[len(s.samples)-1])
+	return b.Bytes()
+}
+
+// tsRangeOptions are the options of TS.RANGE, TS.MRANGE adds WITHLABELS and FILTER
+type tsRangeOptions struct {
+	from           int64
+	to             int64
+	count          int64
+	aggregator     string
+	bucketDuration int64
+	withLabels     bool
+	filters        []*tsFilter
+}
+
+func parseTSRangeOptions(args []string, multi bool) (*tsRangeOptions, error) {
+	opts := &tsRangeOptions{from: 0, to: math.MaxInt64, count: -1}
+	for i, bound := range []*int64{&opts.from, &opts.to} {
+		if args[i] == "-" || args[i] == "+" {
+			continue
+		}
+		ts, ok := parseInt64(args[i])
+		if !ok || ts < 0 {
+			return nil, errTSBadTimestamp
+		}
+		*bound = ts
+	}
+
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "COUNT" && i+1 < len(args):
+			i++
+			count, ok := parseInt64(args[i])
+			if !ok || count < 0 {
+				return nil, errTSBadCount
+			}
+			opts.count = count
+		case option == "AGGREGATION" && i+2 < len(args):
+			var err error
+			if opts.aggregator, opts.bucketDuration, err = parseTSAggregation(args[i+1], args[i+2]); err != nil {
+				return nil, err
+			}
+			i += 2
+		case option == "WITHLABELS" && multi:
+			opts.withLabels = true
+		case option == "FILTER" && multi:
+			for _, expr := range args[i+1:] {
+				f, err := parseTSFilter(expr)
+				if err != nil {
+					return nil, err
+				}
+				opts.filters = append(opts.filters, f)
+			}
+			if !slices.ContainsFunc(opts.filters, (*tsFilter).positive) {
+				return nil, errTSNoMatcher
+			}
+			return opts, nil
+		default:
+			return nil, errSyntax
+		}
+	}
+	if multi {
+		return nil, errTSMissingFilter
+	}
+	return opts, nil
+}
+
+// samples returns the samples of the series the options select, aggregated and in the requested order
+func (opts *tsRangeOptions) samples(s *timeSeries, reverse bool) []tsSample {
+	samples := s.rangeOf(opts.from, opts.to)
+	if opts.aggregator != "" {
+		samples = aggregateSamples(samples, opts.aggregator, opts.bucketDuration)
+	}
+	if reverse {
+		samples = slices.Clone(samples)
+		slices.Reverse(samples)
+	}
+	if opts.count >= 0 && int64(len(samples)) > opts.count {
+		samples = samples[:opts.count]
+	}
+	return samples
+}
+
+// evalTSRange implements TS.RANGE and TS.REVRANGE key from to [COUNT count] [AGGREGATION aggregator bucketDuration],
+// - and + standing for the oldest and the latest samples
+func evalTSRange(cmd string, args []string) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	opts, err := parseTSRangeOptions(args[1:], false)
+	if err != nil {
+		return Encode(err, false)
+	}
+	s, err := existingTimeSeries(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	var b bytes.Buffer
+	writeTSSamples(&b, opts.samples(s, cmd == "ts.revrange"))
+	return b.Bytes()
+}
+
+// evalTSMRange implements TS.MRANGE and TS.MREVRANGE from to [WITHLABELS] [COUNT count]
+// [AGGREGATION aggregator bucketDuration] FILTER filter..., replying the key, the labels and the samples
+// of every series matching all the filters, by key
+func evalTSMRange(cmd string, args []string) []byte {
+	if len(args) < 4 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	opts, err := parseTSRangeOptions(args, true)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	var matches []*timeSeries
+	for _, obj := range store {
+		if obj.Type() != OBJ_TYPE_TIMESERIES || obj.HasExpired() {
+			continue
+		}
+		s := obj.Value.(*timeSeries)
+		if !slices.ContainsFunc(opts.filters, func(f *tsFilter) bool { return !f.matches(s) }) {
+			matches = append(matches, s)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].key < matches[j].key })
+
+	var b bytes.Buffer
+	b.WriteString(fmt.Sprintf("*%d\r\n", len(matches)))
+	for _, s := range matches {
+		b.WriteString("*3\r\n")
+		b.Write(Encode(s.key, false))
+		labels := []interface{}{}
+		if opts.withLabels {
+			for _, l := range s.labels {
+				labels = append(labels, []string{l.name, l.value})
+			}
+		}
+		b.Write(Encode(labels, false))
+		writeTSSamples(&b, opts.samples(s, cmd == "ts.mrevrange"))
+	}
+	return b.Bytes()
+}
+
+// evalTSCreateRule implements TS.CREATERULE source destination AGGREGATION aggregator bucketDuration. The
+// bucket of the latest sample of the source is aggregated from all its samples, older buckets are not
+// added to the destination
+func evalTSCreateRule(args []string) []byte {
+	if len(args) != 5 {
+		return Encode(errWrongArgCount("ts.createrule"), false)
+	}
+	if strings.ToUpper(args[2]) != "AGGREGATION" {
+		return Encode(errSyntax, false)
+	}
+	aggregator, bucketDuration, err := parseTSAggregation(args[3], args[4])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	src, err := existingTimeSeries(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	dst, err := existingTimeSeries(args[1])
+	if err != nil {
+		return Encode(err, false)
+	}
+	if src == dst {
+		return Encode(errTSSameKey, false)
+	}
+	if dst.sourceKey != "" {
+		if source, _ := lookupTimeSeries(dst.sourceKey); source != nil && source.ruleTo(dst.key) >= 0 {
+			return Encode(errTSDestinationHasSource, false)
+		}
+	}
+	// chains of rules may not loop back to their origin
+	if len(dst.rules) > 0 {
+		return Encode(errTSDestinationHasRules, false)
+	}
+
+	r := &tsRule{destKey: dst.key, aggregator: aggregator, bucketDuration: bucketDuration}
+	if n := len(src.samples); n > 0 {
+		r.open, r.bucketStart = true, bucketStart(src.samples[n-1].ts, bucketDuration)
+		r.agg = src.aggregate(r, r.bucketStart)
+	}
+	src.rules = append(src.rules, r)
+	dst.sourceKey = src.key
+	return Encode("OK", true)
+}
+
+// ruleTo returns the index of the rule compacting the series into the destination, -1 when there is none
+func (s *timeSeries) ruleTo(destKey string) int {
+	return slices.IndexFunc(s.rules, func(r *tsRule) bool { return strings.EqualFold(r.destKey, destKey) })
+}
+
+func evalTSDeleteRule(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("ts.deleterule"), false)
+	}
+
+	src, err := existingTimeSeries(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	i := src.ruleTo(args[1])
+	if i < 0 {
+		return Encode(errTSRuleMissing, false)
+	}
+	src.rules = slices.Delete(src.rules, i, i+1)
+	if dst, _ := lookupTimeSeries(args[1]); dst != nil {
+		dst.sourceKey = ""
+	}
+	return Encode("OK", true)
+}
+
+func evalTSInfo(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("ts.info"), false)
+	}
+
+	s, err := existingTimeSeries(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	var first, last int64
+	if n := len(s.samples); n > 0 {
+		first, last = s.samples[0].ts, s.samples[n-1].ts
+	}
+	labels := []interface{}{}
+	for _, l := range s.labels {
+		labels = append(labels, []string{l.name, l.value})
+	}
+	rules := []interface{}{}
+	for _, r := range s.rules {
+		rules = append(rules, []interface{}{r.destKey, r.bucketDuration, strings.ToUpper(r.aggregator)})
+	}
+	var sourceKey interface{}
+	if s.sourceKey != "" {
+		sourceKey = s.sourceKey
+	}
+	return Encode([]interface{}{
+		"totalSamples", len(s.samples),
+		"firstTimestamp", first,
+		"lastTimestamp", last,
+		"retentionTime", s.retention,
+		"duplicatePolicy", s.duplicatePolicy,
+		"labels", labels,
+		"sourceKey", sourceKey,
+		"rules", rules,
+	}, false)
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+)
+
+func lookupTopK(key string) (*topK, error) {
+	obj, err := getOfType(key, OBJ_TYPE_TOPK)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	return obj.Value.(*topK), nil
+}
+
+// existingTopK returns the Top-K at key, failing when there is none
+func existingTopK(key string) (*topK, error) {
+	t, err := lookupTopK(key)
+	if err == nil && t == nil {
+		err = errTopKMissing
+	}
+	return t, err
+}
+
+// evalTopKReserve implements TOPK.RESERVE key topk [width depth decay]
+func evalTopKReserve(args []string) []byte {
+	if len(args) != 2 && len(args) != 5 {
+		return Encode(errWrongArgCount("topk.reserve"), false)
+	}
+
+	k, ok := parseInt64(args[1])
+	if !ok || k < 1 || k > FILTER_MAX_BYTES {
+		return Encode(errTopKBadK, false)
+	}
+	width, depth, decay := int64(TOPK_DEFAULT_WIDTH), int64(TOPK_DEFAULT_DEPTH), TOPK_DEFAULT_DECAY
+	if len(args) == 5 {
+		var ok1, ok2 bool
+		width, ok1 = parseInt64(args[2])
+		depth, ok2 = parseInt64(args[3])
+		if !ok1 || !ok2 || width < 1 || depth < 1 {
+			return Encode(errTopKBadDimensions, false)
+		}
+		if decay, ok = parseFloat(args[4]); !ok || !(decay > 0 && decay <= 1) {
+			return Encode(errTopKBadDecay, false)
+		}
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_TOPK)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj != nil {
+		return Encode(errTopKExists, false)
+	}
+	t, err := newTopK(int(k), uint64(width), uint64(depth), decay)
+	if err != nil {
+		return Encode(err, false)
+	}
+	Put(args[0], NewObj(t, -1, OBJ_TYPE_TOPK, OBJ_ENCODING_TOPK))
+	return Encode("OK", true)
+}
+
+// topKIncr counts the items and replies the items each of them expelled from the Top-K
+func topKIncr(t *topK, items []string, incrs []uint32) []byte {
+	out := make([]interface{}, len(items))
+	for i, item := range items {
+		if expelled, ok := t.incrBy(item, incrs[i]); ok {
+			out[i] = expelled
+		}
+	}
+	return Encode(out, false)
+}
+
+func evalTopKAdd(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("topk.add"), false)
+	}
+
+	t, err := existingTopK(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	incrs := make([]uint32, len(args)-1)
+	for i := range incrs {
+		incrs[i] = 1
+	}
+	return topKIncr(t, args[1:], incrs)
+}
+
+// evalTopKIncrBy implements TOPK.INCRBY key item increment [item increment ...]
+func evalTopKIncrBy(args []string) []byte {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return Encode(errWrongArgCount("topk.incrby"), false)
+	}
+
+	t, err := existingTopK(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	items := make([]string, 0, len(args)/2)
+	incrs := make([]uint32, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		incr, ok := parseInt64(args[i+1])
+		if !ok || incr < 1 || incr > 100000 {
+			return Encode(errTopKBadIncrement, false)
+		}
+		items = append(items, args[i])
+		incrs = append(incrs, uint32(incr))
+	}
+	return topKIncr(t, items, incrs)
+}
+
+func evalTopKQuery(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("topk.query"), false)
+	}
+
+	t, err := existingTopK(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	out := make([]interface{}, len(args)-1)
+	for i, item := range args[1:] {
+		out[i] = 0
+		if t.heapIndex(item) >= 0 {
+			out[i] = 1
+		}
+	}
+	return Encode(out, false)
+}
+
+// evalTopKList implements TOPK.LIST key [WITHCOUNT], listing the items by decreasing count
+func evalTopKList(args []string) []byte {
+	if len(args) != 1 && len(args) != 2 {
+		return Encode(errWrongArgCount("topk.list"), false)
+	}
+	withCount := len(args) == 2
+	if withCount && strings.ToUpper(args[1]) != "WITHCOUNT" {
+		return Encode(errSyntax, false)
+	}
+
+	t, err := existingTopK(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	out := make([]interface{}, 0, len(t.heap)*2)
+	for _, e := range t.list() {
+		out = append(out, e.item)
+		if withCount {
+			out = append(out, int64(e.count))
+		}
+	}
+	return Encode(out, false)
+}
+
+func evalTopKInfo(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("topk.info"), false)
+	}
+
+	t, err := existingTopK(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	return Encode([]interface{}{
+		"k", t.k,
+		"width", int64(t.width),
+		"depth", int64(t.depth),
+		"decay", strconv.FormatFloat(t.decay, 'f', -1, 64),
+	}, false)
+}
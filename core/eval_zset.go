@@ -0,0 +1,1002 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var errMinMaxNotFloat = errors.New("ERR min or max is not a float")
+var errMinMaxNotLex = errors.New("ERR min or max not valid string range item")
+
+// deleteIfEmptyZset removes the key once its sorted set has no members left
+func deleteIfEmptyZset(key string, obj *Obj) {
+	if zsetOf(obj).Len() == 0 {
+		Delete(key)
+	}
+}
+
+// parseScoreBound parses a score interval bound, an exclusive bound is prefixed by "("
+func parseScoreBound(s string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false, false
+	}
+	return f, exclusive, true
+}
+
+func parseScoreRange(min string, max string) (*zscoreRange, error) {
+	r := &zscoreRange{}
+	var ok bool
+	if r.min, r.minEx, ok = parseScoreBound(min); !ok {
+		return nil, errMinMaxNotFloat
+	}
+	if r.max, r.maxEx, ok = parseScoreBound(max); !ok {
+		return nil, errMinMaxNotFloat
+	}
+	return r, nil
+}
+
+// parseLexBound parses a lexicographical interval bound, "[" and "(" prefix inclusive and exclusive
+// members while "-" and "+" are the infinite bounds
+func parseLexBound(s string) (string, int, bool, bool) {
+	switch {
+	case s == "-":
+		return "", -1, false, true
+	case s == "+":
+		return "", 1, false, true
+	case strings.HasPrefix(s, "("):
+		return s[1:], 0, true, true
+	case strings.HasPrefix(s, "["):
+		return s[1:], 0, false, true
+	default:
+		return "", 0, false, false
+	}
+}
+
+func parseLexRange(min string, max string) (*zlexRange, error) {
+	r := &zlexRange{}
+	var ok bool
+	if r.min, r.minInf, r.minEx, ok = parseLexBound(min); !ok {
+		return nil, errMinMaxNotLex
+	}
+	if r.max, r.maxInf, r.maxEx, ok = parseLexBound(max); !ok {
+		return nil, errMinMaxNotLex
+	}
+	return r, nil
+}
+
+func evalZAdd(args []string) []byte {
+	return zaddGeneric("zadd", args)
+}
+
+func evalZIncrBy(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("zincrby"), false)
+	}
+	return zaddGeneric("zincrby", []string{args[0], "INCR", args[1], args[2]})
+}
+
+// zaddGeneric implements ZADD key [NX | XX] [GT | LT] [CH] [INCR] score member [score member ...]
+func zaddGeneric(cmd string, args []string) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	var nx, xx, gt, lt, ch, incr bool
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break flags
+		}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return Encode(errSyntax, false)
+	}
+	if nx && xx {
+		return Encode(errors.New("ERR XX and NX options at the same time are not compatible"), false)
+	}
+	if (gt && nx) || (lt && nx) || (gt && lt) {
+		return Encode(errors.New("ERR GT, LT, and/or NX options at the same time are not compatible"), false)
+	}
+	if incr && len(pairs) > 2 {
+		return Encode(errors.New("ERR INCR option supports a single increment-element pair"), false)
+	}
+
+	scores := make([]float64, len(pairs)/2)
+	maxLen := 0
+	for j := range scores {
+		score, ok := parseFloat(pairs[2*j])
+		if !ok {
+			return Encode(errNotFloat, false)
+		}
+		scores[j] = score
+		maxLen = max(maxLen, len(pairs[2*j+1]))
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_ZSET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		if xx {
+			if incr {
+				return Encode(nil, false)
+			}
+			return Encode(0, false)
+		}
+		obj = newZsetObj(len(scores), maxLen)
+		Put(args[0], obj)
+	}
+
+	added, changed := 0, 0
+	var result *float64
+	for j, score := range scores {
+		member := pairs[2*j+1]
+		// zsetAdd may have converted the listpack to a skiplist, so the value is fetched again for every pair
+		current, exists := zsetOf(obj).Score(member)
+		if !exists {
+			if xx {
+				continue
+			}
+			zsetAdd(obj, member, score)
+			added++
+			result = &score
+			continue
+		}
+
+		if nx {
+			continue
+		}
+		if incr {
+			score += current
+			if math.IsNaN(score) {
+				return Encode(errors.New("ERR resulting score is not a number (NaN)"), false)
+			}
+		}
+		if (gt && score <= current) || (lt && score >= current) {
+			continue
+		}
+		if score != current {
+			zsetAdd(obj, member, score)
+			changed++
+		}
+		result = &score
+	}
+
+	if incr {
+		if result == nil {
+			return Encode(nil, false)
+		}
+		return Encode(formatScore(*result), false)
+	}
+	if ch {
+		return Encode(added+changed, false)
+	}
+	return Encode(added, false)
+}
+
+func evalZRem(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("zrem"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_ZSET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+
+	removed := 0
+	for _, member := range args[1:] {
+		if zsetOf(obj).Remove(member) {
+			removed++
+		}
+	}
+	deleteIfEmptyZset(args[0], obj)
+	return Encode(removed, false)
+}
+
+func evalZScore(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("zscore"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_ZSET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(nil, false)
+	}
+	score, ok := zsetOf(obj).Score(args[1])
+	if !ok {
+		return Encode(nil, false)
+	}
+	return Encode(formatScore(score), false)
+}
+
+func evalZMScore(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("zmscore"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_ZSET)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	scores := make([]interface{}, len(args)-1)
+	for i, member := range args[1:] {
+		if obj == nil {
+			continue
+		}
+		if score, ok := zsetOf(obj).Score(member); ok {
+			scores[i] = formatScore(score)
+		}
+	}
+	return Encode(scores, false)
+}
+
+func evalZCard(args []string) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("zcard"), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_ZSET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+	return Encode(zsetOf(obj).Len(), false)
+}
+
+func evalZCount(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("zcount"), false)
+	}
+
+	r, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return Encode(err, false)
+	}
+	obj, err := getOfType(args[0], OBJ_TYPE_ZSET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+	first, last := r.ranks(zsetOf(obj))
+	return Encode(max(last-first+1, 0), false)
+}
+
+func evalZLexCount(args []string) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount("zlexcount"), false)
+	}
+
+	r, err := parseLexRange(args[1], args[2])
+	if err != nil {
+		return Encode(err, false)
+	}
+	obj, err := getOfType(args[0], OBJ_TYPE_ZSET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode(0, false)
+	}
+	first, last := r.ranks(zsetOf(obj))
+	return Encode(max(last-first+1, 0), false)
+}
+
+func evalZRank(args []string) []byte {
+	return zrankGeneric("zrank", args, false)
+}
+
+func evalZRevRank(args []string) []byte {
+	return zrankGeneric("zrevrank", args, true)
+}
+
+func zrankGeneric(cmd string, args []string, reverse bool) []byte {
+	if len(args) < 2 || len(args) > 3 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+	withScore := false
+	if len(args) == 3 {
+		if strings.ToUpper(args[2]) != "WITHSCORE" {
+			return Encode(errSyntax, false)
+		}
+		withScore = true
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_ZSET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	rank := -1
+	if obj != nil {
+		rank = zsetOf(obj).Rank(args[1])
+	}
+	if rank < 0 {
+		if withScore {
+			return RESP_NIL_ARRAY
+		}
+		return Encode(nil, false)
+	}
+
+	z := zsetOf(obj)
+	if reverse {
+		rank = z.Len() - 1 - rank
+	}
+	if withScore {
+		score, _ := z.Score(args[1])
+		return Encode([]interface{}{rank, formatScore(score)}, false)
+	}
+	return Encode(rank, false)
+}
+
+const (
+	ZRANGE_RANK = iota
+	ZRANGE_SCORE
+	ZRANGE_LEX
+)
+
+// zrangeSpec holds how the elements of a range command are selected and returned
+type zrangeSpec struct {
+	by         int
+	rev        bool
+	limit      bool
+	offset     int64
+	count      int64
+	withScores bool
+}
+
+// parseZrangeOptions parses the options following the range bounds, the BYSCORE, BYLEX and REV options
+// are only accepted by ZRANGE and ZRANGESTORE while the legacy range commands have them set upfront
+func parseZrangeOptions(args []string, spec *zrangeSpec, allowBy bool, allowWithScores bool) error {
+	for i := 0; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case allowBy && option == "BYSCORE":
+			spec.by = ZRANGE_SCORE
+		case allowBy && option == "BYLEX":
+			spec.by = ZRANGE_LEX
+		case allowBy && option == "REV":
+			spec.rev = true
+		case allowWithScores && option == "WITHSCORES":
+			spec.withScores = true
+		case option == "LIMIT" && i+2 < len(args):
+			offset, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return errNotInteger
+			}
+			count, err := strconv.ParseInt(args[i+2], 10, 64)
+			if err != nil {
+				return errNotInteger
+			}
+			spec.limit, spec.offset, spec.count = true, offset, count
+			i += 2
+		default:
+			return errSyntax
+		}
+	}
+
+	if spec.limit && spec.by == ZRANGE_RANK {
+		return errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.by == ZRANGE_LEX {
+		return errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return nil
+}
+
+// zrangeEntries returns the selected elements in the order they are replied, with REV the
+// start bound is the highest one
+func zrangeEntries(z zsetValue, start string, stop string, spec *zrangeSpec) ([]zsetEntry, error) {
+	var first, last int
+	switch spec.by {
+	case ZRANGE_RANK:
+		s, err := strconv.ParseInt(start, 10, 64)
+		if err != nil {
+			return nil, errNotInteger
+		}
+		e, err := strconv.ParseInt(stop, 10, 64)
+		if err != nil {
+			return nil, errNotInteger
+		}
+		n := int64(z.Len())
+		if s < 0 {
+			s += n
+		}
+		if e < 0 {
+			e += n
+		}
+		s = max(s, 0)
+		e = min(e, n-1)
+		if s > e {
+			return []zsetEntry{}, nil
+		}
+		first, last = int(s), int(e)
+		if spec.rev {
+			first, last = int(n-1-e), int(n-1-s)
+		}
+	case ZRANGE_SCORE, ZRANGE_LEX:
+		lo, hi := start, stop
+		if spec.rev {
+			lo, hi = stop, start
+		}
+		if spec.by == ZRANGE_SCORE {
+			r, err := parseScoreRange(lo, hi)
+			if err != nil {
+				return nil, err
+			}
+			first, last = r.ranks(z)
+		} else {
+			r, err := parseLexRange(lo, hi)
+			if err != nil {
+				return nil, err
+			}
+			first, last = r.ranks(z)
+		}
+
+		// LIMIT skips and counts elements in the order they are replied
+		if spec.limit {
+			total := int64(last - first + 1)
+			if spec.offset < 0 || spec.offset >= total {
+				return []zsetEntry{}, nil
+			}
+			take := total - spec.offset
+			if spec.count >= 0 && spec.count < take {
+				take = spec.count
+			}
+			if spec.rev {
+				last -= int(spec.offset)
+				first = last - int(take) + 1
+			} else {
+				first += int(spec.offset)
+				last = first + int(take) - 1
+			}
+		}
+	}
+
+	if first > last {
+		return []zsetEntry{}, nil
+	}
+	entries := z.Range(first, last)
+	if spec.rev {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	return entries, nil
+}
+
+func encodeZsetEntries(entries []zsetEntry, withScores bool) []byte {
+	out := make([]string, 0, len(entries)*2)
+	for _, e := range entries {
+		out = append(out, e.member)
+		if withScores {
+			out = append(out, formatScore(e.score))
+		}
+	}
+	return Encode(out, false)
+}
+
+func evalZRange(args []string) []byte {
+	return zrangeGeneric("zrange", args, zrangeSpec{}, true)
+}
+
+func evalZRevRange(args []string) []byte {
+	return zrangeGeneric("zrevrange", args, zrangeSpec{rev: true}, false)
+}
+
+func evalZRangeByScore(args []string) []byte {
+	return zrangeGeneric("zrangebyscore", args, zrangeSpec{by: ZRANGE_SCORE}, false)
+}
+
+func evalZRevRangeByScore(args []string) []byte {
+	return zrangeGeneric("zrevrangebyscore", args, zrangeSpec{by: ZRANGE_SCORE, rev: true}, false)
+}
+
+func evalZRangeByLex(args []string) []byte {
+	return zrangeGeneric("zrangebylex", args, zrangeSpec{by: ZRANGE_LEX}, false)
+}
+
+func evalZRevRangeByLex(args []string) []byte {
+	return zrangeGeneric("zrevrangebylex", args, zrangeSpec{by: ZRANGE_LEX, rev: true}, false)
+}
+
+func zrangeGeneric(cmd string, args []string, spec zrangeSpec, allowBy bool) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+	if err := parseZrangeOptions(args[3:], &spec, allowBy, true); err != nil {
+		return Encode(err, false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_ZSET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	z := zsetValue(&zsetListpack{lp: newListpack()})
+	if obj != nil {
+		z = zsetOf(obj)
+	}
+
+	entries, err := zrangeEntries(z, args[1], args[2], &spec)
+	if err != nil {
+		return Encode(err, false)
+	}
+	return encodeZsetEntries(entries, spec.withScores)
+}
+
+// evalZRangeStore stores the elements ZRANGE would return at the destination, an empty range deletes it
+func evalZRangeStore(args []string) []byte {
+	if len(args) < 4 {
+		return Encode(errWrongArgCount("zrangestore"), false)
+	}
+	spec := zrangeSpec{}
+	if err := parseZrangeOptions(args[4:], &spec, true, false); err != nil {
+		return Encode(err, false)
+	}
+
+	obj, err := getOfType(args[1], OBJ_TYPE_ZSET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	z := zsetValue(&zsetListpack{lp: newListpack()})
+	if obj != nil {
+		z = zsetOf(obj)
+	}
+
+	entries, err := zrangeEntries(z, args[2], args[3], &spec)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if len(entries) == 0 {
+		Delete(args[0])
+		return Encode(0, false)
+	}
+	Put(args[0], newZsetObjFrom(entries))
+	return Encode(len(entries), false)
+}
+
+func evalZRemRangeByRank(args []string) []byte {
+	return zremRangeGeneric("zremrangebyrank", args, ZRANGE_RANK)
+}
+
+func evalZRemRangeByScore(args []string) []byte {
+	return zremRangeGeneric("zremrangebyscore", args, ZRANGE_SCORE)
+}
+
+func evalZRemRangeByLex(args []string) []byte {
+	return zremRangeGeneric("zremrangebylex", args, ZRANGE_LEX)
+}
+
+func zremRangeGeneric(cmd string, args []string, by int) []byte {
+	if len(args) != 3 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_ZSET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	z := zsetValue(&zsetListpack{lp: newListpack()})
+	if obj != nil {
+		z = zsetOf(obj)
+	}
+
+	entries, err := zrangeEntries(z, args[1], args[2], &zrangeSpec{by: by})
+	if err != nil {
+		return Encode(err, false)
+	}
+	for _, e := range entries {
+		z.Remove(e.member)
+	}
+	if obj != nil {
+		deleteIfEmptyZset(args[0], obj)
+	}
+	return Encode(len(entries), false)
+}
+
+func evalZPopMin(args []string) []byte {
+	return zpopGeneric("zpopmin", args, false)
+}
+
+func evalZPopMax(args []string) []byte {
+	return zpopGeneric("zpopmax", args, true)
+}
+
+func zpopGeneric(cmd string, args []string, fromMax bool) []byte {
+	if len(args) < 1 || len(args) > 2 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	count := int64(1)
+	if len(args) == 2 {
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || n < 0 {
+			return Encode(errors.New("ERR value is out of range, must be positive"), false)
+		}
+		count = n
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_ZSET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return Encode([]string{}, false)
+	}
+	return encodeZsetEntries(zsetPop(args[0], obj, fromMax, count), true)
+}
+
+// zsetPop pops up to count elements with the lowest or the highest scores, deleting the key once empty
+func zsetPop(key string, obj *Obj, fromMax bool, count int64) []zsetEntry {
+	z := zsetOf(obj)
+	popped := make([]zsetEntry, 0, min(count, int64(z.Len())))
+	for ; count > 0 && z.Len() > 0; count-- {
+		rank := 0
+		if fromMax {
+			rank = z.Len() - 1
+		}
+		e := z.At(rank)
+		z.Remove(e.member)
+		popped = append(popped, e)
+	}
+	deleteIfEmptyZset(key, obj)
+	return popped
+}
+
+const (
+	ZAGGREGATE_SUM = iota
+	ZAGGREGATE_MIN
+	ZAGGREGATE_MAX
+)
+
+func zaggregate(aggregate int, a float64, b float64) float64 {
+	switch aggregate {
+	case ZAGGREGATE_MIN:
+		return math.Min(a, b)
+	case ZAGGREGATE_MAX:
+		return math.Max(a, b)
+	default:
+		// inf + -inf is NaN, redis turns it into 0
+		if sum := a + b; !math.IsNaN(sum) {
+			return sum
+		}
+		return 0
+	}
+}
+
+// zsetInputEntries returns the elements of a sorted set or of a set, whose members all score 1,
+// a missing key has no elements
+func zsetInputEntries(key string) ([]zsetEntry, error) {
+	obj := Get(key)
+	if obj == nil {
+		return nil, nil
+	}
+	switch obj.Type() {
+	case OBJ_TYPE_ZSET:
+		z := zsetOf(obj)
+		return z.Range(0, z.Len()-1), nil
+	case OBJ_TYPE_SET:
+		members := setMembers(obj)
+		entries := make([]zsetEntry, len(members))
+		for i, m := range members {
+			entries[i] = zsetEntry{m, 1}
+		}
+		return entries, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+func evalZUnionStore(args []string) []byte {
+	return zunionInterStoreGeneric("zunionstore", args, false)
+}
+
+func evalZInterStore(args []string) []byte {
+	return zunionInterStoreGeneric("zinterstore", args, true)
+}
+
+// zunionInterStoreGeneric implements destination numkeys key [key ...] [WEIGHTS weight [weight ...]]
+// [AGGREGATE SUM | MIN | MAX]
+func zunionInterStoreGeneric(cmd string, args []string, inter bool) []byte {
+	if len(args) < 3 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	numKeys, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return Encode(errNotInteger, false)
+	}
+	if numKeys < 1 {
+		return Encode(errors.New("ERR at least 1 input key is needed for '"+cmd+"' command"), false)
+	}
+	if numKeys > int64(len(args)-2) {
+		return Encode(errSyntax, false)
+	}
+	keys := args[2 : 2+numKeys]
+
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := ZAGGREGATE_SUM
+	rest := args[2+numKeys:]
+	for i := 0; i < len(rest); i++ {
+		switch strings.ToUpper(rest[i]) {
+		case "WEIGHTS":
+			if int64(len(rest)-i-1) < numKeys {
+				return Encode(errSyntax, false)
+			}
+			for j := range weights {
+				w, ok := parseFloat(rest[i+1+j])
+				if !ok {
+					return Encode(errors.New("ERR weight value is not a float"), false)
+				}
+				weights[j] = w
+			}
+			i += int(numKeys)
+		case "AGGREGATE":
+			if i+1 >= len(rest) {
+				return Encode(errSyntax, false)
+			}
+			switch strings.ToUpper(rest[i+1]) {
+			case "SUM":
+				aggregate = ZAGGREGATE_SUM
+			case "MIN":
+				aggregate = ZAGGREGATE_MIN
+			case "MAX":
+				aggregate = ZAGGREGATE_MAX
+			default:
+				return Encode(errSyntax, false)
+			}
+			i++
+		default:
+			return Encode(errSyntax, false)
+		}
+	}
+
+	inputs := make([][]zsetEntry, numKeys)
+	for i, key := range keys {
+		if inputs[i], err = zsetInputEntries(key); err != nil {
+			return Encode(err, false)
+		}
+	}
+
+	scores := make(map[string]float64)
+	seen := make(map[string]int)
+	var order []string
+	for i, input := range inputs {
+		for _, e := range input {
+			score := e.score * weights[i]
+			// 0 * inf is NaN, redis turns it into 0
+			if math.IsNaN(score) {
+				score = 0
+			}
+			if current, ok := scores[e.member]; ok {
+				scores[e.member] = zaggregate(aggregate, current, score)
+			} else {
+				scores[e.member] = score
+				order = append(order, e.member)
+			}
+			seen[e.member]++
+		}
+	}
+
+	entries := make([]zsetEntry, 0, len(order))
+	for _, member := range order {
+		if inter && seen[member] != len(inputs) {
+			continue
+		}
+		entries = append(entries, zsetEntry{member, scores[member]})
+	}
+
+	if len(entries) == 0 {
+		Delete(args[0])
+		return Encode(0, false)
+	}
+	Put(args[0], newZsetObjFrom(entries))
+	return Encode(len(entries), false)
+}
+
+func evalZScan(args []string) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount("zscan"), false)
+	}
+
+	opts, err := parseScanArgs(args[1:], false)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	obj, err := getOfType(args[0], OBJ_TYPE_ZSET)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if obj == nil {
+		return encodeScanReply(0, nil)
+	}
+
+	z := zsetOf(obj)
+	// compact encodings are returned whole in a single call, like redis does
+	var cursor uint64
+	var members []string
+	if obj.Encoding() == OBJ_ENCODING_LISTPACK {
+		for _, e := range z.Range(0, z.Len()-1) {
+			members = append(members, e.member)
+		}
+	} else {
+		cursor, members = scanByHash(args[0], obj, opts.cursor, opts.count)
+	}
+
+	var out []string
+	for _, m := range members {
+		if !opts.matches(m) {
+			continue
+		}
+		score, _ := z.Score(m)
+		out = append(out, m, formatScore(score))
+	}
+	return encodeScanReply(cursor, out)
+}
+
+const (
+	ZSET_MIN = 0
+	ZSET_MAX = 1
+)
+
+func parseZsetWhere(s string) (int, bool) {
+	switch strings.ToUpper(s) {
+	case "MIN":
+		return ZSET_MIN, true
+	case "MAX":
+		return ZSET_MAX, true
+	default:
+		return 0, false
+	}
+}
+
+// zsetPopReply pops from the sorted set and builds the reply of the blocking pops, a flat key, member, score
+// array for BZPOPMIN and BZPOPMAX or the key with the member and score pairs for ZMPOP and BZMPOP
+func zsetPopReply(key string, obj *Obj, where int, count int64, multi bool) []interface{} {
+	popped := zsetPop(key, obj, where == ZSET_MAX, count)
+	if !multi {
+		return []interface{}{key, popped[0].member, formatScore(popped[0].score)}
+	}
+	pairs := make([]interface{}, len(popped))
+	for i, e := range popped {
+		pairs[i] = []string{e.member, formatScore(e.score)}
+	}
+	return []interface{}{key, pairs}
+}
+
+// zsetPopFromKeys pops from the first non empty sorted set among the keys, it returns nil when all are empty
+func zsetPopFromKeys(keys []string, where int, count int64, multi bool) ([]interface{}, error) {
+	for _, key := range keys {
+		obj, err := getOfType(key, OBJ_TYPE_ZSET)
+		if err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			continue
+		}
+		return zsetPopReply(key, obj, where, count, multi), nil
+	}
+	return nil, nil
+}
+
+// serveBlockedZsetPop pops from the key that became ready, the client keeps waiting while the key
+// does not hold a sorted set
+func serveBlockedZsetPop(where int, count int64, multi bool) func(key string) []byte {
+	return func(key string) []byte {
+		obj, err := getOfType(key, OBJ_TYPE_ZSET)
+		if err != nil || obj == nil {
+			return nil
+		}
+		return Encode(zsetPopReply(key, obj, where, count, multi), false)
+	}
+}
+
+func evalZMPop(args []string) []byte {
+	keys, where, count, err := parseMPopArgs("zmpop", args, parseZsetWhere)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	reply, err := zsetPopFromKeys(keys, where, count, true)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if reply == nil {
+		return RESP_NIL_ARRAY
+	}
+	return Encode(reply, false)
+}
+
+func evalBZPopMin(args []string, c io.ReadWriter) []byte {
+	return blockingZPopGeneric("bzpopmin", args, c, ZSET_MIN)
+}
+
+func evalBZPopMax(args []string, c io.ReadWriter) []byte {
+	return blockingZPopGeneric("bzpopmax", args, c, ZSET_MAX)
+}
+
+func blockingZPopGeneric(cmd string, args []string, c io.ReadWriter, where int) []byte {
+	if len(args) < 2 {
+		return Encode(errWrongArgCount(cmd), false)
+	}
+
+	keys := args[:len(args)-1]
+	timeout, err := parseBlockingTimeout(args[len(args)-1])
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	reply, err := zsetPopFromKeys(keys, where, 1, false)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if reply != nil {
+		return Encode(reply, false)
+	}
+
+	blockClient(c, keys, timeout, serveBlockedZsetPop(where, 1, false), RESP_NIL_ARRAY)
+	return nil
+}
+
+func evalBZMPop(args []string, c io.ReadWriter) []byte {
+	if len(args) < 1 {
+		return Encode(errWrongArgCount("bzmpop"), false)
+	}
+
+	timeout, err := parseBlockingTimeout(args[0])
+	if err != nil {
+		return Encode(err, false)
+	}
+	keys, where, count, err := parseMPopArgs("bzmpop", args[1:], parseZsetWhere)
+	if err != nil {
+		return Encode(err, false)
+	}
+
+	reply, err := zsetPopFromKeys(keys, where, count, true)
+	if err != nil {
+		return Encode(err, false)
+	}
+	if reply != nil {
+		return Encode(reply, false)
+	}
+
+	blockClient(c, keys, timeout, serveBlockedZsetPop(where, count, true), RESP_NIL_ARRAY)
+	return nil
+}
@@ -1,83 +1,257 @@
 package core
 
 import (
+	"errors"
+	"math"
+	"time"
+
 	"github.com/diceclone/config"
 )
 
+var errOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+// commands that may grow the dataset are refused with an OOM error when
+// the keyspace is full and the eviction policy cannot make room
+var denyOOMCommands = map[string]bool{
+	"SET":         true,
+	"INCR":        true,
+	"INCRBY":      true,
+	"DECR":        true,
+	"DECRBY":      true,
+	"INCRBYFLOAT": true,
+	"APPEND":      true,
+	"SETRANGE":    true,
+	"MSET":        true,
+	"MSETNX":      true,
+	"GETSET":      true,
+	"LPUSH":       true,
+	"RPUSH":       true,
+	"LPUSHX":      true,
+	"RPUSHX":      true,
+	"LINSERT":     true,
+	"LSET":        true,
+	"LMOVE":       true,
+	"BLMOVE":      true,
+
+	"HSET":         true,
+	"HMSET":        true,
+	"HSETNX":       true,
+	"HINCRBY":      true,
+	"HINCRBYFLOAT": true,
+
+	"SADD":        true,
+	"SMOVE":       true,
+	"SINTERSTORE": true,
+	"SUNIONSTORE": true,
+	"SDIFFSTORE":  true,
+
+	"ZADD":        true,
+	"ZINCRBY":     true,
+	"ZRANGESTORE": true,
+	"ZUNIONSTORE": true,
+	"ZINTERSTORE": true,
+
+	"XADD":       true,
+	"XGROUP":     true,
+	"XREADGROUP": true,
+	"XSETID":     true,
+
+	"PFADD":   true,
+	"PFMERGE": true,
+
+	"SETBIT":   true,
+	"BITOP":    true,
+	"BITFIELD": true,
+
+	"GEOADD":         true,
+	"GEOSEARCHSTORE": true,
+
+	"JSON.SET":       true,
+	"JSON.NUMINCRBY": true,
+	"JSON.STRAPPEND": true,
+	"JSON.ARRAPPEND": true,
+	"JSON.ARRINSERT": true,
+
+	"BF.RESERVE":   true,
+	"BF.ADD":       true,
+	"BF.MADD":      true,
+	"BF.LOADCHUNK": true,
+	"CF.RESERVE":   true,
+	"CF.ADD":       true,
+	"CF.ADDNX":     true,
+	"CF.LOADCHUNK": true,
+
+	"CMS.INITBYDIM":  true,
+	"CMS.INITBYPROB": true,
+	"TOPK.RESERVE":   true,
+
+	"TS.CREATE": true,
+	"TS.ADD":    true,
+	"TS.MADD":   true,
+}
+
+var evictionPolicies = []string{
+	"noeviction",
+	"allkeys-lru",
+	"allkeys-lfu",
+	"allkeys-random",
+	"volatile-lru",
+	"volatile-lfu",
+	"volatile-random",
+	"volatile-ttl",
+}
+
 type EvictionStrategy interface {
-	evict(store map[string]*Obj)
+	// evict removes up to count keys from the store and returns the number of keys removed
+	evict(store map[string]*Obj, count int) int
 }
 
-type EvictFirst struct{}
+type NoEviction struct{}
 
-func (e *EvictFirst) evict(store map[string]*Obj) {
-	for k := range store {
-		delete(store, k)
-		break
-	}
+func (e *NoEviction) evict(store map[string]*Obj, count int) int {
+	return 0
 }
 
-type EvictRandom struct{}
+type EvictRandom struct {
+	volatileOnly bool
+}
 
-func (e *EvictRandom) evict(store map[string]*Obj) {
-	evictionSize := evictionSize()
-	for k := range store {
-		delete(store, k)
-		evictionSize--
-		if evictionSize == 0 {
+func (e *EvictRandom) evict(store map[string]*Obj, count int) int {
+	// go randomizes the map iteration order, which is good enough for picking random victims
+	var victims []string
+	for k, v := range store {
+		if len(victims) >= count {
 			break
 		}
+		if e.volatileOnly && !v.TtlSet() {
+			continue
+		}
+		victims = append(victims, k)
 	}
-	computeKeyspaceSize()
-}
-
-type EvictLru struct{}
 
-func (e *EvictLru) evict(store map[string]*Obj) {
-
-	// TODO - the keys with highest idle time must expire
-	// first compute the current clock
-	// over a sample size, for each key check if it's idle time is worse than the idle time of the
-	// last item in the eviction pool array, if it so, kick it out
+	for _, k := range victims {
+		evictKey(k)
+	}
+	return len(victims)
+}
 
-	logger.Println("Eviction strategy: LRU")
+// EvictSampled approximates LRU, LFU and TTL based eviction the way redis does: keys are sampled,
+// the best candidates are kept in the eviction pool and the key with the highest score is evicted
+type EvictSampled struct {
+	volatileOnly bool
+	score        func(obj *Obj) uint64
+}
 
-	for evictionSize() > 0 {
-		keysDeleted := 0
-		for k := range store {
-			if keysDeleted >= config.SAMPLE_SIZE {
+func (e *EvictSampled) evict(store map[string]*Obj, count int) int {
+	evicted := 0
+	for evicted < count {
+		sampled := 0
+		for k, v := range store {
+			if sampled >= config.SAMPLE_SIZE {
 				break
 			}
-			if canBeEvicted(store[k]) {
-				Delete(k)
+			if e.volatileOnly && !v.TtlSet() {
+				continue
 			}
-			keysDeleted++
+			populateEvictionPool(k, e.score(v))
+			sampled++
+		}
+
+		if sampled == 0 {
+			break
+		}
+
+		key, ok := bestEvictionCandidate()
+		if !ok {
+			break
 		}
+		evictKey(key)
+		evicted++
 	}
+	return evicted
+}
+
+func lruScore(obj *Obj) uint64 {
+	return uint64(idleTimeOf(obj.LastAccessedAt))
+}
+
+func lfuScore(obj *Obj) uint64 {
+	return uint64(255 - lfuDecrAndReturn(obj))
+}
+
+func ttlScore(obj *Obj) uint64 {
+	// the sooner the key expires, the better candidate it is
+	return math.MaxUint64 - uint64(obj.ValidTill)
 }
 
 func getEvictionStrategy() EvictionStrategy {
 	switch config.EVICTION_STRATEGY {
-	case "EVICT_LRU":
-		return &EvictLru{}
-	case "LFU":
-		return &EvictFirst{}
-	case "EVICT_RANDOM":
+	case "allkeys-lru":
+		return &EvictSampled{score: lruScore}
+	case "allkeys-lfu":
+		return &EvictSampled{score: lfuScore}
+	case "allkeys-random":
 		return &EvictRandom{}
+	case "volatile-lru":
+		return &EvictSampled{volatileOnly: true, score: lruScore}
+	case "volatile-lfu":
+		return &EvictSampled{volatileOnly: true, score: lfuScore}
+	case "volatile-random":
+		return &EvictRandom{volatileOnly: true}
+	case "volatile-ttl":
+		return &EvictSampled{volatileOnly: true, score: ttlScore}
 	default:
-		return &EvictFirst{}
+		return &NoEviction{}
 	}
 }
 
+func isValidEvictionPolicy(policy string) bool {
+	for _, p := range evictionPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
 func Evict() {
 	// when the key size reaches KeysLimit, evict 40% of the keys
 	// it is inefficient to calculate the store everytime Evict() is called
 	// hence the store size must be pre-computed
+	strategy := getEvictionStrategy()
+	if _, ok := strategy.(*NoEviction); ok {
+		// nothing is ever evicted, the writes are refused by performEvictions instead
+		return
+	}
 	var evictionSize = evictionSize()
-	logger.Printf("Eviction triggered: %d keys to be evicted\n", evictionSize)
 	if evictionSize != 0 {
-		strategy := getEvictionStrategy()
-		strategy.evict(store)
+		logger.Printf("Eviction triggered: %d keys to be evicted\n", evictionSize)
+		start := time.Now()
+		strategy.evict(store, evictionSize)
+		stats.evictionTime += time.Since(start)
+	}
+}
+
+// performEvictions makes room in the keyspace before a command that may grow the dataset is executed,
+// it returns false when the keyspace is still full after applying the eviction policy
+func performEvictions() bool {
+	if KeyspaceSize() < config.KEYS_LIMIT {
+		return true
+	}
+	Evict()
+	return KeyspaceSize() < config.KEYS_LIMIT
+}
+
+func evictKey(k string) {
+	obj, ok := store[k]
+	if !ok {
+		return
+	}
+	idleTime := idleTimeOf(obj.LastAccessedAt)
+	if Delete(k) {
+		stats.evictedKeys++
+		recordEvictedIdleTime(config.EVICTION_STRATEGY, idleTime)
+		notifyKeyspaceEvent(NOTIFY_EVICTED, "evicted", k)
 	}
 }
 
@@ -86,5 +260,5 @@ func evictionSize() int {
 	if size < config.KEYS_LIMIT {
 		return 0
 	}
-	return int(float64(config.KEYS_LIMIT) * config.EVICTION_RATIO)
+	return max(1, int(float64(config.KEYS_LIMIT)*config.EVICTION_RATIO))
 }
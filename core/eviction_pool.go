@@ -1,59 +1,113 @@
 package core
 
 import (
+	"math/rand"
 	"sort"
 	"time"
 
 	"github.com/diceclone/config"
 )
 
-var evictionPool []*Obj
+const LRU_CLOCK_MAX uint32 = 0x00FFFFFF
+const LFU_INIT_VAL uint8 = 5
+
+type evictionCandidate struct {
+	key   string
+	score uint64
+}
+
+// evictionPool is kept sorted by score in ascending order, the best candidate for eviction is the last one
+var evictionPool []*evictionCandidate
 
 func InitializePool() {
-	evictionPool = make([]*Obj, 0)
+	evictionPool = make([]*evictionCandidate, 0, config.EVICTION_POOL_SIZE)
 }
 
-func canBeEvicted(obj *Obj) bool {
+func populateEvictionPool(key string, score uint64) {
+	for _, c := range evictionPool {
+		if c.key == key {
+			c.score = score
+			arrangeEvictionPool()
+			return
+		}
+	}
 
 	if len(evictionPool) < config.EVICTION_POOL_SIZE {
-		evictionPool = append(evictionPool, obj)
+		evictionPool = append(evictionPool, &evictionCandidate{key: key, score: score})
 		arrangeEvictionPool()
-		return false
+		return
 	}
 
-	logger.Printf("Eviction pool size: %d\n", len(evictionPool))
-	if isIdleForLonger(obj) {
-		evictionPool = evictionPool[1:]
-		evictionPool = append(evictionPool, obj)
+	// the pool is full, the sampled key replaces the worst candidate only if it is a better one
+	if score > evictionPool[0].score {
+		evictionPool[0] = &evictionCandidate{key: key, score: score}
 		arrangeEvictionPool()
-		logger.Printf("%s is older, candidate for eviction.\n", obj.Value)
-		return true
 	}
+}
 
-	return false
+func bestEvictionCandidate() (string, bool) {
+	for len(evictionPool) > 0 {
+		candidate := evictionPool[len(evictionPool)-1]
+		evictionPool = evictionPool[:len(evictionPool)-1]
+		// the key might have been deleted after it was added to the pool
+		if exists(candidate.key) {
+			return candidate.key, true
+		}
+	}
+	return "", false
 }
 
 func arrangeEvictionPool() {
 	sort.Slice(evictionPool, func(i, j int) bool {
-		return evictionPool[j].LastAccessedAt < evictionPool[i].LastAccessedAt
+		return evictionPool[i].score < evictionPool[j].score
 	})
 }
 
-func isIdleForLonger(obj *Obj) bool {
-	latOfWorstCandidate := evictionPool[len(evictionPool)-1].LastAccessedAt
-	latOfCurrentCandidate := obj.LastAccessedAt
+func lruClock() uint32 {
+	return uint32(time.Now().Unix()) & LRU_CLOCK_MAX
+}
 
-	logger.Printf("lat of obj: %d, lat of last element: %d, lat of first element: %d\n", latOfCurrentCandidate, latOfWorstCandidate, evictionPool[0].LastAccessedAt)
+func idleTimeOf(lat uint32) uint32 {
+	currentClock := lruClock()
 
-	return idleTimeOf(latOfCurrentCandidate) > idleTimeOf(latOfWorstCandidate)
+	if lat <= currentClock {
+		return currentClock - lat
+	}
+	// the clock has wrapped around since the last access
+	return currentClock + (LRU_CLOCK_MAX - lat)
 }
 
-func idleTimeOf(lat uint32) uint32 {
-	currentClock := time.Now().Unix() & 0x00FFFFFF
+// touch records an access to the object for the LRU and LFU policies
+func touch(obj *Obj) {
+	obj.Frequency = lfuLogIncr(lfuDecrAndReturn(obj))
+	obj.LastAccessedAt = lruClock()
+}
+
+// lfuLogIncr increments the counter with a probability that decreases as the counter grows,
+// so that 8 bits are enough to tell apart keys accessed a few times from keys accessed millions of times
+func lfuLogIncr(counter uint8) uint8 {
+	if counter == 255 {
+		return counter
+	}
+	baseval := 0.0
+	if counter > LFU_INIT_VAL {
+		baseval = float64(counter - LFU_INIT_VAL)
+	}
+	p := 1.0 / (baseval*float64(config.LFU_LOG_FACTOR) + 1)
+	if rand.Float64() < p {
+		counter++
+	}
+	return counter
+}
 
-	if int64(lat) < currentClock {
-		return lat
-	} else {
-		return uint32(currentClock + int64(0x00FFFFFF-lat))
+// lfuDecrAndReturn decays the counter by one for every LFU_DECAY_TIME minutes the key has been idle
+func lfuDecrAndReturn(obj *Obj) uint8 {
+	if config.LFU_DECAY_TIME <= 0 {
+		return obj.Frequency
+	}
+	periods := idleTimeOf(obj.LastAccessedAt) / 60 / uint32(config.LFU_DECAY_TIME)
+	if periods >= uint32(obj.Frequency) {
+		return 0
 	}
+	return obj.Frequency - uint8(periods)
 }
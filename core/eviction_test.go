@@ -0,0 +1,194 @@
+package core_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/diceclone/config"
+	"github.com/diceclone/core"
+)
+
+func withKeysLimit(t *testing.T, limit int, policy string) {
+	oldLimit, oldPolicy := config.KEYS_LIMIT, config.EVICTION_STRATEGY
+	config.KEYS_LIMIT = limit
+	config.EVICTION_STRATEGY = policy
+	t.Cleanup(func() {
+		config.KEYS_LIMIT = oldLimit
+		config.EVICTION_STRATEGY = oldPolicy
+	})
+}
+
+func TestEvictionPolicies(t *testing.T) {
+	t.Run("noeviction refuses writes when the keyspace is full", func(t *testing.T) {
+		mockReadWriter, timeProvider := setupTest()
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "FLUSHDB", Args: []string{}}, mockReadWriter, timeProvider)
+		withKeysLimit(t, 5, "noeviction")
+
+		for i := 0; i < 5; i++ {
+			core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{fmt.Sprintf("k%d", i), "v"}}, mockReadWriter, timeProvider)
+		}
+		// the refused write must not pretend that keys are about to be evicted
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"k5", "v"}}, mockReadWriter, timeProvider)
+		log.SetOutput(os.Stderr)
+		if strings.Contains(logs.String(), "Eviction triggered") {
+			t.Errorf("noeviction logged an eviction: %q", logs.String())
+		}
+
+		want := []byte("-OOM command not allowed when used memory > 'maxmemory'.\r\n")
+		if !bytes.Equal(mockReadWriter.LastWrite, want) {
+			t.Errorf("got %v, want %v", string(mockReadWriter.LastWrite), string(want))
+		}
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "GET", Args: []string{"k0"}}, mockReadWriter, timeProvider)
+		want = []byte("$1\r\nv\r\n")
+		if !bytes.Equal(mockReadWriter.LastWrite, want) {
+			t.Errorf("got %v, want %v", string(mockReadWriter.LastWrite), string(want))
+		}
+	})
+
+	t.Run("volatile-ttl evicts only keys with an expiry", func(t *testing.T) {
+		mockReadWriter, _ := setupTest()
+		timeProvider := core.RealTimeProvider{}
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "FLUSHDB", Args: []string{}}, mockReadWriter, timeProvider)
+		withKeysLimit(t, 5, "volatile-ttl")
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"persistent1", "v"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"persistent2", "v"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"volatile1", "v", "ex", "100"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"volatile2", "v", "ex", "200"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"persistent3", "v"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"persistent4", "v"}}, mockReadWriter, timeProvider)
+
+		for _, k := range []string{"persistent1", "persistent2", "persistent3", "persistent4"} {
+			core.EvalAndRespond(&core.RedisCmd{Cmd: "GET", Args: []string{k}}, mockReadWriter, timeProvider)
+			if !bytes.Equal(mockReadWriter.LastWrite, []byte("$1\r\nv\r\n")) {
+				t.Errorf("%s was evicted, got %v", k, string(mockReadWriter.LastWrite))
+			}
+		}
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "GET", Args: []string{"volatile1"}}, mockReadWriter, timeProvider)
+		if !bytes.Equal(mockReadWriter.LastWrite, []byte("$-1\r\n")) {
+			t.Errorf("the key closest to expiry was not evicted, got %v", string(mockReadWriter.LastWrite))
+		}
+	})
+
+	t.Run("allkeys-random keeps the keyspace under the limit", func(t *testing.T) {
+		mockReadWriter, timeProvider := setupTest()
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "FLUSHDB", Args: []string{}}, mockReadWriter, timeProvider)
+		withKeysLimit(t, 10, "allkeys-random")
+
+		for i := 0; i < 50; i++ {
+			core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{fmt.Sprintf("k%d", i), "v"}}, mockReadWriter, timeProvider)
+			if !bytes.Equal(mockReadWriter.LastWrite, []byte("+OK\r\n")) {
+				t.Fatalf("got %v, want +OK", string(mockReadWriter.LastWrite))
+			}
+		}
+
+		if core.KeyspaceSize() > 10 {
+			t.Errorf("keyspace size %d exceeds the limit", core.KeyspaceSize())
+		}
+	})
+}
+
+func TestCONFIGCommand(t *testing.T) {
+	mockReadWriter, timeProvider := setupTest()
+	withKeysLimit(t, config.KEYS_LIMIT, config.EVICTION_STRATEGY)
+
+	cases := []struct {
+		name string
+		args []string
+		want []byte
+	}{
+		{
+			name: "set the eviction policy at runtime",
+			args: []string{"SET", "maxmemory-policy", "volatile-lfu"},
+			want: []byte("+OK\r\n"),
+		},
+		{
+			name: "get the eviction policy",
+			args: []string{"GET", "maxmemory-policy"},
+			want: []byte("*2\r\n$16\r\nmaxmemory-policy\r\n$12\r\nvolatile-lfu\r\n"),
+		},
+		{
+			name: "reject an unknown eviction policy",
+			args: []string{"SET", "maxmemory-policy", "evict-everything"},
+			want: []byte("-ERR CONFIG SET failed (possibly related to argument 'maxmemory-policy') - argument must be one of the following: noeviction, allkeys-lru, allkeys-lfu, allkeys-random, volatile-lru, volatile-lfu, volatile-random, volatile-ttl\r\n"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			core.EvalAndRespond(&core.RedisCmd{Cmd: "CONFIG", Args: tc.args}, mockReadWriter, timeProvider)
+			if !bytes.Equal(mockReadWriter.LastWrite, tc.want) {
+				t.Errorf("got %v, want %v", string(mockReadWriter.LastWrite), string(tc.want))
+			}
+		})
+	}
+}
+
+func TestSetConfigValidatesThePolicy(t *testing.T) {
+	withKeysLimit(t, config.KEYS_LIMIT, "allkeys-lru")
+	if err := core.SetConfig("maxmemory-policy", "allkeys-lur"); err == nil {
+		t.Errorf("got no error for an unknown eviction policy")
+	}
+	if config.EVICTION_STRATEGY != "allkeys-lru" {
+		t.Errorf("got policy %q after a rejected value, want allkeys-lru", config.EVICTION_STRATEGY)
+	}
+	if err := core.SetConfig("maxmemory-policy", "VOLATILE-TTL"); err != nil || config.EVICTION_STRATEGY != "volatile-ttl" {
+		t.Errorf("got policy %q and error %v, want volatile-ttl", config.EVICTION_STRATEGY, err)
+	}
+}
+
+func TestEvictionNotifications(t *testing.T) {
+	t.Run("subscribers are notified of evicted keys when enabled", func(t *testing.T) {
+		mockReadWriter, timeProvider := setupTest()
+		subscriber, _ := setupTest()
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "FLUSHDB", Args: []string{}}, mockReadWriter, timeProvider)
+		withKeysLimit(t, 2, "allkeys-lru")
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "CONFIG", Args: []string{"SET", "notify-keyspace-events", "Ee"}}, mockReadWriter, timeProvider)
+		t.Cleanup(func() {
+			core.EvalAndRespond(&core.RedisCmd{Cmd: "CONFIG", Args: []string{"SET", "notify-keyspace-events", ""}}, mockReadWriter, timeProvider)
+			core.DisconnectClient(subscriber)
+		})
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SUBSCRIBE", Args: []string{"__keyevent@0__:evicted"}}, subscriber, timeProvider)
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"k1", "v"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"k2", "v"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"k3", "v"}}, mockReadWriter, timeProvider)
+
+		want := []byte("*3\r\n$7\r\nmessage\r\n$22\r\n__keyevent@0__:evicted\r\n$2\r\nK")
+		if !bytes.HasPrefix(subscriber.LastWrite, want) {
+			t.Errorf("got %v, want prefix %v", string(subscriber.LastWrite), string(want))
+		}
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "INFO", Args: []string{"stats"}}, mockReadWriter, timeProvider)
+		if bytes.Contains(mockReadWriter.LastWrite, []byte("evicted_keys:0\n")) {
+			t.Errorf("evicted keys are not counted: %v", string(mockReadWriter.LastWrite))
+		}
+	})
+
+	t.Run("nothing is published while notifications are disabled", func(t *testing.T) {
+		mockReadWriter, timeProvider := setupTest()
+		subscriber, _ := setupTest()
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "FLUSHDB", Args: []string{}}, mockReadWriter, timeProvider)
+		withKeysLimit(t, 2, "allkeys-lru")
+		t.Cleanup(func() { core.DisconnectClient(subscriber) })
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SUBSCRIBE", Args: []string{"__keyevent@0__:evicted"}}, subscriber, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"k1", "v"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"k2", "v"}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "SET", Args: []string{"k3", "v"}}, mockReadWriter, timeProvider)
+
+		want := []byte("*3\r\n$9\r\nsubscribe\r\n$22\r\n__keyevent@0__:evicted\r\n:1\r\n")
+		if !bytes.Equal(subscriber.LastWrite, want) {
+			t.Errorf("got %v, want %v", string(subscriber.LastWrite), string(want))
+		}
+	})
+}
@@ -1,16 +1,20 @@
 package core
 
-func expireSample() float32 {
+import "strings"
+
+func expireSample() (int, int) {
 	var limit = 20
-	var deletedKeys = 0
+	var sampledKeys = 0
+	var expiredKeys = 0
 
 	for key, value := range store {
 		if value.ValidTill != -1 {
 			limit--
+			sampledKeys++
 
 			if value.HasExpired() {
-				delete(store, key)
-				deletedKeys++
+				expireKey(key)
+				expiredKeys++
 			}
 		}
 		if limit == 0 {
@@ -18,14 +22,35 @@ func expireSample() float32 {
 		}
 	}
 
-	return float32(deletedKeys) / float32(20)
+	return sampledKeys, expiredKeys
 }
 
 func SafeDeleteExpiredKeys() {
+	var totalSampled = 0
+	var totalExpired = 0
 	for {
-		frac := expireSample()
-		if frac < 0.25 {
+		sampled, expired := expireSample()
+		totalSampled += sampled
+		totalExpired += expired
+		if sampled == 0 || float32(expired)/float32(sampled) < 0.25 {
 			break
 		}
 	}
+
+	if totalSampled > 0 {
+		// running average of the share of stale keys found by the active expiry cycle
+		current := float64(totalExpired) / float64(totalSampled)
+		stats.expiredStalePerc = current*0.05 + stats.expiredStalePerc*0.95
+	}
+
+	activeExpireHashFields()
+	notifyWatchers()
+}
+
+func expireKey(k string) {
+	key := strings.ToUpper(k)
+	if Delete(key) {
+		stats.expiredKeys++
+		notifyKeyspaceEvent(NOTIFY_EXPIRED, "expired", key)
+	}
 }
@@ -0,0 +1,130 @@
+package core_test
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/diceclone/core"
+)
+
+func TestGeoAddPosDistHash(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"geoadd", &core.RedisCmd{Cmd: "GEOADD", Args: []string{"Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"}}, []byte(":2\r\n")},
+		{"members are scored with their geohash", &core.RedisCmd{Cmd: "ZSCORE", Args: []string{"Sicily", "Palermo"}}, []byte("$16\r\n3479099956230698\r\n")},
+		{"geodist in meters", &core.RedisCmd{Cmd: "GEODIST", Args: []string{"Sicily", "Palermo", "Catania"}}, []byte("$11\r\n166274.1516\r\n")},
+		{"geodist in kilometers", &core.RedisCmd{Cmd: "GEODIST", Args: []string{"Sicily", "Palermo", "Catania", "KM"}}, []byte("$8\r\n166.2742\r\n")},
+		{"geodist in miles", &core.RedisCmd{Cmd: "GEODIST", Args: []string{"Sicily", "Palermo", "Catania", "mi"}}, []byte("$8\r\n103.3182\r\n")},
+		{"geodist with a missing member", &core.RedisCmd{Cmd: "GEODIST", Args: []string{"Sicily", "Palermo", "Agrigento"}}, []byte("$-1\r\n")},
+		{"geodist with a bad unit", &core.RedisCmd{Cmd: "GEODIST", Args: []string{"Sicily", "Palermo", "Catania", "yd"}}, []byte("-ERR unsupported unit provided. please use M, KM, FT, MI\r\n")},
+		{"geohash", &core.RedisCmd{Cmd: "GEOHASH", Args: []string{"Sicily", "Palermo", "Catania", "Agrigento"}}, []byte("*3\r\n$11\r\nsqc8b49rny0\r\n$11\r\nsqdtr74hyu0\r\n$-1\r\n")},
+		{"geopos", &core.RedisCmd{Cmd: "GEOPOS", Args: []string{"Sicily", "Palermo", "Agrigento"}}, []byte("*2\r\n*2\r\n$20\r\n13.36138933897018433\r\n$20\r\n38.11555639549629859\r\n*-1\r\n")},
+		{"geopos on a missing key", &core.RedisCmd{Cmd: "GEOPOS", Args: []string{"nogeo", "Palermo"}}, []byte("*1\r\n*-1\r\n")},
+		{"geoadd nx", &core.RedisCmd{Cmd: "GEOADD", Args: []string{"Sicily", "NX", "13", "38", "Palermo", "13.583333", "37.316667", "Agrigento"}}, []byte(":1\r\n")},
+		{"nx keeps existing positions", &core.RedisCmd{Cmd: "GEOHASH", Args: []string{"Sicily", "Palermo"}}, []byte("*1\r\n$11\r\nsqc8b49rny0\r\n")},
+		{"geoadd xx ch", &core.RedisCmd{Cmd: "GEOADD", Args: []string{"Sicily", "XX", "CH", "13.361389", "38.115556", "Palermo", "13.6", "37.3", "Agrigento", "14", "37", "Gela"}}, []byte(":1\r\n")},
+		{"xx does not add members", &core.RedisCmd{Cmd: "ZCARD", Args: []string{"Sicily"}}, []byte(":3\r\n")},
+		{"geoadd nx and xx", &core.RedisCmd{Cmd: "GEOADD", Args: []string{"Sicily", "NX", "XX", "13", "38", "Palermo"}}, []byte("-ERR XX and NX options at the same time are not compatible\r\n")},
+		{"geoadd out of range", &core.RedisCmd{Cmd: "GEOADD", Args: []string{"Sicily", "181", "38", "Nowhere"}}, []byte("-ERR invalid longitude,latitude pair 181.000000,38.000000\r\n")},
+		{"geoadd near the pole", &core.RedisCmd{Cmd: "GEOADD", Args: []string{"Sicily", "0", "86", "Nowhere"}}, []byte("-ERR invalid longitude,latitude pair 0.000000,86.000000\r\n")},
+		{"geoadd with a bad coordinate", &core.RedisCmd{Cmd: "GEOADD", Args: []string{"Sicily", "east", "38", "Nowhere"}}, []byte("-ERR value is not a valid float\r\n")},
+		{"geoadd with a missing member", &core.RedisCmd{Cmd: "GEOADD", Args: []string{"Sicily", "13", "38", "Palermo", "14"}}, []byte("-ERR syntax error\r\n")},
+		{"nothing was added by the failed calls", &core.RedisCmd{Cmd: "ZCARD", Args: []string{"Sicily"}}, []byte(":3\r\n")},
+	})
+}
+
+func TestGeoSearch(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania",
+		"12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")
+
+	runCommandCases(t, []commandCase{
+		{"by radius", &core.RedisCmd{Cmd: "GEOSEARCH", Args: []string{"Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"}}, []byte("*2\r\n$7\r\nCatania\r\n$7\r\nPalermo\r\n")},
+		{"by radius descending with hashes", &core.RedisCmd{Cmd: "GEOSEARCH", Args: []string{"Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "DESC", "WITHHASH"}}, []byte("*2\r\n*2\r\n$7\r\nPalermo\r\n:3479099956230698\r\n*2\r\n$7\r\nCatania\r\n:3479447370796909\r\n")},
+		{"by box with coordinates and distances", &core.RedisCmd{Cmd: "GEOSEARCH", Args: []string{"Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "WITHCOORD", "WITHDIST"}}, []byte("*4\r\n" +
+			"*3\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n*2\r\n$20\r\n15.08726745843887329\r\n$20\r\n37.50266842333162032\r\n" +
+			"*3\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n*2\r\n$20\r\n13.36138933897018433\r\n$20\r\n38.11555639549629859\r\n" +
+			"*3\r\n$5\r\nedge2\r\n$8\r\n279.7403\r\n*2\r\n$20\r\n17.24151045083999634\r\n$20\r\n38.78813451624225195\r\n" +
+			"*3\r\n$5\r\nedge1\r\n$8\r\n279.7405\r\n*2\r\n$19\r\n12.7584877610206604\r\n$20\r\n38.78813451624225195\r\n")},
+		{"from a member", &core.RedisCmd{Cmd: "GEOSEARCH", Args: []string{"Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "10", "mi", "WITHDIST"}}, []byte("*1\r\n*2\r\n$7\r\nPalermo\r\n$6\r\n0.0000\r\n")},
+		{"count returns the closest", &core.RedisCmd{Cmd: "GEOSEARCH", Args: []string{"Sicily", "FROMMEMBER", "edge1", "BYRADIUS", "1000", "km", "COUNT", "2"}}, []byte("*2\r\n$5\r\nedge1\r\n$7\r\nPalermo\r\n")},
+		{"missing key", &core.RedisCmd{Cmd: "GEOSEARCH", Args: []string{"nogeo", "FROMMEMBER", "Palermo", "BYRADIUS", "10", "km"}}, []byte("*0\r\n")},
+		{"missing member", &core.RedisCmd{Cmd: "GEOSEARCH", Args: []string{"Sicily", "FROMMEMBER", "Agrigento", "BYRADIUS", "10", "km"}}, []byte("-ERR could not decode requested zset member\r\n")},
+		{"without a center", &core.RedisCmd{Cmd: "GEOSEARCH", Args: []string{"Sicily", "BYRADIUS", "10", "km"}}, []byte("-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH\r\n")},
+		{"with two shapes", &core.RedisCmd{Cmd: "GEOSEARCH", Args: []string{"Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "10", "km", "BYBOX", "1", "1", "km"}}, []byte("-ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH\r\n")},
+		{"negative radius", &core.RedisCmd{Cmd: "GEOSEARCH", Args: []string{"Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "-1", "km"}}, []byte("-ERR radius cannot be negative\r\n")},
+		{"zero count", &core.RedisCmd{Cmd: "GEOSEARCH", Args: []string{"Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "COUNT", "0"}}, []byte("-ERR COUNT must be > 0\r\n")},
+		{"storedist is only for geosearchstore", &core.RedisCmd{Cmd: "GEOSEARCH", Args: []string{"Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "STOREDIST"}}, []byte("-ERR syntax error\r\n")},
+	})
+
+	// COUNT ANY returns as soon as enough members are found, whatever their distance
+	evalAs(c, "GEOSEARCH", "Sicily", "FROMMEMBER", "edge1", "BYRADIUS", "1000", "km", "COUNT", "3", "ANY")
+	if !bytes.HasPrefix(c.LastWrite, []byte("*3\r\n")) {
+		t.Errorf("got %q, want three members", c.LastWrite)
+	}
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania",
+		"17.241510", "38.788135", "edge2")
+
+	runCommandCases(t, []commandCase{
+		{"store geohashes", &core.RedisCmd{Cmd: "GEOSEARCHSTORE", Args: []string{"near", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km"}}, []byte(":2\r\n")},
+		{"stored geohashes", &core.RedisCmd{Cmd: "ZRANGE", Args: []string{"near", "0", "-1", "WITHSCORES"}}, []byte("*4\r\n$7\r\nPalermo\r\n$16\r\n3479099956230698\r\n$7\r\nCatania\r\n$16\r\n3479447370796909\r\n")},
+		{"stored members keep their position", &core.RedisCmd{Cmd: "GEODIST", Args: []string{"near", "Palermo", "Catania"}}, []byte("$11\r\n166274.1516\r\n")},
+		{"store distances", &core.RedisCmd{Cmd: "GEOSEARCHSTORE", Args: []string{"near", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "COUNT", "3", "STOREDIST"}}, []byte(":3\r\n")},
+		{"stored distances", &core.RedisCmd{Cmd: "ZRANGE", Args: []string{"near", "0", "-1", "WITHSCORES"}}, []byte("*6\r\n$7\r\nCatania\r\n$16\r\n56.4412578701582\r\n$7\r\nPalermo\r\n$17\r\n190.4424298477578\r\n$5\r\nedge2\r\n$17\r\n279.7403417843143\r\n")},
+		{"with options are refused", &core.RedisCmd{Cmd: "GEOSEARCHSTORE", Args: []string{"near", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "WITHDIST"}}, []byte("-ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options\r\n")},
+		{"an empty result deletes the destination", &core.RedisCmd{Cmd: "GEOSEARCHSTORE", Args: []string{"near", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km"}}, []byte(":0\r\n")},
+		{"destination is gone", &core.RedisCmd{Cmd: "TYPE", Args: []string{"near"}}, []byte("+none\r\n")},
+	})
+}
+
+// TestGeoSearchMatchesScan checks the geohash cells searched against a scan of every member
+func TestGeoSearchMatchesScan(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	r := rand.New(rand.NewSource(42))
+	type position struct{ lon, lat float64 }
+	positions := make(map[string]position)
+	for i := 0; i < 2000; i++ {
+		member := "p" + strconv.Itoa(i)
+		p := position{r.Float64()*20 - 10, r.Float64()*20 + 40}
+		positions[member] = p
+		evalAs(c, "GEOADD", "points", strconv.FormatFloat(p.lon, 'f', -1, 64), strconv.FormatFloat(p.lat, 'f', -1, 64), member)
+	}
+
+	for _, radius := range []float64{5, 50, 300, 1000} {
+		evalAs(c, "GEOSEARCH", "points", "FROMLONLAT", "0", "50", "BYRADIUS", strconv.FormatFloat(radius, 'f', -1, 64), "km")
+		got := len(sortedReply(t, c))
+
+		// members are stored at the center of their cell, less than a meter away from where they were added
+		inside, maybe := 0, 0
+		for _, p := range positions {
+			switch d := haversineKm(0, 50, p.lon, p.lat); {
+			case d <= radius-0.01:
+				inside++
+			case d <= radius+0.01:
+				maybe++
+			}
+		}
+		if got < inside || got > inside+maybe {
+			t.Errorf("radius %v km: got %d members, the scan finds %d and %d on the border", radius, got, inside, maybe)
+		}
+	}
+}
+
+func haversineKm(lon1, lat1, lon2, lat2 float64) float64 {
+	rad := math.Pi / 180
+	u := math.Sin((lat2 - lat1) * rad / 2)
+	v := math.Sin((lon2 - lon1) * rad / 2)
+	a := u*u + math.Cos(lat1*rad)*math.Cos(lat2*rad)*v*v
+	return 2 * 6372.797560856 * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,324 @@
+package core
+
+import (
+	"math"
+)
+
+const (
+	GEO_STEP_MAX = 26 // 52 bits, the precision of a sorted set score
+	GEO_LAT_MIN  = -85.05112878
+	GEO_LAT_MAX  = 85.05112878
+	GEO_LONG_MIN = -180.0
+	GEO_LONG_MAX = 180.0
+
+	EARTH_RADIUS_IN_METERS = 6372797.560856
+	MERCATOR_MAX           = 20037726.37
+)
+
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geoRange is the interval of a coordinate covered by a geohash
+type geoRange struct {
+	min, max float64
+}
+
+var (
+	geoLongRange = geoRange{GEO_LONG_MIN, GEO_LONG_MAX}
+	geoLatRange  = geoRange{GEO_LAT_MIN, GEO_LAT_MAX}
+	// the standard geohash covers the poles, GEOHASH replies with it
+	geoStdLatRange = geoRange{-90, 90}
+)
+
+// geoHashBits is a geohash of step bits per coordinate, latitude bits are at the even positions and
+// longitude bits at the odd ones
+type geoHashBits struct {
+	bits uint64
+	step uint
+}
+
+func (h geoHashBits) isZero() bool {
+	return h.bits == 0 && h.step == 0
+}
+
+// geoHashArea is the cell of a geohash
+type geoHashArea struct {
+	longitude, latitude geoRange
+}
+
+// interleave64 spreads the bits of x on the even positions and the bits of y on the odd positions
+func interleave64(x, y uint32) uint64 {
+	var out uint64
+	for i := 0; i < 32; i++ {
+		out |= uint64(x>>i&1) << (2 * i)
+		out |= uint64(y>>i&1) << (2*i + 1)
+	}
+	return out
+}
+
+// deinterleave64 returns the even bits in the low half and the odd bits in the high half
+func deinterleave64(v uint64) uint64 {
+	var x, y uint64
+	for i := 0; i < 32; i++ {
+		x |= (v >> (2 * i) & 1) << i
+		y |= (v >> (2*i + 1) & 1) << i
+	}
+	return x | y<<32
+}
+
+func geohashEncode(longRange, latRange geoRange, longitude, latitude float64, step uint) geoHashBits {
+	latOffset := (latitude - latRange.min) / (latRange.max - latRange.min)
+	longOffset := (longitude - longRange.min) / (longRange.max - longRange.min)
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+	return geoHashBits{bits: interleave64(uint32(latOffset), uint32(longOffset)), step: step}
+}
+
+func geohashDecode(longRange, latRange geoRange, h geoHashBits) geoHashArea {
+	sep := deinterleave64(h.bits)
+	ilat, ilong := float64(uint32(sep)), float64(uint32(sep>>32))
+	cells := float64(uint64(1) << h.step)
+	latScale := latRange.max - latRange.min
+	longScale := longRange.max - longRange.min
+	return geoHashArea{
+		latitude:  geoRange{latRange.min + ilat/cells*latScale, latRange.min + (ilat+1)/cells*latScale},
+		longitude: geoRange{longRange.min + ilong/cells*longScale, longRange.min + (ilong+1)/cells*longScale},
+	}
+}
+
+// center returns the middle of the cell, clamped to the valid coordinates
+func (a geoHashArea) center() (float64, float64) {
+	longitude := min(max((a.longitude.min+a.longitude.max)/2, GEO_LONG_MIN), GEO_LONG_MAX)
+	latitude := min(max((a.latitude.min+a.latitude.max)/2, GEO_LAT_MIN), GEO_LAT_MAX)
+	return longitude, latitude
+}
+
+// validGeoCoordinates reports whether a position can be indexed, the poles are left out
+// like in the web mercator projection
+func validGeoCoordinates(longitude, latitude float64) bool {
+	return longitude >= GEO_LONG_MIN && longitude <= GEO_LONG_MAX &&
+		latitude >= GEO_LAT_MIN && latitude <= GEO_LAT_MAX
+}
+
+// geoScore returns the 52-bit geohash of the position as a sorted set score
+func geoScore(longitude, latitude float64) float64 {
+	return float64(geohashEncode(geoLongRange, geoLatRange, longitude, latitude, GEO_STEP_MAX).bits)
+}
+
+// geoDecodeScore returns the center of the cell of a 52-bit geohash score
+func geoDecodeScore(score float64) (float64, float64) {
+	h := geoHashBits{bits: uint64(score), step: GEO_STEP_MAX}
+	return geohashDecode(geoLongRange, geoLatRange, h).center()
+}
+
+// geoHashString returns the 11 characters standard geohash of a score, the last character only
+// holds zero bits since a score keeps 52 of the 55 bits
+func geoHashString(score float64) string {
+	longitude, latitude := geoDecodeScore(score)
+	h := geohashEncode(geoLongRange, geoStdLatRange, longitude, latitude, GEO_STEP_MAX)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		if i < 10 {
+			idx = int(h.bits>>(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return string(buf)
+}
+
+func degRad(d float64) float64 {
+	return d * (math.Pi / 180)
+}
+
+func radDeg(r float64) float64 {
+	return r / (math.Pi / 180)
+}
+
+func geoLatDistance(lat1, lat2 float64) float64 {
+	return EARTH_RADIUS_IN_METERS * math.Abs(degRad(lat2)-degRad(lat1))
+}
+
+// geoDistance returns the haversine distance in meters between two positions
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	v := math.Sin((degRad(lon2) - degRad(lon1)) / 2)
+	if v == 0 {
+		return geoLatDistance(lat1, lat2)
+	}
+	lat1r, lat2r := degRad(lat1), degRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * EARTH_RADIUS_IN_METERS * math.Asin(math.Sqrt(a))
+}
+
+// geohashMove moves the hash by one cell along the longitude when odd is set and along the latitude
+// otherwise, d is the direction
+func geohashMove(h geoHashBits, odd bool, d int) geoHashBits {
+	const oddBits, evenBits = uint64(0xaaaaaaaaaaaaaaaa), uint64(0x5555555555555555)
+	moved, kept, zzMask := oddBits, evenBits, evenBits
+	if !odd {
+		moved, kept, zzMask = evenBits, oddBits, oddBits
+	}
+	shift := 64 - 2*h.step
+	v := h.bits & moved
+	zz := zzMask >> shift
+	if d > 0 {
+		v += zz + 1
+	} else {
+		v = (v | zz) - (zz + 1)
+	}
+	v &= moved >> shift
+	return geoHashBits{bits: v | h.bits&kept, step: h.step}
+}
+
+// geoNeighbors holds the eight cells around a geohash
+type geoNeighbors struct {
+	north, south, east, west                   geoHashBits
+	northEast, northWest, southEast, southWest geoHashBits
+}
+
+func geohashNeighbors(h geoHashBits) geoNeighbors {
+	east, west := geohashMove(h, true, 1), geohashMove(h, true, -1)
+	return geoNeighbors{
+		north:     geohashMove(h, false, 1),
+		south:     geohashMove(h, false, -1),
+		east:      east,
+		west:      west,
+		northEast: geohashMove(east, false, 1),
+		northWest: geohashMove(west, false, 1),
+		southEast: geohashMove(east, false, -1),
+		southWest: geohashMove(west, false, -1),
+	}
+}
+
+// geoShape is the area searched by GEOSEARCH, a circle of radius or a width by height box centered
+// on a position, the sizes are expressed in a unit of conversion meters
+type geoShape struct {
+	longitude, latitude float64
+	byBox               bool
+	radius              float64
+	width, height       float64
+	conversion          float64
+}
+
+// contains returns the distance in meters from the center to the position and whether it lies in the shape
+func (s *geoShape) contains(longitude, latitude float64) (float64, bool) {
+	if !s.byBox {
+		d := geoDistance(s.longitude, s.latitude, longitude, latitude)
+		return d, d <= s.radius*s.conversion
+	}
+	// the latitude distance is the cheaper one, it is checked first
+	if geoLatDistance(latitude, s.latitude) > s.height*s.conversion/2 {
+		return 0, false
+	}
+	if geoDistance(longitude, latitude, s.longitude, latitude) > s.width*s.conversion/2 {
+		return 0, false
+	}
+	return geoDistance(s.longitude, s.latitude, longitude, latitude), true
+}
+
+// boundingBox returns the minimum longitude and latitude and the maximum longitude and latitude of the shape
+func (s *geoShape) boundingBox() (float64, float64, float64, float64) {
+	height, width := s.radius, s.radius
+	if s.byBox {
+		height, width = s.height/2, s.width/2
+	}
+	height *= s.conversion
+	width *= s.conversion
+
+	latDelta := radDeg(height / EARTH_RADIUS_IN_METERS)
+	longDeltaTop := radDeg(width / EARTH_RADIUS_IN_METERS / math.Cos(degRad(s.latitude+latDelta)))
+	longDeltaBottom := radDeg(width / EARTH_RADIUS_IN_METERS / math.Cos(degRad(s.latitude-latDelta)))
+	// the box is widest on the side closer to the equator
+	longDelta := longDeltaTop
+	if s.latitude < 0 {
+		longDelta = longDeltaBottom
+	}
+	return s.longitude - longDelta, s.latitude - latDelta, s.longitude + longDelta, s.latitude + latDelta
+}
+
+// geoEstimateSteps returns the geohash precision whose cells are about as large as the range
+func geoEstimateSteps(rangeMeters float64, latitude float64) uint {
+	if rangeMeters == 0 {
+		return GEO_STEP_MAX
+	}
+	step := 1
+	for rangeMeters < MERCATOR_MAX {
+		rangeMeters *= 2
+		step++
+	}
+	// make sure the range is included in most of the base cases
+	step -= 2
+	// cells shrink towards the poles
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), GEO_STEP_MAX))
+}
+
+// searchCells returns the geohash cells covering the shape, the cell of the center and its neighbors
+// that intersect the bounding box of the shape
+func (s *geoShape) searchCells() []geoHashBits {
+	minLong, minLat, maxLong, maxLat := s.boundingBox()
+	radius := s.radius
+	if s.byBox {
+		radius = math.Sqrt(s.width/2*s.width/2 + s.height/2*s.height/2)
+	}
+	steps := geoEstimateSteps(radius*s.conversion, s.latitude)
+
+	h := geohashEncode(geoLongRange, geoLatRange, s.longitude, s.latitude, steps)
+	n := geohashNeighbors(h)
+
+	// near the edge of its cell the shape may reach past the neighbors, a larger cell is used then
+	north := geohashDecode(geoLongRange, geoLatRange, n.north)
+	south := geohashDecode(geoLongRange, geoLatRange, n.south)
+	east := geohashDecode(geoLongRange, geoLatRange, n.east)
+	west := geohashDecode(geoLongRange, geoLatRange, n.west)
+	if steps > 1 && (north.latitude.max < maxLat || south.latitude.min > minLat ||
+		east.longitude.max < maxLong || west.longitude.min > minLong) {
+		steps--
+		h = geohashEncode(geoLongRange, geoLatRange, s.longitude, s.latitude, steps)
+		n = geohashNeighbors(h)
+	}
+	area := geohashDecode(geoLongRange, geoLatRange, h)
+
+	// skip the neighbors outside of the bounding box
+	if steps >= 2 {
+		if area.latitude.min < minLat {
+			n.south, n.southWest, n.southEast = geoHashBits{}, geoHashBits{}, geoHashBits{}
+		}
+		if area.latitude.max > maxLat {
+			n.north, n.northEast, n.northWest = geoHashBits{}, geoHashBits{}, geoHashBits{}
+		}
+		if area.longitude.min < minLong {
+			n.west, n.southWest, n.northWest = geoHashBits{}, geoHashBits{}, geoHashBits{}
+		}
+		if area.longitude.max > maxLong {
+			n.east, n.southEast, n.northEast = geoHashBits{}, geoHashBits{}, geoHashBits{}
+		}
+	}
+
+	var cells []geoHashBits
+	for _, c := range []geoHashBits{h, n.north, n.south, n.east, n.west, n.northEast, n.northWest, n.southEast, n.southWest} {
+		if c.isZero() {
+			continue
+		}
+		// small cells near the poles or the antimeridian may wrap onto each other
+		duplicate := false
+		for _, seen := range cells {
+			duplicate = duplicate || seen == c
+		}
+		if !duplicate {
+			cells = append(cells, c)
+		}
+	}
+	return cells
+}
+
+// scoreRange returns the scores of the 52-bit geohashes inside the cell, the max is exclusive
+func (h geoHashBits) scoreRange() (float64, float64) {
+	shift := 2 * (GEO_STEP_MAX - h.step)
+	return float64(h.bits << shift), float64((h.bits + 1) << shift)
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"strings"
+	"time"
+
+	"github.com/diceclone/config"
+)
+
+// hashObject is the value of hash keys. Small hashes are listpacks of alternating fields and values,
+// they are converted to a go map once they outgrow hash-max-listpack-entries or hash-max-listpack-value
+// and never converted back
+type hashObject struct {
+	lp   *listpack
+	dict map[string]string
+	// expires holds the unix time in milliseconds at which fields expire, only fields with a ttl are present
+	expires map[string]int64
+}
+
+func newHashObj() *Obj {
+	return NewObj(&hashObject{lp: newListpack()}, -1, OBJ_TYPE_HASH, OBJ_ENCODING_LISTPACK)
+}
+
+func hashObjectOf(obj *Obj) *hashObject {
+	return obj.Value.(*hashObject)
+}
+
+func hashLen(obj *Obj) int {
+	h := hashObjectOf(obj)
+	if h.dict != nil {
+		return len(h.dict)
+	}
+	return h.lp.Len() / 2
+}
+
+func hashGet(obj *Obj, field string) (string, bool) {
+	h := hashObjectOf(obj)
+	if h.dict != nil {
+		value, ok := h.dict[field]
+		return value, ok
+	}
+	i := h.lp.Find(field, 0, 2)
+	if i < 0 {
+		return "", false
+	}
+	return h.lp.Index(i + 1)
+}
+
+// hashSet sets the field and tells whether it was created, like HSET it discards the ttl of the field
+func hashSet(obj *Obj, field string, value string) bool {
+	delete(hashObjectOf(obj).expires, field)
+	return hashSetKeepTTL(obj, field, value)
+}
+
+// hashSetKeepTTL sets the field keeping its ttl, which is what the commands updating a value in place do
+func hashSetKeepTTL(obj *Obj, field string, value string) bool {
+	hashTypeTryConversion(obj, field, value)
+
+	h := hashObjectOf(obj)
+	if h.dict != nil {
+		_, exists := h.dict[field]
+		h.dict[field] = value
+		return !exists
+	}
+	if i := h.lp.Find(field, 0, 2); i >= 0 {
+		h.lp.Replace(i+1, value)
+		return false
+	}
+	h.lp.Append(field, value)
+	if h.lp.Len()/2 > config.HASH_MAX_LISTPACK_ENTRIES {
+		hashTypeConvert(obj)
+	}
+	return true
+}
+
+// hashDelete removes the field and tells whether it existed
+func hashDelete(obj *Obj, field string) bool {
+	h := hashObjectOf(obj)
+	delete(h.expires, field)
+	if h.dict != nil {
+		if _, ok := h.dict[field]; !ok {
+			return false
+		}
+		delete(h.dict, field)
+		return true
+	}
+	i := h.lp.Find(field, 0, 2)
+	if i < 0 {
+		return false
+	}
+	h.lp.Delete(i, 2)
+	return true
+}
+
+// hashEntries returns the fields and values of the hash as a flat field, value, field, value sequence
+func hashEntries(obj *Obj) []string {
+	h := hashObjectOf(obj)
+	if h.dict == nil {
+		return h.lp.Entries()
+	}
+	entries := make([]string, 0, 2*len(h.dict))
+	for field, value := range h.dict {
+		entries = append(entries, field, value)
+	}
+	return entries
+}
+
+// hashTypeTryConversion converts the hash to a map when the strings about to be stored are too long for a listpack
+func hashTypeTryConversion(obj *Obj, values ...string) {
+	if obj.Encoding() != OBJ_ENCODING_LISTPACK {
+		return
+	}
+	for _, v := range values {
+		if len(v) > config.HASH_MAX_LISTPACK_VALUE {
+			hashTypeConvert(obj)
+			return
+		}
+	}
+}
+
+func hashTypeConvert(obj *Obj) {
+	h := hashObjectOf(obj)
+	h.dict = make(map[string]string, h.lp.Len()/2)
+	entries := h.lp.Entries()
+	for i := 0; i < len(entries); i += 2 {
+		h.dict[entries[i]] = entries[i+1]
+	}
+	h.lp = nil
+	obj.setEncoding(OBJ_ENCODING_HT)
+}
+
+// hashes holding fields with a ttl, sampled by the active expiry cycle. Keys deleted or overwritten
+// since their fields got a ttl are dropped when the cycle finds them
+var hashesWithExpiringFields = make(map[string]struct{})
+
+// HASH_FIELD_EXPIRE_MAX is the largest unix time in milliseconds a field can expire at, as in redis
+const HASH_FIELD_EXPIRE_MAX = 1<<48 - 1
+
+// hashFieldExpireAt returns the unix time in milliseconds at which the field expires, -1 when it has no ttl
+func hashFieldExpireAt(obj *Obj, field string) int64 {
+	if at, ok := hashObjectOf(obj).expires[field]; ok {
+		return at
+	}
+	return -1
+}
+
+func hashFieldSetExpire(key string, obj *Obj, field string, at int64) {
+	h := hashObjectOf(obj)
+	if h.expires == nil {
+		h.expires = make(map[string]int64)
+	}
+	h.expires[field] = at
+	hashesWithExpiringFields[strings.ToUpper(key)] = struct{}{}
+}
+
+// hashFieldPersist removes the ttl of the field and tells whether it had one
+func hashFieldPersist(obj *Obj, field string) bool {
+	h := hashObjectOf(obj)
+	if _, ok := h.expires[field]; !ok {
+		return false
+	}
+	delete(h.expires, field)
+	return true
+}
+
+// hashDeleteExpiredFields deletes the fields whose ttl elapsed, and the key once no field is left,
+// it returns the number of fields deleted and whether the key was deleted
+func hashDeleteExpiredFields(key string, obj *Obj) (int, bool) {
+	h := hashObjectOf(obj)
+	if len(h.expires) == 0 {
+		return 0, false
+	}
+
+	now := time.Now().UnixMilli()
+	expired := 0
+	for field, at := range h.expires {
+		if at <= now {
+			hashDelete(obj, field)
+			expired++
+		}
+	}
+	if expired == 0 {
+		return 0, false
+	}
+
+	stats.expiredSubkeys += int64(expired)
+	notifyKeyspaceEvent(NOTIFY_HASH, "hexpired", strings.ToUpper(key))
+	if hashLen(obj) == 0 {
+		Delete(key)
+		notifyKeyspaceEvent(NOTIFY_GENERIC, "del", strings.ToUpper(key))
+		return expired, true
+	}
+	updateIndexes(key)
+	return expired, false
+}
+
+// activeExpireHashFields samples the hashes with expiring fields and deletes the fields whose ttl elapsed
+func activeExpireHashFields() {
+	limit := 20
+	for key := range hashesWithExpiringFields {
+		if limit == 0 {
+			break
+		}
+		limit--
+
+		obj := peek(key)
+		if obj == nil || obj.Type() != OBJ_TYPE_HASH || len(hashObjectOf(obj).expires) == 0 {
+			delete(hashesWithExpiringFields, key)
+			continue
+		}
+		if _, deleted := hashDeleteExpiredFields(key, obj); deleted {
+			delete(hashesWithExpiringFields, key)
+		}
+	}
+}
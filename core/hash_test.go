@@ -0,0 +1,203 @@
+package core_test
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diceclone/config"
+	"github.com/diceclone/core"
+)
+
+func TestHashCommands(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"set fields", &core.RedisCmd{Cmd: "HSET", Args: []string{"user:1", "name", "ann", "age", "30"}}, []byte(":2\r\n")},
+		{"update a field", &core.RedisCmd{Cmd: "HSET", Args: []string{"user:1", "name", "bob", "city", "rome"}}, []byte(":1\r\n")},
+		{"get a field", &core.RedisCmd{Cmd: "HGET", Args: []string{"user:1", "name"}}, []byte("$3\r\nbob\r\n")},
+		{"get a missing field", &core.RedisCmd{Cmd: "HGET", Args: []string{"user:1", "missing"}}, []byte("$-1\r\n")},
+		{"get many fields", &core.RedisCmd{Cmd: "HMGET", Args: []string{"user:1", "age", "missing", "city"}}, []byte("*3\r\n$2\r\n30\r\n$-1\r\n$4\r\nrome\r\n")},
+		{"length of the hash", &core.RedisCmd{Cmd: "HLEN", Args: []string{"user:1"}}, []byte(":3\r\n")},
+		{"all fields and values", &core.RedisCmd{Cmd: "HGETALL", Args: []string{"user:1"}}, []byte("*6\r\n$4\r\nname\r\n$3\r\nbob\r\n$3\r\nage\r\n$2\r\n30\r\n$4\r\ncity\r\n$4\r\nrome\r\n")},
+		{"all fields", &core.RedisCmd{Cmd: "HKEYS", Args: []string{"user:1"}}, []byte("*3\r\n$4\r\nname\r\n$3\r\nage\r\n$4\r\ncity\r\n")},
+		{"all values", &core.RedisCmd{Cmd: "HVALS", Args: []string{"user:1"}}, []byte("*3\r\n$3\r\nbob\r\n$2\r\n30\r\n$4\r\nrome\r\n")},
+		{"field exists", &core.RedisCmd{Cmd: "HEXISTS", Args: []string{"user:1", "age"}}, []byte(":1\r\n")},
+		{"length of a value", &core.RedisCmd{Cmd: "HSTRLEN", Args: []string{"user:1", "city"}}, []byte(":4\r\n")},
+		{"setnx on an existing field", &core.RedisCmd{Cmd: "HSETNX", Args: []string{"user:1", "name", "eve"}}, []byte(":0\r\n")},
+		{"setnx on a new field", &core.RedisCmd{Cmd: "HSETNX", Args: []string{"user:1", "zip", "00100"}}, []byte(":1\r\n")},
+		{"increment a field", &core.RedisCmd{Cmd: "HINCRBY", Args: []string{"user:1", "age", "5"}}, []byte(":35\r\n")},
+		{"increment a missing field", &core.RedisCmd{Cmd: "HINCRBY", Args: []string{"user:1", "visits", "-2"}}, []byte(":-2\r\n")},
+		{"increment a non integer field", &core.RedisCmd{Cmd: "HINCRBY", Args: []string{"user:1", "name", "1"}}, []byte("-ERR hash value is not an integer\r\n")},
+		{"increment by a float", &core.RedisCmd{Cmd: "HINCRBYFLOAT", Args: []string{"user:1", "age", "0.5"}}, []byte("$4\r\n35.5\r\n")},
+		{"set a decimal field", &core.RedisCmd{Cmd: "HSET", Args: []string{"ratios", "ratio", "0.1"}}, []byte(":1\r\n")},
+		{"floats add like a long double", &core.RedisCmd{Cmd: "HINCRBYFLOAT", Args: []string{"ratios", "ratio", "0.2"}}, []byte("$3\r\n0.3\r\n")},
+		{"increment a non float field", &core.RedisCmd{Cmd: "HINCRBYFLOAT", Args: []string{"user:1", "name", "1"}}, []byte("-ERR hash value is not a float\r\n")},
+		{"delete fields", &core.RedisCmd{Cmd: "HDEL", Args: []string{"user:1", "zip", "visits", "missing"}}, []byte(":2\r\n")},
+		{"type of the hash", &core.RedisCmd{Cmd: "TYPE", Args: []string{"user:1"}}, []byte("+hash\r\n")},
+		{"small hashes are listpack encoded", &core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "user:1"}}, []byte("$8\r\nlistpack\r\n")},
+		{"random field of a missing hash", &core.RedisCmd{Cmd: "HRANDFIELD", Args: []string{"nohash"}}, []byte("$-1\r\n")},
+		{"random fields of a missing hash", &core.RedisCmd{Cmd: "HRANDFIELD", Args: []string{"nohash", "3"}}, []byte("*0\r\n")},
+		{"scan a small hash", &core.RedisCmd{Cmd: "HSCAN", Args: []string{"user:1", "0", "MATCH", "c*"}}, []byte("*2\r\n$1\r\n0\r\n*2\r\n$4\r\ncity\r\n$4\r\nrome\r\n")},
+		{"scan without values", &core.RedisCmd{Cmd: "HSCAN", Args: []string{"user:1", "0", "MATCH", "c*", "NOVALUES"}}, []byte("*2\r\n$1\r\n0\r\n*1\r\n$4\r\ncity\r\n")},
+		{"scan with an invalid cursor", &core.RedisCmd{Cmd: "HSCAN", Args: []string{"user:1", "x"}}, []byte("-ERR invalid cursor\r\n")},
+		{"delete all the fields", &core.RedisCmd{Cmd: "HDEL", Args: []string{"user:1", "name", "age", "city"}}, []byte(":3\r\n")},
+		{"the emptied hash is deleted", &core.RedisCmd{Cmd: "TYPE", Args: []string{"user:1"}}, []byte("+none\r\n")},
+		{"odd number of arguments", &core.RedisCmd{Cmd: "HSET", Args: []string{"user:1", "name"}}, []byte("-ERR wrong number of arguments for 'hset' command\r\n")},
+		{"set a string", &core.RedisCmd{Cmd: "SET", Args: []string{"hashstring", "a"}}, []byte("+OK\r\n")},
+		{"hash command on a string", &core.RedisCmd{Cmd: "HGET", Args: []string{"hashstring", "a"}}, []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")},
+	})
+}
+
+func TestHashEncodingConversion(t *testing.T) {
+	defer func(entries, value int) {
+		config.HASH_MAX_LISTPACK_ENTRIES = entries
+		config.HASH_MAX_LISTPACK_VALUE = value
+	}(config.HASH_MAX_LISTPACK_ENTRIES, config.HASH_MAX_LISTPACK_VALUE)
+
+	runCommandCases(t, []commandCase{
+		{"lower the entries limit", &core.RedisCmd{Cmd: "CONFIG", Args: []string{"SET", "hash-max-listpack-entries", "2"}}, []byte("+OK\r\n")},
+		{"lower the value limit", &core.RedisCmd{Cmd: "CONFIG", Args: []string{"SET", "hash-max-listpack-value", "8"}}, []byte("+OK\r\n")},
+		{"fill a hash up to the limit", &core.RedisCmd{Cmd: "HSET", Args: []string{"convhash", "a", "1", "b", "2"}}, []byte(":2\r\n")},
+		{"still a listpack", &core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "convhash"}}, []byte("$8\r\nlistpack\r\n")},
+		{"exceed the entries limit", &core.RedisCmd{Cmd: "HSET", Args: []string{"convhash", "c", "3"}}, []byte(":1\r\n")},
+		{"converted to a hashtable", &core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "convhash"}}, []byte("$9\r\nhashtable\r\n")},
+		{"fields survive the conversion", &core.RedisCmd{Cmd: "HMGET", Args: []string{"convhash", "a", "b", "c"}}, []byte("*3\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n")},
+		{"a small hash", &core.RedisCmd{Cmd: "HSET", Args: []string{"convhash2", "a", "1"}}, []byte(":1\r\n")},
+		{"exceed the value limit", &core.RedisCmd{Cmd: "HSET", Args: []string{"convhash2", "b", "longer than eight"}}, []byte(":1\r\n")},
+		{"converted by a long value", &core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "convhash2"}}, []byte("$9\r\nhashtable\r\n")},
+	})
+}
+
+func TestHScanIteratesHashtable(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+
+	args := []string{"scanhash"}
+	for i := 0; i < 300; i++ {
+		args = append(args, "field"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	evalAs(c, "HSET", args...)
+	evalAs(c, "OBJECT", "ENCODING", "scanhash")
+	expectWrite(t, c, "$9\r\nhashtable\r\n")
+
+	seen := make(map[string]bool)
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		if calls > 300 {
+			t.Fatalf("scan did not terminate")
+		}
+		evalAs(c, "HSCAN", "scanhash", cursor, "COUNT", "20", "NOVALUES")
+		reply, err := core.Decode(c.LastWrite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := reply.([]interface{})
+		for _, f := range parts[1].([]interface{}) {
+			seen[f.(string)] = true
+		}
+		cursor = parts[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != 300 {
+		fields := make([]string, 0, len(seen))
+		for f := range seen {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		t.Fatalf("scan returned %d fields: %s", len(seen), strings.Join(fields, ","))
+	}
+}
+
+func TestHRandField(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "HSET", "randhash", "a", "1", "b", "2", "c", "3")
+
+	evalAs(c, "HRANDFIELD", "randhash", "10", "WITHVALUES")
+	reply, _ := core.Decode(c.LastWrite)
+	if n := len(reply.([]interface{})); n != 6 {
+		t.Fatalf("positive count returned %d items, want the 6 fields and values", n)
+	}
+
+	evalAs(c, "HRANDFIELD", "randhash", "-10")
+	reply, _ = core.Decode(c.LastWrite)
+	if n := len(reply.([]interface{})); n != 10 {
+		t.Fatalf("negative count returned %d fields, want 10", n)
+	}
+
+	// a huge negative count is refused before the picks are allocated
+	evalAs(c, "HRANDFIELD", "randhash", "-4611686018427387903")
+	expectWrite(t, c, "-ERR value is out of range, a negative count picks at most 16777216 elements\r\n")
+	evalAs(c, "HRANDFIELD", "randhash", "-16777217", "WITHVALUES")
+	expectWrite(t, c, "-ERR value is out of range, a negative count picks at most 16777216 elements\r\n")
+}
+
+func TestHashFieldExpiry(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"a hash", &core.RedisCmd{Cmd: "HSET", Args: []string{"flags", "a", "1", "b", "2", "c", "3"}}, []byte(":3\r\n")},
+		{"expire fields", &core.RedisCmd{Cmd: "HEXPIRE", Args: []string{"flags", "100", "FIELDS", "2", "a", "missing"}}, []byte("*2\r\n:1\r\n:-2\r\n")},
+		{"ttl of fields", &core.RedisCmd{Cmd: "HTTL", Args: []string{"flags", "FIELDS", "3", "a", "b", "missing"}}, []byte("*3\r\n:100\r\n:-1\r\n:-2\r\n")},
+		{"nx on a field with a ttl", &core.RedisCmd{Cmd: "HEXPIRE", Args: []string{"flags", "50", "NX", "FIELDS", "1", "a"}}, []byte("*1\r\n:0\r\n")},
+		{"xx on a field without ttl", &core.RedisCmd{Cmd: "HEXPIRE", Args: []string{"flags", "50", "XX", "FIELDS", "1", "b"}}, []byte("*1\r\n:0\r\n")},
+		{"gt with a lower ttl", &core.RedisCmd{Cmd: "HEXPIRE", Args: []string{"flags", "50", "GT", "FIELDS", "1", "a"}}, []byte("*1\r\n:0\r\n")},
+		{"lt with a lower ttl", &core.RedisCmd{Cmd: "HEXPIRE", Args: []string{"flags", "50", "LT", "FIELDS", "1", "a"}}, []byte("*1\r\n:1\r\n")},
+		{"lt on a field without ttl", &core.RedisCmd{Cmd: "HPEXPIRE", Args: []string{"flags", "50000", "LT", "FIELDS", "1", "b"}}, []byte("*1\r\n:1\r\n")},
+		{"ttl in milliseconds", &core.RedisCmd{Cmd: "HPTTL", Args: []string{"flags", "FIELDS", "1", "missing"}}, []byte("*1\r\n:-2\r\n")},
+		{"persist fields", &core.RedisCmd{Cmd: "HPERSIST", Args: []string{"flags", "FIELDS", "3", "a", "c", "missing"}}, []byte("*3\r\n:1\r\n:-1\r\n:-2\r\n")},
+		{"expire time of a field", &core.RedisCmd{Cmd: "HPEXPIREAT", Args: []string{"flags", "253402300800000", "FIELDS", "1", "c"}}, []byte("*1\r\n:1\r\n")},
+		{"read the expire time", &core.RedisCmd{Cmd: "HEXPIRETIME", Args: []string{"flags", "FIELDS", "1", "c"}}, []byte("*1\r\n:253402300800\r\n")},
+		{"hset discards the ttl", &core.RedisCmd{Cmd: "HSET", Args: []string{"flags", "c", "4"}}, []byte(":0\r\n")},
+		{"no ttl after hset", &core.RedisCmd{Cmd: "HTTL", Args: []string{"flags", "FIELDS", "1", "c"}}, []byte("*1\r\n:-1\r\n")},
+		{"a time in the past deletes the field", &core.RedisCmd{Cmd: "HEXPIREAT", Args: []string{"flags", "1", "FIELDS", "1", "c"}}, []byte("*1\r\n:2\r\n")},
+		{"the field is gone", &core.RedisCmd{Cmd: "HEXISTS", Args: []string{"flags", "c"}}, []byte(":0\r\n")},
+		{"get and delete fields", &core.RedisCmd{Cmd: "HGETDEL", Args: []string{"flags", "FIELDS", "2", "a", "missing"}}, []byte("*2\r\n$1\r\n1\r\n$-1\r\n")},
+		{"remaining fields", &core.RedisCmd{Cmd: "HKEYS", Args: []string{"flags"}}, []byte("*1\r\n$1\r\nb\r\n")},
+		{"ttl of a missing key", &core.RedisCmd{Cmd: "HTTL", Args: []string{"noflags", "FIELDS", "1", "a"}}, []byte("*1\r\n:-2\r\n")},
+		{"missing FIELDS", &core.RedisCmd{Cmd: "HEXPIRE", Args: []string{"flags", "10", "NX", "1", "a"}}, []byte("-ERR Mandatory argument FIELDS is missing or not at the right position\r\n")},
+		{"numfields mismatch", &core.RedisCmd{Cmd: "HTTL", Args: []string{"flags", "FIELDS", "2", "a"}}, []byte("-ERR The `numfields` parameter must match the number of arguments\r\n")},
+		{"zero numfields", &core.RedisCmd{Cmd: "HPERSIST", Args: []string{"flags", "FIELDS", "0", "a"}}, []byte("-ERR Parameter `numFields` should be greater than 0\r\n")},
+		{"negative time", &core.RedisCmd{Cmd: "HEXPIRE", Args: []string{"flags", "-1", "FIELDS", "1", "b"}}, []byte("-ERR invalid expire time, must be >= 0 and <= 281474976710655\r\n")},
+		{"delete the last field", &core.RedisCmd{Cmd: "HGETDEL", Args: []string{"flags", "FIELDS", "1", "b"}}, []byte("*1\r\n$1\r\n2\r\n")},
+		{"the emptied hash is deleted", &core.RedisCmd{Cmd: "TYPE", Args: []string{"flags"}}, []byte("+none\r\n")},
+	})
+}
+
+func TestHashFieldLazyAndActiveExpiry(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "HSET", "lazyhash", "short", "1", "long", "2")
+	evalAs(c, "HPEXPIRE", "lazyhash", "10", "FIELDS", "1", "short")
+	evalAs(c, "HSET", "activehash", "only", "1")
+	evalAs(c, "HPEXPIRE", "activehash", "10", "FIELDS", "1", "only")
+	time.Sleep(20 * time.Millisecond)
+
+	evalAs(c, "HGETALL", "lazyhash")
+	expectWrite(t, c, "*2\r\n$4\r\nlong\r\n$1\r\n2\r\n")
+
+	// the active cycle deletes the field, and the key with it, without the key being accessed
+	core.SafeDeleteExpiredKeys()
+	evalAs(c, "INFO", "stats")
+	if !bytes.Contains(c.LastWrite, []byte("expired_subkeys:")) {
+		t.Fatalf("INFO stats does not report expired_subkeys: %q", c.LastWrite)
+	}
+	evalAs(c, "TYPE", "activehash")
+	expectWrite(t, c, "+none\r\n")
+}
+
+func TestHashFieldExpiryRewrite(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "HSET", "aofhash", "a", "1", "b", "2")
+	evalAs(c, "HPEXPIREAT", "aofhash", "253402300800000", "FIELDS", "2", "b", "a")
+	evalAs(c, "BGREWRITEAOF")
+
+	content, _ := os.ReadFile(config.APPEND_ONLY_FILE)
+	os.Remove(config.APPEND_ONLY_FILE)
+	want := "*7\r\n$10\r\nHPEXPIREAT\r\n$7\r\nAOFHASH\r\n$15\r\n253402300800000\r\n$6\r\nFIELDS\r\n$1\r\n2\r\n$1\r\na\r\n$1\r\nb\r\n"
+	if !bytes.Contains(content, []byte(want)) {
+		t.Errorf("AOF content does not rebuild the field ttls:\n%q", content)
+	}
+}
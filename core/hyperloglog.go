@@ -0,0 +1,379 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"strconv"
+
+	"github.com/diceclone/config"
+)
+
+// HyperLogLogs are string values laid out exactly as redis lays them out, so that their raw bytes can be
+// moved between servers with GET and SET. A 16 bytes header holding the "HYLL" magic, the encoding and the
+// cached cardinality is followed by 16384 registers of 6 bits, either packed in the dense representation
+// or run length encoded in the sparse one
+const (
+	HLL_P            = 14
+	HLL_Q            = 64 - HLL_P
+	HLL_REGISTERS    = 1 << HLL_P
+	HLL_P_MASK       = HLL_REGISTERS - 1
+	HLL_BITS         = 6
+	HLL_REGISTER_MAX = 1<<HLL_BITS - 1
+	HLL_HDR_SIZE     = 16
+	HLL_DENSE_SIZE   = HLL_HDR_SIZE + (HLL_REGISTERS*HLL_BITS+7)/8
+	HLL_DENSE        = 0
+	HLL_SPARSE       = 1
+	HLL_ALPHA_INF    = 0.721347520444481703680
+
+	// sparse opcodes: ZERO 00xxxxxx is a run of up to 64 empty registers, XZERO 01xxxxxx yyyyyyyy a run of
+	// up to 16384 empty registers and VAL 1vvvvvxx a run of up to 4 registers set to a value up to 32
+	HLL_SPARSE_ZERO_MAX_LEN  = 64
+	HLL_SPARSE_XZERO_MAX_LEN = 16384
+	HLL_SPARSE_VAL_MAX_VALUE = 32
+	HLL_SPARSE_VAL_MAX_LEN   = 4
+)
+
+var errInvalidHLL = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+var errCorruptedHLL = errors.New("INVALIDOBJ Corrupted HLL object detected")
+
+// murmurHash64A is the hash function redis uses for HyperLogLogs, reading the key as little endian words
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ uint64(len(key))*m
+	words := len(key) / 8
+	for i := 0; i < words; i++ {
+		k := binary.LittleEndian.Uint64(key[i*8:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+
+	tail := key[words*8:]
+	if len(tail) > 0 {
+		for i := len(tail) - 1; i >= 0; i-- {
+			h ^= uint64(tail[i]) << (8 * i)
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register the element maps to and the length of the run of zeros ending its hash,
+// plus one, which is the value the register is raised to
+func hllPatLen(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, 0xadc83b19)
+	index := int(hash & HLL_P_MASK)
+	hash >>= HLL_P
+	// the sentinel bit makes sure the count stops at HLL_Q + 1
+	hash |= 1 << HLL_Q
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+// newHLL returns an empty sparse HyperLogLog, a single XZERO covers all the registers
+func newHLL() []byte {
+	b := make([]byte, HLL_HDR_SIZE, HLL_HDR_SIZE+2)
+	copy(b, "HYLL")
+	b[4] = HLL_SPARSE
+	return append(b, 0x40|byte((HLL_SPARSE_XZERO_MAX_LEN-1)>>8), byte((HLL_SPARSE_XZERO_MAX_LEN-1)&0xff))
+}
+
+// isValidHLL checks the header of the value, the content of sparse values is only checked while decoding
+func isValidHLL(b []byte) bool {
+	if len(b) < HLL_HDR_SIZE || string(b[:4]) != "HYLL" {
+		return false
+	}
+	switch b[4] {
+	case HLL_DENSE:
+		return len(b) == HLL_DENSE_SIZE
+	case HLL_SPARSE:
+		return true
+	default:
+		return false
+	}
+}
+
+// the most significant bit of the cached cardinality flags it as stale
+func hllInvalidateCache(b []byte) {
+	b[15] |= 1 << 7
+}
+
+func hllCachedCount(b []byte) (uint64, bool) {
+	if b[15]&(1<<7) != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(b[8:16]), true
+}
+
+func hllSetCachedCount(b []byte, count uint64) {
+	binary.LittleEndian.PutUint64(b[8:16], count)
+}
+
+// hllDenseGet reads the i-th register of the dense registers, registers are packed starting from
+// the least significant bits of each byte
+func hllDenseGet(regs []byte, i int) uint8 {
+	pos := i * HLL_BITS
+	b, fb := pos/8, uint(pos&7)
+	v := uint(regs[b]) >> fb
+	if fb > 8-HLL_BITS {
+		v |= uint(regs[b+1]) << (8 - fb)
+	}
+	return uint8(v & HLL_REGISTER_MAX)
+}
+
+func hllDenseSet(regs []byte, i int, value uint8) {
+	pos := i * HLL_BITS
+	b, fb := pos/8, uint(pos&7)
+	v := uint(value)
+	regs[b] &^= byte(HLL_REGISTER_MAX << fb)
+	regs[b] |= byte(v << fb)
+	if fb > 8-HLL_BITS {
+		regs[b+1] &^= byte(HLL_REGISTER_MAX >> (8 - fb))
+		regs[b+1] |= byte(v >> (8 - fb))
+	}
+}
+
+// hllRegisters returns the value of every register of the HyperLogLog
+func hllRegisters(b []byte) ([]uint8, error) {
+	regs := make([]uint8, HLL_REGISTERS)
+	if b[4] == HLL_DENSE {
+		for i := range regs {
+			regs[i] = hllDenseGet(b[HLL_HDR_SIZE:], i)
+		}
+		return regs, nil
+	}
+
+	idx := 0
+	for p := HLL_HDR_SIZE; p < len(b); p++ {
+		op := b[p]
+		switch {
+		case op&0xc0 == 0:
+			idx += int(op&0x3f) + 1
+		case op&0xc0 == 0x40:
+			if p+1 >= len(b) {
+				return nil, errCorruptedHLL
+			}
+			idx += (int(op&0x3f)<<8 | int(b[p+1])) + 1
+			p++
+		default:
+			runLen := int(op&0x3) + 1
+			if idx+runLen > HLL_REGISTERS {
+				return nil, errCorruptedHLL
+			}
+			for j := 0; j < runLen; j++ {
+				regs[idx+j] = (op>>2)&0x1f + 1
+			}
+			idx += runLen
+		}
+		if idx > HLL_REGISTERS {
+			return nil, errCorruptedHLL
+		}
+	}
+	if idx != HLL_REGISTERS {
+		return nil, errCorruptedHLL
+	}
+	return regs, nil
+}
+
+// hllEncodeSparse run length encodes the registers, it fails when a register is too large for the sparse
+// representation
+func hllEncodeSparse(regs []uint8) ([]byte, bool) {
+	var out []byte
+	for i := 0; i < len(regs); {
+		runLen := 1
+		for i+runLen < len(regs) && regs[i+runLen] == regs[i] {
+			runLen++
+		}
+
+		value := regs[i]
+		switch {
+		case value > HLL_SPARSE_VAL_MAX_VALUE:
+			return nil, false
+		case value == 0:
+			for left := runLen; left > 0; {
+				if left > HLL_SPARSE_ZERO_MAX_LEN {
+					n := min(left, HLL_SPARSE_XZERO_MAX_LEN)
+					out = append(out, 0x40|byte((n-1)>>8), byte((n-1)&0xff))
+					left -= n
+				} else {
+					out = append(out, byte(left-1))
+					left = 0
+				}
+			}
+		default:
+			for left := runLen; left > 0; {
+				n := min(left, HLL_SPARSE_VAL_MAX_LEN)
+				out = append(out, 0x80|(value-1)<<2|byte(n-1))
+				left -= n
+			}
+		}
+		i += runLen
+	}
+	return out, true
+}
+
+// hllFromRegisters builds a HyperLogLog holding the registers with a stale cached cardinality, it is
+// sparse when allowed and as long as it fits in hll-sparse-max-bytes
+func hllFromRegisters(regs []uint8, sparse bool) []byte {
+	header := make([]byte, HLL_HDR_SIZE)
+	copy(header, "HYLL")
+	hllInvalidateCache(header)
+
+	if sparse {
+		if payload, ok := hllEncodeSparse(regs); ok && HLL_HDR_SIZE+len(payload) <= config.HLL_SPARSE_MAX_BYTES {
+			header[4] = HLL_SPARSE
+			return append(header, payload...)
+		}
+	}
+
+	b := append(header, make([]byte, HLL_DENSE_SIZE-HLL_HDR_SIZE)...)
+	b[4] = HLL_DENSE
+	for i, v := range regs {
+		hllDenseSet(b[HLL_HDR_SIZE:], i, v)
+	}
+	return b
+}
+
+// hllAdd adds the elements and returns the updated HyperLogLog with whether a register changed. Dense
+// values are updated in place, sparse ones are rebuilt and promoted to dense when they outgrow the
+// sparse representation
+func hllAdd(b []byte, elements []string) ([]byte, bool, error) {
+	if b[4] == HLL_DENSE {
+		updated := false
+		for _, e := range elements {
+			index, count := hllPatLen([]byte(e))
+			if count > hllDenseGet(b[HLL_HDR_SIZE:], index) {
+				hllDenseSet(b[HLL_HDR_SIZE:], index, count)
+				updated = true
+			}
+		}
+		if updated {
+			hllInvalidateCache(b)
+		}
+		return b, updated, nil
+	}
+
+	regs, err := hllRegisters(b)
+	if err != nil {
+		return b, false, err
+	}
+	updated := false
+	for _, e := range elements {
+		index, count := hllPatLen([]byte(e))
+		if count > regs[index] {
+			regs[index] = count
+			updated = true
+		}
+	}
+	if !updated {
+		return b, false, nil
+	}
+	return hllFromRegisters(regs, true), true, nil
+}
+
+// hllSigma and hllTau are the corrections of the estimator described by Otmar Ertl in
+// "New cardinality estimation algorithms for HyperLogLog sketches", which redis uses since 5.0
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+// hllCount estimates the cardinality from the histogram of the register values
+func hllCount(regs []uint8) uint64 {
+	var histogram [HLL_Q + 2]int
+	for _, v := range regs {
+		histogram[v]++
+	}
+
+	m := float64(HLL_REGISTERS)
+	z := m * hllTau((m-float64(histogram[HLL_Q+1]))/m)
+	for j := HLL_Q; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(HLL_ALPHA_INF * m * m / z))
+}
+
+// hllSelfTest checks the dense register packing and that both representations agree on cardinalities
+// within the expected error
+func hllSelfTest() error {
+	rng := rand.New(rand.NewSource(1))
+	dense := make([]byte, HLL_DENSE_SIZE-HLL_HDR_SIZE)
+	expected := make([]uint8, HLL_REGISTERS)
+	for round := 0; round < 100; round++ {
+		for i := range expected {
+			expected[i] = uint8(rng.Intn(HLL_REGISTER_MAX + 1))
+			hllDenseSet(dense, i, expected[i])
+		}
+		for i, want := range expected {
+			if got := hllDenseGet(dense, i); got != want {
+				return fmt.Errorf("TESTFAILED Register error at %d", i)
+			}
+		}
+	}
+
+	relErr := 1.04 / math.Sqrt(HLL_REGISTERS)
+	sparseHLL, denseHLL := newHLL(), hllFromRegisters(make([]uint8, HLL_REGISTERS), false)
+	added := 0
+	for _, checkpoint := range []int{10, 100, 1000, 10000, 100000} {
+		var elements []string
+		for ; added < checkpoint; added++ {
+			elements = append(elements, strconv.Itoa(added))
+		}
+		sparseHLL, _, _ = hllAdd(sparseHLL, elements)
+		denseHLL, _, _ = hllAdd(denseHLL, elements)
+
+		sparseRegs, err := hllRegisters(sparseHLL)
+		if err != nil {
+			return err
+		}
+		denseRegs, _ := hllRegisters(denseHLL)
+		sparseCount, denseCount := hllCount(sparseRegs), hllCount(denseRegs)
+		if sparseCount != denseCount {
+			return fmt.Errorf("TESTFAILED sparse count %d does not match dense count %d", sparseCount, denseCount)
+		}
+		if maxErr := max(relErr*5*float64(checkpoint), 1); math.Abs(float64(denseCount)-float64(checkpoint)) > maxErr {
+			return fmt.Errorf("TESTFAILED too big error. card:%d abserr:%f", checkpoint, math.Abs(float64(denseCount)-float64(checkpoint)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package core_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/diceclone/config"
+	"github.com/diceclone/core"
+)
+
+const emptyHLL = "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff"
+
+func TestPFAddAndCount(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"pfadd without elements creates the key", &core.RedisCmd{Cmd: "PFADD", Args: []string{"visitors"}}, []byte(":1\r\n")},
+		{"empty hyperloglog is stored as redis stores it", &core.RedisCmd{Cmd: "GET", Args: []string{"visitors"}}, []byte("$18\r\n" + emptyHLL + "\r\n")},
+		{"pfcount of an empty hyperloglog", &core.RedisCmd{Cmd: "PFCOUNT", Args: []string{"visitors"}}, []byte(":0\r\n")},
+		{"pfadd elements", &core.RedisCmd{Cmd: "PFADD", Args: []string{"visitors", "a", "b", "c"}}, []byte(":1\r\n")},
+		{"pfadd elements already counted", &core.RedisCmd{Cmd: "PFADD", Args: []string{"visitors", "a", "b"}}, []byte(":0\r\n")},
+		{"pfadd the empty string", &core.RedisCmd{Cmd: "PFADD", Args: []string{"visitors", ""}}, []byte(":1\r\n")},
+		{"pfcount", &core.RedisCmd{Cmd: "PFCOUNT", Args: []string{"visitors"}}, []byte(":4\r\n")},
+		{"cached pfcount", &core.RedisCmd{Cmd: "PFCOUNT", Args: []string{"visitors"}}, []byte(":4\r\n")},
+		{"pfcount of a missing key", &core.RedisCmd{Cmd: "PFCOUNT", Args: []string{"nohll"}}, []byte(":0\r\n")},
+		{"set a plain string", &core.RedisCmd{Cmd: "SET", Args: []string{"plain", "hello"}}, []byte("+OK\r\n")},
+		{"pfadd on a plain string", &core.RedisCmd{Cmd: "PFADD", Args: []string{"plain", "a"}}, []byte("-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n")},
+		{"plain string keeps its encoding", &core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "plain"}}, []byte("$6\r\nembstr\r\n")},
+		{"push to a list", &core.RedisCmd{Cmd: "RPUSH", Args: []string{"alist", "a"}}, []byte(":1\r\n")},
+		{"pfcount on a list", &core.RedisCmd{Cmd: "PFCOUNT", Args: []string{"alist"}}, []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")},
+		{"pfadd without a key", &core.RedisCmd{Cmd: "PFADD", Args: []string{}}, []byte("-ERR wrong number of arguments for 'pfadd' command\r\n")},
+	})
+}
+
+func TestPFCountApproximation(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	for added := 0; added < 20000; {
+		var batch []string
+		for i := 0; i < 500; i++ {
+			batch = append(batch, "user:"+strconv.Itoa(added))
+			added++
+		}
+		evalAs(c, "PFADD", append([]string{"approx"}, batch...)...)
+
+		evalAs(c, "PFCOUNT", "approx")
+		count, _ := strconv.Atoi(string(c.LastWrite[1 : len(c.LastWrite)-2]))
+		if diff := count - added; diff*diff*2500 > added*added {
+			t.Fatalf("pfcount %d is more than 2%% off %d", count, added)
+		}
+	}
+
+	// 20000 elements do not fit the default hll-sparse-max-bytes
+	evalAs(c, "PFDEBUG", "ENCODING", "approx")
+	expectWrite(t, c, "+dense\r\n")
+}
+
+func TestHLLSparseToDense(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "PFADD", "small", "a", "b", "c", "d")
+	evalAs(c, "PFDEBUG", "ENCODING", "small")
+	expectWrite(t, c, "+sparse\r\n")
+	evalAs(c, "PFCOUNT", "small")
+	expectWrite(t, c, ":4\r\n")
+
+	evalAs(c, "PFDEBUG", "TODENSE", "small")
+	expectWrite(t, c, ":1\r\n")
+	evalAs(c, "PFDEBUG", "TODENSE", "small")
+	expectWrite(t, c, ":0\r\n")
+	evalAs(c, "STRLEN", "small")
+	expectWrite(t, c, ":12304\r\n")
+	evalAs(c, "PFCOUNT", "small")
+	expectWrite(t, c, ":4\r\n")
+	evalAs(c, "PFDEBUG", "DECODE", "small")
+	expectWrite(t, c, "-ERR HLL encoding is not sparse\r\n")
+
+	defer func(maxBytes int) { config.HLL_SPARSE_MAX_BYTES = maxBytes }(config.HLL_SPARSE_MAX_BYTES)
+	config.HLL_SPARSE_MAX_BYTES = 30
+	evalAs(c, "PFADD", "promoted", "a", "b", "c", "d", "e", "f", "g", "h")
+	evalAs(c, "PFDEBUG", "ENCODING", "promoted")
+	expectWrite(t, c, "+dense\r\n")
+	evalAs(c, "PFCOUNT", "promoted")
+	expectWrite(t, c, ":8\r\n")
+}
+
+func TestPFDebug(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "PFADD", "dbg")
+	evalAs(c, "PFDEBUG", "DECODE", "dbg")
+	expectWrite(t, c, "+XZ:16384\r\n")
+
+	evalAs(c, "PFDEBUG", "GETREG", "dbg")
+	if got := len(arrayOfIntegers(t, c)); got != 16384 {
+		t.Errorf("got %d registers, want 16384", got)
+	}
+	evalAs(c, "PFDEBUG", "ENCODING", "dbg")
+	expectWrite(t, c, "+dense\r\n")
+
+	evalAs(c, "PFDEBUG", "GETREG", "nohll")
+	expectWrite(t, c, "-ERR The specified key does not exist\r\n")
+	evalAs(c, "PFDEBUG", "NOPE", "dbg")
+	expectWrite(t, c, "-ERR Unknown PFDEBUG subcommand 'NOPE'\r\n")
+	evalAs(c, "PFSELFTEST")
+	expectWrite(t, c, "+OK\r\n")
+}
+
+func TestPFMerge(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "PFADD", "hll1", "a", "b", "c")
+	evalAs(c, "PFADD", "hll2", "b", "c", "d", "e")
+
+	evalAs(c, "PFCOUNT", "hll1", "hll2", "nohll")
+	expectWrite(t, c, ":5\r\n")
+	evalAs(c, "PFMERGE", "merged", "hll1", "hll2")
+	expectWrite(t, c, "+OK\r\n")
+	evalAs(c, "PFCOUNT", "merged")
+	expectWrite(t, c, ":5\r\n")
+	evalAs(c, "PFDEBUG", "ENCODING", "merged")
+	expectWrite(t, c, "+sparse\r\n")
+
+	// the destination takes part in the union
+	evalAs(c, "PFADD", "hll3", "f")
+	evalAs(c, "PFDEBUG", "TODENSE", "hll3")
+	evalAs(c, "PFMERGE", "merged", "hll3")
+	evalAs(c, "PFCOUNT", "merged")
+	expectWrite(t, c, ":6\r\n")
+	evalAs(c, "PFDEBUG", "ENCODING", "merged")
+	expectWrite(t, c, "+dense\r\n")
+
+	evalAs(c, "SET", "plain", "hello")
+	evalAs(c, "PFMERGE", "merged", "plain")
+	expectWrite(t, c, "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n")
+}
+
+func TestHLLRawBytesInterop(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "PFADD", "source", "x", "y", "z")
+	evalAs(c, "GET", "source")
+	reply, _ := core.Decode(c.LastWrite)
+	evalAs(c, "SET", "copy", reply.(string))
+	evalAs(c, "PFCOUNT", "copy")
+	expectWrite(t, c, ":3\r\n")
+
+	// a dense value is larger than a single read, with a register byte set to \r it goes through
+	// the RESP decoder the way a client sends it
+	var elements []string
+	for i := 0; i < 20000; i++ {
+		elements = append(elements, "element:"+strconv.Itoa(i))
+	}
+	evalAs(c, "PFADD", append([]string{"densesource"}, elements...)...)
+	evalAs(c, "GET", "densesource")
+	reply, err := core.Decode(c.LastWrite)
+	if err != nil {
+		t.Fatalf("cannot decode the dense value: %v", err)
+	}
+	raw := []byte(reply.(string))
+	raw[100] = '\r'
+	// the cached cardinality is marked stale since a register changed
+	raw[15] |= 0x80
+	evalAs(c, "SET", "densewant", string(raw))
+	evalAs(c, "PFCOUNT", "densewant")
+	count := string(c.LastWrite)
+
+	tokens, err := core.DecodeArrayString(core.Encode([]string{"SET", "densecopy", string(raw)}, false))
+	if err != nil {
+		t.Fatalf("cannot decode the SET command: %v", err)
+	}
+	core.EvalAndRespond(&core.RedisCmd{Cmd: tokens[0], Args: tokens[1:]}, c, core.RealTimeProvider{})
+	expectWrite(t, c, "+OK\r\n")
+	evalAs(c, "PFCOUNT", "densecopy")
+	expectWrite(t, c, count)
+	evalAs(c, "GET", "densewant")
+	want := string(c.LastWrite)
+	evalAs(c, "GET", "densecopy")
+	expectWrite(t, c, want)
+
+	// an empty hyperloglog as written by redis, and the same one with a register run missing
+	evalAs(c, "SET", "fromredis", emptyHLL)
+	evalAs(c, "PFADD", "fromredis", "a")
+	expectWrite(t, c, ":1\r\n")
+	evalAs(c, "PFCOUNT", "fromredis")
+	expectWrite(t, c, ":1\r\n")
+
+	evalAs(c, "SET", "corrupted", "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xfe")
+	evalAs(c, "PFCOUNT", "corrupted")
+	expectWrite(t, c, "-INVALIDOBJ Corrupted HLL object detected\r\n")
+	evalAs(c, "SET", "badheader", "HYLL\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff")
+	evalAs(c, "PFCOUNT", "badheader")
+	expectWrite(t, c, "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n")
+}
+
+func arrayOfIntegers(t *testing.T, c *MockReadWriter) []int64 {
+	t.Helper()
+	reply, err := core.Decode(c.LastWrite)
+	if err != nil {
+		t.Fatalf("cannot decode %q: %v", c.LastWrite, err)
+	}
+	var out []int64
+	for _, e := range reply.([]interface{}) {
+		out = append(out, e.(int64))
+	}
+	return out
+}
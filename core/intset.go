@@ -0,0 +1,54 @@
+package core
+
+import "sort"
+
+// intset is the compact encoding of sets made of integers only, the values are kept sorted
+// so that lookups are binary searches
+type intset struct {
+	values []int64
+}
+
+func newIntset() *intset {
+	return &intset{}
+}
+
+func (is *intset) Len() int {
+	return len(is.values)
+}
+
+// search returns the position of the value and whether it is present
+func (is *intset) search(v int64) (int, bool) {
+	i := sort.Search(len(is.values), func(i int) bool { return is.values[i] >= v })
+	return i, i < len(is.values) && is.values[i] == v
+}
+
+func (is *intset) Contains(v int64) bool {
+	_, ok := is.search(v)
+	return ok
+}
+
+// Add inserts the value and tells whether it was not present
+func (is *intset) Add(v int64) bool {
+	i, ok := is.search(v)
+	if ok {
+		return false
+	}
+	is.values = append(is.values, 0)
+	copy(is.values[i+1:], is.values[i:])
+	is.values[i] = v
+	return true
+}
+
+// Remove deletes the value and tells whether it was present
+func (is *intset) Remove(v int64) bool {
+	i, ok := is.search(v)
+	if !ok {
+		return false
+	}
+	is.values = append(is.values[:i], is.values[i+1:]...)
+	return true
+}
+
+func (is *intset) Get(i int) int64 {
+	return is.values[i]
+}
@@ -0,0 +1,723 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	JSON_NULL uint8 = iota
+	JSON_BOOL
+	JSON_INT
+	JSON_FLOAT
+	JSON_STRING
+	JSON_ARRAY
+	JSON_OBJECT
+)
+
+var jsonTypeNames = []string{"null", "boolean", "integer", "number", "string", "array", "object"}
+
+// jsonValue is a node of a JSON document, integers are kept apart from floats and objects remember
+// the order in which their keys were inserted
+type jsonValue struct {
+	kind   uint8
+	b      bool
+	i      int64
+	f      float64
+	s      string
+	arr    []*jsonValue
+	keys   []string
+	fields map[string]*jsonValue
+}
+
+func newJSONObject() *jsonValue {
+	return &jsonValue{kind: JSON_OBJECT, keys: []string{}, fields: make(map[string]*jsonValue)}
+}
+
+func (v *jsonValue) typeName() string {
+	return jsonTypeNames[v.kind]
+}
+
+func (v *jsonValue) isNumber() bool {
+	return v.kind == JSON_INT || v.kind == JSON_FLOAT
+}
+
+func (v *jsonValue) float() float64 {
+	if v.kind == JSON_INT {
+		return float64(v.i)
+	}
+	return v.f
+}
+
+// set adds the field at the end of the object or replaces its value in place
+func (v *jsonValue) set(key string, value *jsonValue) {
+	if _, ok := v.fields[key]; !ok {
+		v.keys = append(v.keys, key)
+	}
+	v.fields[key] = value
+}
+
+func (v *jsonValue) remove(key string) bool {
+	if _, ok := v.fields[key]; !ok {
+		return false
+	}
+	delete(v.fields, key)
+	for i, k := range v.keys {
+		if k == key {
+			v.keys = append(v.keys[:i], v.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// children returns the elements of an array or the values of an object in order
+func (v *jsonValue) children() []*jsonValue {
+	switch v.kind {
+	case JSON_ARRAY:
+		return v.arr
+	case JSON_OBJECT:
+		out := make([]*jsonValue, len(v.keys))
+		for i, k := range v.keys {
+			out[i] = v.fields[k]
+		}
+		return out
+	}
+	return nil
+}
+
+func (v *jsonValue) clone() *jsonValue {
+	c := *v
+	switch v.kind {
+	case JSON_ARRAY:
+		c.arr = make([]*jsonValue, len(v.arr))
+		for i, e := range v.arr {
+			c.arr[i] = e.clone()
+		}
+	case JSON_OBJECT:
+		c.keys = append([]string{}, v.keys...)
+		c.fields = make(map[string]*jsonValue, len(v.fields))
+		for k, e := range v.fields {
+			c.fields[k] = e.clone()
+		}
+	}
+	return &c
+}
+
+// parseJSON parses a JSON text with the tokenizer of encoding/json, which keeps the order of the keys
+func parseJSON(s string) (*jsonValue, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	v, err := decodeJSONValue(dec)
+	if err == nil {
+		if _, trailing := dec.Token(); trailing != io.EOF {
+			err = errors.New("trailing characters")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ERR invalid JSON, %v", err)
+	}
+	return v, nil
+}
+
+func decodeJSONValue(dec *json.Decoder) (*jsonValue, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case nil:
+		return &jsonValue{kind: JSON_NULL}, nil
+	case bool:
+		return &jsonValue{kind: JSON_BOOL, b: t}, nil
+	case string:
+		return &jsonValue{kind: JSON_STRING, s: t}, nil
+	case json.Number:
+		if !strings.ContainsAny(string(t), ".eE") {
+			// an integer that does not fit an int64 would silently lose digits as a float
+			i, err := strconv.ParseInt(string(t), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("integer %s overflows a 64 bit integer", t)
+			}
+			return &jsonValue{kind: JSON_INT, i: i}, nil
+		}
+		f, err := strconv.ParseFloat(string(t), 64)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonValue{kind: JSON_FLOAT, f: f}, nil
+	case json.Delim:
+		var v *jsonValue
+		if t == '[' {
+			v = &jsonValue{kind: JSON_ARRAY, arr: []*jsonValue{}}
+		} else {
+			v = newJSONObject()
+		}
+		for dec.More() {
+			key := ""
+			if v.kind == JSON_OBJECT {
+				kt, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key = kt.(string)
+			}
+			e, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			if v.kind == JSON_OBJECT {
+				v.set(key, e)
+			} else {
+				v.arr = append(v.arr, e)
+			}
+		}
+		// the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unexpected token %v", tok)
+}
+
+// jsonFormat holds the INDENT, NEWLINE and SPACE strings of JSON.GET, all empty for the compact form
+type jsonFormat struct {
+	indent, newline, space string
+}
+
+func (v *jsonValue) String() string {
+	return v.format(&jsonFormat{})
+}
+
+func (v *jsonValue) format(f *jsonFormat) string {
+	var b strings.Builder
+	v.write(&b, f, 0)
+	return b.String()
+}
+
+func (v *jsonValue) write(b *strings.Builder, f *jsonFormat, depth int) {
+	switch v.kind {
+	case JSON_NULL:
+		b.WriteString("null")
+	case JSON_BOOL:
+		b.WriteString(strconv.FormatBool(v.b))
+	case JSON_INT:
+		b.WriteString(strconv.FormatInt(v.i, 10))
+	case JSON_FLOAT:
+		b.WriteString(formatJSONFloat(v.f))
+	case JSON_STRING:
+		b.WriteString(quoteJSONString(v.s))
+	case JSON_ARRAY, JSON_OBJECT:
+		open, close := "[", "]"
+		if v.kind == JSON_OBJECT {
+			open, close = "{", "}"
+		}
+		b.WriteString(open)
+		children := v.children()
+		for i, e := range children {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(f.newline)
+			b.WriteString(strings.Repeat(f.indent, depth+1))
+			if v.kind == JSON_OBJECT {
+				b.WriteString(quoteJSONString(v.keys[i]))
+				b.WriteString(":")
+				b.WriteString(f.space)
+			}
+			e.write(b, f, depth+1)
+		}
+		if len(children) > 0 {
+			b.WriteString(f.newline)
+			b.WriteString(strings.Repeat(f.indent, depth))
+		}
+		b.WriteString(close)
+	}
+}
+
+// quoteJSONString escapes only what JSON requires, unlike json.Marshal which also escapes HTML
+func quoteJSONString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// formatJSONFloat renders floats so that they read back as floats, 3.0 keeps its fractional part
+func formatJSONFloat(f float64) string {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return "null"
+	}
+	exp := math.Abs(f)
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if exp != 0 && (exp < 1e-5 || exp >= 1e16) {
+		s = strconv.FormatFloat(f, 'e', -1, 64)
+	}
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+const (
+	JSON_STEP_KEY uint8 = iota
+	JSON_STEP_WILDCARD
+	JSON_STEP_INDEX
+	JSON_STEP_SLICE
+	JSON_STEP_FILTER
+)
+
+// jsonPathStep selects children of the matches of the previous step, recursive steps select among
+// the matches and all their descendants
+type jsonPathStep struct {
+	kind      uint8
+	recursive bool
+	keys      []string
+	indexes   []int
+	// a slice start:end:step, start and end are only set when hasStart and hasEnd are
+	start, end, step int
+	hasStart, hasEnd bool
+	filter           *jsonFilter
+}
+
+// jsonPath is a parsed path, legacy paths are the dotted paths not starting with $ whose commands
+// reply about the first match only
+type jsonPath struct {
+	text   string
+	legacy bool
+	steps  []jsonPathStep
+}
+
+// isRoot reports whether the path selects the whole document
+func (p *jsonPath) isRoot() bool {
+	return len(p.steps) == 0
+}
+
+func errJSONPath(path string) error {
+	return fmt.Errorf("ERR invalid JSONPath '%s'", path)
+}
+
+// parseJSONPath parses the JSONPath subset of $, .key, ['key'], [n], [*], .*, [start:end:step],
+// [n,m], ..key and [?(@.key op value)] filters, legacy paths accept the same steps without the $
+func parseJSONPath(text string) (*jsonPath, error) {
+	p := &jsonPath{text: text}
+	rest := text
+	switch {
+	case strings.HasPrefix(rest, "$"):
+		rest = rest[1:]
+	case rest == ".":
+		p.legacy = true
+		return p, nil
+	default:
+		p.legacy = true
+		if !strings.HasPrefix(rest, ".") && !strings.HasPrefix(rest, "[") {
+			rest = "." + rest
+		}
+	}
+
+	recursive := false
+	for len(rest) > 0 {
+		var step jsonPathStep
+		switch {
+		case strings.HasPrefix(rest, "["):
+			end := closingBracket(rest)
+			if end < 0 {
+				return nil, errJSONPath(text)
+			}
+			var err error
+			if step, err = parseJSONBracket(rest[1:end]); err != nil {
+				return nil, errJSONPath(text)
+			}
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, ".") && !recursive:
+			if strings.HasPrefix(rest, "..") {
+				recursive = true
+				rest = rest[2:]
+				// the step selected among the descendants may be a bracket
+				if strings.HasPrefix(rest, "[") {
+					continue
+				}
+			} else {
+				rest = rest[1:]
+			}
+			name := rest
+			if i := strings.IndexAny(rest, ".["); i >= 0 {
+				name = rest[:i]
+			}
+			if name == "" {
+				return nil, errJSONPath(text)
+			}
+			rest = rest[len(name):]
+			if name == "*" {
+				step.kind = JSON_STEP_WILDCARD
+			} else {
+				step.kind, step.keys = JSON_STEP_KEY, []string{name}
+			}
+		default:
+			return nil, errJSONPath(text)
+		}
+		step.recursive, recursive = recursive, false
+		p.steps = append(p.steps, step)
+	}
+	if recursive {
+		return nil, errJSONPath(text)
+	}
+	return p, nil
+}
+
+// closingBracket returns the index of the bracket closing the one s starts with, skipping quoted strings
+func closingBracket(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func parseJSONBracket(s string) (jsonPathStep, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "*":
+		return jsonPathStep{kind: JSON_STEP_WILDCARD}, nil
+	case strings.HasPrefix(s, "?(") && strings.HasSuffix(s, ")"):
+		f, err := parseJSONFilter(s[2 : len(s)-1])
+		return jsonPathStep{kind: JSON_STEP_FILTER, filter: f}, err
+	case strings.HasPrefix(s, "'") || strings.HasPrefix(s, "\""):
+		step := jsonPathStep{kind: JSON_STEP_KEY}
+		for _, part := range splitOutsideQuotes(s, ",") {
+			key, ok := unquoteJSONPathString(strings.TrimSpace(part))
+			if !ok {
+				return step, errSyntax
+			}
+			step.keys = append(step.keys, key)
+		}
+		return step, nil
+	case strings.Contains(s, ":"):
+		step := jsonPathStep{kind: JSON_STEP_SLICE, step: 1}
+		parts := strings.Split(s, ":")
+		if len(parts) > 3 {
+			return step, errSyntax
+		}
+		for i, part := range parts {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return step, err
+			}
+			switch i {
+			case 0:
+				step.start, step.hasStart = n, true
+			case 1:
+				step.end, step.hasEnd = n, true
+			case 2:
+				if n <= 0 {
+					return step, errSyntax
+				}
+				step.step = n
+			}
+		}
+		return step, nil
+	default:
+		step := jsonPathStep{kind: JSON_STEP_INDEX}
+		for _, part := range strings.Split(s, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return step, err
+			}
+			step.indexes = append(step.indexes, n)
+		}
+		return step, nil
+	}
+}
+
+func unquoteJSONPathString(s string) (string, bool) {
+	if len(s) < 2 || (s[0] != '\'' && s[0] != '"') || s[len(s)-1] != s[0] {
+		return "", false
+	}
+	inner := s[1 : len(s)-1]
+	if s[0] == '\'' {
+		inner = strings.ReplaceAll(strings.ReplaceAll(inner, "\\'", "'"), "\"", "\\\"")
+	}
+	out, err := strconv.Unquote("\"" + inner + "\"")
+	return out, err == nil
+}
+
+// splitOutsideQuotes splits s around sep where it does not appear inside a quoted string
+func splitOutsideQuotes(s string, sep string) []string {
+	var parts []string
+	var quote byte
+	last := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[last:i])
+			i += len(sep) - 1
+			last = i + 1
+		}
+	}
+	return append(parts, s[last:])
+}
+
+// jsonFilter is a filter expression, a disjunction of conjunctions of comparisons
+type jsonFilter struct {
+	any [][]jsonComparison
+}
+
+// jsonComparison compares the value at a path relative to the element with a literal, an empty
+// operator only checks that the path exists
+type jsonComparison struct {
+	path  *jsonPath
+	op    string
+	value *jsonValue
+}
+
+var jsonFilterOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func parseJSONFilter(s string) (*jsonFilter, error) {
+	f := &jsonFilter{}
+	for _, disjunct := range splitOutsideQuotes(s, "||") {
+		var all []jsonComparison
+		for _, term := range splitOutsideQuotes(disjunct, "&&") {
+			c, err := parseJSONComparison(strings.TrimSpace(term))
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, c)
+		}
+		f.any = append(f.any, all)
+	}
+	return f, nil
+}
+
+func parseJSONComparison(s string) (jsonComparison, error) {
+	c := jsonComparison{}
+	left, right := s, ""
+	for _, op := range jsonFilterOperators {
+		if parts := splitOutsideQuotes(s, op); len(parts) == 2 {
+			c.op, left, right = op, strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			break
+		}
+	}
+	if !strings.HasPrefix(left, "@") {
+		return c, errSyntax
+	}
+	path, err := parseJSONPath("$" + left[1:])
+	if err != nil {
+		return c, err
+	}
+	c.path = path
+	if c.op == "" {
+		return c, nil
+	}
+
+	if strings.HasPrefix(right, "'") {
+		str, ok := unquoteJSONPathString(right)
+		if !ok {
+			return c, errSyntax
+		}
+		c.value = &jsonValue{kind: JSON_STRING, s: str}
+		return c, nil
+	}
+	c.value, err = parseJSON(right)
+	return c, err
+}
+
+func (f *jsonFilter) matches(v *jsonValue) bool {
+	for _, all := range f.any {
+		ok := true
+		for _, c := range all {
+			ok = ok && c.matches(v)
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *jsonComparison) matches(v *jsonValue) bool {
+	found := c.path.eval(v)
+	if len(found) == 0 {
+		return false
+	}
+	if c.op == "" {
+		return true
+	}
+
+	left, right := found[0].value, c.value
+	cmp := 0
+	switch {
+	case left.kind == JSON_INT && right.kind == JSON_INT:
+		cmp = compareOrdered(left.i, right.i)
+	case left.isNumber() && right.isNumber():
+		cmp = compareOrdered(left.float(), right.float())
+	case left.kind == JSON_STRING && right.kind == JSON_STRING:
+		cmp = strings.Compare(left.s, right.s)
+	default:
+		// other values are only equal to themselves
+		equal := left.String() == right.String()
+		return (c.op == "==" && equal) || (c.op == "!=" && !equal)
+	}
+
+	switch c.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func compareOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// jsonMatch is a value found by a path along with where it is held, parent is nil for the root
+type jsonMatch struct {
+	value  *jsonValue
+	parent *jsonValue
+	key    string
+}
+
+func (p *jsonPath) eval(root *jsonValue) []jsonMatch {
+	matches := []jsonMatch{{value: root}}
+	for _, step := range p.steps {
+		var next []jsonMatch
+		for _, m := range matches {
+			if step.recursive {
+				for _, d := range jsonDescendants(m) {
+					next = append(next, step.apply(d.value)...)
+				}
+			} else {
+				next = append(next, step.apply(m.value)...)
+			}
+		}
+		matches = next
+	}
+	return matches
+}
+
+// jsonDescendants returns the match and every value below it, parents first
+func jsonDescendants(m jsonMatch) []jsonMatch {
+	out := []jsonMatch{m}
+	for i, c := range m.value.children() {
+		child := jsonMatch{value: c, parent: m.value}
+		if m.value.kind == JSON_OBJECT {
+			child.key = m.value.keys[i]
+		}
+		out = append(out, jsonDescendants(child)...)
+	}
+	return out
+}
+
+// apply returns the children of v the step selects
+func (s *jsonPathStep) apply(v *jsonValue) []jsonMatch {
+	var out []jsonMatch
+	switch s.kind {
+	case JSON_STEP_KEY:
+		if v.kind != JSON_OBJECT {
+			return nil
+		}
+		for _, k := range s.keys {
+			if c, ok := v.fields[k]; ok {
+				out = append(out, jsonMatch{value: c, parent: v, key: k})
+			}
+		}
+	case JSON_STEP_WILDCARD, JSON_STEP_FILTER:
+		for i, c := range v.children() {
+			if s.kind == JSON_STEP_FILTER && !s.filter.matches(c) {
+				continue
+			}
+			m := jsonMatch{value: c, parent: v}
+			if v.kind == JSON_OBJECT {
+				m.key = v.keys[i]
+			}
+			out = append(out, m)
+		}
+	case JSON_STEP_INDEX:
+		if v.kind != JSON_ARRAY {
+			return nil
+		}
+		for _, i := range s.indexes {
+			if i < 0 {
+				i += len(v.arr)
+			}
+			if i >= 0 && i < len(v.arr) {
+				out = append(out, jsonMatch{value: v.arr[i], parent: v})
+			}
+		}
+	case JSON_STEP_SLICE:
+		if v.kind != JSON_ARRAY {
+			return nil
+		}
+		n := len(v.arr)
+		start, end := 0, n
+		if s.hasStart {
+			start = s.start
+		}
+		if s.hasEnd {
+			end = s.end
+		}
+		if start < 0 {
+			start += n
+		}
+		if end < 0 {
+			end += n
+		}
+		start, end = max(start, 0), min(end, n)
+		for i := start; i < end; i += s.step {
+			out = append(out, jsonMatch{value: v.arr[i], parent: v})
+		}
+	}
+	return out
+}
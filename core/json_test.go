@@ -0,0 +1,137 @@
+package core_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/diceclone/config"
+	"github.com/diceclone/core"
+)
+
+const storeJSON = `{"store":{"book":[{"title":"Sayings","price":8.95,"tags":["wisdom"]},{"title":"Sword","price":12.99,"isbn":"0-553"}],"bicycle":{"color":"red","price":19}}}`
+
+func TestJSONSetGet(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"set a document", &core.RedisCmd{Cmd: "JSON.SET", Args: []string{"doc", "$", `{"b":1, "a":[1, 2.5, "x"], "c":{"d":null, "e":true}}`}}, []byte("+OK\r\n")},
+		{"get keeps the order of the keys", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc"}}, []byte("$47\r\n{\"b\":1,\"a\":[1,2.5,\"x\"],\"c\":{\"d\":null,\"e\":true}}\r\n")},
+		{"get a jsonpath", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc", "$.a[1]"}}, []byte("$5\r\n[2.5]\r\n")},
+		{"get a legacy path", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc", ".c.e"}}, []byte("$4\r\ntrue\r\n")},
+		{"get a missing jsonpath", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc", "$.z"}}, []byte("$2\r\n[]\r\n")},
+		{"get a missing legacy path", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc", "z"}}, []byte("-ERR Path 'z' does not exist\r\n")},
+		{"get several paths", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc", "$.b", "$.c.d"}}, []byte("$26\r\n{\"$.b\":[1],\"$.c.d\":[null]}\r\n")},
+		{"get several legacy paths", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc", "b", ".a[-1]"}}, []byte("$20\r\n{\"b\":1,\".a[-1]\":\"x\"}\r\n")},
+		{"get formatted", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc", "INDENT", "  ", "NEWLINE", "\n", "SPACE", " ", "$.c"}}, []byte("$40\r\n[\n  {\n    \"d\": null,\n    \"e\": true\n  }\n]\r\n")},
+		{"set a nested value", &core.RedisCmd{Cmd: "JSON.SET", Args: []string{"doc", "$.c.d", `[]`}}, []byte("+OK\r\n")},
+		{"add a key to an object", &core.RedisCmd{Cmd: "JSON.SET", Args: []string{"doc", "$.c.f", `"new"`}}, []byte("+OK\r\n")},
+		{"nested values", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc", "$.c"}}, []byte("$29\r\n[{\"d\":[],\"e\":true,\"f\":\"new\"}]\r\n")},
+		{"set nx on an existing path", &core.RedisCmd{Cmd: "JSON.SET", Args: []string{"doc", "$.b", "2", "NX"}}, []byte("$-1\r\n")},
+		{"set xx on a missing path", &core.RedisCmd{Cmd: "JSON.SET", Args: []string{"doc", "$.z", "2", "XX"}}, []byte("$-1\r\n")},
+		{"set below a missing parent", &core.RedisCmd{Cmd: "JSON.SET", Args: []string{"doc", "$.x.y", "2"}}, []byte("$-1\r\n")},
+		{"set a new key below the root", &core.RedisCmd{Cmd: "JSON.SET", Args: []string{"other", "$.a", "1"}}, []byte("-ERR new objects must be created at the root\r\n")},
+		{"set invalid json", &core.RedisCmd{Cmd: "JSON.SET", Args: []string{"other", "$", "{\"a\":"}}, []byte("-ERR invalid JSON, EOF\r\n")},
+		{"set an integer past int64", &core.RedisCmd{Cmd: "JSON.SET", Args: []string{"other", "$", `{"id":12345678901234567890}`}}, []byte("-ERR invalid JSON, integer 12345678901234567890 overflows a 64 bit integer\r\n")},
+		{"set the smallest int64", &core.RedisCmd{Cmd: "JSON.SET", Args: []string{"other", "$", `[-9223372036854775808,9223372036854775807]`}}, []byte("+OK\r\n")},
+		{"integers keep every digit", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"other"}}, []byte("$42\r\n[-9223372036854775808,9223372036854775807]\r\n")},
+		{"set trailing characters", &core.RedisCmd{Cmd: "JSON.SET", Args: []string{"other", "$", "1 2"}}, []byte("-ERR invalid JSON, trailing characters\r\n")},
+		{"type of the key", &core.RedisCmd{Cmd: "TYPE", Args: []string{"doc"}}, []byte("+ReJSON-RL\r\n")},
+		{"get on a missing key", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"nojson"}}, []byte("$-1\r\n")},
+		{"set a plain string", &core.RedisCmd{Cmd: "SET", Args: []string{"plain", "x"}}, []byte("+OK\r\n")},
+		{"get on a string", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"plain"}}, []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")},
+		{"scalar documents", &core.RedisCmd{Cmd: "JSON.SET", Args: []string{"scalar", ".", `"<a&b>"`}}, []byte("+OK\r\n")},
+		{"strings are not html escaped", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"scalar"}}, []byte("$7\r\n\"<a&b>\"\r\n")},
+	})
+}
+
+func TestJSONPathQueries(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "JSON.SET", "store", "$", storeJSON)
+	runCommandCases(t, []commandCase{
+		{"recursive descent", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"store", "$..price"}}, []byte("$15\r\n[8.95,12.99,19]\r\n")},
+		{"wildcard", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"store", "$.store.bicycle.*"}}, []byte("$10\r\n[\"red\",19]\r\n")},
+		{"bracket keys", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"store", `$['store']["bicycle"]['color','price']`}}, []byte("$10\r\n[\"red\",19]\r\n")},
+		{"slice", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"store", "$.store.book[0:1].title"}}, []byte("$11\r\n[\"Sayings\"]\r\n")},
+		{"index union", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"store", "$.store.book[1,0].title"}}, []byte("$19\r\n[\"Sword\",\"Sayings\"]\r\n")},
+		{"filter", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"store", "$.store.book[?(@.price < 10)].title"}}, []byte("$11\r\n[\"Sayings\"]\r\n")},
+		{"filter on existence", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"store", "$..book[?(@.isbn)].title"}}, []byte("$9\r\n[\"Sword\"]\r\n")},
+		{"filter on strings with or", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"store", `$..book[?(@.title == 'Sword' || @.price > 100)].price`}}, []byte("$7\r\n[12.99]\r\n")},
+		{"filter with and", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"store", `$..book[?(@.price > 1 && @.tags)].title`}}, []byte("$11\r\n[\"Sayings\"]\r\n")},
+		{"recursive wildcard on arrays", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"store", "$..tags[*]"}}, []byte("$10\r\n[\"wisdom\"]\r\n")},
+		{"types", &core.RedisCmd{Cmd: "JSON.TYPE", Args: []string{"store", "$..bicycle.*"}}, []byte("*2\r\n$6\r\nstring\r\n$7\r\ninteger\r\n")},
+		{"legacy type", &core.RedisCmd{Cmd: "JSON.TYPE", Args: []string{"store", ".store.book[0].price"}}, []byte("$6\r\nnumber\r\n")},
+		{"type of the root", &core.RedisCmd{Cmd: "JSON.TYPE", Args: []string{"store"}}, []byte("$6\r\nobject\r\n")},
+		{"object keys", &core.RedisCmd{Cmd: "JSON.OBJKEYS", Args: []string{"store", "$.store.*"}}, []byte("*2\r\n$-1\r\n*2\r\n$5\r\ncolor\r\n$5\r\nprice\r\n")},
+		{"legacy object keys", &core.RedisCmd{Cmd: "JSON.OBJKEYS", Args: []string{"store", ".store"}}, []byte("*2\r\n$4\r\nbook\r\n$7\r\nbicycle\r\n")},
+		{"legacy object keys of an array", &core.RedisCmd{Cmd: "JSON.OBJKEYS", Args: []string{"store", ".store.book"}}, []byte("-WRONGTYPE wrong type of path value - expected object but found array\r\n")},
+		{"bad path", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"store", "$.store["}}, []byte("-ERR invalid JSONPath '$.store['\r\n")},
+	})
+}
+
+func TestJSONUpdates(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "JSON.SET", "doc", "$", `{"n":1,"f":1.5,"s":"ab","a":[1,2,3],"o":{"n":"x"}}`)
+	runCommandCases(t, []commandCase{
+		{"numincrby an integer", &core.RedisCmd{Cmd: "JSON.NUMINCRBY", Args: []string{"doc", "$.n", "2"}}, []byte("$3\r\n[3]\r\n")},
+		{"numincrby to a float", &core.RedisCmd{Cmd: "JSON.NUMINCRBY", Args: []string{"doc", ".n", "0.5"}}, []byte("$3\r\n3.5\r\n")},
+		{"numincrby keeps floats", &core.RedisCmd{Cmd: "JSON.NUMINCRBY", Args: []string{"doc", "$.f", "1.5"}}, []byte("$5\r\n[3.0]\r\n")},
+		{"numincrby on several values", &core.RedisCmd{Cmd: "JSON.NUMINCRBY", Args: []string{"doc", "$..n", "1"}}, []byte("$10\r\n[4.5,null]\r\n")},
+		{"numincrby on a legacy string", &core.RedisCmd{Cmd: "JSON.NUMINCRBY", Args: []string{"doc", ".s", "1"}}, []byte("-WRONGTYPE wrong type of path value - expected a number but found string\r\n")},
+		{"numincrby by a string", &core.RedisCmd{Cmd: "JSON.NUMINCRBY", Args: []string{"doc", "$.n", `"1"`}}, []byte("-ERR the increment must be a number\r\n")},
+		{"strappend", &core.RedisCmd{Cmd: "JSON.STRAPPEND", Args: []string{"doc", "$..*", `"cd"`}}, []byte("*9\r\n$-1\r\n$-1\r\n:4\r\n$-1\r\n$-1\r\n$-1\r\n$-1\r\n$-1\r\n:3\r\n")},
+		{"appended strings", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc", "$..s"}}, []byte("$8\r\n[\"abcd\"]\r\n")},
+		{"strappend to the legacy root", &core.RedisCmd{Cmd: "JSON.STRAPPEND", Args: []string{"doc", `"x"`}}, []byte("-WRONGTYPE wrong type of path value - expected string but found object\r\n")},
+		{"strappend a non string", &core.RedisCmd{Cmd: "JSON.STRAPPEND", Args: []string{"doc", "$.s", "1"}}, []byte("-ERR the value to append must be a JSON string\r\n")},
+		{"arrappend", &core.RedisCmd{Cmd: "JSON.ARRAPPEND", Args: []string{"doc", "$.a", "4", `{"k":[]}`}}, []byte("*1\r\n:5\r\n")},
+		{"arrinsert", &core.RedisCmd{Cmd: "JSON.ARRINSERT", Args: []string{"doc", ".a", "-1", `"before last"`}}, []byte(":6\r\n")},
+		{"arrinsert at the front", &core.RedisCmd{Cmd: "JSON.ARRINSERT", Args: []string{"doc", "$.a", "0", "0"}}, []byte("*1\r\n:7\r\n")},
+		{"array content", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc", ".a"}}, []byte("$34\r\n[0,1,2,3,4,\"before last\",{\"k\":[]}]\r\n")},
+		{"arrinsert out of bounds", &core.RedisCmd{Cmd: "JSON.ARRINSERT", Args: []string{"doc", "$.a", "8", "0"}}, []byte("-ERR index out of bounds\r\n")},
+		{"arrlen", &core.RedisCmd{Cmd: "JSON.ARRLEN", Args: []string{"doc", "$.*"}}, []byte("*5\r\n$-1\r\n$-1\r\n$-1\r\n:7\r\n$-1\r\n")},
+		{"arrpop the last element", &core.RedisCmd{Cmd: "JSON.ARRPOP", Args: []string{"doc", ".a"}}, []byte("$8\r\n{\"k\":[]}\r\n")},
+		{"arrpop an index", &core.RedisCmd{Cmd: "JSON.ARRPOP", Args: []string{"doc", "$.a", "-1"}}, []byte("*1\r\n$13\r\n\"before last\"\r\n")},
+		{"arrpop clamps the index", &core.RedisCmd{Cmd: "JSON.ARRPOP", Args: []string{"doc", ".a", "100"}}, []byte("$1\r\n4\r\n")},
+		{"arrpop the front", &core.RedisCmd{Cmd: "JSON.ARRPOP", Args: []string{"doc", ".a", "0"}}, []byte("$1\r\n0\r\n")},
+		{"arrays left", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc", ".a"}}, []byte("$7\r\n[1,2,3]\r\n")},
+		{"arrappend to a missing key", &core.RedisCmd{Cmd: "JSON.ARRAPPEND", Args: []string{"nojson", "$", "1"}}, []byte("-ERR could not perform this operation on a key that doesn't exist\r\n")},
+		{"arrlen on a missing key", &core.RedisCmd{Cmd: "JSON.ARRLEN", Args: []string{"nojson", "$"}}, []byte("$-1\r\n")},
+	})
+}
+
+func TestJSONDelAndMGet(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "JSON.SET", "doc1", "$", `{"a":1,"nested":{"a":2,"b":3},"list":[{"a":4},5]}`)
+	evalAs(c, "JSON.SET", "doc2", "$", `{"a":"two"}`)
+	evalAs(c, "SET", "plain", "x")
+	runCommandCases(t, []commandCase{
+		{"mget", &core.RedisCmd{Cmd: "JSON.MGET", Args: []string{"doc1", "doc2", "plain", "nojson", "$..a"}}, []byte("*4\r\n$7\r\n[1,2,4]\r\n$7\r\n[\"two\"]\r\n$-1\r\n$-1\r\n")},
+		{"mget a legacy path", &core.RedisCmd{Cmd: "JSON.MGET", Args: []string{"doc1", "doc2", ".nested"}}, []byte("*2\r\n$13\r\n{\"a\":2,\"b\":3}\r\n$-1\r\n")},
+		{"delete everywhere", &core.RedisCmd{Cmd: "JSON.DEL", Args: []string{"doc1", "$..a"}}, []byte(":3\r\n")},
+		{"deleted values", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc1"}}, []byte("$32\r\n{\"nested\":{\"b\":3},\"list\":[{},5]}\r\n")},
+		{"delete array elements", &core.RedisCmd{Cmd: "JSON.DEL", Args: []string{"doc1", "$.list[*]"}}, []byte(":2\r\n")},
+		{"forget", &core.RedisCmd{Cmd: "JSON.FORGET", Args: []string{"doc1", ".nested.b"}}, []byte(":1\r\n")},
+		{"delete a missing path", &core.RedisCmd{Cmd: "JSON.DEL", Args: []string{"doc1", "$.missing"}}, []byte(":0\r\n")},
+		{"what is left", &core.RedisCmd{Cmd: "JSON.GET", Args: []string{"doc1"}}, []byte("$23\r\n{\"nested\":{},\"list\":[]}\r\n")},
+		{"delete the root", &core.RedisCmd{Cmd: "JSON.DEL", Args: []string{"doc1"}}, []byte(":1\r\n")},
+		{"the key is gone", &core.RedisCmd{Cmd: "TYPE", Args: []string{"doc1"}}, []byte("+none\r\n")},
+		{"delete a missing key", &core.RedisCmd{Cmd: "JSON.DEL", Args: []string{"doc1"}}, []byte(":0\r\n")},
+	})
+}
+
+func TestJSONRewriteAOF(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "JSON.SET", "aofjson", "$", `{"z":1,"a":[true,null,1.0]}`)
+	evalAs(c, "BGREWRITEAOF")
+
+	content, _ := os.ReadFile(config.APPEND_ONLY_FILE)
+	os.Remove(config.APPEND_ONLY_FILE)
+	want := "*4\r\n$8\r\nJSON.SET\r\n$7\r\nAOFJSON\r\n$1\r\n$\r\n$27\r\n{\"z\":1,\"a\":[true,null,1.0]}\r\n"
+	if !bytes.Contains(content, []byte(want)) {
+		t.Errorf("AOF content misses %q:\n%q", want, content)
+	}
+}
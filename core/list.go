@@ -0,0 +1,82 @@
+package core
+
+import "github.com/diceclone/config"
+
+const LIST_HEAD = 0
+const LIST_TAIL = 1
+
+// listValue is implemented by both list encodings, a single listpack for small lists and a quicklist
+type listValue interface {
+	Len() int
+	Index(i int) (string, bool)
+	Insert(i int, values ...string)
+	Delete(i int, n int)
+	Replace(i int, value string)
+	Range(start, end int) []string
+}
+
+func newListObj() *Obj {
+	return NewObj(newListpack(), -1, OBJ_TYPE_LIST, OBJ_ENCODING_LISTPACK)
+}
+
+func listOf(obj *Obj) listValue {
+	return obj.Value.(listValue)
+}
+
+func listPush(obj *Obj, value string, where int) {
+	list := listOf(obj)
+	if where == LIST_HEAD {
+		list.Insert(0, value)
+	} else {
+		list.Insert(list.Len(), value)
+	}
+}
+
+func listPop(obj *Obj, where int) (string, bool) {
+	list := listOf(obj)
+	i := 0
+	if where == LIST_TAIL {
+		i = list.Len() - 1
+	}
+	value, ok := list.Index(i)
+	if ok {
+		list.Delete(i, 1)
+	}
+	return value, ok
+}
+
+// listTypeTryConversion moves a list to the quicklist encoding once it does not fit in a single listpack
+// and back to a listpack once it shrinks to half of the limit, the gap avoids flapping between encodings
+func listTypeTryConversion(obj *Obj) {
+	switch obj.Encoding() {
+	case OBJ_ENCODING_LISTPACK:
+		lp := obj.Value.(*listpack)
+		if listpackFitsNode(lp, 1) {
+			return
+		}
+		ql := newQuicklist()
+		ql.Insert(0, lp.Entries()...)
+		obj.Value = ql
+		obj.setEncoding(OBJ_ENCODING_QUICKLIST)
+	case OBJ_ENCODING_QUICKLIST:
+		ql := obj.Value.(*quicklist)
+		if ql.nodes > 1 || (ql.head != nil && !listpackFitsNode(ql.head.lp, 2)) {
+			return
+		}
+		lp := newListpack()
+		if ql.head != nil {
+			lp = ql.head.lp
+		}
+		obj.Value = lp
+		obj.setEncoding(OBJ_ENCODING_LISTPACK)
+	}
+}
+
+// listpackFitsNode tells whether the listpack fits in a single quicklist node whose limit is divided by shrink
+func listpackFitsNode(lp *listpack, shrink int) bool {
+	fill := config.LIST_MAX_LISTPACK_SIZE
+	if fill >= 0 {
+		return lp.Len() <= max(fill, 1)/shrink
+	}
+	return lp.Bytes() <= listpackSizeLimit(fill)/shrink
+}
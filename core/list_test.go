@@ -0,0 +1,139 @@
+package core_test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/diceclone/core"
+)
+
+func TestListCommands(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"push to the tail", &core.RedisCmd{Cmd: "RPUSH", Args: []string{"mylist", "a", "b", "c"}}, []byte(":3\r\n")},
+		{"push to the head", &core.RedisCmd{Cmd: "LPUSH", Args: []string{"mylist", "z"}}, []byte(":4\r\n")},
+		{"range of the whole list", &core.RedisCmd{Cmd: "LRANGE", Args: []string{"mylist", "0", "-1"}}, []byte("*4\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n")},
+		{"length of the list", &core.RedisCmd{Cmd: "LLEN", Args: []string{"mylist"}}, []byte(":4\r\n")},
+		{"type of the list", &core.RedisCmd{Cmd: "TYPE", Args: []string{"mylist"}}, []byte("+list\r\n")},
+		{"small lists are listpack encoded", &core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "mylist"}}, []byte("$8\r\nlistpack\r\n")},
+		{"element by negative index", &core.RedisCmd{Cmd: "LINDEX", Args: []string{"mylist", "-1"}}, []byte("$1\r\nc\r\n")},
+		{"element out of range", &core.RedisCmd{Cmd: "LINDEX", Args: []string{"mylist", "10"}}, []byte("$-1\r\n")},
+		{"set an element", &core.RedisCmd{Cmd: "LSET", Args: []string{"mylist", "1", "A"}}, []byte("+OK\r\n")},
+		{"set an element out of range", &core.RedisCmd{Cmd: "LSET", Args: []string{"mylist", "10", "A"}}, []byte("-ERR index out of range\r\n")},
+		{"insert before a pivot", &core.RedisCmd{Cmd: "LINSERT", Args: []string{"mylist", "BEFORE", "b", "x"}}, []byte(":5\r\n")},
+		{"insert after a missing pivot", &core.RedisCmd{Cmd: "LINSERT", Args: []string{"mylist", "AFTER", "missing", "x"}}, []byte(":-1\r\n")},
+		{"list after the updates", &core.RedisCmd{Cmd: "LRANGE", Args: []string{"mylist", "0", "-1"}}, []byte("*5\r\n$1\r\nz\r\n$1\r\nA\r\n$1\r\nx\r\n$1\r\nb\r\n$1\r\nc\r\n")},
+		{"position of an element", &core.RedisCmd{Cmd: "LPOS", Args: []string{"mylist", "b"}}, []byte(":3\r\n")},
+		{"pop from the head", &core.RedisCmd{Cmd: "LPOP", Args: []string{"mylist"}}, []byte("$1\r\nz\r\n")},
+		{"pop many from the tail", &core.RedisCmd{Cmd: "RPOP", Args: []string{"mylist", "2"}}, []byte("*2\r\n$1\r\nc\r\n$1\r\nb\r\n")},
+		{"trim the list", &core.RedisCmd{Cmd: "LTRIM", Args: []string{"mylist", "1", "-1"}}, []byte("+OK\r\n")},
+		{"list after the trim", &core.RedisCmd{Cmd: "LRANGE", Args: []string{"mylist", "0", "-1"}}, []byte("*1\r\n$1\r\nx\r\n")},
+		{"move the last element", &core.RedisCmd{Cmd: "LMOVE", Args: []string{"mylist", "otherlist", "LEFT", "RIGHT"}}, []byte("$1\r\nx\r\n")},
+		{"the emptied list is deleted", &core.RedisCmd{Cmd: "TYPE", Args: []string{"mylist"}}, []byte("+none\r\n")},
+		{"pushx on a missing list", &core.RedisCmd{Cmd: "LPUSHX", Args: []string{"mylist", "a"}}, []byte(":0\r\n")},
+		{"pop many from a missing list", &core.RedisCmd{Cmd: "LPOP", Args: []string{"mylist", "2"}}, []byte("*-1\r\n")},
+		{"set a string", &core.RedisCmd{Cmd: "SET", Args: []string{"liststring", "a"}}, []byte("+OK\r\n")},
+		{"list command on a string", &core.RedisCmd{Cmd: "LPUSH", Args: []string{"liststring", "a"}}, []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")},
+		{"string command on a list", &core.RedisCmd{Cmd: "GET", Args: []string{"otherlist"}}, []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")},
+	})
+}
+
+func TestLREMAndLPOSCommands(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"create the list", &core.RedisCmd{Cmd: "RPUSH", Args: []string{"remlist", "a", "b", "a", "c", "a", "b"}}, []byte(":6\r\n")},
+		{"all positions of an element", &core.RedisCmd{Cmd: "LPOS", Args: []string{"remlist", "a", "COUNT", "0"}}, []byte("*3\r\n:0\r\n:2\r\n:4\r\n")},
+		{"position from the tail", &core.RedisCmd{Cmd: "LPOS", Args: []string{"remlist", "a", "RANK", "-2"}}, []byte(":2\r\n")},
+		{"position within maxlen", &core.RedisCmd{Cmd: "LPOS", Args: []string{"remlist", "c", "MAXLEN", "3"}}, []byte("$-1\r\n")},
+		{"rank zero", &core.RedisCmd{Cmd: "LPOS", Args: []string{"remlist", "c", "RANK", "0"}}, []byte("-ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list\r\n")},
+		{"remove from the tail", &core.RedisCmd{Cmd: "LREM", Args: []string{"remlist", "-2", "a"}}, []byte(":2\r\n")},
+		{"list after removing from the tail", &core.RedisCmd{Cmd: "LRANGE", Args: []string{"remlist", "0", "-1"}}, []byte("*4\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nb\r\n")},
+		{"remove all occurrences", &core.RedisCmd{Cmd: "LREM", Args: []string{"remlist", "0", "b"}}, []byte(":2\r\n")},
+		{"list after removing all occurrences", &core.RedisCmd{Cmd: "LRANGE", Args: []string{"remlist", "0", "-1"}}, []byte("*2\r\n$1\r\na\r\n$1\r\nc\r\n")},
+	})
+}
+
+func TestListQuicklistEncoding(t *testing.T) {
+	mockReadWriter, timeProvider := setupTest()
+	core.EvalAndRespond(&core.RedisCmd{Cmd: "CONFIG", Args: []string{"SET", "list-max-listpack-size", "4"}}, mockReadWriter, timeProvider)
+	t.Cleanup(func() {
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "CONFIG", Args: []string{"SET", "list-max-listpack-size", "-2"}}, mockReadWriter, timeProvider)
+	})
+
+	// apply random operations to the list and to a slice and compare them after every step,
+	// once with nodes limited by the number of entries and once with nodes limited to 4kb
+	for _, fill := range []struct {
+		size      string
+		valueSize int
+	}{{"4", 1}, {"-1", 300}} {
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "CONFIG", Args: []string{"SET", "list-max-listpack-size", fill.size}}, mockReadWriter, timeProvider)
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "DEL", Args: []string{"qlist"}}, mockReadWriter, timeProvider)
+		fuzzList(t, mockReadWriter, timeProvider, fill.valueSize)
+	}
+
+	core.EvalAndRespond(&core.RedisCmd{Cmd: "CONFIG", Args: []string{"SET", "list-max-listpack-size", "4"}}, mockReadWriter, timeProvider)
+	core.EvalAndRespond(&core.RedisCmd{Cmd: "DEL", Args: []string{"qlist"}}, mockReadWriter, timeProvider)
+	for i := 0; i < 10; i++ {
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "RPUSH", Args: []string{"qlist", fmt.Sprint(i)}}, mockReadWriter, timeProvider)
+	}
+	core.EvalAndRespond(&core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "qlist"}}, mockReadWriter, timeProvider)
+	if !bytes.Equal(mockReadWriter.LastWrite, []byte("$9\r\nquicklist\r\n")) {
+		t.Errorf("got %q, want quicklist", string(mockReadWriter.LastWrite))
+	}
+
+	core.EvalAndRespond(&core.RedisCmd{Cmd: "LTRIM", Args: []string{"qlist", "0", "1"}}, mockReadWriter, timeProvider)
+	core.EvalAndRespond(&core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "qlist"}}, mockReadWriter, timeProvider)
+	if !bytes.Equal(mockReadWriter.LastWrite, []byte("$8\r\nlistpack\r\n")) {
+		t.Errorf("got %q, want listpack", string(mockReadWriter.LastWrite))
+	}
+}
+
+func fuzzList(t *testing.T, mockReadWriter *MockReadWriter, timeProvider MockTimeProvider, valueSize int) {
+	model := []string{}
+	r := rand.New(rand.NewSource(42))
+	for step := 0; step < 500; step++ {
+		value := strings.Repeat(strconv.Itoa(r.Intn(20)), valueSize)
+		var cmd *core.RedisCmd
+		switch op := r.Intn(6); {
+		case op == 0 || len(model) == 0:
+			cmd = &core.RedisCmd{Cmd: "RPUSH", Args: []string{"qlist", value}}
+			model = append(model, value)
+		case op == 1:
+			cmd = &core.RedisCmd{Cmd: "LPUSH", Args: []string{"qlist", value}}
+			model = append([]string{value}, model...)
+		case op == 2:
+			pivot := model[r.Intn(len(model))]
+			cmd = &core.RedisCmd{Cmd: "LINSERT", Args: []string{"qlist", "BEFORE", pivot, value}}
+			for i, v := range model {
+				if v == pivot {
+					model = append(model[:i], append([]string{value}, model[i:]...)...)
+					break
+				}
+			}
+		case op == 3:
+			i := r.Intn(len(model))
+			cmd = &core.RedisCmd{Cmd: "LSET", Args: []string{"qlist", strconv.Itoa(i), value}}
+			model[i] = value
+		case op == 4:
+			cmd = &core.RedisCmd{Cmd: "LREM", Args: []string{"qlist", "1", value}}
+			for i, v := range model {
+				if v == value {
+					model = append(model[:i], model[i+1:]...)
+					break
+				}
+			}
+		default:
+			cmd = &core.RedisCmd{Cmd: "RPOP", Args: []string{"qlist"}}
+			model = model[:len(model)-1]
+		}
+		core.EvalAndRespond(cmd, mockReadWriter, timeProvider)
+
+		core.EvalAndRespond(&core.RedisCmd{Cmd: "LRANGE", Args: []string{"qlist", "0", "-1"}}, mockReadWriter, timeProvider)
+		want := core.Encode(model, false)
+		if !bytes.Equal(mockReadWriter.LastWrite, want) {
+			t.Fatalf("step %d %v: got %q, want %q", step, cmd, string(mockReadWriter.LastWrite), string(want))
+		}
+	}
+}
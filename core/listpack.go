@@ -0,0 +1,210 @@
+package core
+
+import "encoding/binary"
+
+// listpack packs a sequence of strings in a single byte slice, it is the compact encoding used
+// by small lists, hashes and sorted sets and by the nodes of quicklists and streams.
+//
+// Every entry is laid out as <len><data><backlen>: len is the uvarint length of data and backlen
+// is the size of <len><data> encoded so that it can be read backward from the end of the entry,
+// which makes it possible to walk the listpack in both directions.
+type listpack struct {
+	buf   []byte
+	count int
+}
+
+func newListpack() *listpack {
+	return &listpack{}
+}
+
+func (lp *listpack) Len() int {
+	return lp.count
+}
+
+// Bytes returns the size of the packed entries
+func (lp *listpack) Bytes() int {
+	return len(lp.buf)
+}
+
+func encodeListpackEntry(value string) []byte {
+	entry := binary.AppendUvarint(nil, uint64(len(value)))
+	entry = append(entry, value...)
+	return appendBacklen(entry, len(entry))
+}
+
+// appendBacklen stores l in groups of 7 bits written from the most significant one, so that the
+// least significant group is the last byte of the entry. Every group but the most significant one
+// has the high bit set to tell the backward reader that more groups precede it
+func appendBacklen(b []byte, l int) []byte {
+	var groups [5]byte
+	n := 0
+	for {
+		groups[n] = byte(l & 127)
+		l >>= 7
+		n++
+		if l == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		g := groups[i]
+		if i < n-1 {
+			g |= 128
+		}
+		b = append(b, g)
+	}
+	return b
+}
+
+// readBacklen decodes the backlen that ends right before offset end and
+// returns the size of <len><data> and the number of bytes taken by the backlen
+func readBacklen(buf []byte, end int) (int, int) {
+	p := end - 1
+	l := int(buf[p] & 127)
+	shift := 7
+	size := 1
+	for buf[p]&128 != 0 {
+		p--
+		l |= int(buf[p]&127) << shift
+		shift += 7
+		size++
+	}
+	return l, size
+}
+
+// entryAt decodes the entry starting at offset and returns its value and the offset of the next entry
+func (lp *listpack) entryAt(offset int) (string, int) {
+	l, n := binary.Uvarint(lp.buf[offset:])
+	start := offset + n
+	end := start + int(l)
+	value := string(lp.buf[start:end])
+	return value, end + backlenSize(end-offset)
+}
+
+func backlenSize(l int) int {
+	size := 1
+	for l >= 128 {
+		l >>= 7
+		size++
+	}
+	return size
+}
+
+// prevOffset returns the offset of the entry that ends right before offset
+func (lp *listpack) prevOffset(offset int) int {
+	l, n := readBacklen(lp.buf, offset)
+	return offset - n - l
+}
+
+// seek returns the byte offset of the i-th entry, or the size of the listpack when i is the count
+func (lp *listpack) seek(i int) int {
+	if i <= lp.count/2 {
+		offset := 0
+		for ; i > 0; i-- {
+			_, offset = lp.entryAt(offset)
+		}
+		return offset
+	}
+	offset := len(lp.buf)
+	for j := lp.count; j > i; j-- {
+		offset = lp.prevOffset(offset)
+	}
+	return offset
+}
+
+func (lp *listpack) Index(i int) (string, bool) {
+	if i < 0 || i >= lp.count {
+		return "", false
+	}
+	value, _ := lp.entryAt(lp.seek(i))
+	return value, true
+}
+
+// Insert adds the values before the i-th entry, i equal to the count appends them
+func (lp *listpack) Insert(i int, values ...string) {
+	offset := lp.seek(i)
+	var encoded []byte
+	for _, v := range values {
+		encoded = append(encoded, encodeListpackEntry(v)...)
+	}
+
+	buf := make([]byte, 0, len(lp.buf)+len(encoded))
+	buf = append(buf, lp.buf[:offset]...)
+	buf = append(buf, encoded...)
+	buf = append(buf, lp.buf[offset:]...)
+	lp.buf = buf
+	lp.count += len(values)
+}
+
+func (lp *listpack) Append(values ...string) {
+	for _, v := range values {
+		lp.buf = append(lp.buf, encodeListpackEntry(v)...)
+	}
+	lp.count += len(values)
+}
+
+// Delete removes n entries starting from the i-th one
+func (lp *listpack) Delete(i int, n int) {
+	if i < 0 || i >= lp.count || n <= 0 {
+		return
+	}
+	n = min(n, lp.count-i)
+	start := lp.seek(i)
+	end := start
+	for j := 0; j < n; j++ {
+		_, end = lp.entryAt(end)
+	}
+	lp.buf = append(lp.buf[:start], lp.buf[end:]...)
+	lp.count -= n
+}
+
+func (lp *listpack) Replace(i int, value string) {
+	if i < 0 || i >= lp.count {
+		return
+	}
+	start := lp.seek(i)
+	_, end := lp.entryAt(start)
+	encoded := encodeListpackEntry(value)
+
+	buf := make([]byte, 0, len(lp.buf)-(end-start)+len(encoded))
+	buf = append(buf, lp.buf[:start]...)
+	buf = append(buf, encoded...)
+	buf = append(buf, lp.buf[end:]...)
+	lp.buf = buf
+}
+
+// Range returns the entries from start to end, both inclusive
+func (lp *listpack) Range(start, end int) []string {
+	start = max(start, 0)
+	end = min(end, lp.count-1)
+	if start > end {
+		return []string{}
+	}
+	values := make([]string, 0, end-start+1)
+	offset := lp.seek(start)
+	for i := start; i <= end; i++ {
+		var value string
+		value, offset = lp.entryAt(offset)
+		values = append(values, value)
+	}
+	return values
+}
+
+// Entries returns all the entries of the listpack
+func (lp *listpack) Entries() []string {
+	return lp.Range(0, lp.count-1)
+}
+
+// Find returns the index of the first entry equal to value at or after the from-th entry
+// looking only at every step-th entry, this is how field-value listpacks are searched
+func (lp *listpack) Find(value string, from int, step int) int {
+	offset := lp.seek(from)
+	for i := from; i < lp.count; i++ {
+		var entry string
+		entry, offset = lp.entryAt(offset)
+		if (i-from)%step == 0 && entry == value {
+			return i
+		}
+	}
+	return -1
+}
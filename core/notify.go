@@ -0,0 +1,82 @@
+package core
+
+import "strings"
+
+const (
+	NOTIFY_KEYSPACE = 1 << iota
+	NOTIFY_KEYEVENT
+	NOTIFY_GENERIC
+	NOTIFY_STRING
+	NOTIFY_EXPIRED
+	NOTIFY_EVICTED
+	NOTIFY_HASH
+)
+
+const NOTIFY_ALL = NOTIFY_GENERIC | NOTIFY_STRING | NOTIFY_HASH | NOTIFY_EXPIRED | NOTIFY_EVICTED
+
+var notifyKeyspaceEvents = 0
+
+var notifyFlagChars = []struct {
+	c    byte
+	flag int
+}{
+	{'g', NOTIFY_GENERIC},
+	{'$', NOTIFY_STRING},
+	{'h', NOTIFY_HASH},
+	{'x', NOTIFY_EXPIRED},
+	{'e', NOTIFY_EVICTED},
+	{'K', NOTIFY_KEYSPACE},
+	{'E', NOTIFY_KEYEVENT},
+}
+
+func keyspaceEventsStringToFlags(s string) (int, bool) {
+	flags := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'A' {
+			flags |= NOTIFY_ALL
+			continue
+		}
+		found := false
+		for _, f := range notifyFlagChars {
+			if f.c == s[i] {
+				flags |= f.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return flags, true
+}
+
+func keyspaceEventsFlagsToString(flags int) string {
+	var b strings.Builder
+	if flags&NOTIFY_ALL == NOTIFY_ALL {
+		b.WriteByte('A')
+	}
+	for _, f := range notifyFlagChars {
+		if f.flag&NOTIFY_ALL != 0 && flags&NOTIFY_ALL == NOTIFY_ALL {
+			continue
+		}
+		if flags&f.flag != 0 {
+			b.WriteByte(f.c)
+		}
+	}
+	return b.String()
+}
+
+// notifyKeyspaceEvent publishes the event on the __keyspace@0__ and __keyevent@0__ channels
+// when the event class has been enabled through the notify-keyspace-events config
+func notifyKeyspaceEvent(class int, event string, key string) {
+	if notifyKeyspaceEvents&class == 0 {
+		return
+	}
+	if notifyKeyspaceEvents&NOTIFY_KEYSPACE != 0 {
+		publish("__keyspace@0__:"+key, event)
+	}
+	if notifyKeyspaceEvents&NOTIFY_KEYEVENT != 0 {
+		publish("__keyevent@0__:"+event, key)
+	}
+}
@@ -1,18 +1,98 @@
 package core
 
-import "time"
+import (
+	"errors"
+	"strconv"
+)
 
+// the higher 4 bits of TypeEncoding hold the type of the object and the lower 4 bits its encoding
 var OBJ_TYPE_STRING uint8 = 0 << 4
+var OBJ_TYPE_LIST uint8 = 1 << 4
+var OBJ_TYPE_SET uint8 = 2 << 4
+var OBJ_TYPE_ZSET uint8 = 3 << 4
+var OBJ_TYPE_HASH uint8 = 4 << 4
+var OBJ_TYPE_STREAM uint8 = 6 << 4
+var OBJ_TYPE_JSON uint8 = 7 << 4
+var OBJ_TYPE_BLOOM uint8 = 8 << 4
+var OBJ_TYPE_CUCKOO uint8 = 9 << 4
+var OBJ_TYPE_CMS uint8 = 10 << 4
+var OBJ_TYPE_TOPK uint8 = 11 << 4
+var OBJ_TYPE_TIMESERIES uint8 = 12 << 4
 
 var OBJ_ENCODING_RAW uint8 = 0
 var OBJ_ENCODING_INT uint8 = 1
+var OBJ_ENCODING_HT uint8 = 2
+var OBJ_ENCODING_INTSET uint8 = 6
+var OBJ_ENCODING_SKIPLIST uint8 = 7
 var OBJ_ENCODING_EMBSTR uint8 = 8
+var OBJ_ENCODING_QUICKLIST uint8 = 9
+var OBJ_ENCODING_STREAM uint8 = 10
+var OBJ_ENCODING_LISTPACK uint8 = 11
+var OBJ_ENCODING_JSON uint8 = 12
+var OBJ_ENCODING_BLOOM uint8 = 13
+var OBJ_ENCODING_CUCKOO uint8 = 14
+
+// 3 to 5 are the zipmap, linkedlist and ziplist encodings redis retired, the 4 bits of the encoding ran out
+var OBJ_ENCODING_CMS uint8 = 3
+var OBJ_ENCODING_TOPK uint8 = 4
+var OBJ_ENCODING_TIMESERIES uint8 = 5
+
+// OBJ_SHARED_INTEGERS is the number of small integers whose boxed values are shared by all the int encoded objects
+const OBJ_SHARED_INTEGERS = 10000
+
+// the Obj itself cannot be shared since it carries the ttl and the access information of its key,
+// sharing the immutable boxed value spares an allocation for every small integer stored
+var sharedIntegers [OBJ_SHARED_INTEGERS]interface{}
+
+func init() {
+	for i := range sharedIntegers {
+		sharedIntegers[i] = int64(i)
+	}
+}
+
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+var typeNames = map[uint8]string{
+	OBJ_TYPE_STRING:     "string",
+	OBJ_TYPE_LIST:       "list",
+	OBJ_TYPE_SET:        "set",
+	OBJ_TYPE_ZSET:       "zset",
+	OBJ_TYPE_HASH:       "hash",
+	OBJ_TYPE_STREAM:     "stream",
+	OBJ_TYPE_JSON:       "ReJSON-RL",
+	OBJ_TYPE_BLOOM:      "MBbloom--",
+	OBJ_TYPE_CUCKOO:     "MBbloomCF",
+	OBJ_TYPE_CMS:        "CMSk-TYPE",
+	OBJ_TYPE_TOPK:       "TopK-TYPE",
+	OBJ_TYPE_TIMESERIES: "TSDB-TYPE",
+}
+
+var encodingNames = map[uint8]string{
+	OBJ_ENCODING_RAW:        "raw",
+	OBJ_ENCODING_INT:        "int",
+	OBJ_ENCODING_HT:         "hashtable",
+	OBJ_ENCODING_INTSET:     "intset",
+	OBJ_ENCODING_SKIPLIST:   "skiplist",
+	OBJ_ENCODING_EMBSTR:     "embstr",
+	OBJ_ENCODING_QUICKLIST:  "quicklist",
+	OBJ_ENCODING_STREAM:     "stream",
+	OBJ_ENCODING_LISTPACK:   "listpack",
+	OBJ_ENCODING_JSON:       "json",
+	OBJ_ENCODING_BLOOM:      "bloom",
+	OBJ_ENCODING_CUCKOO:     "cuckoo",
+	OBJ_ENCODING_CMS:        "cms",
+	OBJ_ENCODING_TOPK:       "topk",
+	OBJ_ENCODING_TIMESERIES: "timeseries",
+}
 
 type Obj struct {
-	TypeEncoding   uint8
-	Value          interface{}
+	TypeEncoding uint8
+	Value        interface{}
+	// ValidTill is the unix time in milliseconds at which the key expires, -1 when it has no expiry
 	ValidTill      int
 	LastAccessedAt uint32
+	// Frequency is a logarithmic access counter used by the LFU policies
+	Frequency uint8
 }
 
 func NewObj(value interface{}, validTill int, oType uint8, oEncoding uint8) *Obj {
@@ -21,18 +101,70 @@ func NewObj(value interface{}, validTill int, oType uint8, oEncoding uint8) *Obj
 		Value:          value,
 		ValidTill:      validTill,
 		TypeEncoding:   oType | oEncoding,
-		LastAccessedAt: uint32(time.Now().Unix()) & 0x00FFFFFF,
+		LastAccessedAt: lruClock(),
+		Frequency:      LFU_INIT_VAL,
 	}
 }
 
-func assertEncoding(oTypeEncoding uint8, expected uint8) bool {
+// newStringObj creates a string object with the most compact encoding for the value,
+// strings that represent an int64 are held as int64, short strings as immutable go strings
+// and raw strings as byte slices so that they can be modified in place
+func newStringObj(value string, validTill int) *Obj {
+	oType, oEncoding := deduceTypeEncoding(value)
+	switch oEncoding {
+	case OBJ_ENCODING_INT:
+		n, _ := strconv.ParseInt(value, 10, 64)
+		return newIntObj(n, validTill)
+	case OBJ_ENCODING_RAW:
+		return NewObj([]byte(value), validTill, oType, oEncoding)
+	default:
+		return NewObj(value, validTill, oType, oEncoding)
+	}
+}
+
+func newIntObj(value int64, validTill int) *Obj {
+	return NewObj(boxInt64(value), validTill, OBJ_TYPE_STRING, OBJ_ENCODING_INT)
+}
 
-	oEnc := (oTypeEncoding << 4) >> 4
+func boxInt64(value int64) interface{} {
+	if value >= 0 && value < OBJ_SHARED_INTEGERS {
+		return sharedIntegers[value]
+	}
+	return value
+}
+
+func isSharedInteger(obj *Obj) bool {
+	if !assertEncoding(obj.TypeEncoding, OBJ_ENCODING_INT) {
+		return false
+	}
+	v, ok := obj.Value.(int64)
+	return ok && v >= 0 && v < OBJ_SHARED_INTEGERS
+}
+
+func (o *Obj) Type() uint8 {
+	return getType(o.TypeEncoding)
+}
+
+func (o *Obj) Encoding() uint8 {
+	return getEncoding(o.TypeEncoding)
+}
+
+func (o *Obj) setEncoding(oEncoding uint8) {
+	o.TypeEncoding = o.Type() | oEncoding
+}
+
+func getType(oTypeEncoding uint8) uint8 {
+	return oTypeEncoding & 0xF0
+}
+
+func getEncoding(oTypeEncoding uint8) uint8 {
+	return oTypeEncoding & 0x0F
+}
 
-	return oEnc == expected
+func assertEncoding(oTypeEncoding uint8, expected uint8) bool {
+	return getEncoding(oTypeEncoding) == expected
 }
 
 func assertType(oTypeEncoding uint8, expected uint8) bool {
-	oType := oTypeEncoding >> 4
-	return oType == expected
+	return getType(oTypeEncoding) == expected
 }
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"io"
+	"sort"
+)
+
+// pubsubClient tracks what a connected client is subscribed to, it is needed to answer
+// with the subscription count and to clean up when the client disconnects
+type pubsubClient struct {
+	channels map[string]bool
+	patterns map[string]bool
+}
+
+var pubsubClients = make(map[io.ReadWriter]*pubsubClient)
+var channelSubscribers = make(map[string]map[io.ReadWriter]bool)
+var patternSubscribers = make(map[string]map[io.ReadWriter]bool)
+
+func pubsubClientOf(c io.ReadWriter) *pubsubClient {
+	client, ok := pubsubClients[c]
+	if !ok {
+		client = &pubsubClient{channels: make(map[string]bool), patterns: make(map[string]bool)}
+		pubsubClients[c] = client
+	}
+	return client
+}
+
+func (p *pubsubClient) subscriptionCount() int {
+	return len(p.channels) + len(p.patterns)
+}
+
+func evalSubscribe(args []string, c io.ReadWriter) []byte {
+	if len(args) == 0 {
+		return Encode(errWrongArgCount("subscribe"), false)
+	}
+
+	client := pubsubClientOf(c)
+	var buf []byte
+	for _, channel := range args {
+		if !client.channels[channel] {
+			client.channels[channel] = true
+			if channelSubscribers[channel] == nil {
+				channelSubscribers[channel] = make(map[io.ReadWriter]bool)
+			}
+			channelSubscribers[channel][c] = true
+		}
+		buf = append(buf, Encode([]interface{}{"subscribe", channel, client.subscriptionCount()}, false)...)
+	}
+	return buf
+}
+
+func evalPSubscribe(args []string, c io.ReadWriter) []byte {
+	if len(args) == 0 {
+		return Encode(errWrongArgCount("psubscribe"), false)
+	}
+
+	client := pubsubClientOf(c)
+	var buf []byte
+	for _, pattern := range args {
+		if !client.patterns[pattern] {
+			client.patterns[pattern] = true
+			if patternSubscribers[pattern] == nil {
+				patternSubscribers[pattern] = make(map[io.ReadWriter]bool)
+			}
+			patternSubscribers[pattern][c] = true
+		}
+		buf = append(buf, Encode([]interface{}{"psubscribe", pattern, client.subscriptionCount()}, false)...)
+	}
+	return buf
+}
+
+func evalUnsubscribe(args []string, c io.ReadWriter) []byte {
+	client := pubsubClientOf(c)
+	channels := args
+	if len(channels) == 0 {
+		channels = sortedKeys(client.channels)
+	}
+	if len(channels) == 0 {
+		return Encode([]interface{}{"unsubscribe", nil, client.subscriptionCount()}, false)
+	}
+
+	var buf []byte
+	for _, channel := range channels {
+		unsubscribeChannel(c, client, channel)
+		buf = append(buf, Encode([]interface{}{"unsubscribe", channel, client.subscriptionCount()}, false)...)
+	}
+	return buf
+}
+
+func evalPUnsubscribe(args []string, c io.ReadWriter) []byte {
+	client := pubsubClientOf(c)
+	patterns := args
+	if len(patterns) == 0 {
+		patterns = sortedKeys(client.patterns)
+	}
+	if len(patterns) == 0 {
+		return Encode([]interface{}{"punsubscribe", nil, client.subscriptionCount()}, false)
+	}
+
+	var buf []byte
+	for _, pattern := range patterns {
+		unsubscribePattern(c, client, pattern)
+		buf = append(buf, Encode([]interface{}{"punsubscribe", pattern, client.subscriptionCount()}, false)...)
+	}
+	return buf
+}
+
+func evalPublish(args []string) []byte {
+	if len(args) != 2 {
+		return Encode(errWrongArgCount("publish"), false)
+	}
+	return Encode(publish(args[0], args[1]), false)
+}
+
+// publish delivers the message to the subscribers of the channel and of the matching patterns
+// and returns the number of clients that received it
+func publish(channel string, message string) int {
+	receivers := 0
+	for c := range channelSubscribers[channel] {
+		c.Write(Encode([]string{"message", channel, message}, false))
+		receivers++
+	}
+	for pattern, subscribers := range patternSubscribers {
+		if !matchPattern(pattern, channel) {
+			continue
+		}
+		for c := range subscribers {
+			c.Write(Encode([]string{"pmessage", pattern, channel, message}, false))
+			receivers++
+		}
+	}
+	return receivers
+}
+
+func unsubscribeChannel(c io.ReadWriter, client *pubsubClient, channel string) {
+	delete(client.channels, channel)
+	delete(channelSubscribers[channel], c)
+	if len(channelSubscribers[channel]) == 0 {
+		delete(channelSubscribers, channel)
+	}
+}
+
+func unsubscribePattern(c io.ReadWriter, client *pubsubClient, pattern string) {
+	delete(client.patterns, pattern)
+	delete(patternSubscribers[pattern], c)
+	if len(patternSubscribers[pattern]) == 0 {
+		delete(patternSubscribers, pattern)
+	}
+}
+
+func unsubscribeAll(c io.ReadWriter) {
+	client, ok := pubsubClients[c]
+	if !ok {
+		return
+	}
+	for channel := range client.channels {
+		unsubscribeChannel(c, client, channel)
+	}
+	for pattern := range client.patterns {
+		unsubscribePattern(c, client, pattern)
+	}
+	delete(pubsubClients, c)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
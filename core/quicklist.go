@@ -0,0 +1,212 @@
+package core
+
+import "github.com/diceclone/config"
+
+// size limits in bytes of a listpack node for the negative values of list-max-listpack-size
+var listpackSizeLimits = []int{4096, 8192, 16384, 32768, 65536}
+
+type quicklistNode struct {
+	prev *quicklistNode
+	next *quicklistNode
+	lp   *listpack
+}
+
+// quicklist is a doubly linked list of listpacks, every node holds as many entries as
+// list-max-listpack-size allows, this keeps the per element overhead close to the one
+// of a listpack while pushes and pops at both ends stay cheap
+type quicklist struct {
+	head  *quicklistNode
+	tail  *quicklistNode
+	count int
+	nodes int
+}
+
+func newQuicklist() *quicklist {
+	return &quicklist{}
+}
+
+// listpackAllows tells whether a listpack can grow by the given entry without exceeding list-max-listpack-size
+func listpackAllows(lp *listpack, value string) bool {
+	fill := config.LIST_MAX_LISTPACK_SIZE
+	if fill >= 0 {
+		return lp.Len() < max(fill, 1)
+	}
+	return lp.Bytes()+len(encodeListpackEntry(value)) <= listpackSizeLimit(fill)
+}
+
+func listpackSizeLimit(fill int) int {
+	return listpackSizeLimits[min(-fill, len(listpackSizeLimits))-1]
+}
+
+func (ql *quicklist) Len() int {
+	return ql.count
+}
+
+func (ql *quicklist) insertNode(after *quicklistNode, node *quicklistNode) {
+	if after == nil {
+		// insert as the head
+		node.next = ql.head
+		if ql.head != nil {
+			ql.head.prev = node
+		}
+		ql.head = node
+		if ql.tail == nil {
+			ql.tail = node
+		}
+	} else {
+		node.prev = after
+		node.next = after.next
+		if after.next != nil {
+			after.next.prev = node
+		} else {
+			ql.tail = node
+		}
+		after.next = node
+	}
+	ql.nodes++
+}
+
+func (ql *quicklist) unlinkNode(node *quicklistNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		ql.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		ql.tail = node.prev
+	}
+	ql.nodes--
+}
+
+// locate returns the node holding the i-th element and the index of the element in the node
+func (ql *quicklist) locate(i int) (*quicklistNode, int) {
+	if i < ql.count/2 {
+		for node := ql.head; node != nil; node = node.next {
+			if i < node.lp.Len() {
+				return node, i
+			}
+			i -= node.lp.Len()
+		}
+		return nil, 0
+	}
+	i = ql.count - 1 - i
+	for node := ql.tail; node != nil; node = node.prev {
+		if i < node.lp.Len() {
+			return node, node.lp.Len() - 1 - i
+		}
+		i -= node.lp.Len()
+	}
+	return nil, 0
+}
+
+func (ql *quicklist) Index(i int) (string, bool) {
+	if i < 0 || i >= ql.count {
+		return "", false
+	}
+	node, idx := ql.locate(i)
+	return node.lp.Index(idx)
+}
+
+// Insert adds the values before the i-th element, i equal to the length appends them
+func (ql *quicklist) Insert(i int, values ...string) {
+	for k, v := range values {
+		ql.insertOne(i+k, v)
+	}
+}
+
+func (ql *quicklist) insertOne(i int, value string) {
+	defer func() { ql.count++ }()
+
+	if i >= ql.count {
+		if ql.tail == nil || !listpackAllows(ql.tail.lp, value) {
+			ql.insertNode(ql.tail, &quicklistNode{lp: newListpack()})
+		}
+		ql.tail.lp.Append(value)
+		return
+	}
+
+	if i == 0 {
+		if !listpackAllows(ql.head.lp, value) {
+			ql.insertNode(nil, &quicklistNode{lp: newListpack()})
+		}
+		ql.head.lp.Insert(0, value)
+		return
+	}
+
+	node, idx := ql.locate(i)
+	if listpackAllows(node.lp, value) {
+		node.lp.Insert(idx, value)
+		return
+	}
+	if idx == 0 && listpackAllows(node.prev.lp, value) {
+		node.prev.lp.Append(value)
+		return
+	}
+
+	// the node is full, split it at the insertion point and put the value in a node of its own
+	// unless it fits at the end of the first half
+	if idx > 0 {
+		rest := &listpack{}
+		rest.Append(node.lp.Range(idx, node.lp.Len()-1)...)
+		node.lp.Delete(idx, node.lp.Len()-idx)
+		ql.insertNode(node, &quicklistNode{lp: rest})
+	}
+	if idx > 0 && listpackAllows(node.lp, value) {
+		node.lp.Append(value)
+		return
+	}
+	created := &quicklistNode{lp: newListpack()}
+	created.lp.Append(value)
+	if idx > 0 {
+		ql.insertNode(node, created)
+	} else {
+		ql.insertNode(node.prev, created)
+	}
+}
+
+// Delete removes n elements starting from the i-th one
+func (ql *quicklist) Delete(i int, n int) {
+	if i < 0 || i >= ql.count || n <= 0 {
+		return
+	}
+	n = min(n, ql.count-i)
+	for n > 0 {
+		node, idx := ql.locate(i)
+		k := min(n, node.lp.Len()-idx)
+		node.lp.Delete(idx, k)
+		if node.lp.Len() == 0 {
+			ql.unlinkNode(node)
+		}
+		ql.count -= k
+		n -= k
+	}
+}
+
+func (ql *quicklist) Replace(i int, value string) {
+	if i < 0 || i >= ql.count {
+		return
+	}
+	node, idx := ql.locate(i)
+	node.lp.Replace(idx, value)
+}
+
+// Range returns the elements from start to end, both inclusive
+func (ql *quicklist) Range(start, end int) []string {
+	start = max(start, 0)
+	end = min(end, ql.count-1)
+	if start > end {
+		return []string{}
+	}
+
+	values := make([]string, 0, end-start+1)
+	node, idx := ql.locate(start)
+	for remaining := end - start + 1; remaining > 0 && node != nil; node = node.next {
+		k := min(remaining, node.lp.Len()-idx)
+		values = append(values, node.lp.Range(idx, idx+k-1)...)
+		remaining -= k
+		idx = 0
+	}
+	return values
+}
@@ -0,0 +1,380 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var errWatchQuery = errors.New("ERR unsupported query, expected GET key or SELECT $key[, $value] WHERE $key LIKE 'pattern' [ORDER BY $key|$value [ASC|DESC]] [LIMIT n]")
+
+// watchedQuery is a query clients subscribed to with Q.WATCH, its result set is pushed to them
+// every time a write changes it
+type watchedQuery struct {
+	text string
+	// a GET query follows a single key, a SELECT query the string keys matching a pattern
+	key          string
+	pattern      string
+	columns      []string
+	orderByValue bool
+	desc         bool
+	limit        int
+	// matches holds the string keys matching the pattern, it is kept up to date as keys are written
+	// so that re-evaluating the query does not scan the keyspace
+	matches  map[string]bool
+	watchers map[io.ReadWriter]bool
+	// result is the last result set pushed, a write that leaves it unchanged pushes nothing
+	result []byte
+}
+
+var watchedQueries = make(map[string]*watchedQuery)
+var watchingClients = make(map[io.ReadWriter]map[string]bool)
+
+// GET queries are found through their key and SELECT queries by matching their pattern,
+// so that a write only re-evaluates the queries it can affect
+var queriesOnKey = make(map[string]map[*watchedQuery]bool)
+var patternQueries = make(map[*watchedQuery]bool)
+
+// queries whose result may have changed since the last time the watchers were notified
+var dirtyQueries = make(map[*watchedQuery]bool)
+
+// lexQuery splits a query into words, commas and quoted strings, quotes are kept
+// so that the parser can tell a quoted keyword from the keyword
+func lexQuery(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		switch {
+		case unicode.IsSpace(rune(s[i])):
+			i++
+		case s[i] == ',':
+			tokens = append(tokens, ",")
+			i++
+		case s[i] == '\'' || s[i] == '"':
+			end := strings.IndexByte(s[i+1:], s[i])
+			if end < 0 {
+				return nil, errWatchQuery
+			}
+			tokens = append(tokens, s[i:i+end+2])
+			i += end + 2
+		default:
+			start := i
+			for i < len(s) && s[i] != ',' && !unicode.IsSpace(rune(s[i])) {
+				i++
+			}
+			tokens = append(tokens, s[start:i])
+		}
+	}
+	return tokens, nil
+}
+
+func unquote(token string) string {
+	if len(token) >= 2 && (token[0] == '\'' || token[0] == '"') {
+		return token[1 : len(token)-1]
+	}
+	return token
+}
+
+// parseWatchQuery parses GET key or a SELECT over the keys matching a pattern
+func parseWatchQuery(text string) (*watchedQuery, error) {
+	tokens, err := lexQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	q := &watchedQuery{text: text, watchers: make(map[io.ReadWriter]bool)}
+	if len(tokens) == 2 && strings.EqualFold(tokens[0], "GET") {
+		q.key = strings.ToUpper(unquote(tokens[1]))
+		return q, nil
+	}
+	if len(tokens) == 0 || !strings.EqualFold(tokens[0], "SELECT") {
+		return nil, errWatchQuery
+	}
+
+	i := 1
+	for {
+		if i >= len(tokens) {
+			return nil, errWatchQuery
+		}
+		column := strings.ToLower(tokens[i])
+		if column != "$key" && column != "$value" {
+			return nil, errWatchQuery
+		}
+		q.columns = append(q.columns, column)
+		i++
+		if i < len(tokens) && tokens[i] == "," {
+			i++
+			continue
+		}
+		break
+	}
+
+	if i+3 >= len(tokens) || !strings.EqualFold(tokens[i], "WHERE") || !strings.EqualFold(tokens[i+1], "$key") || !strings.EqualFold(tokens[i+2], "LIKE") {
+		return nil, errWatchQuery
+	}
+	q.pattern = strings.ToUpper(unquote(tokens[i+3]))
+	i += 4
+
+	if i < len(tokens) && strings.EqualFold(tokens[i], "ORDER") {
+		if i+2 >= len(tokens) || !strings.EqualFold(tokens[i+1], "BY") {
+			return nil, errWatchQuery
+		}
+		switch strings.ToLower(tokens[i+2]) {
+		case "$key":
+		case "$value":
+			q.orderByValue = true
+		default:
+			return nil, errWatchQuery
+		}
+		i += 3
+		if i < len(tokens) && (strings.EqualFold(tokens[i], "ASC") || strings.EqualFold(tokens[i], "DESC")) {
+			q.desc = strings.EqualFold(tokens[i], "DESC")
+			i++
+		}
+	}
+
+	if i < len(tokens) && strings.EqualFold(tokens[i], "LIMIT") {
+		if i+1 >= len(tokens) {
+			return nil, errWatchQuery
+		}
+		limit, err := strconv.Atoi(tokens[i+1])
+		if err != nil || limit <= 0 {
+			return nil, errWatchQuery
+		}
+		q.limit = limit
+		i += 2
+	}
+
+	if i != len(tokens) {
+		return nil, errWatchQuery
+	}
+	return q, nil
+}
+
+// register makes the query follow the keyspace, the keys already matching the pattern are collected once
+func (q *watchedQuery) register() {
+	watchedQueries[q.text] = q
+	if q.pattern == "" {
+		if queriesOnKey[q.key] == nil {
+			queriesOnKey[q.key] = make(map[*watchedQuery]bool)
+		}
+		queriesOnKey[q.key][q] = true
+		return
+	}
+
+	q.matches = make(map[string]bool)
+	for key, obj := range store {
+		if assertType(obj.TypeEncoding, OBJ_TYPE_STRING) && matchPattern(q.pattern, key) {
+			q.matches[key] = true
+		}
+	}
+	patternQueries[q] = true
+}
+
+func (q *watchedQuery) unregister() {
+	delete(watchedQueries, q.text)
+	delete(dirtyQueries, q)
+	if q.pattern == "" {
+		delete(queriesOnKey[q.key], q)
+		if len(queriesOnKey[q.key]) == 0 {
+			delete(queriesOnKey, q.key)
+		}
+		return
+	}
+	delete(patternQueries, q)
+}
+
+// evaluate runs the query and returns its result set as pushed to the watchers
+func (q *watchedQuery) evaluate() []byte {
+	if q.pattern == "" {
+		obj := peek(q.key)
+		switch {
+		case obj == nil:
+			return q.push(nil)
+		case !assertType(obj.TypeEncoding, OBJ_TYPE_STRING):
+			return q.push(errWrongType)
+		}
+		return q.push(stringValueOf(obj))
+	}
+
+	keys := make([]string, 0, len(q.matches))
+	for key := range q.matches {
+		keys = append(keys, key)
+	}
+	type row struct {
+		key   string
+		value string
+	}
+	rows := make([]row, 0, len(keys))
+	for _, key := range keys {
+		// expiring a key while reading it marks the query dirty again, which is harmless
+		if obj := peek(key); obj != nil {
+			rows = append(rows, row{key, stringValueOf(obj)})
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if q.desc {
+			a, b = b, a
+		}
+		if q.orderByValue && a.value != b.value {
+			// values are compared as numbers when both are, as strings otherwise
+			x, xok := parseFloat(a.value)
+			y, yok := parseFloat(b.value)
+			if xok && yok && x != y {
+				return x < y
+			}
+			return a.value < b.value
+		}
+		return a.key < b.key
+	})
+	if q.limit > 0 && len(rows) > q.limit {
+		rows = rows[:q.limit]
+	}
+
+	result := make([]interface{}, len(rows))
+	for i, r := range rows {
+		fields := make([]interface{}, len(q.columns))
+		for j, column := range q.columns {
+			if column == "$key" {
+				fields[j] = r.key
+			} else {
+				fields[j] = r.value
+			}
+		}
+		result[i] = fields
+	}
+	return q.push(result)
+}
+
+func (q *watchedQuery) push(result interface{}) []byte {
+	return Encode([]interface{}{"q.watch", q.text, result}, false)
+}
+
+func evalQWatch(args []string, c io.ReadWriter) []byte {
+	if len(args) != 1 {
+		return Encode(errWrongArgCount("q.watch"), false)
+	}
+
+	q, ok := watchedQueries[args[0]]
+	if !ok {
+		var err error
+		if q, err = parseWatchQuery(args[0]); err != nil {
+			return Encode(err, false)
+		}
+		q.register()
+		q.result = q.evaluate()
+	}
+
+	q.watchers[c] = true
+	if watchingClients[c] == nil {
+		watchingClients[c] = make(map[string]bool)
+	}
+	watchingClients[c][q.text] = true
+	return q.result
+}
+
+func evalQUnwatch(args []string, c io.ReadWriter) []byte {
+	queries := args
+	if len(queries) == 0 {
+		queries = sortedKeys(watchingClients[c])
+	}
+	if len(queries) == 0 {
+		return Encode([]interface{}{"q.unwatch", nil, 0}, false)
+	}
+
+	var buf []byte
+	for _, text := range queries {
+		unwatchQuery(c, text)
+		buf = append(buf, Encode([]interface{}{"q.unwatch", text, len(watchingClients[c])}, false)...)
+	}
+	return buf
+}
+
+func unwatchQuery(c io.ReadWriter, text string) {
+	delete(watchingClients[c], text)
+	if len(watchingClients[c]) == 0 {
+		delete(watchingClients, c)
+	}
+	q, ok := watchedQueries[text]
+	if !ok {
+		return
+	}
+	delete(q.watchers, c)
+	if len(q.watchers) == 0 {
+		q.unregister()
+	}
+}
+
+func unwatchAll(c io.ReadWriter) {
+	for text := range watchingClients[c] {
+		unwatchQuery(c, text)
+	}
+}
+
+// signalKeyModified records that the key was written, created or removed, the queries it can affect
+// are re-evaluated once the command completes
+func signalKeyModified(k string) {
+	if len(watchedQueries) == 0 {
+		return
+	}
+	key := strings.ToUpper(k)
+	for q := range queriesOnKey[key] {
+		dirtyQueries[q] = true
+	}
+	if len(patternQueries) == 0 {
+		return
+	}
+
+	obj, ok := store[key]
+	isString := ok && assertType(obj.TypeEncoding, OBJ_TYPE_STRING)
+	for q := range patternQueries {
+		if !matchPattern(q.pattern, key) {
+			continue
+		}
+		if isString {
+			q.matches[key] = true
+		} else if q.matches[key] {
+			delete(q.matches, key)
+		} else {
+			continue
+		}
+		dirtyQueries[q] = true
+	}
+}
+
+// signalDBCleared empties the result sets of all the watched queries
+func signalDBCleared() {
+	for _, q := range watchedQueries {
+		if q.matches != nil {
+			q.matches = make(map[string]bool)
+		}
+		dirtyQueries[q] = true
+	}
+}
+
+// notifyWatchers re-evaluates the queries affected by the writes of a command, or of the expiry cycle,
+// and pushes the result sets that changed to their watchers
+func notifyWatchers() {
+	for len(dirtyQueries) > 0 {
+		queries := make([]*watchedQuery, 0, len(dirtyQueries))
+		for q := range dirtyQueries {
+			queries = append(queries, q)
+		}
+		dirtyQueries = make(map[*watchedQuery]bool)
+		sort.Slice(queries, func(i, j int) bool { return queries[i].text < queries[j].text })
+
+		for _, q := range queries {
+			result := q.evaluate()
+			if string(result) == string(q.result) {
+				continue
+			}
+			q.result = result
+			for c := range q.watchers {
+				c.Write(result)
+			}
+		}
+	}
+}
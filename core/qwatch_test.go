@@ -0,0 +1,120 @@
+package core_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/diceclone/core"
+)
+
+func watchPush(query string, result string) string {
+	return "*3\r\n$7\r\nq.watch\r\n$" + strconv.Itoa(len(query)) + "\r\n" + query + "\r\n" + result
+}
+
+func TestWatchGet(t *testing.T) {
+	c, _ := setupTest()
+	watcher, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	defer core.DisconnectClient(watcher)
+
+	query := "GET watched"
+	evalAs(watcher, "Q.WATCH", query)
+	expectWrite(t, watcher, watchPush(query, "$-1\r\n"))
+
+	evalAs(c, "SET", "watched", "1")
+	expectWrite(t, watcher, watchPush(query, "$1\r\n1\r\n"))
+	evalAs(c, "INCRBY", "watched", "41")
+	expectWrite(t, watcher, watchPush(query, "$2\r\n42\r\n"))
+
+	// writes that leave the result unchanged push nothing
+	pushes := watcher.WriteBuffer.Len()
+	evalAs(c, "SET", "other", "1")
+	evalAs(c, "SET", "watched", "42")
+	if watcher.WriteBuffer.Len() != pushes {
+		t.Errorf("got a push for a write that does not change the result: %q", watcher.LastWrite)
+	}
+
+	evalAs(c, "DEL", "watched")
+	evalAs(c, "LPUSH", "watched", "x")
+	expectWrite(t, watcher, watchPush(query, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"))
+	evalAs(c, "SET", "watched", "again")
+	evalAs(c, "PEXPIREAT", "watched", strconv.FormatInt(time.Now().UnixMilli()+1, 10))
+	time.Sleep(5 * time.Millisecond)
+	core.SafeDeleteExpiredKeys()
+	expectWrite(t, watcher, watchPush(query, "$-1\r\n"))
+
+	evalAs(watcher, "Q.UNWATCH", query)
+	expectWrite(t, watcher, "*3\r\n$9\r\nq.unwatch\r\n$11\r\nGET watched\r\n:0\r\n")
+	evalAs(c, "SET", "watched", "gone")
+	expectWrite(t, watcher, "*3\r\n$9\r\nq.unwatch\r\n$11\r\nGET watched\r\n:0\r\n")
+}
+
+func TestWatchSelect(t *testing.T) {
+	c, _ := setupTest()
+	watcher, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	defer core.DisconnectClient(watcher)
+
+	evalAs(c, "SET", "score:alice", "10")
+	evalAs(c, "SET", "score:bob", "9")
+	evalAs(c, "SET", "rank:carol", "100")
+	query := "SELECT $key, $value WHERE $key LIKE 'score:*' ORDER BY $value DESC LIMIT 2"
+	row := func(key, value string) string {
+		return "*2\r\n$" + strconv.Itoa(len(key)) + "\r\n" + key + "\r\n$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+	}
+	evalAs(watcher, "Q.WATCH", query)
+	// values are ordered as numbers, 10 comes before 9
+	expectWrite(t, watcher, watchPush(query, "*2\r\n"+row("SCORE:ALICE", "10")+row("SCORE:BOB", "9")))
+
+	evalAs(c, "INCRBY", "score:bob", "2")
+	expectWrite(t, watcher, watchPush(query, "*2\r\n"+row("SCORE:BOB", "11")+row("SCORE:ALICE", "10")))
+	evalAs(c, "SET", "score:dave", "50")
+	expectWrite(t, watcher, watchPush(query, "*2\r\n"+row("SCORE:DAVE", "50")+row("SCORE:BOB", "11")))
+
+	// keys outside of the pattern or the limit do not affect the result
+	pushes := watcher.WriteBuffer.Len()
+	evalAs(c, "SET", "rank:carol", "1000")
+	evalAs(c, "SET", "score:alice", "1")
+	if watcher.WriteBuffer.Len() != pushes {
+		t.Errorf("got a push for a write that does not change the result: %q", watcher.LastWrite)
+	}
+
+	evalAs(c, "DEL", "score:dave")
+	expectWrite(t, watcher, watchPush(query, "*2\r\n"+row("SCORE:BOB", "11")+row("SCORE:ALICE", "1")))
+	// keys holding other types are left out
+	evalAs(c, "DEL", "score:bob")
+	evalAs(c, "RPUSH", "score:bob", "x")
+	expectWrite(t, watcher, watchPush(query, "*1\r\n"+row("SCORE:ALICE", "1")))
+	evalAs(c, "SET", "score:bob", "not a number")
+	expectWrite(t, watcher, watchPush(query, "*2\r\n"+row("SCORE:BOB", "not a number")+row("SCORE:ALICE", "1")))
+	evalAs(c, "FLUSHDB")
+	expectWrite(t, watcher, watchPush(query, "*0\r\n"))
+
+	// a client watching the same query gets the current result
+	other, _ := setupTest()
+	defer core.DisconnectClient(other)
+	evalAs(c, "SET", "score:erin", "3")
+	evalAs(other, "Q.WATCH", query)
+	expectWrite(t, other, watchPush(query, "*1\r\n"+row("SCORE:ERIN", "3")))
+
+	// disconnected clients stop receiving pushes
+	core.DisconnectClient(watcher)
+	evalAs(c, "SET", "score:frank", "4")
+	expectWrite(t, watcher, watchPush(query, "*1\r\n"+row("SCORE:ERIN", "3")))
+	expectWrite(t, other, watchPush(query, "*2\r\n"+row("SCORE:FRANK", "4")+row("SCORE:ERIN", "3")))
+}
+
+func TestWatchQueryErrors(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"no query", &core.RedisCmd{Cmd: "Q.WATCH", Args: []string{}}, []byte("-ERR wrong number of arguments for 'q.watch' command\r\n")},
+		{"unknown statement", &core.RedisCmd{Cmd: "Q.WATCH", Args: []string{"DELETE $key"}}, []byte("-" + errWatchQueryMessage + "\r\n")},
+		{"unknown column", &core.RedisCmd{Cmd: "Q.WATCH", Args: []string{"SELECT $ttl WHERE $key LIKE '*'"}}, []byte("-" + errWatchQueryMessage + "\r\n")},
+		{"missing pattern", &core.RedisCmd{Cmd: "Q.WATCH", Args: []string{"SELECT $key WHERE $key LIKE"}}, []byte("-" + errWatchQueryMessage + "\r\n")},
+		{"invalid limit", &core.RedisCmd{Cmd: "Q.WATCH", Args: []string{"SELECT $key WHERE $key LIKE '*' LIMIT 0"}}, []byte("-" + errWatchQueryMessage + "\r\n")},
+		{"unterminated string", &core.RedisCmd{Cmd: "Q.WATCH", Args: []string{"SELECT $key WHERE $key LIKE 'a*"}}, []byte("-" + errWatchQueryMessage + "\r\n")},
+		{"unwatching nothing", &core.RedisCmd{Cmd: "Q.UNWATCH", Args: []string{}}, []byte("*3\r\n$9\r\nq.unwatch\r\n$-1\r\n:0\r\n")},
+	})
+}
+
+const errWatchQueryMessage = "ERR unsupported query, expected GET key or SELECT $key[, $value] WHERE $key LIKE 'pattern' [ORDER BY $key|$value [ASC|DESC]] [LIMIT n]"
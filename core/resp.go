@@ -1,65 +1,79 @@
 package core
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"strconv"
 )
 
-func readLength(data []byte) (int, int) {
+// ErrIncompleteCommand is returned while the data ends before the value it starts, the caller
+// reads more from the connection and decodes again
+var ErrIncompleteCommand = errors.New("incomplete command")
 
-	var c []byte
-	var pos int = 0
-	for _, value := range data {
-		if value == '\r' {
-			break
-		}
-		c = append(c, value)
-		pos++
+func readLength(data []byte) (int, int, error) {
+	pos := bytes.Index(data, []byte("\r\n"))
+	if pos < 0 {
+		return 0, 0, ErrIncompleteCommand
 	}
 
-	len, _ := strconv.ParseInt(string(c), 10, 64)
+	len, _ := strconv.ParseInt(string(data[:pos]), 10, 64)
 
-	return int(len), int(pos + 2)
+	return int(len), pos + 2, nil
 }
 
 func readSimpleString(data []byte) (string, int, error) {
-	pos := 0
-	for ; data[pos] != '\r'; pos++ {
-
+	pos := bytes.IndexByte(data, '\r')
+	if pos < 0 {
+		return "", 0, ErrIncompleteCommand
 	}
 	return string(data[:pos]), pos + 3, nil
 }
 
 func readInt64(data []byte) (int64, int, error) {
-	var result []byte
-	pos := 0
-	for ; data[pos] != '\r'; pos++ {
-		result = append(result, data[pos])
+	pos := bytes.IndexByte(data, '\r')
+	if pos < 0 {
+		return 0, 0, ErrIncompleteCommand
 	}
-	parsedValue, _ := strconv.ParseInt(string(result), 10, 64)
+	parsedValue, _ := strconv.ParseInt(string(data[:pos]), 10, 64)
 	return parsedValue, pos + 3, nil
 }
 
 func readBulkstring(data []byte) (string, int, error) {
 
-	length, delta := readLength(data)
-	var bulkstringStartPosition = delta
-	var result []byte
-	for i := bulkstringStartPosition; data[i] != '\r' && i < bulkstringStartPosition+int(length); i++ {
-		result = append(result, data[i])
+	length, delta, err := readLength(data)
+	if err != nil {
+		return "", 0, err
+	}
+	if length < 0 {
+		// the null bulk string has no content nor trailing CRLF
+		return "", delta + 1, nil
+	}
+	// bulk strings are binary safe, the content is exactly length bytes whatever they are
+	end := delta + length
+	if end+2 > len(data) {
+		return "", 0, ErrIncompleteCommand
+	}
+	if data[end] != '\r' || data[end+1] != '\n' {
+		return "", 0, errors.New("invalid bulk string length")
 	}
 
-	return string(result), length + delta + 3, nil
+	return string(data[delta:end]), end + 3, nil
 }
 
 func readArray(data []byte) (interface{}, int, error) {
 
-	count, nextPos := readLength(data)
+	count, nextPos, err := readLength(data)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	var result []interface{}
 
 	for i := 0; i < count; i++ {
+		if nextPos >= len(data) {
+			return nil, 0, ErrIncompleteCommand
+		}
 		response, delta, err := DecodeOne(data[nextPos:])
 		if err != nil {
 			return nil, 0, err
@@ -67,7 +81,8 @@ func readArray(data []byte) (interface{}, int, error) {
 		result = append(result, response)
 		nextPos += delta
 	}
-	return result, 0, nil
+	// the identifier of the array was consumed by the caller, nested arrays are then skipped like other elements
+	return result, nextPos + 1, nil
 }
 
 func DecodeOne(data []byte) (interface{}, int, error) {
@@ -101,6 +116,9 @@ func Decode(data []byte) (interface{}, error) {
 	return result, err
 }
 
+// RESP_NIL_ARRAY is the reply of commands that answer with an array when there is nothing to return
+var RESP_NIL_ARRAY = []byte("*-1\r\n")
+
 func Encode(value interface{}, isSimple bool) []byte {
 	if value == nil {
 		return []byte("$-1\r\n")
@@ -116,21 +134,54 @@ func Encode(value interface{}, isSimple bool) []byte {
 		return []byte(fmt.Sprintf("-%s\r\n", v.Error()))
 	case int, int32, int64:
 		return []byte(fmt.Sprintf(":%d\r\n", v))
+	case []string:
+		var b bytes.Buffer
+		b.WriteString(fmt.Sprintf("*%d\r\n", len(v)))
+		for _, s := range v {
+			b.Write(Encode(s, false))
+		}
+		return b.Bytes()
+	case []interface{}:
+		var b bytes.Buffer
+		b.WriteString(fmt.Sprintf("*%d\r\n", len(v)))
+		for _, e := range v {
+			b.Write(Encode(e, false))
+		}
+		return b.Bytes()
 	default:
 		return []byte("$-1\r\n")
 	}
 }
 
 func DecodeArrayString(data []byte) ([]string, error) {
-	value, err := Decode(data)
+	tokens, _, err := DecodeCommand(data)
+	return tokens, err
+}
+
+// DecodeCommand decodes the command at the start of the data, an array of bulk strings, and returns the number
+// of bytes it spans so that the commands a client pipelined are decoded one after the other
+func DecodeCommand(data []byte) ([]string, int, error) {
+	if len(data) == 0 {
+		return nil, 0, ErrIncompleteCommand
+	}
+	value, n, err := DecodeOne(data)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	// simple strings and integers end at their \r, the \n that follows may not have arrived yet
+	if n > len(data) {
+		return nil, 0, ErrIncompleteCommand
+	}
+
+	ts, ok := value.([]interface{})
+	if !ok {
+		return nil, 0, errors.New("invalid command")
 	}
-	ts := value.([]interface{})
 	tokens := make([]string, len(ts))
 	for i := range ts {
-		tokens[i] = ts[i].(string)
+		if tokens[i], ok = ts[i].(string); !ok {
+			return nil, 0, errors.New("invalid command")
+		}
 	}
-
-	return tokens, nil
+	return tokens, n, nil
 }
@@ -2,6 +2,7 @@ package core_test
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/diceclone/core"
@@ -53,6 +54,8 @@ func TestBulkString(t *testing.T) {
 		"$5\r\nhello\r\n":         "hello",
 		"$0\r\n\r\n":              "",
 		"$12\r\nbgrewriteaof\r\n": "bgrewriteaof",
+		"$4\r\na\rbc\r\n":         "a\rbc",
+		"$4\r\n\r\n\r\n\r\n":      "\r\n\r\n",
 	}
 
 	for input, want := range cases {
@@ -63,12 +66,33 @@ func TestBulkString(t *testing.T) {
 	}
 }
 
+func TestIncompleteCommand(t *testing.T) {
+	cases := []string{
+		"*2\r\n$3\r\nGET\r\n",
+		"*2\r\n$3\r\nGET\r\n$3\r\nke",
+		"*2\r\n$3\r\nGET\r\n$3\r\nkey\r",
+		"*2\r",
+		"$5\r\nhel",
+	}
+
+	for _, input := range cases {
+		if _, err := core.DecodeArrayString([]byte(input)); err != core.ErrIncompleteCommand {
+			t.Errorf("TestIncompleteCommand failed for %q: got %v", input, err)
+		}
+	}
+
+	if _, err := core.Decode([]byte("$2\r\nhello\r\n")); err == nil || err == core.ErrIncompleteCommand {
+		t.Errorf("TestIncompleteCommand failed: a bulk string longer than its length should be invalid, got %v", err)
+	}
+}
+
 func TestArray(t *testing.T) {
 
 	cases := map[string][]interface{}{
-		"*2\r\n$5\r\nhello\r\n$5\r\nworld\r\n":        {"hello", "world"},
-		"*3\r\n:1\r\n:2\r\n:3\r\n":                    {int64(1), int64(2), int64(3)},
-		"*5\r\n:1\r\n:2\r\n:3\r\n+OK\r\n$2\r\nOK\r\n": {int64(1), int64(2), int64(3), "OK", "OK"},
+		"*2\r\n$5\r\nhello\r\n$5\r\nworld\r\n":             {"hello", "world"},
+		"*3\r\n:1\r\n:2\r\n:3\r\n":                         {int64(1), int64(2), int64(3)},
+		"*5\r\n:1\r\n:2\r\n:3\r\n+OK\r\n$2\r\nOK\r\n":      {int64(1), int64(2), int64(3), "OK", "OK"},
+		"*4\r\n*2\r\n:1\r\n:2\r\n*0\r\n$-1\r\n$1\r\na\r\n": {[]interface{}{int64(1), int64(2)}, []interface{}(nil), "", "a"},
 	}
 
 	for command, want := range cases {
@@ -109,3 +133,30 @@ func TestDecodeArrayString(t *testing.T) {
 
 	}
 }
+
+func TestDecodePipelinedCommands(t *testing.T) {
+	data := []byte("*1\r\n$4\r\nPING\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\n*3\r\n$3\r\nSET\r\n$1\r\nk")
+
+	var got []string
+	for {
+		tokens, n, err := core.DecodeCommand(data)
+		if err == core.ErrIncompleteCommand {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.Join(tokens, " "))
+		data = data[n:]
+	}
+	if want := []string{"PING", "GET k"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("decoded %q, want %q", got, want)
+	}
+	if string(data) != "*3\r\n$3\r\nSET\r\n$1\r\nk" {
+		t.Errorf("the incomplete command was not left in the buffer, got %q", data)
+	}
+
+	if _, _, err := core.DecodeCommand([]byte("+OK\r\n")); err == nil || err == core.ErrIncompleteCommand {
+		t.Errorf("a command that is not an array should be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errInvalidCursor = errors.New("ERR invalid cursor")
+
+// scanOptions holds the cursor and the options shared by HSCAN, SSCAN and ZSCAN
+type scanOptions struct {
+	cursor   uint64
+	pattern  string
+	count    int
+	noValues bool
+}
+
+// parseScanArgs parses cursor [MATCH pattern] [COUNT count], NOVALUES is only accepted when allowNoValues is set
+func parseScanArgs(args []string, allowNoValues bool) (*scanOptions, error) {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	opts := &scanOptions{cursor: cursor, count: 10}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			opts.pattern = args[i+1]
+			i++
+		case "COUNT":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			count, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+			if count < 1 {
+				return nil, errSyntax
+			}
+			opts.count = int(min(count, int64(1<<31-1)))
+			i++
+		case "NOVALUES":
+			if !allowNoValues {
+				return nil, errSyntax
+			}
+			opts.noValues = true
+		default:
+			return nil, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+func (opts *scanOptions) matches(member string) bool {
+	return opts.pattern == "" || matchPattern(opts.pattern, member)
+}
+
+func scanHash(member string) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(member))
+	return uint64(h.Sum32())
+}
+
+// scanOrder holds the members of a key sorted by hash, it is built when an iteration starts and reused by
+// the calls resuming it so that each call only walks the members it returns
+type scanOrder struct {
+	obj     *Obj
+	members []string
+	hashes  []uint64
+}
+
+func (o *scanOrder) Len() int           { return len(o.members) }
+func (o *scanOrder) Less(i, j int) bool { return o.hashes[i] < o.hashes[j] }
+func (o *scanOrder) Swap(i, j int) {
+	o.members[i], o.members[j] = o.members[j], o.members[i]
+	o.hashes[i], o.hashes[j] = o.hashes[j], o.hashes[i]
+}
+
+// scanOrders maps the keys being iterated to their scan order
+var scanOrders = make(map[string]*scanOrder)
+
+func forgetScanOrder(key string) {
+	delete(scanOrders, strings.ToUpper(key))
+}
+
+// scanOrderOf returns the scan order of the hash, set or sorted set, rebuilding it when the iteration starts
+// or when the key now holds another object
+func scanOrderOf(key string, obj *Obj, cursor uint64) *scanOrder {
+	key = strings.ToUpper(key)
+	if order, ok := scanOrders[key]; ok && cursor != 0 && order.obj == obj {
+		return order
+	}
+
+	var members []string
+	switch v := obj.Value.(type) {
+	case *hashObject:
+		members = make([]string, 0, len(v.dict))
+		for field := range v.dict {
+			members = append(members, field)
+		}
+	case map[string]struct{}:
+		members = setMembers(obj)
+	case *zsetSkiplist:
+		members = make([]string, 0, len(v.dict))
+		for member := range v.dict {
+			members = append(members, member)
+		}
+	}
+	order := &scanOrder{obj: obj, members: members, hashes: make([]uint64, len(members))}
+	for i, m := range members {
+		order.hashes[i] = scanHash(m) + 1
+	}
+	sort.Sort(order)
+	scanOrders[key] = order
+	return order
+}
+
+// scanContains tells whether the member is still in the hash, set or sorted set
+func scanContains(obj *Obj, member string) bool {
+	switch obj.Type() {
+	case OBJ_TYPE_HASH:
+		_, ok := hashGet(obj, member)
+		return ok
+	case OBJ_TYPE_SET:
+		return setIsMember(obj, member)
+	case OBJ_TYPE_ZSET:
+		_, ok := zsetOf(obj).Score(member)
+		return ok
+	}
+	return false
+}
+
+// scanByHash walks the members of the hashtable encoded key in the order of their hash, the cursor is the hash
+// to resume from plus one so that 0 is left to start and end the iteration. Members present for the whole
+// iteration are returned at least once whatever is added or removed in between, members added after the
+// iteration started may be missed, members sharing a hash are returned together
+func scanByHash(key string, obj *Obj, cursor uint64, count int) (uint64, []string) {
+	order := scanOrderOf(key, obj, cursor)
+	start := sort.Search(len(order.hashes), func(i int) bool { return order.hashes[i] >= cursor })
+
+	var out []string
+	for i := start; i < len(order.members); i++ {
+		if len(out) >= count && order.hashes[i] != order.hashes[i-1] {
+			return order.hashes[i], out
+		}
+		if scanContains(obj, order.members[i]) {
+			out = append(out, order.members[i])
+		}
+	}
+	forgetScanOrder(key)
+	return 0, out
+}
+
+// encodeScanReply builds the cursor and elements reply of the SCAN family
+func encodeScanReply(cursor uint64, elements []string) []byte {
+	if elements == nil {
+		elements = []string{}
+	}
+	return Encode([]interface{}{strconv.FormatUint(cursor, 10), elements}, false)
+}
@@ -0,0 +1,747 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var errFTIndexExists = errors.New("ERR Index already exists")
+var errFTUnknownIndex = errors.New("ERR Unknown Index name")
+var errFTNoFields = errors.New("ERR Fields arguments are missing")
+var errFTDuplicateField = errors.New("ERR Duplicate field in schema")
+var errFTBadFieldType = errors.New("ERR Invalid field type")
+var errFTBadSeparator = errors.New("ERR Tag separator must be a single character")
+var errFTBadVectorArgs = errors.New("ERR Bad arguments for vector similarity index")
+var errFTQuerySyntax = errors.New("ERR Syntax error in query")
+var errFTBadNumericRange = errors.New("ERR Bad numeric range in query")
+var errFTBadKNN = errors.New("ERR Invalid KNN clause in query")
+var errFTBadVectorBlob = errors.New("ERR Error parsing vector similarity query: query vector blob size does not match index's expected size")
+var errFTNoSuchParam = errors.New("ERR No such parameter in query")
+
+// ftField is an attribute of the schema of an index. Queries refer to it by its alias, which defaults to the
+// name of the hash field it indexes
+type ftField struct {
+	name  string
+	alias string
+	kind  string
+
+	// TAG fields index the documents by each of the values the separator splits the field into, and TEXT
+	// fields by each of the words of the field, postings holds the keys of the documents by tag or word
+	separator     byte
+	caseSensitive bool
+	postings      map[string]map[string]struct{}
+
+	// VECTOR fields hold FLOAT32 vectors of dim components
+	algorithm      string
+	dim            int
+	metric         string
+	m              int
+	efConstruction int
+	efRuntime      int
+	vectors        vectorIndex
+}
+
+func (f *ftField) reset() {
+	switch f.kind {
+	case "TAG", "TEXT":
+		f.postings = make(map[string]map[string]struct{})
+	case "VECTOR":
+		if f.algorithm == "HNSW" {
+			f.vectors = newHNSWIndex(f.metric, f.m, f.efConstruction)
+		} else {
+			f.vectors = newFlatIndex(f.metric)
+		}
+	}
+}
+
+// splitTags returns the distinct tags of the value, trimmed and lower cased unless the field is case sensitive
+func (f *ftField) splitTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, string(f.separator)) {
+		tag = strings.TrimSpace(tag)
+		if !f.caseSensitive {
+			tag = strings.ToLower(tag)
+		}
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// tokenize returns the distinct words of the text in lower case, words are made of letters, digits and
+// underscores and are indexed as they are, without stemming nor stop words
+func tokenize(text string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool { return !isWordRune(r) }) {
+		word = strings.ToLower(word)
+		if !slices.Contains(words, word) {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func (f *ftField) addPosting(token string, key string) {
+	if f.postings[token] == nil {
+		f.postings[token] = make(map[string]struct{})
+	}
+	f.postings[token][key] = struct{}{}
+}
+
+func (f *ftField) removePosting(token string, key string) {
+	delete(f.postings[token], key)
+	if len(f.postings[token]) == 0 {
+		delete(f.postings, token)
+	}
+}
+
+// ftDoc is what an index keeps of an indexed hash, by alias of the fields present in the hash
+type ftDoc struct {
+	// tokens holds the tags of the TAG fields and the words of the TEXT fields
+	tokens  map[string][]string
+	numbers map[string]float64
+	vectors map[string][]float32
+}
+
+// ftIndex indexes the hashes whose key starts with one of its prefixes, all of them without prefixes.
+// Documents are known by their key in upper case as the store knows them, and are updated by the writes
+// to the keyspace through updateIndexes and removeFromIndexes
+type ftIndex struct {
+	name string
+	// definition holds the arguments of FT.CREATE following the name, for the AOF rewrite
+	definition []string
+	prefixes   []string
+	fields     []*ftField
+	docs       map[string]*ftDoc
+	failures   int
+}
+
+var searchIndexes = make(map[string]*ftIndex)
+
+func (idx *ftIndex) field(alias string) *ftField {
+	for _, f := range idx.fields {
+		if f.alias == alias {
+			return f
+		}
+	}
+	return nil
+}
+
+func (idx *ftIndex) covers(key string) bool {
+	if len(idx.prefixes) == 0 {
+		return true
+	}
+	for _, p := range idx.prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// reset drops every document, then indexes the hashes of the keyspace
+func (idx *ftIndex) reset() {
+	idx.docs = make(map[string]*ftDoc)
+	idx.failures = 0
+	for _, f := range idx.fields {
+		f.reset()
+	}
+	for key, obj := range store {
+		if obj.Type() == OBJ_TYPE_HASH && idx.covers(key) {
+			idx.index(key, obj)
+		}
+	}
+}
+
+// index updates the document of the hash stored at key. A hash with a field the index cannot parse, a
+// NUMERIC field that is not a number or a VECTOR field of the wrong size, is counted as a failure and
+// left out of the index
+func (idx *ftIndex) index(key string, obj *Obj) {
+	doc := &ftDoc{tokens: make(map[string][]string), numbers: make(map[string]float64), vectors: make(map[string][]float32)}
+	for _, f := range idx.fields {
+		value, ok := hashGet(obj, f.name)
+		if !ok {
+			continue
+		}
+		switch f.kind {
+		case "TAG":
+			doc.tokens[f.alias] = f.splitTags(value)
+		case "TEXT":
+			doc.tokens[f.alias] = tokenize(value)
+		case "NUMERIC":
+			n, ok := parseFloat(value)
+			if !ok {
+				idx.unindex(key)
+				idx.failures++
+				return
+			}
+			doc.numbers[f.alias] = n
+		case "VECTOR":
+			v, ok := decodeVector(value, f.dim)
+			if !ok {
+				idx.unindex(key)
+				idx.failures++
+				return
+			}
+			if f.metric == "COSINE" {
+				normalize(v)
+			}
+			doc.vectors[f.alias] = v
+		}
+	}
+
+	old := idx.docs[key]
+	for _, f := range idx.fields {
+		switch f.kind {
+		case "TAG", "TEXT":
+			if old != nil {
+				for _, token := range old.tokens[f.alias] {
+					f.removePosting(token, key)
+				}
+			}
+			for _, token := range doc.tokens[f.alias] {
+				f.addPosting(token, key)
+			}
+		case "VECTOR":
+			// the graph of an HNSW index is only updated when the vector changes
+			v, ok := doc.vectors[f.alias]
+			if old != nil && slices.Equal(old.vectors[f.alias], v) {
+				continue
+			}
+			if !ok {
+				f.vectors.remove(key)
+			} else {
+				f.vectors.add(key, v)
+			}
+		}
+	}
+	idx.docs[key] = doc
+}
+
+func (idx *ftIndex) unindex(key string) {
+	doc, ok := idx.docs[key]
+	if !ok {
+		return
+	}
+	for _, f := range idx.fields {
+		switch f.kind {
+		case "TAG", "TEXT":
+			for _, token := range doc.tokens[f.alias] {
+				f.removePosting(token, key)
+			}
+		case "VECTOR":
+			f.vectors.remove(key)
+		}
+	}
+	delete(idx.docs, key)
+}
+
+// updateIndexes brings the documents of the key up to date after a write to the key
+func updateIndexes(k string) {
+	if len(searchIndexes) == 0 {
+		return
+	}
+	key := strings.ToUpper(k)
+	obj := store[key]
+	for _, idx := range searchIndexes {
+		if !idx.covers(key) {
+			continue
+		}
+		if obj == nil || obj.Type() != OBJ_TYPE_HASH {
+			idx.unindex(key)
+		} else {
+			idx.index(key, obj)
+		}
+	}
+}
+
+func removeFromIndexes(k string) {
+	key := strings.ToUpper(k)
+	for _, idx := range searchIndexes {
+		idx.unindex(key)
+	}
+}
+
+// clearIndexes empties the indexes when the keyspace is flushed, their definitions are kept
+func clearIndexes() {
+	for _, idx := range searchIndexes {
+		idx.reset()
+	}
+}
+
+// ftNode is a node of a parsed query, it evaluates to the keys of the documents matching it
+type ftNode interface {
+	eval(idx *ftIndex) map[string]struct{}
+}
+
+type ftAllNode struct{}
+
+func (n *ftAllNode) eval(idx *ftIndex) map[string]struct{} {
+	keys := make(map[string]struct{}, len(idx.docs))
+	for key := range idx.docs {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+type ftTagNode struct {
+	field *ftField
+	tags  []string
+}
+
+func (n *ftTagNode) eval(idx *ftIndex) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for _, tag := range n.tags {
+		for key := range n.field.postings[tag] {
+			keys[key] = struct{}{}
+		}
+	}
+	return keys
+}
+
+type ftNumericNode struct {
+	alias        string
+	min          float64
+	max          float64
+	minExclusive bool
+	maxExclusive bool
+}
+
+func (n *ftNumericNode) eval(idx *ftIndex) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for key, doc := range idx.docs {
+		v, ok := doc.numbers[n.alias]
+		if !ok || v < n.min || v > n.max || (n.minExclusive && v == n.min) || (n.maxExclusive && v == n.max) {
+			continue
+		}
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+type ftIntersectNode struct {
+	children []ftNode
+}
+
+func (n *ftIntersectNode) eval(idx *ftIndex) map[string]struct{} {
+	keys := n.children[0].eval(idx)
+	for _, child := range n.children[1:] {
+		other := child.eval(idx)
+		for key := range keys {
+			if _, ok := other[key]; !ok {
+				delete(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+type ftUnionNode struct {
+	children []ftNode
+}
+
+func (n *ftUnionNode) eval(idx *ftIndex) map[string]struct{} {
+	keys := n.children[0].eval(idx)
+	for _, child := range n.children[1:] {
+		for key := range child.eval(idx) {
+			keys[key] = struct{}{}
+		}
+	}
+	return keys
+}
+
+type ftNotNode struct {
+	child ftNode
+}
+
+func (n *ftNotNode) eval(idx *ftIndex) map[string]struct{} {
+	excluded := n.child.eval(idx)
+	keys := make(map[string]struct{})
+	for key := range idx.docs {
+		if _, ok := excluded[key]; !ok {
+			keys[key] = struct{}{}
+		}
+	}
+	return keys
+}
+
+// ftTextNode matches the documents with the word in one of the TEXT fields, or with a word starting with it
+// for a prefix
+type ftTextNode struct {
+	fields []*ftField
+	word   string
+	prefix bool
+}
+
+func (n *ftTextNode) eval(idx *ftIndex) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for _, f := range n.fields {
+		if !n.prefix {
+			for key := range f.postings[n.word] {
+				keys[key] = struct{}{}
+			}
+			continue
+		}
+		for word, postings := range f.postings {
+			if strings.HasPrefix(word, n.word) {
+				for key := range postings {
+					keys[key] = struct{}{}
+				}
+			}
+		}
+	}
+	return keys
+}
+
+// ftQueryParser parses the filters of a query: * for every document, words for the documents with the word in
+// one of their TEXT fields, word* for the documents with a word starting with it, @field:word or
+// @field:(query) to only look for the words in the field, @field:{tag | tag} for the documents with one of the
+// tags, @field:[min max] for the documents with a number in the range, where a bound prefixed with ( is
+// exclusive and -inf and +inf are the unbounded ends, and parenthesized groups. Terms separated by spaces
+// must all match, | separates alternatives of lower precedence and - negates the following term. $name refers
+// to a parameter of the query in numeric bounds
+type ftQueryParser struct {
+	idx    *ftIndex
+	query  string
+	pos    int
+	params map[string]string
+	// textFields are the fields words are looked for in, every TEXT field outside of @field:(query)
+	textFields []*ftField
+}
+
+func parseFTQuery(idx *ftIndex, query string, params map[string]string) (ftNode, error) {
+	p := &ftQueryParser{idx: idx, query: query, params: params}
+	for _, f := range idx.fields {
+		if f.kind == "TEXT" {
+			p.textFields = append(p.textFields, f)
+		}
+	}
+	n, err := p.parseUnion()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.query) {
+		return nil, errFTQuerySyntax
+	}
+	return n, nil
+}
+
+func (p *ftQueryParser) skipSpaces() {
+	for p.pos < len(p.query) && p.query[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *ftQueryParser) parseUnion() (ftNode, error) {
+	var children []ftNode
+	for {
+		n, err := p.parseIntersection()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+		if p.pos == len(p.query) || p.query[p.pos] != '|' {
+			break
+		}
+		p.pos++
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &ftUnionNode{children}, nil
+}
+
+func (p *ftQueryParser) parseIntersection() (ftNode, error) {
+	var children []ftNode
+	for {
+		p.skipSpaces()
+		if p.pos == len(p.query) || p.query[p.pos] == ')' || p.query[p.pos] == '|' {
+			break
+		}
+		n, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+	}
+	switch len(children) {
+	case 0:
+		return nil, errFTQuerySyntax
+	case 1:
+		return children[0], nil
+	}
+	return &ftIntersectNode{children}, nil
+}
+
+func (p *ftQueryParser) parseTerm() (ftNode, error) {
+	switch p.query[p.pos] {
+	case '-':
+		p.pos++
+		if p.pos == len(p.query) || p.query[p.pos] == ' ' {
+			return nil, errFTQuerySyntax
+		}
+		n, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		return &ftNotNode{n}, nil
+	case '(':
+		return p.parseGroup()
+	case '*':
+		p.pos++
+		return &ftAllNode{}, nil
+	case '@':
+		return p.parseFieldTerm()
+	}
+	return p.parseWord()
+}
+
+func (p *ftQueryParser) parseGroup() (ftNode, error) {
+	p.pos++
+	n, err := p.parseUnion()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos == len(p.query) {
+		return nil, errFTQuerySyntax
+	}
+	p.pos++
+	return n, nil
+}
+
+func (p *ftQueryParser) parseWord() (ftNode, error) {
+	start := p.pos
+	for p.pos < len(p.query) {
+		r, size := utf8.DecodeRuneInString(p.query[p.pos:])
+		if !isWordRune(r) {
+			break
+		}
+		p.pos += size
+	}
+	if p.pos == start {
+		return nil, errFTQuerySyntax
+	}
+	n := &ftTextNode{fields: p.textFields, word: strings.ToLower(p.query[start:p.pos])}
+	if p.pos < len(p.query) && p.query[p.pos] == '*' {
+		n.prefix = true
+		p.pos++
+	}
+	return n, nil
+}
+
+func isFieldNameChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func (p *ftQueryParser) parseFieldTerm() (ftNode, error) {
+	p.pos++
+	start := p.pos
+	for p.pos < len(p.query) && isFieldNameChar(p.query[p.pos]) {
+		p.pos++
+	}
+	alias := p.query[start:p.pos]
+	if p.pos+1 >= len(p.query) || p.query[p.pos] != ':' {
+		return nil, errFTQuerySyntax
+	}
+	p.pos++
+	f := p.idx.field(alias)
+	if f == nil {
+		return nil, fmt.Errorf("ERR Unknown field '%s'", alias)
+	}
+
+	switch p.query[p.pos] {
+	case '{':
+		if f.kind != "TAG" {
+			return nil, fmt.Errorf("ERR Field '%s' is not a TAG field", alias)
+		}
+		return p.parseTags(f)
+	case '[':
+		if f.kind != "NUMERIC" {
+			return nil, fmt.Errorf("ERR Field '%s' is not a NUMERIC field", alias)
+		}
+		return p.parseNumericRange(f)
+	}
+
+	if f.kind != "TEXT" {
+		return nil, fmt.Errorf("ERR Field '%s' is not a TEXT field", alias)
+	}
+	outer := p.textFields
+	p.textFields = []*ftField{f}
+	defer func() { p.textFields = outer }()
+	if p.query[p.pos] == '(' {
+		return p.parseGroup()
+	}
+	return p.parseWord()
+}
+
+// parseTags reads the tags separated by | up to the closing brace, a backslash escapes the next character
+func (p *ftQueryParser) parseTags(f *ftField) (ftNode, error) {
+	n := &ftTagNode{field: f}
+	var tag strings.Builder
+	addTag := func() {
+		value := strings.TrimSpace(tag.String())
+		if !f.caseSensitive {
+			value = strings.ToLower(value)
+		}
+		if value != "" {
+			n.tags = append(n.tags, value)
+		}
+		tag.Reset()
+	}
+
+	for p.pos++; p.pos < len(p.query); p.pos++ {
+		switch c := p.query[p.pos]; c {
+		case '\\':
+			if p.pos++; p.pos < len(p.query) {
+				tag.WriteByte(p.query[p.pos])
+			}
+		case '|':
+			addTag()
+		case '}':
+			p.pos++
+			addTag()
+			if len(n.tags) == 0 {
+				return nil, errFTQuerySyntax
+			}
+			return n, nil
+		default:
+			tag.WriteByte(c)
+		}
+	}
+	return nil, errFTQuerySyntax
+}
+
+func (p *ftQueryParser) parseNumericRange(f *ftField) (ftNode, error) {
+	end := strings.IndexByte(p.query[p.pos:], ']')
+	if end < 0 {
+		return nil, errFTQuerySyntax
+	}
+	bounds := strings.Fields(p.query[p.pos+1 : p.pos+end])
+	p.pos += end + 1
+	if len(bounds) != 2 {
+		return nil, errFTBadNumericRange
+	}
+
+	n := &ftNumericNode{alias: f.alias}
+	var err error
+	if n.min, n.minExclusive, err = p.parseBound(bounds[0]); err != nil {
+		return nil, err
+	}
+	if n.max, n.maxExclusive, err = p.parseBound(bounds[1]); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (p *ftQueryParser) parseBound(s string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	s, err := p.param(s)
+	if err != nil {
+		return 0, false, err
+	}
+	v, ok := parseFloat(s)
+	if !ok {
+		return 0, false, errFTBadNumericRange
+	}
+	return v, exclusive, nil
+}
+
+// param resolves the references to the parameters of the query, other values are returned as is
+func (p *ftQueryParser) param(s string) (string, error) {
+	if !strings.HasPrefix(s, "$") {
+		return s, nil
+	}
+	value, ok := p.params[s[1:]]
+	if !ok {
+		return "", errFTNoSuchParam
+	}
+	return value, nil
+}
+
+// ftKNN is the vector similarity clause of a query, [KNN k @field $vector [EF_RUNTIME ef] [AS name]], which
+// keeps the k documents passing the filters whose vector is the closest to the query vector, the distance
+// is returned as the field scoreField of the results
+type ftKNN struct {
+	k          int
+	field      *ftField
+	vector     []float32
+	efRuntime  int
+	scoreField string
+}
+
+func (p *ftQueryParser) parseKNN(clause string) (*ftKNN, error) {
+	clause = strings.TrimSpace(clause)
+	if !strings.HasPrefix(clause, "[") || !strings.HasSuffix(clause, "]") {
+		return nil, errFTBadKNN
+	}
+	tokens := strings.Fields(clause[1 : len(clause)-1])
+	if len(tokens) < 4 || strings.ToUpper(tokens[0]) != "KNN" || !strings.HasPrefix(tokens[2], "@") || !strings.HasPrefix(tokens[3], "$") {
+		return nil, errFTBadKNN
+	}
+
+	knn := &ftKNN{}
+	k, err := p.param(tokens[1])
+	if err != nil {
+		return nil, err
+	}
+	n, ok := parseInt64(k)
+	if !ok || n < 0 {
+		return nil, errFTBadKNN
+	}
+	knn.k = int(n)
+
+	alias := tokens[2][1:]
+	if knn.field = p.idx.field(alias); knn.field == nil {
+		return nil, fmt.Errorf("ERR Unknown field '%s'", alias)
+	}
+	if knn.field.kind != "VECTOR" {
+		return nil, fmt.Errorf("ERR Field '%s' is not a VECTOR field", alias)
+	}
+	blob, err := p.param(tokens[3])
+	if err != nil {
+		return nil, err
+	}
+	if knn.vector, ok = decodeVector(blob, knn.field.dim); !ok {
+		return nil, errFTBadVectorBlob
+	}
+	if knn.field.metric == "COSINE" {
+		normalize(knn.vector)
+	}
+
+	knn.efRuntime = knn.field.efRuntime
+	knn.scoreField = "__" + alias + "_score"
+	for i := 4; i < len(tokens); i += 2 {
+		if i+1 == len(tokens) {
+			return nil, errFTBadKNN
+		}
+		switch strings.ToUpper(tokens[i]) {
+		case "EF_RUNTIME":
+			ef, err := p.param(tokens[i+1])
+			if err != nil {
+				return nil, err
+			}
+			n, ok := parseInt64(ef)
+			if !ok || n <= 0 || knn.field.algorithm != "HNSW" {
+				return nil, errFTBadKNN
+			}
+			knn.efRuntime = int(n)
+		case "AS":
+			knn.scoreField = tokens[i+1]
+		default:
+			return nil, errFTBadKNN
+		}
+	}
+	return knn, nil
+}
@@ -0,0 +1,109 @@
+package core_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/diceclone/core"
+)
+
+func TestFullTextSearch(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "HSET", "book:1", "title", "The Go Programming Language", "author", "Donovan, Kernighan", "year", "2015", "summary", "A complete guide to programming in Go")
+	evalAs(c, "HSET", "book:2", "title", "The C Programming Language", "author", "Kernighan, Ritchie", "year", "1978", "summary", "The classic book about C")
+	evalAs(c, "HSET", "book:3", "title", "Learning Redis", "author", "Das", "year", "2015", "summary", "Caching and data structures with Redis")
+	evalAs(c, "HSET", "book:4", "title", "Go in Action", "author", "Kennedy", "year", "2016", "summary", "Practical go programs")
+	evalAs(c, "FT.CREATE", "books", "ON", "HASH", "PREFIX", "1", "book:", "SCHEMA", "title", "TEXT", "author", "TAG", "year", "NUMERIC", "SORTABLE", "summary", "AS", "about", "TEXT", "NOSTEM")
+	expectWrite(t, c, "+OK\r\n")
+	defer evalAs(c, "FT.DROPINDEX", "books")
+
+	search := func(query string, options ...string) *core.RedisCmd {
+		return &core.RedisCmd{Cmd: "FT.SEARCH", Args: append([]string{"books", query}, options...)}
+	}
+	runCommandCases(t, []commandCase{
+		{"a word in any text field", search("programming", "NOCONTENT"), []byte("*3\r\n:2\r\n$6\r\nBOOK:1\r\n$6\r\nBOOK:2\r\n")},
+		{"words must all match", search("go programming", "NOCONTENT"), []byte("*2\r\n:1\r\n$6\r\nBOOK:1\r\n")},
+		{"alternatives", search("c | redis", "NOCONTENT"), []byte("*3\r\n:2\r\n$6\r\nBOOK:2\r\n$6\r\nBOOK:3\r\n")},
+		{"intersections bind tighter than alternatives", search("go action | classic", "NOCONTENT"), []byte("*3\r\n:2\r\n$6\r\nBOOK:2\r\n$6\r\nBOOK:4\r\n")},
+		{"negation", search("go -@author:{kennedy}", "NOCONTENT"), []byte("*2\r\n:1\r\n$6\r\nBOOK:1\r\n")},
+		{"negated group", search("-(go | redis)", "NOCONTENT"), []byte("*2\r\n:1\r\n$6\r\nBOOK:2\r\n")},
+		{"prefix", search("program*", "NOCONTENT"), []byte("*4\r\n:3\r\n$6\r\nBOOK:1\r\n$6\r\nBOOK:2\r\n$6\r\nBOOK:4\r\n")},
+		{"a word in a field", search("@about:classic", "NOCONTENT"), []byte("*2\r\n:1\r\n$6\r\nBOOK:2\r\n")},
+		{"words in a field", search("@title:(c | redis) language", "NOCONTENT"), []byte("*2\r\n:1\r\n$6\r\nBOOK:2\r\n")},
+		{"numeric range and tag", search("@year:[2015 2015] @author:{kernighan}", "NOCONTENT"), []byte("*2\r\n:1\r\n$6\r\nBOOK:1\r\n")},
+		{"exclusive numeric bound", search("@year:[-inf (2015]", "NOCONTENT"), []byte("*2\r\n:1\r\n$6\r\nBOOK:2\r\n")},
+		{"sort by a number", search("*", "SORTBY", "year", "DESC", "LIMIT", "0", "2", "NOCONTENT"), []byte("*3\r\n:4\r\n$6\r\nBOOK:4\r\n$6\r\nBOOK:1\r\n")},
+		{"sort by a text", search("*", "SORTBY", "title", "NOCONTENT"), []byte("*5\r\n:4\r\n$6\r\nBOOK:4\r\n$6\r\nBOOK:3\r\n$6\r\nBOOK:2\r\n$6\r\nBOOK:1\r\n")},
+		{"limit", search("*", "LIMIT", "1", "2", "NOCONTENT"), []byte("*3\r\n:4\r\n$6\r\nBOOK:2\r\n$6\r\nBOOK:3\r\n")},
+		{"count only", search("*", "LIMIT", "0", "0"), []byte("*1\r\n:4\r\n")},
+		{"return fields", search("@author:{das}", "RETURN", "3", "title", "about", "missing"),
+			[]byte("*3\r\n:1\r\n$6\r\nBOOK:3\r\n*4\r\n$5\r\ntitle\r\n$14\r\nLearning Redis\r\n$5\r\nabout\r\n$38\r\nCaching and data structures with Redis\r\n")},
+		{"return no field", search("@author:{das}", "RETURN", "0"), []byte("*2\r\n:1\r\n$6\r\nBOOK:3\r\n")},
+		{"unbalanced parentheses", search("(go"), []byte("-ERR Syntax error in query\r\n")},
+		{"words in a numeric field", search("@year:go"), []byte("-ERR Field 'year' is not a TEXT field\r\n")},
+		{"sort by an unknown field", search("*", "SORTBY", "pages"), []byte("-ERR Property 'pages' not loaded nor in schema\r\n")},
+		{"invalid limit", search("*", "LIMIT", "0"), []byte("-ERR syntax error\r\n")},
+	})
+}
+
+func TestSearchIndexFollowsTheKeyspace(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "FT.CREATE", "notes", "PREFIX", "1", "note:", "SCHEMA", "text", "TEXT", "tags", "TAG", "SEPARATOR", ";")
+	defer evalAs(c, "FT.DROPINDEX", "notes")
+	count := func(query string) int64 {
+		t.Helper()
+		evalAs(c, "FT.SEARCH", "notes", query, "LIMIT", "0", "0")
+		return arrayOfIntegers(t, c)[0]
+	}
+
+	evalAs(c, "HSET", "note:1", "text", "buy milk", "tags", "home;todo")
+	evalAs(c, "HSET", "note:2", "text", "call bob", "tags", "work")
+	evalAs(c, "HSET", "other:1", "text", "buy milk")
+	if n := count("milk"); n != 1 {
+		t.Errorf("got %d notes about milk, want 1", n)
+	}
+
+	evalAs(c, "HSET", "note:1", "text", "buy bread")
+	evalAs(c, "HDEL", "note:2", "tags")
+	if n := count("milk | @tags:{work}"); n != 0 {
+		t.Errorf("got %d notes after updating them, want 0", n)
+	}
+	evalAs(c, "SET", "note:2", "not a hash")
+	evalAs(c, "DEL", "note:1")
+	if n := count("*"); n != 0 {
+		t.Errorf("got %d notes after deleting them, want 0", n)
+	}
+
+	// expired keys and expired fields leave the index
+	evalAs(c, "HSET", "note:3", "text", "expiring note")
+	evalAs(c, "HSET", "note:4", "text", "expiring field", "tags", "todo")
+	evalAs(c, "PEXPIREAT", "note:3", strconv.FormatInt(time.Now().UnixMilli()+1, 10))
+	evalAs(c, "HPEXPIRE", "note:4", "1", "FIELDS", "1", "text")
+	time.Sleep(5 * time.Millisecond)
+	if n := count("expiring"); n != 0 {
+		t.Errorf("got %d notes after their expiry, want 0", n)
+	}
+	if n := count("@tags:{todo}"); n != 1 {
+		t.Errorf("got %d notes with the todo tag, want 1", n)
+	}
+
+	// evicted keys leave the index
+	evalAs(c, "FLUSHDB")
+	withKeysLimit(t, 10, "allkeys-random")
+	for i := 0; i < 30; i++ {
+		evalAs(c, "HSET", "note:"+strconv.Itoa(i), "text", "evicted")
+	}
+	evalAs(c, "FT.SEARCH", "notes", "evicted", "LIMIT", "0", "30", "NOCONTENT")
+	reply, _ := core.Decode(c.LastWrite)
+	keys := reply.([]interface{})[1:]
+	if len(keys) == 0 || len(keys) >= 30 {
+		t.Errorf("got %d notes out of 30 with a limit of 10 keys", len(keys))
+	}
+	for _, key := range keys {
+		evalAs(c, "HGET", key.(string), "text")
+		expectWrite(t, c, "$7\r\nevicted\r\n")
+	}
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"math/rand"
+	"strconv"
+
+	"github.com/diceclone/config"
+)
+
+// sets made of integers only start as an intset and are converted to a go map, never to be converted back,
+// once a member is not an integer or the set outgrows set-max-intset-entries
+
+func newSetObj() *Obj {
+	return NewObj(newIntset(), -1, OBJ_TYPE_SET, OBJ_ENCODING_INTSET)
+}
+
+// newSetObjFrom creates a set holding the members, picking the encoding that fits them
+func newSetObjFrom(members []string) *Obj {
+	obj := newSetObj()
+	for _, m := range members {
+		setAdd(obj, m)
+	}
+	return obj
+}
+
+func setLen(obj *Obj) int {
+	switch v := obj.Value.(type) {
+	case *intset:
+		return v.Len()
+	case map[string]struct{}:
+		return len(v)
+	}
+	return 0
+}
+
+func setIsMember(obj *Obj, member string) bool {
+	switch v := obj.Value.(type) {
+	case *intset:
+		n, ok := parseInt64(member)
+		return ok && v.Contains(n)
+	case map[string]struct{}:
+		_, ok := v[member]
+		return ok
+	}
+	return false
+}
+
+// setAdd adds the member and tells whether it was not already in the set
+func setAdd(obj *Obj, member string) bool {
+	if is, ok := obj.Value.(*intset); ok {
+		n, isInt := parseInt64(member)
+		if isInt {
+			if !is.Add(n) {
+				return false
+			}
+			if is.Len() > config.SET_MAX_INTSET_ENTRIES {
+				setTypeConvert(obj)
+			}
+			return true
+		}
+		setTypeConvert(obj)
+	}
+
+	m := obj.Value.(map[string]struct{})
+	if _, ok := m[member]; ok {
+		return false
+	}
+	m[member] = struct{}{}
+	return true
+}
+
+// setRemove removes the member and tells whether it was in the set
+func setRemove(obj *Obj, member string) bool {
+	switch v := obj.Value.(type) {
+	case *intset:
+		n, ok := parseInt64(member)
+		return ok && v.Remove(n)
+	case map[string]struct{}:
+		if _, ok := v[member]; !ok {
+			return false
+		}
+		delete(v, member)
+		return true
+	}
+	return false
+}
+
+// setMembers returns the members of the set, sorted by value when the set is an intset
+func setMembers(obj *Obj) []string {
+	switch v := obj.Value.(type) {
+	case *intset:
+		members := make([]string, v.Len())
+		for i := range members {
+			members[i] = strconv.FormatInt(v.Get(i), 10)
+		}
+		return members
+	case map[string]struct{}:
+		members := make([]string, 0, len(v))
+		for m := range v {
+			members = append(members, m)
+		}
+		return members
+	}
+	return nil
+}
+
+// setRandomMember returns a random member of a non empty set
+func setRandomMember(obj *Obj) string {
+	switch v := obj.Value.(type) {
+	case *intset:
+		return strconv.FormatInt(v.Get(rand.Intn(v.Len())), 10)
+	case map[string]struct{}:
+		// go randomizes the iteration order of maps, but not uniformly, so pick an index instead
+		i := rand.Intn(len(v))
+		for m := range v {
+			if i == 0 {
+				return m
+			}
+			i--
+		}
+	}
+	return ""
+}
+
+func setTypeConvert(obj *Obj) {
+	is := obj.Value.(*intset)
+	m := make(map[string]struct{}, is.Len())
+	for i := 0; i < is.Len(); i++ {
+		m[strconv.FormatInt(is.Get(i), 10)] = struct{}{}
+	}
+	obj.Value = m
+	obj.setEncoding(OBJ_ENCODING_HT)
+}
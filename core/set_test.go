@@ -0,0 +1,213 @@
+package core_test
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/diceclone/config"
+	"github.com/diceclone/core"
+)
+
+// sortedReply decodes an array reply and sorts it, set members come in no particular order
+func sortedReply(t *testing.T, c *MockReadWriter) []string {
+	t.Helper()
+	reply, err := core.Decode(c.LastWrite)
+	if err != nil {
+		t.Fatalf("cannot decode %q: %v", c.LastWrite, err)
+	}
+	var members []string
+	for _, m := range reply.([]interface{}) {
+		members = append(members, m.(string))
+	}
+	sort.Strings(members)
+	return members
+}
+
+func expectMembers(t *testing.T, c *MockReadWriter, want ...string) {
+	t.Helper()
+	got := sortedReply(t, c)
+	sort.Strings(want)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSetCommands(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"add integers", &core.RedisCmd{Cmd: "SADD", Args: []string{"ints", "3", "1", "2", "1"}}, []byte(":3\r\n")},
+		{"integer sets are intsets", &core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "ints"}}, []byte("$6\r\nintset\r\n")},
+		{"intset members are sorted", &core.RedisCmd{Cmd: "SMEMBERS", Args: []string{"ints"}}, []byte("*3\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n")},
+		{"cardinality", &core.RedisCmd{Cmd: "SCARD", Args: []string{"ints"}}, []byte(":3\r\n")},
+		{"is a member", &core.RedisCmd{Cmd: "SISMEMBER", Args: []string{"ints", "2"}}, []byte(":1\r\n")},
+		{"non integer is not a member of an intset", &core.RedisCmd{Cmd: "SISMEMBER", Args: []string{"ints", "a"}}, []byte(":0\r\n")},
+		{"many members", &core.RedisCmd{Cmd: "SMISMEMBER", Args: []string{"ints", "1", "9"}}, []byte("*2\r\n:1\r\n:0\r\n")},
+		{"a string converts the intset", &core.RedisCmd{Cmd: "SADD", Args: []string{"ints", "a"}}, []byte(":1\r\n")},
+		{"converted to a hashtable", &core.RedisCmd{Cmd: "OBJECT", Args: []string{"ENCODING", "ints"}}, []byte("$9\r\nhashtable\r\n")},
+		{"remove members", &core.RedisCmd{Cmd: "SREM", Args: []string{"ints", "a", "3", "missing"}}, []byte(":2\r\n")},
+		{"type of the set", &core.RedisCmd{Cmd: "TYPE", Args: []string{"ints"}}, []byte("+set\r\n")},
+		{"move a member", &core.RedisCmd{Cmd: "SMOVE", Args: []string{"ints", "moved", "1"}}, []byte(":1\r\n")},
+		{"move a missing member", &core.RedisCmd{Cmd: "SMOVE", Args: []string{"ints", "moved", "1"}}, []byte(":0\r\n")},
+		{"destination of the move", &core.RedisCmd{Cmd: "SMEMBERS", Args: []string{"moved"}}, []byte("*1\r\n$1\r\n1\r\n")},
+		{"pop the last member", &core.RedisCmd{Cmd: "SPOP", Args: []string{"ints"}}, []byte("$1\r\n2\r\n")},
+		{"the emptied set is deleted", &core.RedisCmd{Cmd: "TYPE", Args: []string{"ints"}}, []byte("+none\r\n")},
+		{"pop from a missing set", &core.RedisCmd{Cmd: "SPOP", Args: []string{"ints"}}, []byte("$-1\r\n")},
+		{"pop many from a missing set", &core.RedisCmd{Cmd: "SPOP", Args: []string{"ints", "2"}}, []byte("*0\r\n")},
+		{"random member of a missing set", &core.RedisCmd{Cmd: "SRANDMEMBER", Args: []string{"ints"}}, []byte("$-1\r\n")},
+		{"scan an intset", &core.RedisCmd{Cmd: "SSCAN", Args: []string{"moved", "0"}}, []byte("*2\r\n$1\r\n0\r\n*1\r\n$1\r\n1\r\n")},
+		{"set a string", &core.RedisCmd{Cmd: "SET", Args: []string{"setstring", "a"}}, []byte("+OK\r\n")},
+		{"set command on a string", &core.RedisCmd{Cmd: "SADD", Args: []string{"setstring", "a"}}, []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")},
+		{"algebra with a string", &core.RedisCmd{Cmd: "SUNION", Args: []string{"moved", "setstring"}}, []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")},
+	})
+}
+
+func TestSetAlgebra(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "SADD", "sa", "a", "b", "c", "d")
+	evalAs(c, "SADD", "sb", "c", "d", "e")
+	evalAs(c, "SADD", "sc", "d", "e", "f")
+
+	evalAs(c, "SINTER", "sa", "sb", "sc")
+	expectMembers(t, c, "d")
+	evalAs(c, "SINTER", "sa", "nosuchset")
+	expectMembers(t, c)
+	evalAs(c, "SUNION", "sa", "sb", "nosuchset")
+	expectMembers(t, c, "a", "b", "c", "d", "e")
+	evalAs(c, "SDIFF", "sa", "sb", "sc")
+	expectMembers(t, c, "a", "b")
+
+	evalAs(c, "SINTERSTORE", "sdst", "sa", "sb")
+	expectWrite(t, c, ":2\r\n")
+	evalAs(c, "SMEMBERS", "sdst")
+	expectMembers(t, c, "c", "d")
+	evalAs(c, "SUNIONSTORE", "sdst", "sb", "sc")
+	expectWrite(t, c, ":4\r\n")
+	evalAs(c, "SDIFFSTORE", "sdst", "sa", "sa")
+	expectWrite(t, c, ":0\r\n")
+	evalAs(c, "TYPE", "sdst")
+	expectWrite(t, c, "+none\r\n")
+
+	evalAs(c, "SADD", "sints", "1", "2", "3")
+	evalAs(c, "SUNIONSTORE", "sdst", "sints", "sints")
+	evalAs(c, "OBJECT", "ENCODING", "sdst")
+	expectWrite(t, c, "$6\r\nintset\r\n")
+
+	evalAs(c, "SINTERCARD", "2", "sa", "sb")
+	expectWrite(t, c, ":2\r\n")
+	evalAs(c, "SINTERCARD", "2", "sa", "sb", "LIMIT", "1")
+	expectWrite(t, c, ":1\r\n")
+	evalAs(c, "SINTERCARD", "3", "sa", "sb")
+	expectWrite(t, c, "-ERR Number of keys can't be greater than number of args\r\n")
+}
+
+func TestSetRandomAndPop(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "SADD", "rset", "a", "b", "c")
+
+	evalAs(c, "SRANDMEMBER", "rset", "5")
+	expectMembers(t, c, "a", "b", "c")
+	evalAs(c, "SRANDMEMBER", "rset", "-5")
+	if n := len(sortedReply(t, c)); n != 5 {
+		t.Fatalf("negative count returned %d members, want 5", n)
+	}
+	// the picks of a negative count are allocated up front, a huge count is refused instead of crashing
+	evalAs(c, "SRANDMEMBER", "rset", "-4611686018427387903")
+	expectWrite(t, c, "-ERR value is out of range, a negative count picks at most 16777216 elements\r\n")
+
+	evalAs(c, "SPOP", "rset", "2")
+	popped := sortedReply(t, c)
+	evalAs(c, "SMEMBERS", "rset")
+	left := sortedReply(t, c)
+	if len(popped) != 2 || len(left) != 1 {
+		t.Fatalf("popped %v and left %v", popped, left)
+	}
+}
+
+func TestSetIntsetConversionAndScan(t *testing.T) {
+	defer func(entries int) { config.SET_MAX_INTSET_ENTRIES = entries }(config.SET_MAX_INTSET_ENTRIES)
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "CONFIG", "SET", "set-max-intset-entries", "16")
+
+	args := []string{"bigset"}
+	for i := 0; i < 200; i++ {
+		args = append(args, strconv.Itoa(i))
+	}
+	evalAs(c, "SADD", args...)
+	evalAs(c, "OBJECT", "ENCODING", "bigset")
+	expectWrite(t, c, "$9\r\nhashtable\r\n")
+
+	seen := make(map[string]bool)
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		if calls > 200 {
+			t.Fatalf("scan did not terminate")
+		}
+		evalAs(c, "SSCAN", "bigset", cursor, "MATCH", "1*", "COUNT", "15")
+		reply, _ := core.Decode(c.LastWrite)
+		parts := reply.([]interface{})
+		for _, m := range parts[1].([]interface{}) {
+			seen[m.(string)] = true
+		}
+		cursor = parts[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+	// 1, 10 to 19 and 100 to 199
+	if len(seen) != 111 {
+		t.Fatalf("scan returned %d members, want 111", len(seen))
+	}
+}
+
+func TestSScanSkipsMembersRemovedDuringIteration(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+
+	args := []string{"scanset"}
+	for i := 0; i < 200; i++ {
+		args = append(args, "m"+strconv.Itoa(i))
+	}
+	evalAs(c, "SADD", args...)
+
+	seen := make(map[string]bool)
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		if calls > 200 {
+			t.Fatalf("scan did not terminate")
+		}
+		evalAs(c, "SSCAN", "scanset", cursor, "COUNT", "10")
+		reply, _ := core.Decode(c.LastWrite)
+		parts := reply.([]interface{})
+		for _, m := range parts[1].([]interface{}) {
+			if calls > 0 && m.(string) >= "m1" && m.(string) < "m2" {
+				t.Fatalf("scan returned %s after it was removed", m)
+			}
+			seen[m.(string)] = true
+		}
+		cursor = parts[0].(string)
+		if cursor == "0" {
+			break
+		}
+		if calls == 0 {
+			// the members starting with m1 are removed once the iteration started
+			removed := []string{"scanset"}
+			for i := 0; i < 200; i++ {
+				if m := "m" + strconv.Itoa(i); m >= "m1" && m < "m2" && !seen[m] {
+					removed = append(removed, m)
+				}
+			}
+			evalAs(c, "SREM", removed...)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		if m := "m" + strconv.Itoa(i); (m < "m1" || m >= "m2") && !seen[m] {
+			t.Fatalf("scan did not return %s", m)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package core
+
+import "math/rand"
+
+// ZSKIPLIST_MAXLEVEL is enough for 2^64 elements with ZSKIPLIST_P = 1/4
+const ZSKIPLIST_MAXLEVEL = 32
+const ZSKIPLIST_P = 0.25
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	// span is the number of nodes the forward link skips, it is what ranks are computed with
+	span int
+}
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+// zskiplist keeps the elements of a sorted set ordered by score and then by member,
+// it is the same structure redis uses, with spans that make rank lookups logarithmic
+type zskiplist struct {
+	header *zskiplistNode
+	tail   *zskiplistNode
+	length int
+	level  int
+}
+
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		header: &zskiplistNode{level: make([]zskiplistLevel, ZSKIPLIST_MAXLEVEL)},
+		level:  1,
+	}
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for rand.Float64() < ZSKIPLIST_P && level < ZSKIPLIST_MAXLEVEL {
+		level++
+	}
+	return level
+}
+
+// zslLess tells whether the node sorts before the score and member
+func zslLess(x *zskiplistNode, score float64, member string) bool {
+	return x.score < score || (x.score == score && x.member < member)
+}
+
+// insert adds an element, the caller makes sure the member is not already in the skiplist
+func (zsl *zskiplist) insert(score float64, member string) *zskiplistNode {
+	var update [ZSKIPLIST_MAXLEVEL]*zskiplistNode
+	var rank [ZSKIPLIST_MAXLEVEL]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && zslLess(x.level[i].forward, score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+// delete removes the element and tells whether it was found
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	var update [ZSKIPLIST_MAXLEVEL]*zskiplistNode
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && zslLess(x.level[i].forward, score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+	return true
+}
+
+// rank returns the 1-based rank of the element, 0 when it is not in the skiplist
+func (zsl *zskiplist) rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.score < score || (x.level[i].forward.score == score && x.level[i].forward.member <= member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.score == score && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the element at the 1-based rank
+func (zsl *zskiplist) byRank(rank int) *zskiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type serverStats struct {
+	evictedKeys      int64
+	expiredKeys      int64
+	expiredSubkeys   int64
+	expiredStalePerc float64
+	evictionTime     time.Duration
+	// evictedIdleTimes holds a histogram of the idle time of evicted keys for every eviction policy
+	evictedIdleTimes map[string][]int64
+}
+
+var stats = serverStats{
+	evictedIdleTimes: make(map[string][]int64),
+}
+
+// upper bounds in seconds of the idle time histogram buckets, the last bucket is unbounded
+var idleTimeBuckets = []struct {
+	label string
+	limit uint32
+}{
+	{"le_1s", 1},
+	{"le_10s", 10},
+	{"le_1m", 60},
+	{"le_10m", 600},
+	{"le_1h", 3600},
+	{"le_1d", 86400},
+	{"gt_1d", ^uint32(0)},
+}
+
+func recordEvictedIdleTime(policy string, idleTime uint32) {
+	histogram, ok := stats.evictedIdleTimes[policy]
+	if !ok {
+		histogram = make([]int64, len(idleTimeBuckets))
+		stats.evictedIdleTimes[policy] = histogram
+	}
+	for i, bucket := range idleTimeBuckets {
+		if idleTime <= bucket.limit {
+			histogram[i]++
+			return
+		}
+	}
+}
+
+type infoSection struct {
+	name   string
+	render func() string
+}
+
+// sections rendered by INFO, in the order they are printed
+var infoSections = []infoSection{
+	{name: "stats", render: statsInfo},
+	{name: "eviction", render: evictionInfo},
+	{name: "keyspace", render: keyspaceInfo},
+}
+
+// INFO without arguments keeps reporting only the keyspace section
+var defaultInfoSections = []string{"keyspace"}
+
+func statsInfo() string {
+	var b strings.Builder
+	b.WriteString("# Stats\n")
+	b.WriteString(fmt.Sprintf("expired_keys:%d\n", stats.expiredKeys))
+	b.WriteString(fmt.Sprintf("expired_subkeys:%d\n", stats.expiredSubkeys))
+	b.WriteString(fmt.Sprintf("expired_stale_perc:%.2f\n", stats.expiredStalePerc*100))
+	b.WriteString(fmt.Sprintf("evicted_keys:%d\n", stats.evictedKeys))
+	b.WriteString(fmt.Sprintf("total_eviction_time_us:%d\n", stats.evictionTime.Microseconds()))
+	return b.String()
+}
+
+func evictionInfo() string {
+	var b strings.Builder
+	b.WriteString("# Eviction\n")
+	for _, policy := range evictionPolicies {
+		histogram, ok := stats.evictedIdleTimes[policy]
+		if !ok {
+			continue
+		}
+		buckets := make([]string, len(idleTimeBuckets))
+		for i, bucket := range idleTimeBuckets {
+			buckets[i] = fmt.Sprintf("%s=%d", bucket.label, histogram[i])
+		}
+		b.WriteString(fmt.Sprintf("evicted_idle_time_%s:%s\n", policy, strings.Join(buckets, ",")))
+	}
+	return b.String()
+}
+
+func keyspaceInfo() string {
+	return fmt.Sprintf("# Keyspace\ndb0:keys=%d,expires=0,avg_ttl=0\n", KeyspaceSize())
+}
+
+func renderInfo(sections []string) string {
+	wanted := make(map[string]bool)
+	for _, s := range sections {
+		wanted[strings.ToLower(s)] = true
+	}
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if wanted["all"] || wanted["everything"] || wanted[section.name] {
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(section.render())
+		}
+	}
+	return b.String()
+}
@@ -20,18 +20,33 @@ func init() {
 func Put(key string, value *Obj) {
 	// takes care of evicting policy
 	Evict()
+	put(key, value)
+}
 
+// put stores the value without making room for it first, commands writing several keys make room
+// once before the first write so that they cannot evict the keys they just wrote
+func put(key string, value *Obj) {
 	if !exists(key) {
 		keysCount++
 	}
-	value.LastAccessedAt = uint32(time.Now().Unix()) & 0x00FFFFFF
+	touch(value)
 	store[strings.ToUpper(key)] = value
+	forgetScanOrder(key)
+	updateIndexes(key)
+	signalKeyModified(key)
+	// a new value may serve the clients blocked on the key
+	signalKeyAsReady(key)
 	logger.Printf("Put: Key=%s, Value=%v", key, value)
 }
 
 func Get(k string) *Obj {
 	if v, ok := store[strings.ToUpper(k)]; ok {
-		v.LastAccessedAt = uint32(time.Now().Unix()) & 0x00FFFFFFF
+		if v.HasExpired() {
+			expireKey(k)
+			logger.Printf("Get: Key=%s expired", k)
+			return nil
+		}
+		touch(v)
 		logger.Printf("Get: Key=%s, Value=%v", k, v)
 		return v
 	}
@@ -39,10 +54,41 @@ func Get(k string) *Obj {
 	return nil
 }
 
+// peek returns the object stored at the key without counting it as an access
+func peek(k string) *Obj {
+	v, ok := store[strings.ToUpper(k)]
+	if !ok {
+		return nil
+	}
+	if v.HasExpired() {
+		expireKey(k)
+		return nil
+	}
+	return v
+}
+
+// getOfType returns the object stored at the key when it holds a value of the expected type,
+// a missing key is reported as a nil object and a different type as a WRONGTYPE error
+func getOfType(k string, oType uint8) (*Obj, error) {
+	v := Get(k)
+	if v == nil {
+		return nil, nil
+	}
+	if !assertType(v.TypeEncoding, oType) {
+		return nil, errWrongType
+	}
+	return v, nil
+}
+
 func Delete(k string) bool {
 	if _, ok := store[strings.ToUpper(k)]; ok {
 		delete(store, strings.ToUpper(k))
 		keysCount--
+		removeFromIndexes(k)
+		forgetScanOrder(k)
+		// clients blocked in XREADGROUP on a deleted stream are unblocked with an error
+		signalKeyAsReady(k)
+		signalKeyModified(k)
 		logger.Printf("Delete: Key=%s deleted", k)
 		return true
 	}
@@ -53,6 +99,12 @@ func Delete(k string) bool {
 func ClearDB() {
 	store = make(map[string]*Obj)
 	keysCount = 0
+	clearIndexes()
+	scanOrders = make(map[string]*scanOrder)
+	for key := range blockedOnKey {
+		signalKeyAsReady(key)
+	}
+	signalDBCleared()
 	logger.Println("ClearDB: All entries cleared")
 }
 
@@ -62,7 +114,7 @@ func exists(k string) bool {
 }
 
 func (o Obj) HasExpired() bool {
-	return o.ValidTill != -1 && o.ValidTill < int(time.Now().Unix())
+	return o.ValidTill != -1 && o.ValidTill < int(time.Now().UnixMilli())
 }
 
 func (o Obj) TtlSet() bool {
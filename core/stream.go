@@ -0,0 +1,518 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/diceclone/config"
+)
+
+var errInvalidStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+// streamID is the ms-seq identifier of stream entries, ids are unique and always increasing
+type streamID struct {
+	ms  uint64
+	seq uint64
+}
+
+var streamIDMax = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) compare(other streamID) int {
+	switch {
+	case id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq):
+		return -1
+	case id == other:
+		return 0
+	default:
+		return 1
+	}
+}
+
+func (id streamID) isZero() bool {
+	return id.ms == 0 && id.seq == 0
+}
+
+// incr returns the id following id, it fails on the largest id
+func (id streamID) incr() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	default:
+		return id, false
+	}
+}
+
+// decr returns the id preceding id, it fails on 0-0
+func (id streamID) decr() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	default:
+		return id, false
+	}
+}
+
+// parseStreamID parses ms-seq ids, missingSeq is the sequence of ids given as ms only
+func parseStreamID(s string, missingSeq uint64) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	if !hasSeq {
+		return streamID{ms, missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	return streamID{ms, seq}, nil
+}
+
+// parseStreamRangeID parses a bound of XRANGE and similar commands, "-" and "+" are the smallest and the
+// largest ids and a "(" prefix excludes the id. A start given as ms only starts at its first sequence
+// and an end given as ms only ends at its last one
+func parseStreamRangeID(s string, isStart bool) (streamID, error) {
+	switch s {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return streamIDMax, nil
+	}
+
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	missingSeq := uint64(0)
+	if !isStart {
+		missingSeq = math.MaxUint64
+	}
+	id, err := parseStreamID(s, missingSeq)
+	if err != nil {
+		return id, err
+	}
+	if exclusive {
+		var ok bool
+		if isStart {
+			id, ok = id.incr()
+		} else {
+			id, ok = id.decr()
+		}
+		if !ok && isStart {
+			return id, errors.New("ERR invalid start ID for the interval")
+		}
+		if !ok {
+			return id, errors.New("ERR invalid end ID for the interval")
+		}
+	}
+	return id, nil
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+// streamNode packs consecutive entries in a listpack, every entry is stored as the ms and seq deltas
+// from the master id of the node, the number of fields and the fields with their values
+type streamNode struct {
+	master streamID
+	lp     *listpack
+	count  int
+}
+
+func (n *streamNode) entries() []streamEntry {
+	items := n.lp.Entries()
+	entries := make([]streamEntry, 0, n.count)
+	for i := 0; i < len(items); {
+		msDelta, _ := strconv.ParseUint(items[i], 10, 64)
+		seqDelta, _ := strconv.ParseUint(items[i+1], 10, 64)
+		numFields, _ := strconv.Atoi(items[i+2])
+		i += 3
+		id := streamID{n.master.ms + msDelta, n.master.seq + seqDelta}
+		if msDelta > 0 {
+			id.seq = seqDelta
+		}
+		entries = append(entries, streamEntry{id, items[i : i+2*numFields]})
+		i += 2 * numFields
+	}
+	return entries
+}
+
+func (n *streamNode) append(e streamEntry) {
+	msDelta := e.id.ms - n.master.ms
+	// entries with the master ms store their seq as a delta too, the others store it whole
+	seqDelta := e.id.seq
+	if msDelta == 0 {
+		seqDelta -= n.master.seq
+	}
+	n.lp.Append(strconv.FormatUint(msDelta, 10), strconv.FormatUint(seqDelta, 10), strconv.Itoa(len(e.fields)/2))
+	n.lp.Append(e.fields...)
+	n.count++
+}
+
+func (n *streamNode) full() bool {
+	return (config.STREAM_NODE_MAX_ENTRIES > 0 && n.count >= config.STREAM_NODE_MAX_ENTRIES) ||
+		(config.STREAM_NODE_MAX_BYTES > 0 && n.lp.Bytes() >= config.STREAM_NODE_MAX_BYTES)
+}
+
+// rebuild packs the entries again, it is how entries are removed from the middle of a node
+func (n *streamNode) rebuild(entries []streamEntry) {
+	n.lp = newListpack()
+	n.count = 0
+	if len(entries) > 0 {
+		n.master = entries[0].id
+	}
+	for _, e := range entries {
+		n.append(e)
+	}
+}
+
+// stream is the value of stream keys. Redis indexes the listpack nodes with a radix tree keyed by the
+// master id of every node, here the nodes are kept in a slice sorted by master id and looked up with
+// a binary search, which offers the same ordered seeks and walks
+type stream struct {
+	nodes        []*streamNode
+	length       int
+	lastID       streamID
+	maxDeletedID streamID
+	entriesAdded int64
+	groups       map[string]*streamGroup
+}
+
+func newStreamObj() *Obj {
+	return NewObj(&stream{groups: make(map[string]*streamGroup)}, -1, OBJ_TYPE_STREAM, OBJ_ENCODING_STREAM)
+}
+
+func streamOf(obj *Obj) *stream {
+	return obj.Value.(*stream)
+}
+
+// add appends the entry, the caller makes sure its id is greater than the last id of the stream
+func (s *stream) add(id streamID, fields []string) {
+	var node *streamNode
+	if len(s.nodes) > 0 && !s.nodes[len(s.nodes)-1].full() {
+		node = s.nodes[len(s.nodes)-1]
+	} else {
+		node = &streamNode{master: id, lp: newListpack()}
+		s.nodes = append(s.nodes, node)
+	}
+	node.append(streamEntry{id, fields})
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// nodeFor returns the index of the node that may hold the id, the last node whose master id is not greater
+func (s *stream) nodeFor(id streamID) int {
+	i := sort.Search(len(s.nodes), func(i int) bool { return s.nodes[i].master.compare(id) > 0 })
+	return max(i-1, 0)
+}
+
+// rangeEntries returns up to count entries between start and end, both inclusive, from the end when rev
+// is set, a count of 0 returns all of them
+func (s *stream) rangeEntries(start streamID, end streamID, count int, rev bool) []streamEntry {
+	out := []streamEntry{}
+	if len(s.nodes) == 0 || start.compare(end) > 0 {
+		return out
+	}
+
+	if !rev {
+		for i := s.nodeFor(start); i < len(s.nodes); i++ {
+			for _, e := range s.nodes[i].entries() {
+				if e.id.compare(start) < 0 {
+					continue
+				}
+				if e.id.compare(end) > 0 {
+					return out
+				}
+				out = append(out, e)
+				if count > 0 && len(out) >= count {
+					return out
+				}
+			}
+		}
+		return out
+	}
+
+	for i := s.nodeFor(end); i >= 0; i-- {
+		entries := s.nodes[i].entries()
+		for j := len(entries) - 1; j >= 0; j-- {
+			e := entries[j]
+			if e.id.compare(end) > 0 {
+				continue
+			}
+			if e.id.compare(start) < 0 {
+				return out
+			}
+			out = append(out, e)
+			if count > 0 && len(out) >= count {
+				return out
+			}
+		}
+	}
+	return out
+}
+
+func (s *stream) get(id streamID) (streamEntry, bool) {
+	entries := s.rangeEntries(id, id, 1, false)
+	if len(entries) == 0 {
+		return streamEntry{}, false
+	}
+	return entries[0], true
+}
+
+func (s *stream) firstEntry() (streamEntry, bool) {
+	entries := s.rangeEntries(streamID{}, streamIDMax, 1, false)
+	if len(entries) == 0 {
+		return streamEntry{}, false
+	}
+	return entries[0], true
+}
+
+func (s *stream) lastEntry() (streamEntry, bool) {
+	entries := s.rangeEntries(streamID{}, streamIDMax, 1, true)
+	if len(entries) == 0 {
+		return streamEntry{}, false
+	}
+	return entries[0], true
+}
+
+// delete removes the entry and tells whether it was in the stream
+func (s *stream) delete(id streamID) bool {
+	if len(s.nodes) == 0 {
+		return false
+	}
+	i := s.nodeFor(id)
+	node := s.nodes[i]
+	entries := node.entries()
+	for j, e := range entries {
+		if e.id != id {
+			continue
+		}
+		s.removeFromNode(i, append(entries[:j:j], entries[j+1:]...))
+		s.length--
+		if id.compare(s.maxDeletedID) > 0 {
+			s.maxDeletedID = id
+		}
+		return true
+	}
+	return false
+}
+
+// removeFromNode replaces the entries of the i-th node, dropping the node once it is empty
+func (s *stream) removeFromNode(i int, entries []streamEntry) {
+	if len(entries) == 0 {
+		s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+		return
+	}
+	s.nodes[i].rebuild(entries)
+}
+
+const (
+	STREAM_TRIM_MAXLEN = iota
+	STREAM_TRIM_MINID
+)
+
+// streamTrimSpec holds the MAXLEN or MINID trimming options of XADD and XTRIM
+type streamTrimSpec struct {
+	strategy int
+	maxLen   int64
+	minID    streamID
+	approx   bool
+	limit    int64
+	hasLimit bool
+}
+
+// trim evicts the oldest entries beyond the threshold and returns how many were removed. An approximate
+// trim only removes whole nodes, so it may leave a few more entries than asked, in exchange for its speed
+func (s *stream) trim(spec *streamTrimSpec) int64 {
+	limit := spec.limit
+	if spec.approx && !spec.hasLimit {
+		limit = int64(max(config.STREAM_NODE_MAX_ENTRIES, 1)) * 100
+	}
+	if !spec.approx {
+		limit = 0
+	}
+
+	removed := int64(0)
+	for len(s.nodes) > 0 {
+		node := s.nodes[0]
+		entries := node.entries()
+
+		// the whole node goes when all its entries are beyond the threshold
+		var whole bool
+		if spec.strategy == STREAM_TRIM_MAXLEN {
+			whole = int64(s.length-node.count) >= spec.maxLen
+		} else {
+			whole = entries[len(entries)-1].id.compare(spec.minID) < 0
+		}
+		if whole {
+			if limit > 0 && removed+int64(node.count) > limit {
+				break
+			}
+			s.nodes = s.nodes[1:]
+			s.length -= node.count
+			removed += int64(node.count)
+			continue
+		}
+		if spec.approx {
+			break
+		}
+
+		drop := 0
+		for _, e := range entries {
+			if spec.strategy == STREAM_TRIM_MAXLEN && int64(s.length-drop) <= spec.maxLen {
+				break
+			}
+			if spec.strategy == STREAM_TRIM_MINID && e.id.compare(spec.minID) >= 0 {
+				break
+			}
+			drop++
+		}
+		if drop > 0 {
+			s.removeFromNode(0, entries[drop:])
+			s.length -= drop
+			removed += int64(drop)
+		}
+		break
+	}
+	return removed
+}
+
+// streamNACK is a pending entry of a consumer group, delivered to a consumer and not acknowledged yet
+type streamNACK struct {
+	consumer      *streamConsumer
+	deliveryTime  int64
+	deliveryCount int64
+}
+
+type streamConsumer struct {
+	name       string
+	seenTime   int64
+	activeTime int64
+	pending    map[streamID]*streamNACK
+}
+
+type streamGroup struct {
+	name        string
+	lastID      streamID
+	entriesRead int64
+	pel         map[streamID]*streamNACK
+	consumers   map[string]*streamConsumer
+}
+
+func newStreamGroup(name string, lastID streamID, entriesRead int64) *streamGroup {
+	return &streamGroup{
+		name:        name,
+		lastID:      lastID,
+		entriesRead: entriesRead,
+		pel:         make(map[streamID]*streamNACK),
+		consumers:   make(map[string]*streamConsumer),
+	}
+}
+
+// consumer returns the consumer of the group, creating it when create is set
+func (g *streamGroup) consumer(name string, create bool, now int64) (*streamConsumer, bool) {
+	if c, ok := g.consumers[name]; ok {
+		return c, false
+	}
+	if !create {
+		return nil, false
+	}
+	c := &streamConsumer{name: name, seenTime: now, activeTime: -1, pending: make(map[streamID]*streamNACK)}
+	g.consumers[name] = c
+	return c, true
+}
+
+// pendingIDs returns the ids of the pending entries in increasing order
+func pendingIDs(pel map[streamID]*streamNACK) []streamID {
+	ids := make([]streamID, 0, len(pel))
+	for id := range pel {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].compare(ids[j]) < 0 })
+	return ids
+}
+
+// assign makes the consumer the owner of the pending entry, creating the entry when it is not pending
+func (g *streamGroup) assign(id streamID, c *streamConsumer, now int64) *streamNACK {
+	nack, ok := g.pel[id]
+	if !ok {
+		nack = &streamNACK{}
+		g.pel[id] = nack
+	} else if nack.consumer != nil {
+		delete(nack.consumer.pending, id)
+	}
+	nack.consumer = c
+	nack.deliveryTime = now
+	c.pending[id] = nack
+	return nack
+}
+
+// ack removes the entry from the pending entries and tells whether it was pending
+func (g *streamGroup) ack(id streamID) bool {
+	nack, ok := g.pel[id]
+	if !ok {
+		return false
+	}
+	delete(nack.consumer.pending, id)
+	delete(g.pel, id)
+	return true
+}
+
+func (g *streamGroup) deleteConsumer(name string) int {
+	c, ok := g.consumers[name]
+	if !ok {
+		return 0
+	}
+	pending := len(c.pending)
+	for id := range c.pending {
+		delete(g.pel, id)
+	}
+	delete(g.consumers, name)
+	return pending
+}
+
+// sortedGroupNames returns the names of the consumer groups of the stream in a stable order
+func (s *stream) sortedGroupNames() []string {
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lag is the number of entries of the stream the group has not read yet
+func (s *stream) lag(g *streamGroup) int {
+	if g.lastID == streamIDMax {
+		return 0
+	}
+	next, _ := g.lastID.incr()
+	return len(s.rangeEntries(next, streamIDMax, 0, false))
+}
+
+func (e streamEntry) reply() []interface{} {
+	return []interface{}{e.id.String(), e.fields}
+}
+
+func streamEntriesReply(entries []streamEntry) []interface{} {
+	out := make([]interface{}, len(entries))
+	for i, e := range entries {
+		out[i] = e.reply()
+	}
+	return out
+}
@@ -0,0 +1,315 @@
+package core_test
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/diceclone/config"
+	"github.com/diceclone/core"
+)
+
+func TestStreamAddAndRange(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"xadd with an explicit id", &core.RedisCmd{Cmd: "XADD", Args: []string{"events", "1-1", "a", "1"}}, []byte("$3\r\n1-1\r\n")},
+		{"xadd with a sequence to generate", &core.RedisCmd{Cmd: "XADD", Args: []string{"events", "1-*", "b", "2"}}, []byte("$3\r\n1-2\r\n")},
+		{"xadd with a smaller id", &core.RedisCmd{Cmd: "XADD", Args: []string{"events", "1-1", "c", "3"}}, []byte("-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n")},
+		{"xadd with 0-0", &core.RedisCmd{Cmd: "XADD", Args: []string{"zeros", "0-0", "c", "3"}}, []byte("-ERR The ID specified in XADD must be greater than 0-0\r\n")},
+		{"failed xadd does not create the key", &core.RedisCmd{Cmd: "TYPE", Args: []string{"zeros"}}, []byte("+none\r\n")},
+		{"xadd with an odd number of fields", &core.RedisCmd{Cmd: "XADD", Args: []string{"events", "*", "a"}}, []byte("-ERR wrong number of arguments for 'xadd' command\r\n")},
+		{"xadd with an invalid id", &core.RedisCmd{Cmd: "XADD", Args: []string{"events", "x-1", "a", "1"}}, []byte("-ERR Invalid stream ID specified as stream command argument\r\n")},
+		{"xadd nomkstream on a missing key", &core.RedisCmd{Cmd: "XADD", Args: []string{"nostream", "NOMKSTREAM", "*", "a", "1"}}, []byte("$-1\r\n")},
+		{"xadd a third entry", &core.RedisCmd{Cmd: "XADD", Args: []string{"events", "2-0", "c", "3", "d", "4"}}, []byte("$3\r\n2-0\r\n")},
+		{"xlen", &core.RedisCmd{Cmd: "XLEN", Args: []string{"events"}}, []byte(":3\r\n")},
+		{"xlen on a missing key", &core.RedisCmd{Cmd: "XLEN", Args: []string{"nostream"}}, []byte(":0\r\n")},
+		{"type of a stream", &core.RedisCmd{Cmd: "TYPE", Args: []string{"events"}}, []byte("+stream\r\n")},
+		{"xrange everything", &core.RedisCmd{Cmd: "XRANGE", Args: []string{"events", "-", "+"}},
+			[]byte("*3\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n*2\r\n$3\r\n2-0\r\n*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nd\r\n$1\r\n4\r\n")},
+		{"xrange with an ms only end", &core.RedisCmd{Cmd: "XRANGE", Args: []string{"events", "-", "1", "COUNT", "5"}},
+			[]byte("*2\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n")},
+		{"xrange with an exclusive start", &core.RedisCmd{Cmd: "XRANGE", Args: []string{"events", "(1-2", "+"}},
+			[]byte("*1\r\n*2\r\n$3\r\n2-0\r\n*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nd\r\n$1\r\n4\r\n")},
+		{"xrevrange with count", &core.RedisCmd{Cmd: "XREVRANGE", Args: []string{"events", "+", "-", "COUNT", "1"}},
+			[]byte("*1\r\n*2\r\n$3\r\n2-0\r\n*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nd\r\n$1\r\n4\r\n")},
+		{"xrange on a missing key", &core.RedisCmd{Cmd: "XRANGE", Args: []string{"nostream", "-", "+"}}, []byte("*0\r\n")},
+		{"xdel", &core.RedisCmd{Cmd: "XDEL", Args: []string{"events", "1-2", "5-0"}}, []byte(":1\r\n")},
+		{"xrange after xdel", &core.RedisCmd{Cmd: "XRANGE", Args: []string{"events", "-", "1-5"}}, []byte("*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n")},
+		{"xadd keeps ids increasing after xdel", &core.RedisCmd{Cmd: "XADD", Args: []string{"events", "2-0", "e", "5"}}, []byte("-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n")},
+		{"push to a list", &core.RedisCmd{Cmd: "RPUSH", Args: []string{"notastream", "a"}}, []byte(":1\r\n")},
+		{"xadd on a wrong type", &core.RedisCmd{Cmd: "XADD", Args: []string{"notastream", "*", "a", "1"}}, []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")},
+	})
+}
+
+func TestStreamAutoIDs(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	previous := ""
+	for i := 0; i < 5; i++ {
+		evalAs(c, "XADD", "autoids", "*", "n", strconv.Itoa(i))
+		id := string(c.LastWrite)
+		if id <= previous && len(id) <= len(previous) {
+			t.Fatalf("id %q does not follow %q", id, previous)
+		}
+		previous = id
+	}
+	evalAs(c, "XLEN", "autoids")
+	expectWrite(t, c, ":5\r\n")
+}
+
+func TestStreamTrimming(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	defer func(entries int) { config.STREAM_NODE_MAX_ENTRIES = entries }(config.STREAM_NODE_MAX_ENTRIES)
+	config.STREAM_NODE_MAX_ENTRIES = 4
+
+	for i := 1; i <= 10; i++ {
+		evalAs(c, "XADD", "trimmed", strconv.Itoa(i)+"-0", "n", strconv.Itoa(i))
+	}
+
+	// approximate trimming only removes whole nodes, the first node holds 1-0 to 4-0
+	evalAs(c, "XTRIM", "trimmed", "MAXLEN", "~", "5")
+	expectWrite(t, c, ":4\r\n")
+	evalAs(c, "XTRIM", "trimmed", "MAXLEN", "=", "5")
+	expectWrite(t, c, ":1\r\n")
+	evalAs(c, "XRANGE", "trimmed", "-", "+", "COUNT", "1")
+	expectWrite(t, c, "*1\r\n*2\r\n$3\r\n6-0\r\n*2\r\n$1\r\nn\r\n$1\r\n6\r\n")
+
+	evalAs(c, "XTRIM", "trimmed", "MINID", "8")
+	expectWrite(t, c, ":2\r\n")
+	evalAs(c, "XLEN", "trimmed")
+	expectWrite(t, c, ":3\r\n")
+
+	evalAs(c, "XADD", "trimmed", "MAXLEN", "1", "11-0", "n", "11")
+	expectWrite(t, c, "$4\r\n11-0\r\n")
+	evalAs(c, "XLEN", "trimmed")
+	expectWrite(t, c, ":1\r\n")
+
+	evalAs(c, "XTRIM", "trimmed", "MAXLEN", "1", "LIMIT", "10")
+	expectWrite(t, c, "-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n")
+	evalAs(c, "XTRIM", "trimmed", "MAXLEN", "-1")
+	expectWrite(t, c, "-ERR The MAXLEN argument must be >= 0.\r\n")
+}
+
+func TestStreamRead(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "XADD", "reads", "1-0", "a", "1")
+	evalAs(c, "XADD", "reads", "2-0", "b", "2")
+
+	evalAs(c, "XREAD", "COUNT", "1", "STREAMS", "reads", "missing", "0", "0")
+	expectWrite(t, c, "*1\r\n*2\r\n$5\r\nreads\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n")
+	evalAs(c, "XREAD", "STREAMS", "reads", "2-0")
+	expectWrite(t, c, "*-1\r\n")
+	evalAs(c, "XREAD", "STREAMS", "reads", "missing", "0")
+	expectWrite(t, c, "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.\r\n")
+}
+
+func TestStreamBlockingRead(t *testing.T) {
+	reader, _ := setupTest()
+	writer, _ := setupTest()
+	evalAs(writer, "FLUSHDB")
+	evalAs(writer, "XADD", "blocked", "1-0", "a", "1")
+
+	// "$" only waits for entries added after the call
+	evalAs(reader, "XREAD", "BLOCK", "0", "STREAMS", "blocked", "$")
+	if reader.LastWrite != nil {
+		t.Fatalf("xread replied %q instead of blocking", reader.LastWrite)
+	}
+	evalAs(writer, "XADD", "blocked", "2-0", "b", "2")
+	expectWrite(t, reader, "*1\r\n*2\r\n$7\r\nblocked\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n")
+
+	// blocked on a missing key, served when the stream is created
+	reader.LastWrite = nil
+	evalAs(reader, "XREAD", "BLOCK", "0", "STREAMS", "later", "0")
+	evalAs(writer, "XADD", "later", "5-0", "c", "3")
+	expectWrite(t, reader, "*1\r\n*2\r\n$5\r\nlater\r\n*1\r\n*2\r\n$3\r\n5-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n")
+
+	reader.LastWrite = nil
+	evalAs(reader, "XREAD", "BLOCK", "10", "STREAMS", "blocked", "$")
+	time.Sleep(20 * time.Millisecond)
+	core.HandleBlockedClientsTimeout()
+	expectWrite(t, reader, "*-1\r\n")
+}
+
+func TestStreamConsumerGroups(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"xgroup create on a missing key", &core.RedisCmd{Cmd: "XGROUP", Args: []string{"jobs", "CREATE", "jobs", "workers", "0"}}, []byte("-ERR unknown subcommand 'jobs'. Try XGROUP HELP.\r\n")},
+		{"xgroup create requires the key", &core.RedisCmd{Cmd: "XGROUP", Args: []string{"CREATE", "jobs", "workers", "0"}}, []byte("-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.\r\n")},
+		{"xgroup create with mkstream", &core.RedisCmd{Cmd: "XGROUP", Args: []string{"CREATE", "jobs", "workers", "$", "MKSTREAM"}}, []byte("+OK\r\n")},
+		{"xgroup create twice", &core.RedisCmd{Cmd: "XGROUP", Args: []string{"CREATE", "jobs", "workers", "$"}}, []byte("-BUSYGROUP Consumer Group name already exists\r\n")},
+		{"add a first job", &core.RedisCmd{Cmd: "XADD", Args: []string{"jobs", "1-0", "job", "a"}}, []byte("$3\r\n1-0\r\n")},
+		{"add a second job", &core.RedisCmd{Cmd: "XADD", Args: []string{"jobs", "2-0", "job", "b"}}, []byte("$3\r\n2-0\r\n")},
+		{"xreadgroup on a missing group", &core.RedisCmd{Cmd: "XREADGROUP", Args: []string{"GROUP", "nobody", "alice", "STREAMS", "jobs", ">"}}, []byte("-NOGROUP No such key 'jobs' or consumer group 'nobody' in XREADGROUP with GROUP option\r\n")},
+		{"alice reads a new job", &core.RedisCmd{Cmd: "XREADGROUP", Args: []string{"GROUP", "workers", "alice", "COUNT", "1", "STREAMS", "jobs", ">"}},
+			[]byte("*1\r\n*2\r\n$4\r\njobs\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$3\r\njob\r\n$1\r\na\r\n")},
+		{"bob reads the next job", &core.RedisCmd{Cmd: "XREADGROUP", Args: []string{"GROUP", "workers", "bob", "STREAMS", "jobs", ">"}},
+			[]byte("*1\r\n*2\r\n$4\r\njobs\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$3\r\njob\r\n$1\r\nb\r\n")},
+		{"no new jobs left", &core.RedisCmd{Cmd: "XREADGROUP", Args: []string{"GROUP", "workers", "bob", "STREAMS", "jobs", ">"}}, []byte("*-1\r\n")},
+		{"alice reads her history", &core.RedisCmd{Cmd: "XREADGROUP", Args: []string{"GROUP", "workers", "alice", "STREAMS", "jobs", "0"}},
+			[]byte("*1\r\n*2\r\n$4\r\njobs\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$3\r\njob\r\n$1\r\na\r\n")},
+		{"xpending summary", &core.RedisCmd{Cmd: "XPENDING", Args: []string{"jobs", "workers"}},
+			[]byte("*4\r\n:2\r\n$3\r\n1-0\r\n$3\r\n2-0\r\n*2\r\n*2\r\n$5\r\nalice\r\n$1\r\n1\r\n*2\r\n$3\r\nbob\r\n$1\r\n1\r\n")},
+		{"xack", &core.RedisCmd{Cmd: "XACK", Args: []string{"jobs", "workers", "1-0", "1-0", "9-0"}}, []byte(":1\r\n")},
+		{"alice history is empty once acked", &core.RedisCmd{Cmd: "XREADGROUP", Args: []string{"GROUP", "workers", "alice", "STREAMS", "jobs", "0"}}, []byte("*1\r\n*2\r\n$4\r\njobs\r\n*0\r\n")},
+		{"xclaim bob's job for alice", &core.RedisCmd{Cmd: "XCLAIM", Args: []string{"jobs", "workers", "alice", "0", "2-0", "JUSTID"}}, []byte("*1\r\n$3\r\n2-0\r\n")},
+		{"xclaim skips entries not idle long enough", &core.RedisCmd{Cmd: "XCLAIM", Args: []string{"jobs", "workers", "bob", "3600000", "2-0"}}, []byte("*0\r\n")},
+		{"xpending of a consumer", &core.RedisCmd{Cmd: "XPENDING", Args: []string{"jobs", "workers", "-", "+", "10", "bob"}}, []byte("*0\r\n")},
+		{"xgroup delconsumer", &core.RedisCmd{Cmd: "XGROUP", Args: []string{"DELCONSUMER", "jobs", "workers", "alice"}}, []byte(":1\r\n")},
+		{"xpending empty summary", &core.RedisCmd{Cmd: "XPENDING", Args: []string{"jobs", "workers"}}, []byte("*4\r\n:0\r\n$-1\r\n$-1\r\n$-1\r\n")},
+		{"xgroup createconsumer", &core.RedisCmd{Cmd: "XGROUP", Args: []string{"CREATECONSUMER", "jobs", "workers", "carol"}}, []byte(":1\r\n")},
+		{"xgroup createconsumer twice", &core.RedisCmd{Cmd: "XGROUP", Args: []string{"CREATECONSUMER", "jobs", "workers", "carol"}}, []byte(":0\r\n")},
+		{"xgroup setid", &core.RedisCmd{Cmd: "XGROUP", Args: []string{"SETID", "jobs", "workers", "0"}}, []byte("+OK\r\n")},
+		{"xgroup destroy", &core.RedisCmd{Cmd: "XGROUP", Args: []string{"DESTROY", "jobs", "workers"}}, []byte(":1\r\n")},
+		{"xgroup destroy a missing group", &core.RedisCmd{Cmd: "XGROUP", Args: []string{"DESTROY", "jobs", "workers"}}, []byte(":0\r\n")},
+		{"xpending on a missing group", &core.RedisCmd{Cmd: "XPENDING", Args: []string{"jobs", "workers"}}, []byte("-NOGROUP No such key 'jobs' or consumer group 'workers'\r\n")},
+	})
+}
+
+func TestStreamAutoClaimAndDeletedEntries(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "XGROUP", "CREATE", "tasks", "g", "0", "MKSTREAM")
+	for i := 1; i <= 3; i++ {
+		evalAs(c, "XADD", "tasks", strconv.Itoa(i)+"-0", "n", strconv.Itoa(i))
+	}
+	evalAs(c, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "tasks", ">")
+	evalAs(c, "XDEL", "tasks", "2-0")
+
+	evalAs(c, "XAUTOCLAIM", "tasks", "g", "bob", "0", "0", "COUNT", "1", "JUSTID")
+	expectWrite(t, c, "*3\r\n$3\r\n2-0\r\n*1\r\n$3\r\n1-0\r\n*0\r\n")
+	evalAs(c, "XAUTOCLAIM", "tasks", "g", "bob", "0", "2-0")
+	expectWrite(t, c, "*3\r\n$3\r\n0-0\r\n*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nn\r\n$1\r\n3\r\n*1\r\n$3\r\n2-0\r\n")
+
+	evalAs(c, "XPENDING", "tasks", "g")
+	expectWrite(t, c, "*4\r\n:2\r\n$3\r\n1-0\r\n$3\r\n3-0\r\n*1\r\n*2\r\n$3\r\nbob\r\n$1\r\n2\r\n")
+
+	// claiming with JUSTID leaves the delivery count of 1-0 untouched
+	evalAs(c, "XPENDING", "tasks", "g", "-", "+", "10")
+	if !bytes.Contains(c.LastWrite, []byte(":1\r\n*4\r\n$3\r\n3-0\r\n$3\r\nbob\r\n")) || !bytes.HasSuffix(c.LastWrite, []byte(":2\r\n")) {
+		t.Errorf("unexpected delivery counts %q", c.LastWrite)
+	}
+
+	evalAs(c, "XINFO", "GROUPS", "tasks")
+	want := "*1\r\n*12\r\n$4\r\nname\r\n$1\r\ng\r\n$9\r\nconsumers\r\n:2\r\n$7\r\npending\r\n:2\r\n$17\r\nlast-delivered-id\r\n$3\r\n3-0\r\n$12\r\nentries-read\r\n:3\r\n$3\r\nlag\r\n:0\r\n"
+	expectWrite(t, c, want)
+}
+
+func TestStreamBlockingReadGroup(t *testing.T) {
+	reader, _ := setupTest()
+	writer, _ := setupTest()
+	evalAs(writer, "FLUSHDB")
+	evalAs(writer, "XGROUP", "CREATE", "queue", "g", "$", "MKSTREAM")
+
+	evalAs(reader, "XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "queue", ">")
+	if reader.LastWrite != nil {
+		t.Fatalf("xreadgroup replied %q instead of blocking", reader.LastWrite)
+	}
+	evalAs(writer, "XADD", "queue", "1-0", "a", "1")
+	expectWrite(t, reader, "*1\r\n*2\r\n$5\r\nqueue\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n")
+
+	evalAs(writer, "XPENDING", "queue", "g")
+	expectWrite(t, writer, "*4\r\n:1\r\n$3\r\n1-0\r\n$3\r\n1-0\r\n*1\r\n*2\r\n$5\r\nalice\r\n$1\r\n1\r\n")
+}
+
+func TestStreamBlockingReadGroupOnDeletedStream(t *testing.T) {
+	reader, _ := setupTest()
+	writer, _ := setupTest()
+	evalAs(writer, "FLUSHDB")
+	block := func() {
+		t.Helper()
+		reader.LastWrite = nil
+		evalAs(writer, "XGROUP", "CREATE", "gone", "g", "$", "MKSTREAM")
+		evalAs(reader, "XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "gone", ">")
+		if reader.LastWrite != nil {
+			t.Fatalf("xreadgroup replied %q instead of blocking", reader.LastWrite)
+		}
+	}
+
+	block()
+	evalAs(writer, "DEL", "gone")
+	expectWrite(t, reader, "-UNBLOCKED the stream key no longer exists\r\n")
+
+	block()
+	evalAs(writer, "SET", "gone", "not a stream")
+	expectWrite(t, reader, "-UNBLOCKED the stream key no longer exists\r\n")
+
+	evalAs(writer, "DEL", "gone")
+	block()
+	evalAs(writer, "XGROUP", "DESTROY", "gone", "g")
+	expectWrite(t, reader, "-NOGROUP the consumer group this client was blocked on no longer exists\r\n")
+
+	block()
+	evalAs(writer, "FLUSHDB")
+	expectWrite(t, reader, "-UNBLOCKED the stream key no longer exists\r\n")
+}
+
+func TestStreamSetIDAndInfo(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "XADD", "info", "5-0", "a", "1")
+	evalAs(c, "XADD", "info", "6-0", "b", "2")
+	evalAs(c, "XDEL", "info", "6-0")
+
+	evalAs(c, "XSETID", "info", "4-0")
+	expectWrite(t, c, "-ERR The ID specified in XSETID is smaller than the target stream top item\r\n")
+	evalAs(c, "XSETID", "info", "10-0", "ENTRIESADDED", "7", "MAXDELETEDID", "6-0")
+	expectWrite(t, c, "+OK\r\n")
+	evalAs(c, "XADD", "info", "9-0", "c", "3")
+	expectWrite(t, c, "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n")
+
+	evalAs(c, "XINFO", "STREAM", "info")
+	want := "*18\r\n$6\r\nlength\r\n:1\r\n$15\r\nradix-tree-keys\r\n:1\r\n$17\r\nlast-generated-id\r\n$4\r\n10-0\r\n" +
+		"$20\r\nmax-deleted-entry-id\r\n$3\r\n6-0\r\n$13\r\nentries-added\r\n:7\r\n$23\r\nrecorded-first-entry-id\r\n$3\r\n5-0\r\n" +
+		"$6\r\ngroups\r\n:0\r\n$11\r\nfirst-entry\r\n*2\r\n$3\r\n5-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n" +
+		"$10\r\nlast-entry\r\n*2\r\n$3\r\n5-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n"
+	expectWrite(t, c, want)
+
+	evalAs(c, "XINFO", "STREAM", "missing")
+	expectWrite(t, c, "-ERR no such key\r\n")
+}
+
+func TestStreamRewrite(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "XADD", "aofstream", "1-0", "a", "1")
+	evalAs(c, "XADD", "aofstream", "2-0", "b", "2")
+	evalAs(c, "XGROUP", "CREATE", "aofstream", "g", "0")
+	evalAs(c, "XREADGROUP", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "aofstream", ">")
+	evalAs(c, "BGREWRITEAOF")
+
+	content, _ := os.ReadFile(config.APPEND_ONLY_FILE)
+	os.Remove(config.APPEND_ONLY_FILE)
+	for _, want := range []string{
+		"*5\r\n$4\r\nXADD\r\n$9\r\nAOFSTREAM\r\n$3\r\n1-0\r\n$1\r\na\r\n$1\r\n1\r\n",
+		"*7\r\n$6\r\nXSETID\r\n$9\r\nAOFSTREAM\r\n$3\r\n2-0\r\n$12\r\nENTRIESADDED\r\n$1\r\n2\r\n$12\r\nMAXDELETEDID\r\n$3\r\n0-0\r\n",
+		"*7\r\n$6\r\nXGROUP\r\n$6\r\nCREATE\r\n$9\r\nAOFSTREAM\r\n$1\r\ng\r\n$3\r\n1-0\r\n$11\r\nENTRIESREAD\r\n$1\r\n1\r\n",
+		"*5\r\n$6\r\nXGROUP\r\n$14\r\nCREATECONSUMER\r\n$9\r\nAOFSTREAM\r\n$1\r\ng\r\n$5\r\nalice\r\n",
+		"$6\r\nXCLAIM\r\n$9\r\nAOFSTREAM\r\n$1\r\ng\r\n$5\r\nalice\r\n$1\r\n0\r\n$3\r\n1-0\r\n$4\r\nTIME\r\n",
+	} {
+		if !bytes.Contains(content, []byte(want)) {
+			t.Errorf("AOF content misses %q:\n%q", want, content)
+		}
+	}
+
+	// replaying the rewritten commands rebuilds the group and its pending entries
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "XADD", "restored", "MAXLEN", "0", "0-1", "x", "y")
+	evalAs(c, "XSETID", "restored", "0-0", "ENTRIESADDED", "0", "MAXDELETEDID", "0-0")
+	expectWrite(t, c, "+OK\r\n")
+	evalAs(c, "XLEN", "restored")
+	expectWrite(t, c, ":0\r\n")
+	evalAs(c, "XADD", "restored", "1-0", "a", "1")
+	evalAs(c, "XGROUP", "CREATE", "restored", "g", "1-0", "ENTRIESREAD", "1")
+	evalAs(c, "XCLAIM", "restored", "g", "alice", "0", "1-0", "TIME", "1000", "RETRYCOUNT", "3", "JUSTID", "FORCE")
+	expectWrite(t, c, "*1\r\n$3\r\n1-0\r\n")
+	evalAs(c, "XPENDING", "restored", "g", "-", "+", "10")
+	if !bytes.HasPrefix(c.LastWrite, []byte("*1\r\n*4\r\n$3\r\n1-0\r\n$5\r\nalice\r\n")) || !bytes.HasSuffix(c.LastWrite, []byte(":3\r\n")) {
+		t.Errorf("unexpected pending entries %q", c.LastWrite)
+	}
+}
@@ -0,0 +1,295 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var errTSExists = errors.New("ERR TSDB: key already exists")
+var errTSMissing = errors.New("ERR TSDB: the key does not exist")
+var errTSBadTimestamp = errors.New("ERR TSDB: invalid timestamp")
+var errTSBadValue = errors.New("ERR TSDB: invalid value")
+var errTSBadRetention = errors.New("ERR TSDB: Couldn't parse RETENTION")
+var errTSBadDuplicatePolicy = errors.New("ERR TSDB: Unknown DUPLICATE_POLICY")
+var errTSBadLabels = errors.New("ERR TSDB: Couldn't parse LABELS")
+var errTSBadAggregation = errors.New("ERR TSDB: Unknown aggregation type")
+var errTSBadBucketDuration = errors.New("ERR TSDB: bucketDuration must be greater than zero")
+var errTSBadCount = errors.New("ERR TSDB: Couldn't parse COUNT")
+var errTSOlderThanRetention = errors.New("ERR TSDB: Timestamp is older than retention")
+var errTSDuplicateBlocked = errors.New("ERR TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode")
+var errTSSameKey = errors.New("ERR TSDB: the source key and destination key should be different")
+var errTSDestinationHasSource = errors.New("ERR TSDB: the destination key already has a src rule")
+var errTSDestinationHasRules = errors.New("ERR TSDB: the destination key already has a dst rule")
+var errTSRuleMissing = errors.New("ERR TSDB: compaction rule does not exist")
+var errTSBadFilter = errors.New("ERR TSDB: failed parsing labels")
+var errTSMissingFilter = errors.New("ERR TSDB: missing FILTER argument")
+var errTSNoMatcher = errors.New("ERR TSDB: please provide at least one matcher")
+
+var tsDuplicatePolicies = []string{"block", "first", "last", "min", "max", "sum"}
+var tsAggregators = []string{"avg", "sum", "min", "max", "count", "first", "last"}
+
+type tsSample struct {
+	ts    int64
+	value float64
+}
+
+type tsLabel struct {
+	name  string
+	value string
+}
+
+// tsAggregation folds the values of a bucket into the value of its aggregator
+type tsAggregation struct {
+	aggregator string
+	count      int64
+	sum        float64
+	min        float64
+	max        float64
+	first      float64
+	last       float64
+}
+
+func (a *tsAggregation) add(v float64) {
+	if a.count == 0 {
+		a.min, a.max, a.first = v, v, v
+	}
+	a.count++
+	a.sum += v
+	a.min = math.Min(a.min, v)
+	a.max = math.Max(a.max, v)
+	a.last = v
+}
+
+func (a *tsAggregation) value() float64 {
+	switch a.aggregator {
+	case "avg":
+		return a.sum / float64(a.count)
+	case "sum":
+		return a.sum
+	case "min":
+		return a.min
+	case "max":
+		return a.max
+	case "count":
+		return float64(a.count)
+	case "first":
+		return a.first
+	}
+	return a.last
+}
+
+// tsRule aggregates the samples of its source into buckets of bucketDuration milliseconds, the bucket of
+// the latest sample stays open and is added to the destination once a sample falls in a later bucket.
+// The open bucket is aggregated as samples arrive, the retention of the source may drop them before it closes
+type tsRule struct {
+	destKey        string
+	aggregator     string
+	bucketDuration int64
+	open           bool
+	bucketStart    int64
+	agg            tsAggregation
+}
+
+// timeSeries holds samples sorted by timestamp, in milliseconds. Samples older than the retention period
+// relative to the latest sample are dropped, and compaction rules downsample the series into other series
+// holding one aggregated sample per bucket of the rule
+type timeSeries struct {
+	// key is the name the series was created with, the store only knows it in upper case
+	key             string
+	samples         []tsSample
+	retention       int64
+	duplicatePolicy string
+	labels          []tsLabel
+	rules           []*tsRule
+	sourceKey       string
+}
+
+func newTimeSeries(key string, retention int64, duplicatePolicy string, labels []tsLabel) *timeSeries {
+	return &timeSeries{key: key, retention: retention, duplicatePolicy: duplicatePolicy, labels: labels}
+}
+
+func lookupTimeSeries(key string) (*timeSeries, error) {
+	obj, err := getOfType(key, OBJ_TYPE_TIMESERIES)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	return obj.Value.(*timeSeries), nil
+}
+
+func bucketStart(ts int64, bucketDuration int64) int64 {
+	return ts - ts%bucketDuration
+}
+
+func (s *timeSeries) label(name string) (string, bool) {
+	for _, l := range s.labels {
+		if l.name == name {
+			return l.value, true
+		}
+	}
+	return "", false
+}
+
+// rangeOf returns the samples between from and to, both included
+func (s *timeSeries) rangeOf(from int64, to int64) []tsSample {
+	start := sort.Search(len(s.samples), func(i int) bool { return s.samples[i].ts >= from })
+	end := sort.Search(len(s.samples), func(i int) bool { return s.samples[i].ts > to })
+	if start >= end {
+		return nil
+	}
+	return s.samples[start:end]
+}
+
+// add stores the sample, resolving a sample already at its timestamp with the policy, and feeds the
+// compaction rules with the resulting value
+func (s *timeSeries) add(ts int64, value float64, policy string) error {
+	n := len(s.samples)
+	if s.retention > 0 && n > 0 && ts < s.samples[n-1].ts-s.retention {
+		return errTSOlderThanRetention
+	}
+
+	i := sort.Search(n, func(i int) bool { return s.samples[i].ts >= ts })
+	appended := i == n
+	if i < n && s.samples[i].ts == ts {
+		cur := &s.samples[i].value
+		switch policy {
+		case "block":
+			return errTSDuplicateBlocked
+		case "last":
+			*cur = value
+		case "min":
+			*cur = math.Min(*cur, value)
+		case "max":
+			*cur = math.Max(*cur, value)
+		case "sum":
+			*cur += value
+		}
+	} else {
+		s.samples = append(s.samples, tsSample{})
+		copy(s.samples[i+1:], s.samples[i:])
+		s.samples[i] = tsSample{ts, value}
+	}
+
+	// the rules are fed before the retention trims the samples their buckets may still need
+	s.compact(ts, value, appended)
+
+	if s.retention > 0 {
+		oldest := s.samples[len(s.samples)-1].ts - s.retention
+		if drop := sort.Search(len(s.samples), func(i int) bool { return s.samples[i].ts >= oldest }); drop > 0 {
+			s.samples = append(s.samples[:0], s.samples[drop:]...)
+		}
+	}
+	return nil
+}
+
+// compact updates the destinations of the rules with the sample of value added at ts, appended tells whether
+// it is the latest sample rather than one inserted before it or updating another. The rules whose destination
+// is gone are dropped
+func (s *timeSeries) compact(ts int64, value float64, appended bool) {
+	rules := s.rules[:0]
+	for _, r := range s.rules {
+		dst, err := lookupTimeSeries(r.destKey)
+		if err != nil || dst == nil || !strings.EqualFold(dst.sourceKey, s.key) {
+			continue
+		}
+		rules = append(rules, r)
+
+		bucket := bucketStart(ts, r.bucketDuration)
+		switch {
+		case !r.open || bucket > r.bucketStart:
+			if r.open && r.agg.count > 0 {
+				dst.add(r.bucketStart, r.agg.value(), "last")
+			}
+			r.open, r.bucketStart = true, bucket
+			r.agg = tsAggregation{aggregator: r.aggregator}
+			r.agg.add(value)
+		case bucket == r.bucketStart && appended:
+			r.agg.add(value)
+		case bucket == r.bucketStart:
+			// the sample replaced or preceded another one of the open bucket, which is aggregated again
+			r.agg = s.aggregate(r, bucket)
+		default:
+			// a late sample changes a bucket already added to the destination
+			if agg := s.aggregate(r, bucket); agg.count > 0 {
+				dst.add(bucket, agg.value(), "last")
+			}
+		}
+	}
+	s.rules = rules
+}
+
+// aggregate aggregates the samples of the bucket of the rule starting at start
+func (s *timeSeries) aggregate(r *tsRule, start int64) tsAggregation {
+	agg := tsAggregation{aggregator: r.aggregator}
+	for _, sample := range s.rangeOf(start, start+r.bucketDuration-1) {
+		agg.add(sample.value)
+	}
+	return agg
+}
+
+// aggregateSamples folds the samples into one sample per bucket, timestamped with the start of the bucket
+func aggregateSamples(samples []tsSample, aggregator string, bucketDuration int64) []tsSample {
+	var out []tsSample
+	var agg tsAggregation
+	var start int64
+	for _, sample := range samples {
+		bucket := bucketStart(sample.ts, bucketDuration)
+		if agg.count > 0 && bucket != start {
+			out = append(out, tsSample{start, agg.value()})
+		}
+		if agg.count == 0 || bucket != start {
+			agg, start = tsAggregation{aggregator: aggregator}, bucket
+		}
+		agg.add(sample.value)
+	}
+	if agg.count > 0 {
+		out = append(out, tsSample{start, agg.value()})
+	}
+	return out
+}
+
+// tsFilter is a label matcher of TS.MRANGE: label=value, label!=value, label= for series without the
+// label, label!= for series with it, and label=(value,...) or label!=(value,...) for lists of values
+type tsFilter struct {
+	label  string
+	negate bool
+	values []string
+}
+
+func parseTSFilter(expr string) (*tsFilter, error) {
+	i := strings.Index(expr, "=")
+	if i <= 0 {
+		return nil, errTSBadFilter
+	}
+	f := &tsFilter{label: expr[:i], negate: expr[i-1] == '!'}
+	if f.negate {
+		f.label = expr[:i-1]
+	}
+	value := expr[i+1:]
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		f.values = strings.Split(value[1:len(value)-1], ",")
+	} else if value != "" {
+		f.values = []string{value}
+	}
+	if f.label == "" {
+		return nil, errTSBadFilter
+	}
+	return f, nil
+}
+
+// positive tells whether the filter selects series by the value of a label, at least one filter of
+// TS.MRANGE must
+func (f *tsFilter) positive() bool {
+	return !f.negate && len(f.values) > 0
+}
+
+func (f *tsFilter) matches(s *timeSeries) bool {
+	value, ok := s.label(f.label)
+	if len(f.values) == 0 {
+		return ok == f.negate
+	}
+	in := ok && slices.Contains(f.values, value)
+	return in != f.negate
+}
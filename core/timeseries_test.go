@@ -0,0 +1,171 @@
+package core_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/diceclone/config"
+	"github.com/diceclone/core"
+)
+
+func TestTimeSeriesAddAndRange(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"ts.create", &core.RedisCmd{Cmd: "TS.CREATE", Args: []string{"temp", "LABELS", "room", "kitchen"}}, []byte("+OK\r\n")},
+		{"ts.create an existing series", &core.RedisCmd{Cmd: "TS.CREATE", Args: []string{"temp"}}, []byte("-ERR TSDB: key already exists\r\n")},
+		{"ts.add", &core.RedisCmd{Cmd: "TS.ADD", Args: []string{"temp", "1000", "20.5"}}, []byte(":1000\r\n")},
+		{"ts.madd", &core.RedisCmd{Cmd: "TS.MADD", Args: []string{"temp", "1010", "21", "temp", "1020", "23", "nots", "1000", "1"}}, []byte("*3\r\n:1010\r\n:1020\r\n-ERR TSDB: the key does not exist\r\n")},
+		{"ts.add out of order", &core.RedisCmd{Cmd: "TS.ADD", Args: []string{"temp", "1005", "19"}}, []byte(":1005\r\n")},
+		{"ts.add a duplicate is blocked", &core.RedisCmd{Cmd: "TS.ADD", Args: []string{"temp", "1000", "1"}}, []byte("-ERR TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode\r\n")},
+		{"ts.add a duplicate with on_duplicate", &core.RedisCmd{Cmd: "TS.ADD", Args: []string{"temp", "1000", "1", "ON_DUPLICATE", "SUM"}}, []byte(":1000\r\n")},
+		{"ts.get", &core.RedisCmd{Cmd: "TS.GET", Args: []string{"temp"}}, []byte("*2\r\n:1020\r\n+23\r\n")},
+		{"ts.range", &core.RedisCmd{Cmd: "TS.RANGE", Args: []string{"temp", "-", "+"}}, []byte("*4\r\n*2\r\n:1000\r\n+21.5\r\n*2\r\n:1005\r\n+19\r\n*2\r\n:1010\r\n+21\r\n*2\r\n:1020\r\n+23\r\n")},
+		{"ts.range between timestamps", &core.RedisCmd{Cmd: "TS.RANGE", Args: []string{"temp", "1001", "1010"}}, []byte("*2\r\n*2\r\n:1005\r\n+19\r\n*2\r\n:1010\r\n+21\r\n")},
+		{"ts.revrange with count", &core.RedisCmd{Cmd: "TS.REVRANGE", Args: []string{"temp", "-", "+", "COUNT", "2"}}, []byte("*2\r\n*2\r\n:1020\r\n+23\r\n*2\r\n:1010\r\n+21\r\n")},
+		{"ts.range avg", &core.RedisCmd{Cmd: "TS.RANGE", Args: []string{"temp", "-", "+", "AGGREGATION", "avg", "10"}}, []byte("*3\r\n*2\r\n:1000\r\n+20.25\r\n*2\r\n:1010\r\n+21\r\n*2\r\n:1020\r\n+23\r\n")},
+		{"ts.range sum", &core.RedisCmd{Cmd: "TS.RANGE", Args: []string{"temp", "-", "+", "AGGREGATION", "SUM", "20"}}, []byte("*2\r\n*2\r\n:1000\r\n+61.5\r\n*2\r\n:1020\r\n+23\r\n")},
+		{"ts.range min", &core.RedisCmd{Cmd: "TS.RANGE", Args: []string{"temp", "-", "+", "AGGREGATION", "min", "100"}}, []byte("*1\r\n*2\r\n:1000\r\n+19\r\n")},
+		{"ts.range max", &core.RedisCmd{Cmd: "TS.RANGE", Args: []string{"temp", "-", "+", "AGGREGATION", "max", "100"}}, []byte("*1\r\n*2\r\n:1000\r\n+23\r\n")},
+		{"ts.range count", &core.RedisCmd{Cmd: "TS.RANGE", Args: []string{"temp", "-", "+", "AGGREGATION", "count", "10"}}, []byte("*3\r\n*2\r\n:1000\r\n+2\r\n*2\r\n:1010\r\n+1\r\n*2\r\n:1020\r\n+1\r\n")},
+		{"ts.range first", &core.RedisCmd{Cmd: "TS.RANGE", Args: []string{"temp", "-", "+", "AGGREGATION", "first", "100"}}, []byte("*1\r\n*2\r\n:1000\r\n+21.5\r\n")},
+		{"ts.revrange last", &core.RedisCmd{Cmd: "TS.REVRANGE", Args: []string{"temp", "-", "+", "AGGREGATION", "last", "10"}}, []byte("*3\r\n*2\r\n:1020\r\n+23\r\n*2\r\n:1010\r\n+21\r\n*2\r\n:1000\r\n+19\r\n")},
+		{"ts.range with an unknown aggregator", &core.RedisCmd{Cmd: "TS.RANGE", Args: []string{"temp", "-", "+", "AGGREGATION", "median", "10"}}, []byte("-ERR TSDB: Unknown aggregation type\r\n")},
+		{"ts.range with a null bucket", &core.RedisCmd{Cmd: "TS.RANGE", Args: []string{"temp", "-", "+", "AGGREGATION", "avg", "0"}}, []byte("-ERR TSDB: bucketDuration must be greater than zero\r\n")},
+		{"ts.range on a missing key", &core.RedisCmd{Cmd: "TS.RANGE", Args: []string{"nots", "-", "+"}}, []byte("-ERR TSDB: the key does not exist\r\n")},
+		{"ts.add creates a series", &core.RedisCmd{Cmd: "TS.ADD", Args: []string{"auto", "5", "1", "DUPLICATE_POLICY", "LAST"}}, []byte(":5\r\n")},
+		{"ts.add overwrites with the last policy", &core.RedisCmd{Cmd: "TS.ADD", Args: []string{"auto", "5", "2"}}, []byte(":5\r\n")},
+		{"ts.get of the overwritten sample", &core.RedisCmd{Cmd: "TS.GET", Args: []string{"auto"}}, []byte("*2\r\n:5\r\n+2\r\n")},
+		{"ts.add with an invalid value", &core.RedisCmd{Cmd: "TS.ADD", Args: []string{"auto", "6", "warm"}}, []byte("-ERR TSDB: invalid value\r\n")},
+		{"ts.add with an invalid timestamp", &core.RedisCmd{Cmd: "TS.ADD", Args: []string{"auto", "-6", "1"}}, []byte("-ERR TSDB: invalid timestamp\r\n")},
+		{"type of a time series", &core.RedisCmd{Cmd: "TYPE", Args: []string{"temp"}}, []byte("+TSDB-TYPE\r\n")},
+	})
+}
+
+func TestTimeSeriesRetention(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "TS.CREATE", "short", "RETENTION", "100")
+	for _, ts := range []string{"0", "50", "100", "150", "200"} {
+		evalAs(c, "TS.ADD", "short", ts, "1")
+	}
+	evalAs(c, "TS.RANGE", "short", "-", "+")
+	expectWrite(t, c, "*3\r\n*2\r\n:100\r\n+1\r\n*2\r\n:150\r\n+1\r\n*2\r\n:200\r\n+1\r\n")
+	evalAs(c, "TS.ADD", "short", "99", "1")
+	expectWrite(t, c, "-ERR TSDB: Timestamp is older than retention\r\n")
+	evalAs(c, "TS.ADD", "short", "120", "1")
+	expectWrite(t, c, ":120\r\n")
+}
+
+func TestTimeSeriesCompaction(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "TS.CREATE", "raw")
+	evalAs(c, "TS.CREATE", "avg10")
+	evalAs(c, "TS.CREATE", "max20")
+	evalAs(c, "TS.CREATERULE", "raw", "avg10", "AGGREGATION", "avg", "10")
+	expectWrite(t, c, "+OK\r\n")
+	evalAs(c, "TS.CREATERULE", "raw", "max20", "AGGREGATION", "max", "20")
+	expectWrite(t, c, "+OK\r\n")
+
+	evalAs(c, "TS.MADD", "raw", "1", "2", "raw", "5", "4", "raw", "12", "10", "raw", "15", "20")
+	// the open bucket is only added once a later bucket starts
+	evalAs(c, "TS.RANGE", "avg10", "-", "+")
+	expectWrite(t, c, "*1\r\n*2\r\n:0\r\n+3\r\n")
+	evalAs(c, "TS.ADD", "raw", "25", "1")
+	evalAs(c, "TS.RANGE", "avg10", "-", "+")
+	expectWrite(t, c, "*2\r\n*2\r\n:0\r\n+3\r\n*2\r\n:10\r\n+15\r\n")
+	evalAs(c, "TS.RANGE", "max20", "-", "+")
+	expectWrite(t, c, "*1\r\n*2\r\n:0\r\n+20\r\n")
+
+	// a late sample updates the bucket it falls in
+	evalAs(c, "TS.ADD", "raw", "3", "12")
+	evalAs(c, "TS.RANGE", "avg10", "-", "+")
+	expectWrite(t, c, "*2\r\n*2\r\n:0\r\n+6\r\n*2\r\n:10\r\n+15\r\n")
+
+	evalAs(c, "TS.INFO", "raw")
+	reply, _ := core.Decode(c.LastWrite)
+	if info := reply.([]interface{}); info[1] != int64(6) {
+		t.Errorf("got %v samples, want 6", info[1])
+	}
+	evalAs(c, "TS.CREATERULE", "raw", "avg10", "AGGREGATION", "sum", "10")
+	expectWrite(t, c, "-ERR TSDB: the destination key already has a src rule\r\n")
+	evalAs(c, "TS.CREATERULE", "avg10", "raw", "AGGREGATION", "sum", "10")
+	expectWrite(t, c, "-ERR TSDB: the destination key already has a dst rule\r\n")
+	evalAs(c, "TS.CREATERULE", "raw", "raw", "AGGREGATION", "sum", "10")
+	expectWrite(t, c, "-ERR TSDB: the source key and destination key should be different\r\n")
+	evalAs(c, "TS.CREATERULE", "raw", "nots", "AGGREGATION", "sum", "10")
+	expectWrite(t, c, "-ERR TSDB: the key does not exist\r\n")
+
+	evalAs(c, "TS.DELETERULE", "raw", "avg10")
+	expectWrite(t, c, "+OK\r\n")
+	evalAs(c, "TS.DELETERULE", "raw", "avg10")
+	expectWrite(t, c, "-ERR TSDB: compaction rule does not exist\r\n")
+	evalAs(c, "TS.ADD", "raw", "45", "1")
+	evalAs(c, "TS.RANGE", "avg10", "-", "+")
+	expectWrite(t, c, "*2\r\n*2\r\n:0\r\n+6\r\n*2\r\n:10\r\n+15\r\n")
+	evalAs(c, "TS.RANGE", "max20", "-", "+")
+	expectWrite(t, c, "*2\r\n*2\r\n:0\r\n+20\r\n*2\r\n:20\r\n+1\r\n")
+}
+
+func TestTimeSeriesCompactionWithRetention(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "TS.CREATE", "src", "RETENTION", "10")
+	evalAs(c, "TS.CREATE", "dst")
+	evalAs(c, "TS.CREATERULE", "src", "dst", "AGGREGATION", "sum", "100")
+
+	// the retention drops the samples of the open bucket before it closes
+	evalAs(c, "TS.ADD", "src", "10", "1")
+	evalAs(c, "TS.ADD", "src", "50", "2")
+	evalAs(c, "TS.ADD", "src", "250", "3")
+	evalAs(c, "TS.RANGE", "src", "-", "+")
+	expectWrite(t, c, "*1\r\n*2\r\n:250\r\n+3\r\n")
+	evalAs(c, "TS.RANGE", "dst", "-", "+")
+	expectWrite(t, c, "*1\r\n*2\r\n:0\r\n+3\r\n")
+}
+
+func TestTimeSeriesMRange(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "TS.CREATE", "cpu:1", "LABELS", "metric", "cpu", "host", "a")
+	evalAs(c, "TS.CREATE", "cpu:2", "LABELS", "metric", "cpu", "host", "b")
+	evalAs(c, "TS.CREATE", "mem:1", "LABELS", "metric", "mem", "host", "a")
+	evalAs(c, "TS.MADD", "cpu:1", "10", "1", "cpu:1", "20", "3", "cpu:2", "10", "5", "mem:1", "10", "7")
+
+	runCommandCases(t, []commandCase{
+		{"ts.mrange by label", &core.RedisCmd{Cmd: "TS.MRANGE", Args: []string{"-", "+", "FILTER", "metric=cpu"}}, []byte("*2\r\n*3\r\n$5\r\ncpu:1\r\n*0\r\n*2\r\n*2\r\n:10\r\n+1\r\n*2\r\n:20\r\n+3\r\n*3\r\n$5\r\ncpu:2\r\n*0\r\n*1\r\n*2\r\n:10\r\n+5\r\n")},
+		{"ts.mrange withlabels and aggregation", &core.RedisCmd{Cmd: "TS.MRANGE", Args: []string{"-", "+", "WITHLABELS", "AGGREGATION", "sum", "100", "FILTER", "host=a", "metric!=mem"}}, []byte("*1\r\n*3\r\n$5\r\ncpu:1\r\n*2\r\n*2\r\n$6\r\nmetric\r\n$3\r\ncpu\r\n*2\r\n$4\r\nhost\r\n$1\r\na\r\n*1\r\n*2\r\n:0\r\n+4\r\n")},
+		{"ts.mrange by a list of values", &core.RedisCmd{Cmd: "TS.MRANGE", Args: []string{"0", "10", "FILTER", "host=(b,c)"}}, []byte("*1\r\n*3\r\n$5\r\ncpu:2\r\n*0\r\n*1\r\n*2\r\n:10\r\n+5\r\n")},
+		{"ts.mrange without a label", &core.RedisCmd{Cmd: "TS.MRANGE", Args: []string{"-", "+", "FILTER", "metric=mem", "zone="}}, []byte("*1\r\n*3\r\n$5\r\nmem:1\r\n*0\r\n*1\r\n*2\r\n:10\r\n+7\r\n")},
+		{"ts.mrevrange with count", &core.RedisCmd{Cmd: "TS.MREVRANGE", Args: []string{"-", "+", "COUNT", "1", "FILTER", "host=a", "metric=cpu"}}, []byte("*1\r\n*3\r\n$5\r\ncpu:1\r\n*0\r\n*1\r\n*2\r\n:20\r\n+3\r\n")},
+		{"ts.mrange without a matcher", &core.RedisCmd{Cmd: "TS.MRANGE", Args: []string{"-", "+", "FILTER", "host!=a"}}, []byte("-ERR TSDB: please provide at least one matcher\r\n")},
+		{"ts.mrange without a filter", &core.RedisCmd{Cmd: "TS.MRANGE", Args: []string{"-", "+", "COUNT", "1"}}, []byte("-ERR TSDB: missing FILTER argument\r\n")},
+	})
+}
+
+func TestTimeSeriesRewriteAOF(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "TS.CREATE", "aofts", "RETENTION", "1000", "LABELS", "k", "v")
+	evalAs(c, "TS.CREATE", "aofagg")
+	evalAs(c, "TS.CREATERULE", "aofts", "aofagg", "AGGREGATION", "avg", "60")
+	evalAs(c, "TS.MADD", "aofts", "1", "1.5", "aofts", "2", "2")
+	evalAs(c, "BGREWRITEAOF")
+
+	content, _ := os.ReadFile(config.APPEND_ONLY_FILE)
+	os.Remove(config.APPEND_ONLY_FILE)
+	for _, want := range []string{
+		"*9\r\n$9\r\nTS.CREATE\r\n$5\r\naofts\r\n$9\r\nRETENTION\r\n$4\r\n1000\r\n$16\r\nDUPLICATE_POLICY\r\n$5\r\nblock\r\n$6\r\nLABELS\r\n$1\r\nk\r\n$1\r\nv\r\n",
+		"*7\r\n$7\r\nTS.MADD\r\n$5\r\naofts\r\n$1\r\n1\r\n$3\r\n1.5\r\n$5\r\naofts\r\n$1\r\n2\r\n$1\r\n2\r\n",
+	} {
+		if !bytes.Contains(content, []byte(want)) {
+			t.Errorf("AOF content misses %q:\n%q", want, content)
+		}
+	}
+	rule := "*6\r\n$13\r\nTS.CREATERULE\r\n$5\r\naofts\r\n$6\r\naofagg\r\n$11\r\nAGGREGATION\r\n$3\r\navg\r\n$2\r\n60\r\n"
+	if !bytes.HasSuffix(content, []byte(rule)) {
+		t.Errorf("AOF content does not end with %q:\n%q", rule, content)
+	}
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	TOPK_DEFAULT_WIDTH = 8
+	TOPK_DEFAULT_DEPTH = 7
+	TOPK_DEFAULT_DECAY = 0.9
+	// decays are looked up for counters below TOPK_DECAY_LOOKUP_SIZE, larger counters practically never decay
+	TOPK_DECAY_LOOKUP_SIZE = 256
+)
+
+var errTopKExists = errors.New("ERR TopK: key already exists")
+var errTopKMissing = errors.New("ERR TopK: key does not exist")
+var errTopKBadK = errors.New("ERR TopK: invalid k")
+var errTopKBadDimensions = errors.New("ERR TopK: invalid width/depth")
+var errTopKBadDecay = errors.New("ERR TopK: decay must be between 0 and 1")
+var errTopKBadIncrement = errors.New("ERR TopK: increment must be an integer greater or equal to 1 and less than or equal to 100000")
+
+// topKBucket counts the item whose fingerprint it holds
+type topKBucket struct {
+	fingerprint uint32
+	count       uint32
+}
+
+type topKEntry struct {
+	item  string
+	count uint32
+}
+
+// topK tracks the heavy hitters of a stream with HeavyKeeper: like a Count-Min Sketch every item is counted
+// in a bucket of each of depth rows, but a bucket only counts the item it was last claimed by. Other items
+// decay its count with a probability exponentially decreasing with the count and claim it once it falls to
+// zero, so that buckets end up counting the frequent items while infrequent ones barely register. The k
+// items with the largest counts are kept in a min heap
+type topK struct {
+	k       int
+	width   uint64
+	depth   uint64
+	decay   float64
+	buckets []topKBucket
+	heap    []topKEntry
+	// decays caches decay^count for the small counts
+	decays []float64
+}
+
+func newTopK(k int, width uint64, depth uint64, decay float64) (*topK, error) {
+	if width == 0 || depth == 0 || width > FILTER_MAX_BYTES/8/depth {
+		return nil, errTopKBadDimensions
+	}
+	t := &topK{
+		k:       k,
+		width:   width,
+		depth:   depth,
+		decay:   decay,
+		buckets: make([]topKBucket, width*depth),
+		decays:  make([]float64, TOPK_DECAY_LOOKUP_SIZE),
+	}
+	for i := range t.decays {
+		t.decays[i] = math.Pow(decay, float64(i))
+	}
+	return t, nil
+}
+
+func (t *topK) decayOf(count uint32) float64 {
+	if count < TOPK_DECAY_LOOKUP_SIZE {
+		return t.decays[count]
+	}
+	return math.Pow(t.decay, float64(count))
+}
+
+// incrBy counts the item incr more times and returns the item it expelled from the heap, if any
+func (t *topK) incrBy(item string, incr uint32) (string, bool) {
+	fingerprint := uint32(murmurHash64A([]byte(item), 0))
+	var maxCount uint32
+	for row := uint64(0); row < t.depth; row++ {
+		b := &t.buckets[row*t.width+murmurHash64A([]byte(item), row+1)%t.width]
+		switch {
+		case b.count == 0:
+			b.fingerprint, b.count = fingerprint, incr
+		case b.fingerprint == fingerprint:
+			b.count = uint32(min(uint64(b.count)+uint64(incr), math.MaxUint32))
+		default:
+			for n := incr; n > 0; n-- {
+				if rand.Float64() >= t.decayOf(b.count) {
+					continue
+				}
+				b.count--
+				if b.count == 0 {
+					b.fingerprint, b.count = fingerprint, n
+					break
+				}
+			}
+		}
+		if b.fingerprint == fingerprint {
+			maxCount = max(maxCount, b.count)
+		}
+	}
+
+	if i := t.heapIndex(item); i >= 0 {
+		// the count of the item may have decayed as well as grown
+		t.heap[i].count = maxCount
+		t.siftDown(i)
+		t.siftUp(i)
+		return "", false
+	}
+	if len(t.heap) < t.k {
+		if maxCount > 0 {
+			t.heap = append(t.heap, topKEntry{item, maxCount})
+			t.siftUp(len(t.heap) - 1)
+		}
+		return "", false
+	}
+	if maxCount > t.heap[0].count {
+		expelled := t.heap[0].item
+		t.heap[0] = topKEntry{item, maxCount}
+		t.siftDown(0)
+		return expelled, true
+	}
+	return "", false
+}
+
+func (t *topK) heapIndex(item string) int {
+	for i, e := range t.heap {
+		if e.item == item {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *topK) siftUp(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if t.heap[parent].count <= t.heap[i].count {
+			return
+		}
+		t.heap[parent], t.heap[i] = t.heap[i], t.heap[parent]
+		i = parent
+	}
+}
+
+func (t *topK) siftDown(i int) {
+	for {
+		smallest := i
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(t.heap) && t.heap[child].count < t.heap[smallest].count {
+				smallest = child
+			}
+		}
+		if smallest == i {
+			return
+		}
+		t.heap[smallest], t.heap[i] = t.heap[i], t.heap[smallest]
+		i = smallest
+	}
+}
+
+// list returns the items of the heap by decreasing count
+func (t *topK) list() []topKEntry {
+	entries := append([]topKEntry{}, t.heap...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].count != entries[j].count {
+			return entries[i].count > entries[j].count
+		}
+		return entries[i].item < entries[j].item
+	})
+	return entries
+}
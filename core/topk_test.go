@@ -0,0 +1,51 @@
+package core_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/diceclone/core"
+)
+
+func TestTopKCommands(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	runCommandCases(t, []commandCase{
+		{"topk.reserve", &core.RedisCmd{Cmd: "TOPK.RESERVE", Args: []string{"topk", "2"}}, []byte("+OK\r\n")},
+		{"topk.reserve an existing key", &core.RedisCmd{Cmd: "TOPK.RESERVE", Args: []string{"topk", "2"}}, []byte("-ERR TopK: key already exists\r\n")},
+		{"topk.add", &core.RedisCmd{Cmd: "TOPK.ADD", Args: []string{"topk", "a", "b", "a"}}, []byte("*3\r\n$-1\r\n$-1\r\n$-1\r\n")},
+		{"topk.incrby expels the smallest item", &core.RedisCmd{Cmd: "TOPK.INCRBY", Args: []string{"topk", "c", "5"}}, []byte("*1\r\n$1\r\nb\r\n")},
+		{"topk.list", &core.RedisCmd{Cmd: "TOPK.LIST", Args: []string{"topk"}}, []byte("*2\r\n$1\r\nc\r\n$1\r\na\r\n")},
+		{"topk.list withcount", &core.RedisCmd{Cmd: "TOPK.LIST", Args: []string{"topk", "WITHCOUNT"}}, []byte("*4\r\n$1\r\nc\r\n:5\r\n$1\r\na\r\n:2\r\n")},
+		{"topk.query", &core.RedisCmd{Cmd: "TOPK.QUERY", Args: []string{"topk", "a", "b", "c"}}, []byte("*3\r\n:1\r\n:0\r\n:1\r\n")},
+		{"topk.info", &core.RedisCmd{Cmd: "TOPK.INFO", Args: []string{"topk"}}, []byte("*8\r\n$1\r\nk\r\n:2\r\n$5\r\nwidth\r\n:8\r\n$5\r\ndepth\r\n:7\r\n$5\r\ndecay\r\n$3\r\n0.9\r\n")},
+		{"type of a topk", &core.RedisCmd{Cmd: "TYPE", Args: []string{"topk"}}, []byte("+TopK-TYPE\r\n")},
+		{"topk.add on a missing key", &core.RedisCmd{Cmd: "TOPK.ADD", Args: []string{"notopk", "a"}}, []byte("-ERR TopK: key does not exist\r\n")},
+		{"topk.incrby with a null increment", &core.RedisCmd{Cmd: "TOPK.INCRBY", Args: []string{"topk", "a", "0"}}, []byte("-ERR TopK: increment must be an integer greater or equal to 1 and less than or equal to 100000\r\n")},
+		{"topk.reserve with a null k", &core.RedisCmd{Cmd: "TOPK.RESERVE", Args: []string{"bad", "0"}}, []byte("-ERR TopK: invalid k\r\n")},
+		{"topk.reserve with an invalid decay", &core.RedisCmd{Cmd: "TOPK.RESERVE", Args: []string{"bad", "3", "8", "7", "1.5"}}, []byte("-ERR TopK: decay must be between 0 and 1\r\n")},
+		{"topk.reserve with a partial sizing", &core.RedisCmd{Cmd: "TOPK.RESERVE", Args: []string{"bad", "3", "8"}}, []byte("-ERR wrong number of arguments for 'topk.reserve' command\r\n")},
+	})
+}
+
+func TestTopKFindsHeavyHitters(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "TOPK.RESERVE", "urls", "5", "100", "5", "0.9")
+	// five heavy hitters hit 200 to 600 times among 2000 urls hit once
+	for round := 0; round < 600; round++ {
+		for h := 0; h < 5; h++ {
+			if round < 200*(h+1)/2+100 {
+				evalAs(c, "TOPK.ADD", "urls", "heavy:"+strconv.Itoa(h))
+			}
+		}
+		for n := 0; n < 4; n++ {
+			evalAs(c, "TOPK.ADD", "urls", "light:"+strconv.Itoa(round*4+n))
+		}
+	}
+
+	evalAs(c, "TOPK.LIST", "urls")
+	expectWrite(t, c, "*5\r\n$7\r\nheavy:4\r\n$7\r\nheavy:3\r\n$7\r\nheavy:2\r\n$7\r\nheavy:1\r\n$7\r\nheavy:0\r\n")
+	evalAs(c, "TOPK.QUERY", "urls", "heavy:0", "light:7")
+	expectWrite(t, c, "*2\r\n:1\r\n:0\r\n")
+}
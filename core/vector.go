@@ -0,0 +1,409 @@
+package core
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+)
+
+const (
+	HNSW_DEFAULT_M               = 16
+	HNSW_DEFAULT_EF_CONSTRUCTION = 200
+	HNSW_DEFAULT_EF_RUNTIME      = 10
+)
+
+var vectorMetrics = []string{"L2", "IP", "COSINE"}
+
+// decodeVector reads a FLOAT32 vector of dim components stored little endian, as clients send them
+func decodeVector(blob string, dim int) ([]float32, bool) {
+	if len(blob) != 4*dim {
+		return nil, false
+	}
+	v := make([]float32, dim)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32([]byte(blob[4*i : 4*i+4])))
+	}
+	return v, true
+}
+
+// normalize scales the vector to a unit norm, cosine distances are then computed as inner products
+func normalize(v []float32) {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return
+	}
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] = float32(float64(v[i]) / norm)
+	}
+}
+
+// vectorDistance returns the distance of the metric between the vectors, the squared euclidean distance
+// for L2 and one minus the inner product for IP and COSINE, whose vectors are normalized beforehand
+func vectorDistance(metric string, a []float32, b []float32) float64 {
+	var d float64
+	if metric == "L2" {
+		for i := range a {
+			diff := float64(a[i]) - float64(b[i])
+			d += diff * diff
+		}
+		return d
+	}
+	for i := range a {
+		d += float64(a[i]) * float64(b[i])
+	}
+	return 1 - d
+}
+
+type vectorMatch struct {
+	key      string
+	distance float64
+}
+
+// insertMatch inserts the match in the matches sorted by distance, keeping at most limit of them
+func insertMatch(matches []vectorMatch, m vectorMatch, limit int) []vectorMatch {
+	i := sort.Search(len(matches), func(i int) bool { return matches[i].distance > m.distance })
+	if i >= limit {
+		return matches
+	}
+	if len(matches) < limit {
+		matches = append(matches, vectorMatch{})
+	}
+	copy(matches[i+1:], matches[i:])
+	matches[i] = m
+	return matches
+}
+
+// vectorIndex holds the vectors of a VECTOR field by key, filter restricts a search to the keys it
+// contains unless it is nil
+type vectorIndex interface {
+	add(key string, v []float32)
+	remove(key string)
+	search(q []float32, k int, ef int, filter map[string]struct{}) []vectorMatch
+	size() int
+}
+
+// flatIndex compares the query with every vector
+type flatIndex struct {
+	metric  string
+	vectors map[string][]float32
+}
+
+func newFlatIndex(metric string) *flatIndex {
+	return &flatIndex{metric: metric, vectors: make(map[string][]float32)}
+}
+
+func (f *flatIndex) add(key string, v []float32) {
+	f.vectors[key] = v
+}
+
+func (f *flatIndex) remove(key string) {
+	delete(f.vectors, key)
+}
+
+func (f *flatIndex) size() int {
+	return len(f.vectors)
+}
+
+func (f *flatIndex) search(q []float32, k int, ef int, filter map[string]struct{}) []vectorMatch {
+	return bruteForceSearch(f.metric, f.vectors, q, k, filter)
+}
+
+func bruteForceSearch(metric string, vectors map[string][]float32, q []float32, k int, filter map[string]struct{}) []vectorMatch {
+	var matches []vectorMatch
+	visit := func(key string, v []float32) {
+		matches = insertMatch(matches, vectorMatch{key, vectorDistance(metric, q, v)}, k)
+	}
+	if filter != nil && len(filter) < len(vectors) {
+		for key := range filter {
+			if v, ok := vectors[key]; ok {
+				visit(key, v)
+			}
+		}
+		return matches
+	}
+	for key, v := range vectors {
+		if _, ok := filter[key]; filter == nil || ok {
+			visit(key, v)
+		}
+	}
+	return matches
+}
+
+type hnswNode struct {
+	key    string
+	vector []float32
+	// links holds the neighbours of the node on each of its levels, and inbound the nodes linking to it
+	links   [][]*hnswNode
+	inbound []map[*hnswNode]struct{}
+}
+
+// hnswIndex is a hierarchical navigable small world graph: every node is on level 0 and on each upper level
+// with an exponentially decreasing probability, a search descends greedily from the entry point on the top
+// level and explores the ef nodes closest to the query on level 0
+type hnswIndex struct {
+	metric         string
+	m              int
+	efConstruction int
+	levelFactor    float64
+	nodes          map[string]*hnswNode
+	vectors        map[string][]float32
+	entry          *hnswNode
+}
+
+func newHNSWIndex(metric string, m int, efConstruction int) *hnswIndex {
+	return &hnswIndex{
+		metric:         metric,
+		m:              m,
+		efConstruction: efConstruction,
+		levelFactor:    1 / math.Log(float64(m)),
+		nodes:          make(map[string]*hnswNode),
+		vectors:        make(map[string][]float32),
+	}
+}
+
+func (h *hnswIndex) size() int {
+	return len(h.nodes)
+}
+
+// maxLinks is the number of neighbours a node keeps on the level, twice m on level 0 as in the paper
+func (h *hnswIndex) maxLinks(level int) int {
+	if level == 0 {
+		return 2 * h.m
+	}
+	return h.m
+}
+
+func (h *hnswIndex) distance(a []float32, b []float32) float64 {
+	return vectorDistance(h.metric, a, b)
+}
+
+func (h *hnswIndex) setLinks(n *hnswNode, level int, links []*hnswNode) {
+	for _, old := range n.links[level] {
+		delete(old.inbound[level], n)
+	}
+	n.links[level] = links
+	for _, l := range links {
+		l.inbound[level][n] = struct{}{}
+	}
+}
+
+func (h *hnswIndex) add(key string, v []float32) {
+	if _, ok := h.nodes[key]; ok {
+		h.remove(key)
+	}
+
+	level := int(-math.Log(1-rand.Float64()) * h.levelFactor)
+	n := &hnswNode{key: key, vector: v, links: make([][]*hnswNode, level+1), inbound: make([]map[*hnswNode]struct{}, level+1)}
+	for l := range n.inbound {
+		n.inbound[l] = make(map[*hnswNode]struct{})
+	}
+	h.nodes[key] = n
+	h.vectors[key] = v
+	if h.entry == nil {
+		h.entry = n
+		return
+	}
+
+	top := len(h.entry.links) - 1
+	entries := []*hnswNode{h.entry}
+	for l := top; l > level; l-- {
+		entries = h.searchLevel(v, entries, 1, l)
+	}
+	for l := min(level, top); l >= 0; l-- {
+		candidates := h.searchLevel(v, entries, h.efConstruction, l)
+		h.setLinks(n, l, h.selectNeighbours(v, candidates, h.maxLinks(l)))
+		for _, neighbour := range n.links[l] {
+			h.connect(neighbour, n, l)
+		}
+		entries = candidates
+	}
+	if level > top {
+		h.entry = n
+	}
+}
+
+// connect adds the link from n to other, pruning the links of n when it has too many
+func (h *hnswIndex) connect(n *hnswNode, other *hnswNode, level int) {
+	links := append(append([]*hnswNode(nil), n.links[level]...), other)
+	if len(links) > h.maxLinks(level) {
+		sort.Slice(links, func(i, j int) bool {
+			return h.distance(n.vector, links[i].vector) < h.distance(n.vector, links[j].vector)
+		})
+		links = h.selectNeighbours(n.vector, links, h.maxLinks(level))
+	}
+	h.setLinks(n, level, links)
+}
+
+// selectNeighbours picks up to limit of the candidates, sorted by distance to v, with the heuristic of the
+// paper: a candidate closer to an already selected neighbour than to v is skipped, so that links spread in
+// every direction, then the skipped candidates fill the remaining links
+func (h *hnswIndex) selectNeighbours(v []float32, candidates []*hnswNode, limit int) []*hnswNode {
+	selected := make([]*hnswNode, 0, limit)
+	var skipped []*hnswNode
+	for _, c := range candidates {
+		if len(selected) == limit {
+			break
+		}
+		d := h.distance(v, c.vector)
+		keep := true
+		for _, s := range selected {
+			if h.distance(c.vector, s.vector) < d {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			selected = append(selected, c)
+		} else {
+			skipped = append(skipped, c)
+		}
+	}
+	for _, c := range skipped {
+		if len(selected) == limit {
+			break
+		}
+		selected = append(selected, c)
+	}
+	return selected
+}
+
+// searchLevel returns the ef nodes of the level closest to v found from the entries, sorted by distance
+func (h *hnswIndex) searchLevel(v []float32, entries []*hnswNode, ef int, level int) []*hnswNode {
+	type candidate struct {
+		node     *hnswNode
+		distance float64
+	}
+	byDistance := func(cs []candidate, c candidate) int {
+		return sort.Search(len(cs), func(i int) bool { return cs[i].distance > c.distance })
+	}
+
+	visited := make(map[*hnswNode]struct{})
+	var frontier, nearest []candidate
+	for _, e := range entries {
+		visited[e] = struct{}{}
+		c := candidate{e, h.distance(v, e.vector)}
+		frontier = slices.Insert(frontier, byDistance(frontier, c), c)
+		nearest = slices.Insert(nearest, byDistance(nearest, c), c)
+	}
+	if len(nearest) > ef {
+		nearest = nearest[:ef]
+	}
+
+	for len(frontier) > 0 {
+		closest := frontier[0]
+		frontier = frontier[1:]
+		if len(nearest) == ef && closest.distance > nearest[len(nearest)-1].distance {
+			break
+		}
+		for _, n := range closest.node.links[level] {
+			if _, ok := visited[n]; ok {
+				continue
+			}
+			visited[n] = struct{}{}
+			c := candidate{n, h.distance(v, n.vector)}
+			if len(nearest) == ef && c.distance >= nearest[len(nearest)-1].distance {
+				continue
+			}
+			frontier = slices.Insert(frontier, byDistance(frontier, c), c)
+			nearest = slices.Insert(nearest, byDistance(nearest, c), c)
+			if len(nearest) > ef {
+				nearest = nearest[:ef]
+			}
+		}
+	}
+
+	nodes := make([]*hnswNode, len(nearest))
+	for i, c := range nearest {
+		nodes[i] = c.node
+	}
+	return nodes
+}
+
+// remove unlinks the node and reconnects the nodes it was linked with among the neighbours it leaves
+func (h *hnswIndex) remove(key string) {
+	n, ok := h.nodes[key]
+	if !ok {
+		return
+	}
+	delete(h.nodes, key)
+	delete(h.vectors, key)
+
+	for l := range n.links {
+		affected := make(map[*hnswNode]struct{})
+		for _, other := range n.links[l] {
+			affected[other] = struct{}{}
+		}
+		for other := range n.inbound[l] {
+			affected[other] = struct{}{}
+		}
+		h.setLinks(n, l, nil)
+		delete(affected, n)
+
+		for other := range affected {
+			seen := map[*hnswNode]struct{}{other: {}}
+			var candidates []*hnswNode
+			for _, c := range other.links[l] {
+				if _, dup := seen[c]; !dup && c != n {
+					seen[c] = struct{}{}
+					candidates = append(candidates, c)
+				}
+			}
+			for c := range affected {
+				if _, dup := seen[c]; !dup {
+					seen[c] = struct{}{}
+					candidates = append(candidates, c)
+				}
+			}
+			sort.Slice(candidates, func(i, j int) bool {
+				return h.distance(other.vector, candidates[i].vector) < h.distance(other.vector, candidates[j].vector)
+			})
+			h.setLinks(other, l, h.selectNeighbours(other.vector, candidates, h.maxLinks(l)))
+		}
+	}
+
+	if h.entry == n {
+		h.entry = nil
+		for _, other := range h.nodes {
+			if h.entry == nil || len(other.links) > len(h.entry.links) {
+				h.entry = other
+			}
+		}
+	}
+}
+
+// search explores the graph with a list of max(ef, k) candidates. A filter makes the candidates that do not
+// pass it useless, the search is then retried with a list twice as long until k of them pass, and falls back
+// to comparing the query with every vector passing the filter when those are few or the list outgrows the graph
+func (h *hnswIndex) search(q []float32, k int, ef int, filter map[string]struct{}) []vectorMatch {
+	if h.entry == nil || k <= 0 {
+		return nil
+	}
+	if filter != nil && len(filter) <= max(ef, k) {
+		return bruteForceSearch(h.metric, h.vectors, q, k, filter)
+	}
+
+	for ef = max(ef, k); ; ef *= 2 {
+		entries := []*hnswNode{h.entry}
+		for l := len(h.entry.links) - 1; l > 0; l-- {
+			entries = h.searchLevel(q, entries, 1, l)
+		}
+		var matches []vectorMatch
+		for _, n := range h.searchLevel(q, entries, ef, 0) {
+			if _, ok := filter[n.key]; filter == nil || ok {
+				matches = insertMatch(matches, vectorMatch{n.key, h.distance(q, n.vector)}, k)
+			}
+		}
+		if len(matches) == k || filter == nil {
+			return matches
+		}
+		if ef >= len(h.nodes) {
+			return bruteForceSearch(h.metric, h.vectors, q, k, filter)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/diceclone/config"
+	"github.com/diceclone/core"
+)
+
+// vectorBlob encodes the components as FLOAT32 little endian, as clients store vectors in hash fields
+func vectorBlob(v ...float32) string {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(x))
+	}
+	return string(b)
+}
+
+// searchKeys returns the keys of the results of the FT.SEARCH reply, in order
+func searchKeys(t *testing.T, c *MockReadWriter) []string {
+	t.Helper()
+	reply, err := core.Decode(c.LastWrite)
+	if err != nil {
+		t.Fatalf("cannot decode %q: %v", c.LastWrite, err)
+	}
+	results, ok := reply.([]interface{})
+	if !ok {
+		t.Fatalf("unexpected reply %q", c.LastWrite)
+	}
+	keys := []string{}
+	for i := 1; i < len(results); i += 2 {
+		keys = append(keys, results[i].(string))
+	}
+	return keys
+}
+
+func TestVectorSearch(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "FT.CREATE", "vec:idx", "ON", "HASH", "PREFIX", "1", "movie:", "SCHEMA",
+		"genre", "TAG", "year", "NUMERIC", "embedding", "AS", "v", "VECTOR", "FLAT", "6", "TYPE", "FLOAT32", "DIM", "2", "DISTANCE_METRIC", "L2")
+	expectWrite(t, c, "+OK\r\n")
+	defer evalAs(c, "FT.DROPINDEX", "vec:idx")
+
+	evalAs(c, "HSET", "movie:1", "genre", "drama", "year", "1994", "embedding", vectorBlob(0, 0))
+	evalAs(c, "HSET", "movie:2", "genre", "comedy", "year", "2004", "embedding", vectorBlob(1, 0))
+	evalAs(c, "HSET", "movie:3", "genre", "drama,comedy", "year", "2010", "embedding", vectorBlob(3, 4))
+	evalAs(c, "HSET", "other:1", "genre", "drama", "embedding", vectorBlob(0, 0))
+
+	q := vectorBlob(1, 1)
+	runCommandCases(t, []commandCase{
+		{"knn", &core.RedisCmd{Cmd: "FT.SEARCH", Args: []string{"vec:idx", "*=>[KNN 2 @v $q]", "PARAMS", "2", "q", q, "DIALECT", "2"}},
+			[]byte("*5\r\n:2\r\n$7\r\nMOVIE:2\r\n*8\r\n$9\r\n__v_score\r\n$1\r\n1\r\n$5\r\ngenre\r\n$6\r\ncomedy\r\n$4\r\nyear\r\n$4\r\n2004\r\n$9\r\nembedding\r\n$8\r\n" + vectorBlob(1, 0) + "\r\n" +
+				"$7\r\nMOVIE:1\r\n*8\r\n$9\r\n__v_score\r\n$1\r\n2\r\n$5\r\ngenre\r\n$5\r\ndrama\r\n$4\r\nyear\r\n$4\r\n1994\r\n$9\r\nembedding\r\n$8\r\n" + vectorBlob(0, 0) + "\r\n")},
+		{"knn with a tag filter", &core.RedisCmd{Cmd: "FT.SEARCH", Args: []string{"vec:idx", "(@genre:{drama})=>[KNN 1 @v $q AS dist]", "PARAMS", "2", "q", vectorBlob(3, 3)}},
+			[]byte("*3\r\n:1\r\n$7\r\nMOVIE:3\r\n*8\r\n$4\r\ndist\r\n$1\r\n1\r\n$5\r\ngenre\r\n$12\r\ndrama,comedy\r\n$4\r\nyear\r\n$4\r\n2010\r\n$9\r\nembedding\r\n$8\r\n" + vectorBlob(3, 4) + "\r\n")},
+		{"filters without knn", &core.RedisCmd{Cmd: "FT.SEARCH", Args: []string{"vec:idx", "@genre:{comedy} @year:[2000 (2010]"}},
+			[]byte("*3\r\n:1\r\n$7\r\nMOVIE:2\r\n*6\r\n$5\r\ngenre\r\n$6\r\ncomedy\r\n$4\r\nyear\r\n$4\r\n2004\r\n$9\r\nembedding\r\n$8\r\n" + vectorBlob(1, 0) + "\r\n")},
+		{"knn with a wrong vector size", &core.RedisCmd{Cmd: "FT.SEARCH", Args: []string{"vec:idx", "*=>[KNN 2 @v $q]", "PARAMS", "2", "q", vectorBlob(1)}},
+			[]byte("-ERR Error parsing vector similarity query: query vector blob size does not match index's expected size\r\n")},
+		{"knn with a missing parameter", &core.RedisCmd{Cmd: "FT.SEARCH", Args: []string{"vec:idx", "*=>[KNN 2 @v $q]"}}, []byte("-ERR No such parameter in query\r\n")},
+		{"knn on a field that is not a vector", &core.RedisCmd{Cmd: "FT.SEARCH", Args: []string{"vec:idx", "*=>[KNN 2 @year $q]", "PARAMS", "2", "q", q}}, []byte("-ERR Field 'year' is not a VECTOR field\r\n")},
+		{"filter on an unknown field", &core.RedisCmd{Cmd: "FT.SEARCH", Args: []string{"vec:idx", "@title:{x}"}}, []byte("-ERR Unknown field 'title'\r\n")},
+		{"every document", &core.RedisCmd{Cmd: "FT.SEARCH", Args: []string{"vec:idx", "*"}},
+			[]byte("*7\r\n:3\r\n$7\r\nMOVIE:1\r\n*6\r\n$5\r\ngenre\r\n$5\r\ndrama\r\n$4\r\nyear\r\n$4\r\n1994\r\n$9\r\nembedding\r\n$8\r\n" + vectorBlob(0, 0) + "\r\n" +
+				"$7\r\nMOVIE:2\r\n*6\r\n$5\r\ngenre\r\n$6\r\ncomedy\r\n$4\r\nyear\r\n$4\r\n2004\r\n$9\r\nembedding\r\n$8\r\n" + vectorBlob(1, 0) + "\r\n" +
+				"$7\r\nMOVIE:3\r\n*6\r\n$5\r\ngenre\r\n$12\r\ndrama,comedy\r\n$4\r\nyear\r\n$4\r\n2010\r\n$9\r\nembedding\r\n$8\r\n" + vectorBlob(3, 4) + "\r\n")},
+		{"search an unknown index", &core.RedisCmd{Cmd: "FT.SEARCH", Args: []string{"noidx", "*"}}, []byte("-ERR Unknown Index name\r\n")},
+		{"create an existing index", &core.RedisCmd{Cmd: "FT.CREATE", Args: []string{"vec:idx", "SCHEMA", "a", "TAG"}}, []byte("-ERR Index already exists\r\n")},
+		{"create a vector field without a metric", &core.RedisCmd{Cmd: "FT.CREATE", Args: []string{"bad", "SCHEMA", "v", "VECTOR", "FLAT", "4", "TYPE", "FLOAT32", "DIM", "2"}}, []byte("-ERR Bad arguments for vector similarity index\r\n")},
+		{"create a flat field with hnsw attributes", &core.RedisCmd{Cmd: "FT.CREATE", Args: []string{"bad", "SCHEMA", "v", "VECTOR", "FLAT", "8", "TYPE", "FLOAT32", "DIM", "2", "DISTANCE_METRIC", "L2", "M", "8"}}, []byte("-ERR Bad arguments for vector similarity index\r\n")},
+	})
+
+	// the index follows the writes to the hashes
+	evalAs(c, "HSET", "movie:1", "embedding", vectorBlob(10, 10))
+	evalAs(c, "DEL", "movie:2")
+	evalAs(c, "FT.SEARCH", "vec:idx", "*=>[KNN 3 @v $q]", "PARAMS", "2", "q", q)
+	if keys := searchKeys(t, c); len(keys) != 2 || keys[0] != "MOVIE:3" || keys[1] != "MOVIE:1" {
+		t.Errorf("got %v after updating movie:1 and deleting movie:2", keys)
+	}
+
+	// a hash whose vector has the wrong size is not indexed
+	evalAs(c, "HSET", "movie:4", "embedding", vectorBlob(1, 2, 3))
+	evalAs(c, "FT.SEARCH", "vec:idx", "*")
+	if keys := searchKeys(t, c); len(keys) != 2 || keys[0] != "MOVIE:1" || keys[1] != "MOVIE:3" {
+		t.Errorf("got %v after storing a vector of the wrong size", keys)
+	}
+	evalAs(c, "FT.INFO", "vec:idx")
+	reply, _ := core.Decode(c.LastWrite)
+	info := reply.([]interface{})
+	if info[7] != int64(2) || info[9] != int64(1) {
+		t.Errorf("got %v documents and %v failures, want 2 and 1", info[7], info[9])
+	}
+}
+
+// TestVectorSearchOverRESP sends the vectors the way clients do, their bytes hold \r and \n
+func TestVectorSearchOverRESP(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "FT.CREATE", "resp:idx", "PREFIX", "1", "resp:", "SCHEMA", "v", "VECTOR", "FLAT", "6", "TYPE", "FLOAT32", "DIM", "2", "DISTANCE_METRIC", "L2")
+	defer evalAs(c, "FT.DROPINDEX", "resp:idx")
+
+	send := func(args ...string) {
+		t.Helper()
+		tokens, err := core.DecodeArrayString(core.Encode(args, false))
+		if err != nil {
+			t.Fatalf("cannot decode %q: %v", args, err)
+		}
+		core.EvalAndRespond(&core.RedisCmd{Cmd: tokens[0], Args: tokens[1:]}, c, core.RealTimeProvider{})
+	}
+	crlf := vectorBlob(math.Float32frombits(0x3f0d0a0d), math.Float32frombits(0x0d0d0a0a))
+	send("HSET", "resp:crlf", "v", crlf)
+	expectWrite(t, c, ":1\r\n")
+	send("HSET", "resp:other", "v", vectorBlob(1, 1))
+	send("FT.SEARCH", "resp:idx", "*=>[KNN 1 @v $q]", "PARAMS", "2", "q", crlf)
+	expectWrite(t, c, "*3\r\n:1\r\n$9\r\nRESP:CRLF\r\n*4\r\n$9\r\n__v_score\r\n$1\r\n0\r\n$1\r\nv\r\n$8\r\n"+crlf+"\r\n")
+}
+
+func TestVectorDistanceMetrics(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	for _, metric := range []string{"COSINE", "IP"} {
+		evalAs(c, "FT.CREATE", "metric:"+metric, "PREFIX", "1", "metric:", "SCHEMA", "v", "VECTOR", "HNSW", "6", "TYPE", "FLOAT32", "DIM", "2", "DISTANCE_METRIC", metric)
+		defer evalAs(c, "FT.DROPINDEX", "metric:"+metric)
+	}
+	evalAs(c, "HSET", "metric:a", "v", vectorBlob(0.6, 0.8))
+	evalAs(c, "HSET", "metric:b", "v", vectorBlob(0, 0.5))
+
+	// cosine distances ignore the norm of the vectors
+	evalAs(c, "FT.SEARCH", "metric:COSINE", "*=>[KNN 2 @v $q AS d]", "PARAMS", "2", "q", vectorBlob(0, 3))
+	expectWrite(t, c, "*5\r\n:2\r\n$8\r\nMETRIC:B\r\n*4\r\n$1\r\nd\r\n$1\r\n0\r\n$1\r\nv\r\n$8\r\n"+vectorBlob(0, 0.5)+"\r\n"+
+		"$8\r\nMETRIC:A\r\n*4\r\n$1\r\nd\r\n$10\r\n0.19999999\r\n$1\r\nv\r\n$8\r\n"+vectorBlob(0.6, 0.8)+"\r\n")
+	evalAs(c, "FT.SEARCH", "metric:IP", "*=>[KNN 2 @v $q AS d]", "PARAMS", "2", "q", vectorBlob(1, 1))
+	expectWrite(t, c, "*5\r\n:2\r\n$8\r\nMETRIC:A\r\n*4\r\n$1\r\nd\r\n$11\r\n-0.40000004\r\n$1\r\nv\r\n$8\r\n"+vectorBlob(0.6, 0.8)+"\r\n"+
+		"$8\r\nMETRIC:B\r\n*4\r\n$1\r\nd\r\n$3\r\n0.5\r\n$1\r\nv\r\n$8\r\n"+vectorBlob(0, 0.5)+"\r\n")
+}
+
+// TestHNSWRecall compares the neighbours found in the graph with the exact neighbours found by a flat index,
+// while the graph is updated and with filters
+func TestHNSWRecall(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	withKeysLimit(t, 2000, "allkeys-lru")
+	// the points would not fit the keys limit of the tests that follow
+	defer evalAs(c, "FLUSHDB")
+	schema := []string{"PREFIX", "1", "point:", "SCHEMA", "parity", "TAG", "v", "VECTOR"}
+	attrs := []string{"TYPE", "FLOAT32", "DIM", "8", "DISTANCE_METRIC", "L2"}
+	evalAs(c, "FT.CREATE", append(append(append([]string{"recall:flat"}, schema...), "FLAT", "6"), attrs...)...)
+	evalAs(c, "FT.CREATE", append(append(append([]string{"recall:hnsw"}, schema...), "HNSW", "8", "M", "8"), attrs...)...)
+	defer evalAs(c, "FT.DROPINDEX", "recall:flat")
+	defer evalAs(c, "FT.DROPINDEX", "recall:hnsw")
+
+	rng := rand.New(rand.NewSource(7))
+	randomVector := func() string {
+		v := make([]float32, 8)
+		for i := range v {
+			v[i] = rng.Float32()
+		}
+		return vectorBlob(v...)
+	}
+	for i := 0; i < 1000; i++ {
+		evalAs(c, "HSET", "point:"+strconv.Itoa(i), "parity", strconv.Itoa(i%2), "v", randomVector())
+	}
+	for i := 0; i < 1000; i += 5 {
+		evalAs(c, "DEL", "point:"+strconv.Itoa(i))
+	}
+	for i := 1; i < 1000; i += 10 {
+		evalAs(c, "HSET", "point:"+strconv.Itoa(i), "v", randomVector())
+	}
+
+	for _, query := range []string{"*=>[KNN 10 @v $q EF_RUNTIME 50]", "@parity:{1}=>[KNN 10 @v $q EF_RUNTIME 50]"} {
+		found, total := 0, 0
+		for n := 0; n < 20; n++ {
+			q := randomVector()
+			evalAs(c, "FT.SEARCH", "recall:flat", query[:len(query)-len(" EF_RUNTIME 50]")]+"]", "PARAMS", "2", "q", q)
+			exact := searchKeys(t, c)
+			evalAs(c, "FT.SEARCH", "recall:hnsw", query, "PARAMS", "2", "q", q)
+			approx := searchKeys(t, c)
+			if len(approx) != 10 {
+				t.Fatalf("%s found %d neighbours, want 10", query, len(approx))
+			}
+			for _, key := range approx {
+				if n, _ := strconv.Atoi(key[len("POINT:"):]); n%5 == 0 || (query[0] == '@' && n%2 == 0) {
+					t.Fatalf("%s found %s which it should not", query, key)
+				}
+				for _, want := range exact {
+					if key == want {
+						found++
+					}
+				}
+			}
+			total += len(exact)
+		}
+		if recall := float64(found) / float64(total); recall < 0.9 {
+			t.Errorf("recall of %s is %.2f, want at least 0.9", query, recall)
+		}
+	}
+}
+
+func TestSearchIndexRewriteAOF(t *testing.T) {
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "HSET", "aofdoc:1", "v", vectorBlob(1, 2))
+	evalAs(c, "FT.CREATE", "aof:idx", "PREFIX", "1", "aofdoc:", "SCHEMA", "v", "VECTOR", "FLAT", "6", "TYPE", "FLOAT32", "DIM", "2", "DISTANCE_METRIC", "IP")
+	defer evalAs(c, "FT.DROPINDEX", "aof:idx")
+	evalAs(c, "BGREWRITEAOF")
+
+	content, _ := os.ReadFile(config.APPEND_ONLY_FILE)
+	os.Remove(config.APPEND_ONLY_FILE)
+	// the index is created once its hashes exist
+	create := "*16\r\n$9\r\nFT.CREATE\r\n$7\r\naof:idx\r\n$6\r\nPREFIX\r\n$1\r\n1\r\n$7\r\naofdoc:\r\n$6\r\nSCHEMA\r\n$1\r\nv\r\n$6\r\nVECTOR\r\n" +
+		"$4\r\nFLAT\r\n$1\r\n6\r\n$4\r\nTYPE\r\n$7\r\nFLOAT32\r\n$3\r\nDIM\r\n$1\r\n2\r\n$15\r\nDISTANCE_METRIC\r\n$2\r\nIP\r\n"
+	if !bytes.Contains(content, []byte("$8\r\nAOFDOC:1\r\n")) || !bytes.HasSuffix(content, []byte(create)) {
+		t.Errorf("AOF content does not end with %q after the hash:\n%q", create, content)
+	}
+}
@@ -0,0 +1,275 @@
+package core
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/diceclone/config"
+)
+
+type zsetEntry struct {
+	member string
+	score  float64
+}
+
+// zsetValue is implemented by both sorted set encodings, a listpack for small sets and a skiplist
+// with a member to score map. Ranks are 0-based and ascending
+type zsetValue interface {
+	Len() int
+	Score(member string) (float64, bool)
+	// Add inserts the member or updates its score
+	Add(member string, score float64)
+	Remove(member string) bool
+	// Rank returns the rank of the member, -1 when it is not in the set
+	Rank(member string) int
+	At(rank int) zsetEntry
+	// Range returns the elements from rank start to end, both inclusive
+	Range(start, end int) []zsetEntry
+}
+
+// zsetListpack holds alternating members and scores ordered by score and then by member
+type zsetListpack struct {
+	lp *listpack
+}
+
+func (z *zsetListpack) Len() int {
+	return z.lp.Len() / 2
+}
+
+func (z *zsetListpack) Score(member string) (float64, bool) {
+	i := z.lp.Find(member, 0, 2)
+	if i < 0 {
+		return 0, false
+	}
+	s, _ := z.lp.Index(i + 1)
+	return parseScore(s), true
+}
+
+func (z *zsetListpack) Add(member string, score float64) {
+	z.Remove(member)
+	rank := sort.Search(z.Len(), func(r int) bool {
+		e := z.At(r)
+		return e.score > score || (e.score == score && e.member > member)
+	})
+	z.lp.Insert(2*rank, member, formatScore(score))
+}
+
+func (z *zsetListpack) Remove(member string) bool {
+	i := z.lp.Find(member, 0, 2)
+	if i < 0 {
+		return false
+	}
+	z.lp.Delete(i, 2)
+	return true
+}
+
+func (z *zsetListpack) Rank(member string) int {
+	i := z.lp.Find(member, 0, 2)
+	if i < 0 {
+		return -1
+	}
+	return i / 2
+}
+
+func (z *zsetListpack) At(rank int) zsetEntry {
+	pair := z.lp.Range(2*rank, 2*rank+1)
+	return zsetEntry{pair[0], parseScore(pair[1])}
+}
+
+func (z *zsetListpack) Range(start, end int) []zsetEntry {
+	pairs := z.lp.Range(2*start, 2*end+1)
+	entries := make([]zsetEntry, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		entries = append(entries, zsetEntry{pairs[i], parseScore(pairs[i+1])})
+	}
+	return entries
+}
+
+type zsetSkiplist struct {
+	zsl  *zskiplist
+	dict map[string]float64
+}
+
+func newZsetSkiplist() *zsetSkiplist {
+	return &zsetSkiplist{zsl: newZskiplist(), dict: make(map[string]float64)}
+}
+
+func (z *zsetSkiplist) Len() int {
+	return z.zsl.length
+}
+
+func (z *zsetSkiplist) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+func (z *zsetSkiplist) Add(member string, score float64) {
+	if current, ok := z.dict[member]; ok {
+		if current == score {
+			return
+		}
+		z.zsl.delete(current, member)
+	}
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+}
+
+func (z *zsetSkiplist) Remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+	return true
+}
+
+func (z *zsetSkiplist) Rank(member string) int {
+	score, ok := z.dict[member]
+	if !ok {
+		return -1
+	}
+	return z.zsl.rank(score, member) - 1
+}
+
+func (z *zsetSkiplist) At(rank int) zsetEntry {
+	x := z.zsl.byRank(rank + 1)
+	return zsetEntry{x.member, x.score}
+}
+
+func (z *zsetSkiplist) Range(start, end int) []zsetEntry {
+	start = max(start, 0)
+	end = min(end, z.Len()-1)
+	if start > end {
+		return []zsetEntry{}
+	}
+	entries := make([]zsetEntry, 0, end-start+1)
+	for x := z.zsl.byRank(start + 1); x != nil && len(entries) < end-start+1; x = x.level[0].forward {
+		entries = append(entries, zsetEntry{x.member, x.score})
+	}
+	return entries
+}
+
+// newZsetObj creates a sorted set whose encoding fits size members as long as maxLen bytes
+func newZsetObj(size int, maxLen int) *Obj {
+	if size > config.ZSET_MAX_LISTPACK_ENTRIES || maxLen > config.ZSET_MAX_LISTPACK_VALUE {
+		return NewObj(newZsetSkiplist(), -1, OBJ_TYPE_ZSET, OBJ_ENCODING_SKIPLIST)
+	}
+	return NewObj(&zsetListpack{lp: newListpack()}, -1, OBJ_TYPE_ZSET, OBJ_ENCODING_LISTPACK)
+}
+
+// newZsetObjFrom creates a sorted set holding the entries, picking the encoding that fits them
+func newZsetObjFrom(entries []zsetEntry) *Obj {
+	maxLen := 0
+	for _, e := range entries {
+		maxLen = max(maxLen, len(e.member))
+	}
+	obj := newZsetObj(len(entries), maxLen)
+	z := zsetOf(obj)
+	for _, e := range entries {
+		z.Add(e.member, e.score)
+	}
+	return obj
+}
+
+func zsetOf(obj *Obj) zsetValue {
+	return obj.Value.(zsetValue)
+}
+
+// zsetAdd adds the member or updates its score, converting the listpack to a skiplist once it outgrows
+// zset-max-listpack-entries or zset-max-listpack-value, sorted sets are never converted back
+func zsetAdd(obj *Obj, member string, score float64) {
+	z := zsetOf(obj)
+	z.Add(member, score)
+	if obj.Encoding() == OBJ_ENCODING_LISTPACK &&
+		(z.Len() > config.ZSET_MAX_LISTPACK_ENTRIES || len(member) > config.ZSET_MAX_LISTPACK_VALUE) {
+		zsetConvert(obj)
+	}
+}
+
+func zsetConvert(obj *Obj) {
+	z := zsetOf(obj)
+	zs := newZsetSkiplist()
+	for _, e := range z.Range(0, z.Len()-1) {
+		zs.Add(e.member, e.score)
+	}
+	obj.Value = zs
+	obj.setEncoding(OBJ_ENCODING_SKIPLIST)
+}
+
+// zsetFirstRank returns the first rank whose element satisfies pred, pred must be false up to some
+// rank and true from there on, the length of the set is returned when no element satisfies it
+func zsetFirstRank(z zsetValue, pred func(e zsetEntry) bool) int {
+	return sort.Search(z.Len(), func(r int) bool { return pred(z.At(r)) })
+}
+
+// zscoreRange is a score interval, min and max are exclusive when minEx and maxEx are set
+type zscoreRange struct {
+	min, max     float64
+	minEx, maxEx bool
+}
+
+// ranks returns the ascending ranks of the first and the last element in the interval,
+// the first is greater than the last when the interval is empty
+func (r *zscoreRange) ranks(z zsetValue) (int, int) {
+	first := zsetFirstRank(z, func(e zsetEntry) bool {
+		return e.score > r.min || (!r.minEx && e.score == r.min)
+	})
+	end := zsetFirstRank(z, func(e zsetEntry) bool {
+		return e.score > r.max || (r.maxEx && e.score == r.max)
+	})
+	return first, end - 1
+}
+
+// zlexRange is a lexicographical interval of members, minInf and maxInf are -1 for the "-" bound,
+// 1 for the "+" bound and 0 for a member bound
+type zlexRange struct {
+	min, max       string
+	minInf, maxInf int
+	minEx, maxEx   bool
+}
+
+func (r *zlexRange) ranks(z zsetValue) (int, int) {
+	first := 0
+	switch r.minInf {
+	case 1:
+		first = z.Len()
+	case 0:
+		first = zsetFirstRank(z, func(e zsetEntry) bool {
+			return e.member > r.min || (!r.minEx && e.member == r.min)
+		})
+	}
+	end := z.Len()
+	switch r.maxInf {
+	case -1:
+		end = 0
+	case 0:
+		end = zsetFirstRank(z, func(e zsetEntry) bool {
+			return e.member > r.max || (r.maxEx && e.member == r.max)
+		})
+	}
+	return first, end - 1
+}
+
+// parseScore reads the scores stored in listpacks, which are always written by formatScore
+func parseScore(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// formatScore renders scores the way redis replies them, integers below 2^52 in full like the geohash
+// scores and other values with the shortest representation that round trips
+func formatScore(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f == 0 && math.Signbit(f):
+		return "-0"
+	case f > -(1<<52) && f < 1<<52 && f == math.Trunc(f):
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
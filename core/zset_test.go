@@ -0,0 +1,190 @@
+package core_test
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/diceclone/config"
+	"github.com/diceclone/core"
+)
+
+func TestSortedSetCommands(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"add members", &core.RedisCmd{Cmd: "ZADD", Args: []string{"board", "10", "ann", "20", "bob", "15", "cid"}}, []byte(":3\r\n")},
+		{"update a score", &core.RedisCmd{Cmd: "ZADD", Args: []string{"board", "CH", "25", "bob", "5", "dan"}}, []byte(":2\r\n")},
+		{"nx does not update", &core.RedisCmd{Cmd: "ZADD", Args: []string{"board", "NX", "1", "ann"}}, []byte(":0\r\n")},
+		{"xx does not add", &core.RedisCmd{Cmd: "ZADD", Args: []string{"board", "XX", "1", "eve"}}, []byte(":0\r\n")},
+		{"gt refuses a lower score", &core.RedisCmd{Cmd: "ZADD", Args: []string{"board", "GT", "CH", "1", "ann"}}, []byte(":0\r\n")},
+		{"lt accepts a lower score", &core.RedisCmd{Cmd: "ZADD", Args: []string{"board", "LT", "CH", "9", "ann"}}, []byte(":1\r\n")},
+		{"incr returns the score", &core.RedisCmd{Cmd: "ZADD", Args: []string{"board", "INCR", "1.5", "ann"}}, []byte("$4\r\n10.5\r\n")},
+		{"incr aborted by gt", &core.RedisCmd{Cmd: "ZADD", Args: []string{"board", "GT", "INCR", "-1", "ann"}}, []byte("$-1\r\n")},
+		{"nx and xx together", &core.RedisCmd{Cmd: "ZADD", Args: []string{"board", "NX", "XX", "1", "ann"}}, []byte("-ERR XX and NX options at the same time are not compatible\r\n")},
+		{"gt and lt together", &core.RedisCmd{Cmd: "ZADD", Args: []string{"board", "GT", "LT", "1", "ann"}}, []byte("-ERR GT, LT, and/or NX options at the same time are not compatible\r\n")},
+		{"incr with many pairs", &core.RedisCmd{Cmd: "ZADD", Args: []string{"board", "INCR", "1", "ann", "2", "bob"}}, []byte("-ERR INCR option supports a single increment-element pair\r\n")},
+		{"invalid score", &core.RedisCmd{Cmd: "ZADD", Args: []string{"board", "abc", "ann"}}, []byte("-ERR value is not a valid float\r\n")},
+		{"increment a member", &core.RedisCmd{Cmd: "ZINCRBY", Args: []string{"board", "-0.5", "ann"}}, []byte("$2\r\n10\r\n")},
+		{"score of a member", &core.RedisCmd{Cmd: "ZSCORE", Args: []string{"board", "cid"}}, []byte("$2\r\n15\r\n")},
+		{"scores of members", &core.RedisCmd{Cmd: "ZMSCORE", Args: []string{"board", "dan", "nobody"}}, []byte("*2\r\n$1\r\n5\r\n$-1\r\n")},
+		{"cardinality", &core.RedisCmd{Cmd: "ZCARD", Args: []string{"board"}}, []byte(":4\r\n")},
+		{"whole range", &core.RedisCmd{Cmd: "ZRANGE", Args: []string{"board", "0", "-1", "WITHSCORES"}}, []byte("*8\r\n$3\r\ndan\r\n$1\r\n5\r\n$3\r\nann\r\n$2\r\n10\r\n$3\r\ncid\r\n$2\r\n15\r\n$3\r\nbob\r\n$2\r\n25\r\n")},
+		{"reversed rank range", &core.RedisCmd{Cmd: "ZRANGE", Args: []string{"board", "0", "1", "REV"}}, []byte("*2\r\n$3\r\nbob\r\n$3\r\ncid\r\n")},
+		{"legacy reversed range", &core.RedisCmd{Cmd: "ZREVRANGE", Args: []string{"board", "-1", "-1"}}, []byte("*1\r\n$3\r\ndan\r\n")},
+		{"score range", &core.RedisCmd{Cmd: "ZRANGE", Args: []string{"board", "(5", "15", "BYSCORE"}}, []byte("*2\r\n$3\r\nann\r\n$3\r\ncid\r\n")},
+		{"reversed score range with limit", &core.RedisCmd{Cmd: "ZRANGE", Args: []string{"board", "+inf", "-inf", "BYSCORE", "REV", "LIMIT", "1", "2"}}, []byte("*2\r\n$3\r\ncid\r\n$3\r\nann\r\n")},
+		{"legacy score range", &core.RedisCmd{Cmd: "ZRANGEBYSCORE", Args: []string{"board", "-inf", "(10", "WITHSCORES"}}, []byte("*2\r\n$3\r\ndan\r\n$1\r\n5\r\n")},
+		{"count a score range", &core.RedisCmd{Cmd: "ZCOUNT", Args: []string{"board", "10", "+inf"}}, []byte(":3\r\n")},
+		{"invalid score bound", &core.RedisCmd{Cmd: "ZCOUNT", Args: []string{"board", "x", "1"}}, []byte("-ERR min or max is not a float\r\n")},
+		{"limit without by", &core.RedisCmd{Cmd: "ZRANGE", Args: []string{"board", "0", "1", "LIMIT", "0", "1"}}, []byte("-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n")},
+		{"rank of a member", &core.RedisCmd{Cmd: "ZRANK", Args: []string{"board", "cid"}}, []byte(":2\r\n")},
+		{"reverse rank with score", &core.RedisCmd{Cmd: "ZREVRANK", Args: []string{"board", "cid", "WITHSCORE"}}, []byte("*2\r\n:1\r\n$2\r\n15\r\n")},
+		{"rank of a missing member", &core.RedisCmd{Cmd: "ZRANK", Args: []string{"board", "nobody"}}, []byte("$-1\r\n")},
+		{"remove members", &core.RedisCmd{Cmd: "ZREM", Args: []string{"board", "dan", "nobody"}}, []byte(":1\r\n")},
+		{"pop the lowest", &core.RedisCmd{Cmd: "ZPOPMIN", Args: []string{"board"}}, []byte("*2\r\n$3\r\nann\r\n$2\r\n10\r\n")},
+		{"pop the highest", &core.RedisCmd{Cmd: "ZPOPMAX", Args: []string{"board", "5"}}, []byte("*4\r\n$3\r\nbob\r\n$2\r\n25\r\n$3\r\ncid\r\n$2\r\n15\r\n")},
+		{"the emptied set is deleted", &core.RedisCmd{Cmd: "TYPE", Args: []string{"board"}}, []byte("+none\r\n")},
+		{"xx on a missing key", &core.RedisCmd{Cmd: "ZADD", Args: []string{"board", "XX", "1", "ann"}}, []byte(":0\r\n")},
+		{"pop from a missing key", &core.RedisCmd{Cmd: "ZPOPMIN", Args: []string{"board"}}, []byte("*0\r\n")},
+		{"set a string", &core.RedisCmd{Cmd: "SET", Args: []string{"zsetstring", "a"}}, []byte("+OK\r\n")},
+		{"zset command on a string", &core.RedisCmd{Cmd: "ZADD", Args: []string{"zsetstring", "1", "a"}}, []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")},
+	})
+}
+
+func TestSortedSetLexAndRemoveRanges(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"members with the same score", &core.RedisCmd{Cmd: "ZADD", Args: []string{"lex", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e"}}, []byte(":5\r\n")},
+		{"lex range", &core.RedisCmd{Cmd: "ZRANGE", Args: []string{"lex", "[b", "(d", "BYLEX"}}, []byte("*2\r\n$1\r\nb\r\n$1\r\nc\r\n")},
+		{"reversed lex range", &core.RedisCmd{Cmd: "ZREVRANGEBYLEX", Args: []string{"lex", "+", "(c", "LIMIT", "0", "1"}}, []byte("*1\r\n$1\r\ne\r\n")},
+		{"count a lex range", &core.RedisCmd{Cmd: "ZLEXCOUNT", Args: []string{"lex", "-", "+"}}, []byte(":5\r\n")},
+		{"invalid lex bound", &core.RedisCmd{Cmd: "ZLEXCOUNT", Args: []string{"lex", "a", "+"}}, []byte("-ERR min or max not valid string range item\r\n")},
+		{"withscores with bylex", &core.RedisCmd{Cmd: "ZRANGE", Args: []string{"lex", "-", "+", "BYLEX", "WITHSCORES"}}, []byte("-ERR syntax error, WITHSCORES not supported in combination with BYLEX\r\n")},
+		{"remove a lex range", &core.RedisCmd{Cmd: "ZREMRANGEBYLEX", Args: []string{"lex", "-", "[a"}}, []byte(":1\r\n")},
+		{"remove a rank range", &core.RedisCmd{Cmd: "ZREMRANGEBYRANK", Args: []string{"lex", "-2", "-1"}}, []byte(":2\r\n")},
+		{"remove a score range", &core.RedisCmd{Cmd: "ZREMRANGEBYSCORE", Args: []string{"lex", "(0", "+inf"}}, []byte(":0\r\n")},
+		{"left members", &core.RedisCmd{Cmd: "ZRANGE", Args: []string{"lex", "0", "-1"}}, []byte("*2\r\n$1\r\nb\r\n$1\r\nc\r\n")},
+	})
+}
+
+func TestSortedSetStore(t *testing.T) {
+	runCommandCases(t, []commandCase{
+		{"first set", &core.RedisCmd{Cmd: "ZADD", Args: []string{"za", "1", "a", "2", "b", "3", "c"}}, []byte(":3\r\n")},
+		{"second set", &core.RedisCmd{Cmd: "ZADD", Args: []string{"zb", "10", "b", "20", "c", "30", "d"}}, []byte(":3\r\n")},
+		{"a plain set", &core.RedisCmd{Cmd: "SADD", Args: []string{"zplain", "c", "e"}}, []byte(":2\r\n")},
+		{"union", &core.RedisCmd{Cmd: "ZUNIONSTORE", Args: []string{"zout", "3", "za", "zb", "zplain"}}, []byte(":5\r\n")},
+		{"union scores", &core.RedisCmd{Cmd: "ZRANGE", Args: []string{"zout", "0", "-1", "WITHSCORES"}}, []byte("*10\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\ne\r\n$1\r\n1\r\n$1\r\nb\r\n$2\r\n12\r\n$1\r\nc\r\n$2\r\n24\r\n$1\r\nd\r\n$2\r\n30\r\n")},
+		{"weighted intersection", &core.RedisCmd{Cmd: "ZINTERSTORE", Args: []string{"zout", "2", "za", "zb", "WEIGHTS", "2", "0.5", "AGGREGATE", "MAX"}}, []byte(":2\r\n")},
+		{"intersection scores", &core.RedisCmd{Cmd: "ZRANGE", Args: []string{"zout", "0", "-1", "WITHSCORES"}}, []byte("*4\r\n$1\r\nb\r\n$1\r\n5\r\n$1\r\nc\r\n$2\r\n10\r\n")},
+		{"intersection with a missing key", &core.RedisCmd{Cmd: "ZINTERSTORE", Args: []string{"zout", "2", "za", "nozset"}}, []byte(":0\r\n")},
+		{"empty result deletes the destination", &core.RedisCmd{Cmd: "TYPE", Args: []string{"zout"}}, []byte("+none\r\n")},
+		{"no input keys", &core.RedisCmd{Cmd: "ZUNIONSTORE", Args: []string{"zout", "0", "za"}}, []byte("-ERR at least 1 input key is needed for 'zunionstore' command\r\n")},
+		{"invalid weight", &core.RedisCmd{Cmd: "ZUNIONSTORE", Args: []string{"zout", "1", "za", "WEIGHTS", "x"}}, []byte("-ERR weight value is not a float\r\n")},
+		{"store a range", &core.RedisCmd{Cmd: "ZRANGESTORE", Args: []string{"zout", "zb", "15", "+inf", "BYSCORE"}}, []byte(":2\r\n")},
+		{"stored range", &core.RedisCmd{Cmd: "ZRANGE", Args: []string{"zout", "0", "-1", "WITHSCORES"}}, []byte("*4\r\n$1\r\nc\r\n$2\r\n20\r\n$1\r\nd\r\n$2\r\n30\r\n")},
+		{"scan a small sorted set", &core.RedisCmd{Cmd: "ZSCAN", Args: []string{"zout", "0", "MATCH", "d"}}, []byte("*2\r\n$1\r\n0\r\n*2\r\n$1\r\nd\r\n$2\r\n30\r\n")},
+	})
+}
+
+// TestSortedSetEncodingsAgainstModel runs random operations on both encodings and compares every
+// rank and range with a sorted slice
+func TestSortedSetEncodingsAgainstModel(t *testing.T) {
+	defer func(entries int) { config.ZSET_MAX_LISTPACK_ENTRIES = entries }(config.ZSET_MAX_LISTPACK_ENTRIES)
+
+	for _, limit := range []string{"1000", "8"} {
+		c, _ := setupTest()
+		evalAs(c, "FLUSHDB")
+		evalAs(c, "CONFIG", "SET", "zset-max-listpack-entries", limit)
+
+		rng := rand.New(rand.NewSource(7))
+		model := make(map[string]float64)
+		for i := 0; i < 500; i++ {
+			member := "m" + strconv.Itoa(rng.Intn(60))
+			if rng.Intn(4) == 0 {
+				evalAs(c, "ZREM", "fuzz", member)
+				delete(model, member)
+				continue
+			}
+			score := float64(rng.Intn(20))
+			evalAs(c, "ZADD", "fuzz", strconv.Itoa(int(score)), member)
+			model[member] = score
+		}
+
+		members := make([]string, 0, len(model))
+		for m := range model {
+			members = append(members, m)
+		}
+		sort.Slice(members, func(i, j int) bool {
+			if model[members[i]] != model[members[j]] {
+				return model[members[i]] < model[members[j]]
+			}
+			return members[i] < members[j]
+		})
+
+		evalAs(c, "ZRANGE", "fuzz", "0", "-1")
+		got := arrayReply(t, c)
+		if len(got) != len(members) {
+			t.Fatalf("limit %s: got %d members, want %d", limit, len(got), len(members))
+		}
+		for i := range got {
+			if got[i] != members[i] {
+				t.Fatalf("limit %s: rank %d is %s, want %s", limit, i, got[i], members[i])
+			}
+			evalAs(c, "ZRANK", "fuzz", members[i])
+			expectWrite(t, c, ":"+strconv.Itoa(i)+"\r\n")
+		}
+
+		evalAs(c, "ZRANGE", "fuzz", "5", "(10", "BYSCORE")
+		got = arrayReply(t, c)
+		want := []string{}
+		for _, m := range members {
+			if model[m] >= 5 && model[m] < 10 {
+				want = append(want, m)
+			}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("limit %s: score range got %v, want %v", limit, got, want)
+		}
+	}
+	c, _ := setupTest()
+	evalAs(c, "OBJECT", "ENCODING", "fuzz")
+	expectWrite(t, c, "$8\r\nskiplist\r\n")
+}
+
+func TestZAddConvertingPartway(t *testing.T) {
+	defer func(entries int) { config.ZSET_MAX_LISTPACK_ENTRIES = entries }(config.ZSET_MAX_LISTPACK_ENTRIES)
+	c, _ := setupTest()
+	evalAs(c, "FLUSHDB")
+	evalAs(c, "CONFIG", "SET", "zset-max-listpack-entries", "128")
+
+	args := []string{"convz"}
+	for i := 0; i < 128; i++ {
+		args = append(args, strconv.Itoa(i), "m"+strconv.Itoa(i))
+	}
+	evalAs(c, "ZADD", args...)
+	evalAs(c, "OBJECT", "ENCODING", "convz")
+	expectWrite(t, c, "$8\r\nlistpack\r\n")
+
+	// the second pair converts the set, the third one updates the member it just added
+	evalAs(c, "ZADD", "convz", "1000", "new", "2000", "new2", "5", "new2")
+	expectWrite(t, c, ":2\r\n")
+	evalAs(c, "OBJECT", "ENCODING", "convz")
+	expectWrite(t, c, "$8\r\nskiplist\r\n")
+	evalAs(c, "ZCARD", "convz")
+	expectWrite(t, c, ":130\r\n")
+	evalAs(c, "ZSCORE", "convz", "new2")
+	expectWrite(t, c, "$1\r\n5\r\n")
+}
+
+// arrayReply decodes an array reply keeping the order of its elements
+func arrayReply(t *testing.T, c *MockReadWriter) []string {
+	t.Helper()
+	reply, err := core.Decode(c.LastWrite)
+	if err != nil {
+		t.Fatalf("cannot decode %q: %v", c.LastWrite, err)
+	}
+	var out []string
+	for _, e := range reply.([]interface{}) {
+		out = append(out, e.(string))
+	}
+	return out
+}
@@ -9,14 +9,21 @@ import (
 	"syscall"
 
 	"github.com/diceclone/config"
+	"github.com/diceclone/core"
 	"github.com/diceclone/server"
 )
 
 func setUpFlags() {
 	flag.StringVar(&config.Host, "host", "0.0.0.0", "host for dicedb server")
 	flag.IntVar(&config.Port, "port", 7379, "port for dicedb server")
+	flag.StringVar(&config.EVICTION_STRATEGY, "maxmemory-policy", "allkeys-lru", "eviction policy applied when the keyspace is full")
 
 	flag.Parse()
+
+	// the policy goes through the same validation as CONFIG SET, a typo must not silently disable eviction
+	if err := core.SetConfig("maxmemory-policy", config.EVICTION_STRATEGY); err != nil {
+		log.Fatalf("invalid maxmemory-policy: %v", err)
+	}
 }
 
 func main() {
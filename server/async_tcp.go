@@ -15,6 +15,11 @@ import (
 // var connectedClients int = 0
 var cronFrequency time.Duration = 1 * time.Second
 var lastCronExectime time.Time = time.Now()
+var blockedClientsTimeoutResolution time.Duration = 100 * time.Millisecond
+
+// clientBuffers holds, per client socket, the bytes received that were not run yet: a command that did not
+// arrive whole or the commands pipelined after a blocking one
+var clientBuffers = make(map[int][]byte)
 
 func RunAsyncTCPServer(wg *sync.WaitGroup) error {
 	defer wg.Done()
@@ -63,6 +68,9 @@ func RunAsyncTCPServer(wg *sync.WaitGroup) error {
 
 	var events []syscall.Kevent_t = make([]syscall.Kevent_t, maxClients)
 
+	// wake up periodically even without events so that blocked clients time out on time
+	waitTimeout := syscall.NsecToTimespec(int64(blockedClientsTimeoutResolution))
+
 	for atomic.LoadInt32(&eStatus) != EngineStatus_SHUTTING_DOWN {
 
 		// every one min, run a check on the keys to delete the expired keys
@@ -73,9 +81,10 @@ func RunAsyncTCPServer(wg *sync.WaitGroup) error {
 			core.SafeDeleteExpiredKeys()
 			lastCronExectime = time.Now()
 		}
+		core.HandleBlockedClientsTimeout()
 
 		// equivalent of EPOLL_WAIT
-		nEvents, err := syscall.Kevent(epollFD, nil, events[:], nil)
+		nEvents, err := syscall.Kevent(epollFD, nil, events[:], &waitTimeout)
 		if err != nil {
 			continue
 		}
@@ -112,14 +121,28 @@ func RunAsyncTCPServer(wg *sync.WaitGroup) error {
 
 			} else {
 				// data on an existing connection
-				comm := core.FDComm{Fd: int(events[i].Ident)}
-				cmd, err := readCommand(comm)
+				fd := int(events[i].Ident)
+				buf := clientBuffers[fd]
+				err := readInto(core.FDComm{Fd: fd}, &buf)
+				clientBuffers[fd] = buf
+				if err == nil {
+					err = runBufferedCommands(fd)
+				}
 				if err != nil {
-					syscall.Close(int(events[i].Ident))
+					disconnectClient(fd)
 					connectedClients -= 1
-					continue
 				}
-				respond(comm, cmd)
+			}
+		}
+
+		// the clients served or timed out while blocked run the commands they pipelined after the blocking one
+		for fd, buf := range clientBuffers {
+			if len(buf) == 0 {
+				continue
+			}
+			if err := runBufferedCommands(fd); err != nil {
+				disconnectClient(fd)
+				connectedClients -= 1
 			}
 		}
 		atomic.StoreInt32(&eStatus, EngineStatus_WAITING)
@@ -127,6 +150,31 @@ func RunAsyncTCPServer(wg *sync.WaitGroup) error {
 	return nil
 }
 
+// runBufferedCommands runs the commands received whole from the client, in the order they were sent,
+// until its buffer only holds an incomplete command or one of them blocks the client
+func runBufferedCommands(fd int) error {
+	comm := core.FDComm{Fd: fd}
+	buf := clientBuffers[fd]
+	defer func() { clientBuffers[fd] = buf }()
+	for !core.IsClientBlocked(comm) {
+		cmd, err := nextCommand(&buf)
+		if err != nil {
+			return err
+		}
+		if cmd == nil {
+			return nil
+		}
+		respond(comm, cmd)
+	}
+	return nil
+}
+
+func disconnectClient(fd int) {
+	core.DisconnectClient(core.FDComm{Fd: fd})
+	syscall.Close(fd)
+	delete(clientBuffers, fd)
+}
+
 func createServerSocket(connections int) (int, error) {
 	// create a socket
 	serverFD, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
@@ -1,12 +1,14 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/diceclone/core"
 )
@@ -32,39 +34,75 @@ func RunSyncTCPServer(host string, port int) {
 		cons_client += 1
 		log.Println("client connected with address:", c.RemoteAddr(), ", concurrent clients:", cons_client)
 
+		var buf []byte
 		for {
-			cmd, err := readCommand(c)
+			err := readInto(c, &buf)
+			for err == nil {
+				var cmd *core.RedisCmd
+				if cmd, err = nextCommand(&buf); err != nil || cmd == nil {
+					break
+				}
+				respond(c, cmd)
+			}
 			if err != nil {
+				core.DisconnectClient(c)
 				c.Close()
 				cons_client -= 1
 				log.Println("client disconnected with address:", c.RemoteAddr(), ", concurrent clients:", cons_client)
-				if err == io.EOF {
-					break
+				if err != io.EOF {
+					log.Println("err", err)
 				}
-				log.Println("err", err)
+				break
 			}
-			respond(c, cmd)
 		}
 	}
 }
 
-func readCommand(c io.ReadWriter) (*core.RedisCmd, error) {
-
-	buf := make([]byte, 512)
-	n, err := c.Read(buf[:])
-
-	if err != nil {
-		return nil, err
+// readInto reads what the client sent once and appends it to its buffer. A command may not fit in a single
+// read, e.g. a serialized value passed to SET, and a client may pipeline several commands in one, so the
+// buffer is kept per client and decoded by nextCommand
+func readInto(c io.ReadWriter, buf *[]byte) error {
+	chunk := make([]byte, 512)
+	n, err := c.Read(chunk)
+	if n > 0 {
+		*buf = append(*buf, chunk[:n]...)
 	}
-	tokens, err := core.DecodeArrayString(buf[:n])
 	if err != nil {
-		return nil, err
+		// the client sockets of the async server are non blocking, a read that finds nothing is
+		// retried once the event loop reports the socket readable again
+		if errors.Is(err, syscall.EAGAIN) {
+			return nil
+		}
+		return err
 	}
-	return &core.RedisCmd{
-		Cmd:  strings.ToUpper(tokens[0]),
-		Args: tokens[1:],
-	}, nil
+	if n == 0 {
+		return io.EOF
+	}
+	return nil
+}
 
+// nextCommand decodes the first command of the buffer and drops its bytes, it returns nil while the
+// command has not been received whole
+func nextCommand(buf *[]byte) (*core.RedisCmd, error) {
+	for {
+		tokens, n, err := core.DecodeCommand(*buf)
+		if err == core.ErrIncompleteCommand {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// the consumed bytes are left behind, append drops them when it grows the buffer again
+		*buf = (*buf)[n:]
+		// redis ignores empty arrays
+		if len(tokens) == 0 {
+			continue
+		}
+		return &core.RedisCmd{
+			Cmd:  strings.ToUpper(tokens[0]),
+			Args: tokens[1:],
+		}, nil
+	}
 }
 
 func respond(c io.ReadWriter, cmd *core.RedisCmd) {